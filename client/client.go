@@ -0,0 +1,368 @@
+// Package client is a Go client of the mailheap REST API. Modifying requests
+// take care of the CSRF double submit token.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MaxPageSize is the largest page returned by the server.
+const MaxPageSize = 100
+
+const apiPrefix = "/api/v1"
+
+// Errors matched by StatusError via errors.Is.
+var (
+	ErrBadRequest      = errors.New("bad request")
+	ErrForbidden       = errors.New("forbidden")
+	ErrNotFound        = errors.New("not found")
+	ErrTooLarge        = errors.New("request too large")
+	ErrUnprocessable   = errors.New("unprocessable")
+	ErrServerError     = errors.New("server error")
+	ErrUnexpectedReply = errors.New("unexpected reply")
+)
+
+// ErrMailNotFound is returned by GetMail if no mail has the ID.
+var ErrMailNotFound = fmt.Errorf("mail %w", ErrNotFound)
+
+// StatusError is returned for responses with a status other than 2xx. Code,
+// Message and Details are taken from the error body of the API.
+type StatusError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Code       string
+	Message    string
+	Details    json.RawMessage
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%v %v: %v %v: %v", e.Method, e.Path, e.StatusCode,
+		http.StatusText(e.StatusCode), e.Message)
+}
+
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrTooLarge:
+		return e.StatusCode == http.StatusRequestEntityTooLarge
+	case ErrUnprocessable:
+		return e.StatusCode == http.StatusUnprocessableEntity
+	case ErrServerError:
+		return e.StatusCode >= 500
+	case ErrUnexpectedReply:
+		return e.StatusCode < 400
+	}
+	return false
+}
+
+// Filter restricts the mails like the query parameters of the REST API.
+// Zero values do not filter.
+type Filter struct {
+	Ids     []int64
+	To      string
+	From    string
+	Subject string
+	Tag     string
+	Mailbox string
+	Seen    *bool
+	Starred *bool
+	Since   time.Time
+	Until   time.Time
+}
+
+// Query returns the filter as query parameters.
+func (f Filter) Query() url.Values {
+	q := url.Values{}
+	if len(f.Ids) > 0 {
+		ids := make([]string, len(f.Ids))
+		for i, id := range f.Ids {
+			ids[i] = strconv.FormatInt(id, 10)
+		}
+		q.Set("id", strings.Join(ids, ","))
+	}
+	set := func(key, value string) {
+		if len(value) > 0 {
+			q.Set(key, value)
+		}
+	}
+	set("to", f.To)
+	set("from", f.From)
+	set("subject", f.Subject)
+	set("tag", f.Tag)
+	set("mailbox", f.Mailbox)
+	if f.Seen != nil {
+		q.Set("seen", strconv.FormatBool(*f.Seen))
+	}
+	if f.Starred != nil {
+		q.Set("starred", strconv.FormatBool(*f.Starred))
+	}
+	if !f.Since.IsZero() {
+		q.Set("since", f.Since.UTC().Format(time.RFC3339Nano))
+	}
+	if !f.Until.IsZero() {
+		q.Set("until", f.Until.UTC().Format(time.RFC3339Nano))
+	}
+	return q
+}
+
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+// New creates a client of the instance at baseURL, e.g. http://localhost:8080.
+// A nil http.Client is replaced by http.DefaultClient.
+func New(baseURL string, hc *http.Client) *Client {
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	return &Client{baseURL: strings.TrimSuffix(baseURL, "/"), http: hc}
+}
+
+// SeekMails returns a page of mails with IDs below afterId, newest first. An
+// afterId of 0 starts with the newest mail. The server clamps the limit to
+// 10..MaxPageSize.
+func (c *Client) SeekMails(ctx context.Context, afterId int64, limit int, f Filter) (SeekMailsResult, error) {
+	res := SeekMailsResult{}
+	q := f.Query()
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	err := c.getJson(ctx, "/mails/"+strconv.FormatInt(afterId, 10), q, &res)
+	return res, err
+}
+
+// Mails iterates over all matching mails, newest first, fetching the pages
+// as needed. Iteration stops after the first error.
+func (c *Client) Mails(ctx context.Context, f Filter) iter.Seq2[Mail, error] {
+	return func(yield func(Mail, error) bool) {
+		afterId := int64(0)
+		for {
+			page, err := c.SeekMails(ctx, afterId, MaxPageSize, f)
+			if err != nil {
+				yield(Mail{}, err)
+				return
+			}
+			for _, m := range page.Data {
+				if !yield(m, nil) {
+					return
+				}
+			}
+			if page.Size < page.Limit || page.Size == 0 {
+				return
+			}
+			afterId = page.Data[page.Size-1].Id
+		}
+	}
+}
+
+// GetMail returns the metadata of a mail.
+func (c *Client) GetMail(ctx context.Context, id int64) (Mail, error) {
+	page, err := c.SeekMails(ctx, 0, 0, Filter{Ids: []int64{id}})
+	if err != nil {
+		return Mail{}, err
+	} else if page.Size == 0 {
+		return Mail{}, fmt.Errorf("%w: %v", ErrMailNotFound, id)
+	}
+	return page.Data[0], nil
+}
+
+// GetEml returns the raw message.
+func (c *Client) GetEml(ctx context.Context, id int64) ([]byte, error) {
+	return c.getBytes(ctx, "/mail/"+strconv.FormatInt(id, 10), nil)
+}
+
+// GetHtml returns the sanitized HTML of a mail.
+func (c *Client) GetHtml(ctx context.Context, id int64) ([]byte, error) {
+	return c.getBytes(ctx, "/mail/"+strconv.FormatInt(id, 10)+"/html", nil)
+}
+
+// GetLinks returns the links of a mail, checked by the server if check is
+// set and the link checker is enabled.
+func (c *Client) GetLinks(ctx context.Context, id int64, check bool) (GetLinksResult, error) {
+	res := GetLinksResult{}
+	q := url.Values{}
+	if check {
+		q.Set("check", "true")
+	}
+	err := c.getJson(ctx, "/mail/"+strconv.FormatInt(id, 10)+"/links", q, &res)
+	return res, err
+}
+
+// Export returns the matching mails as mbox or zip archive, which must be
+// closed by the caller.
+func (c *Client) Export(ctx context.Context, format string, f Filter) (io.ReadCloser, error) {
+	if format != "mbox" && format != "zip" {
+		return nil, fmt.Errorf("unknown export format: %v", format)
+	}
+	res, err := c.do(ctx, http.MethodGet, "/mails/export."+format, f.Query(), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// UploadFile is an .eml, mbox or zip file; the format is detected by the
+// server from the name and content.
+type UploadFile struct {
+	Name string
+	Body io.Reader
+}
+
+// Upload stores the mails of the files. The time source is "now", "date" or
+// "received"; an empty one defaults to "now". Failures of single mails are
+// reported in the result; an error is only returned if all mails failed.
+func (c *Client) Upload(ctx context.Context, timeSource string, files ...UploadFile) (UploadResult, error) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		for _, f := range files {
+			part, err := mw.CreateFormFile("eml", f.Name)
+			if err == nil {
+				_, err = io.Copy(part, f.Body)
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.CloseWithError(mw.Close())
+	}()
+	defer pr.Close()
+	q := url.Values{}
+	if len(timeSource) > 0 {
+		q.Set("time", timeSource)
+	}
+	res := UploadResult{}
+	err := c.sendJson(ctx, http.MethodPost, "/upload", q, mw.FormDataContentType(), pr, &res)
+	if se := new(StatusError); errors.As(err, &se) && se.StatusCode == http.StatusBadRequest {
+		// all mails failed; the result is returned as error details
+		json.Unmarshal(se.Details, &res)
+	}
+	return res, err
+}
+
+// UploadEml stores a single mail and returns its ID.
+func (c *Client) UploadEml(ctx context.Context, r io.Reader) (int64, error) {
+	res, err := c.Upload(ctx, "", UploadFile{Name: "mail.eml", Body: r})
+	if err != nil {
+		return 0, err
+	} else if len(res.Messages) != 1 || len(res.Messages[0].Error) > 0 {
+		return 0, fmt.Errorf("%w: %+v", ErrUnexpectedReply, res.Messages)
+	}
+	return res.Messages[0].Id, nil
+}
+
+// DeleteMails deletes the mails and returns how many were deleted.
+func (c *Client) DeleteMails(ctx context.Context, ids ...int64) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return c.delete(ctx, Filter{Ids: ids}.Query())
+}
+
+// DeleteAllMails deletes all mails and returns how many were deleted.
+func (c *Client) DeleteAllMails(ctx context.Context) (int64, error) {
+	return c.delete(ctx, url.Values{})
+}
+
+func (c *Client) delete(ctx context.Context, q url.Values) (int64, error) {
+	res := DeleteMailsResult{}
+	err := c.sendJson(ctx, http.MethodDelete, "/mails", q, "", nil, &res)
+	return res.NumDeleted, err
+}
+
+func (c *Client) getBytes(ctx context.Context, path string, q url.Values) ([]byte, error) {
+	res, err := c.do(ctx, http.MethodGet, path, q, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	return io.ReadAll(res.Body)
+}
+
+func (c *Client) getJson(ctx context.Context, path string, q url.Values, v any) error {
+	return c.sendJson(ctx, http.MethodGet, path, q, "", nil, v)
+}
+
+func (c *Client) sendJson(ctx context.Context, method, path string, q url.Values,
+	contentType string, body io.Reader, v any) error {
+	res, err := c.do(ctx, method, path, q, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("%w: %w", ErrUnexpectedReply, err)
+	}
+	return nil
+}
+
+// do sends the request and returns the response if its status is 2xx.
+func (c *Client) do(ctx context.Context, method, path string, q url.Values,
+	contentType string, body io.Reader) (*http.Response, error) {
+	token := ""
+	if method != http.MethodGet {
+		if q == nil {
+			q = url.Values{}
+		}
+		token = uuid.NewString()
+		q.Set("csrf-token", token)
+	}
+	u := c.baseURL + apiPrefix + path
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	} else if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if len(token) > 0 {
+		req.Header.Set("X-Csrf-Token", token)
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	} else if res.StatusCode >= 200 && res.StatusCode <= 299 {
+		return res, nil
+	}
+	defer res.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+	apiErr := struct {
+		Code    string          `json:"code"`
+		Message string          `json:"message"`
+		Details json.RawMessage `json:"details"`
+	}{}
+	if json.Unmarshal(msg, &apiErr) != nil {
+		apiErr.Message = strings.TrimSpace(string(msg))
+	}
+	return nil, &StatusError{
+		Method:     method,
+		Path:       path,
+		StatusCode: res.StatusCode,
+		Code:       apiErr.Code,
+		Message:    apiErr.Message,
+		Details:    apiErr.Details,
+	}
+}
@@ -0,0 +1,167 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rntrp/mailheap/internal/linkcheck"
+	"github.com/rntrp/mailheap/internal/model"
+	"github.com/rntrp/mailheap/internal/rest"
+	"github.com/rntrp/mailheap/mailheaptest"
+)
+
+func eml(subject string) string {
+	return "Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"From: alice@example.com\r\nTo: bob@example.com\r\n" +
+		"Subject: " + subject + "\r\n\r\nHello\r\n"
+}
+
+func TestClient(t *testing.T) {
+	srv := mailheaptest.NewServer(t)
+	c := New(srv.URL, nil)
+	ctx := context.Background()
+	files := make([]UploadFile, 0)
+	for i := range 25 {
+		files = append(files, UploadFile{
+			Name: fmt.Sprintf("%v.eml", i),
+			Body: strings.NewReader(eml(fmt.Sprintf("mail %v", i))),
+		})
+	}
+	up, err := c.Upload(ctx, "now", files...)
+	if err != nil || up.NumStored != 25 {
+		t.Fatalf("upload failed: %+v, %v", up, err)
+	}
+	page, err := c.SeekMails(ctx, 0, 10, Filter{})
+	if err != nil || page.Size != 10 || page.Total != 25 {
+		t.Fatalf("unexpected page: %+v, %v", page, err)
+	}
+	n := 0
+	for m, err := range c.Mails(ctx, Filter{Subject: "mail 1"}) {
+		if err != nil {
+			t.Fatal(err)
+		} else if !strings.HasPrefix(m.Subject, "mail 1") {
+			t.Errorf("unexpected subject: %v", m.Subject)
+		}
+		n++
+	}
+	if n != 11 {
+		t.Errorf("expected 11 mails, got %v", n)
+	}
+	id, err := c.UploadEml(ctx, strings.NewReader(eml("single")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, err := c.GetMail(ctx, id); err != nil || m.Subject != "single" {
+		t.Errorf("unexpected mail: %+v, %v", m, err)
+	}
+	if b, err := c.GetEml(ctx, id); err != nil || !strings.Contains(string(b), "Subject: single") {
+		t.Errorf("unexpected eml: %q, %v", b, err)
+	}
+	if n, err := c.DeleteMails(ctx, id); err != nil || n != 1 {
+		t.Errorf("delete failed: %v, %v", n, err)
+	}
+	if _, err := c.GetEml(ctx, id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected not found, got %v", err)
+	}
+	if _, err := c.GetMail(ctx, id); !errors.Is(err, ErrMailNotFound) || !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected not found, got %v", err)
+	}
+	if n, err := c.DeleteAllMails(ctx); err != nil || n != 25 {
+		t.Errorf("delete all failed: %v, %v", n, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	srv := mailheaptest.NewServer(t)
+	c := New(srv.URL, nil)
+	ctx := context.Background()
+	_, err := c.do(ctx, http.MethodGet, "/mails/x", nil, "", nil)
+	if se := new(StatusError); !errors.As(err, &se) || !errors.Is(err, ErrBadRequest) || se.Code != "bad_request" {
+		t.Errorf("expected bad request, got %v", err)
+	}
+	res, err := c.Upload(ctx, "", UploadFile{Name: "bad.eml", Body: strings.NewReader("no mail at all")})
+	if !errors.Is(err, ErrBadRequest) || res.NumFailed != 1 {
+		t.Errorf("expected failed upload, got %+v, %v", res, err)
+	}
+	r, err := c.Export(ctx, "mbox", Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if b, err := io.ReadAll(r); err != nil || len(b) != 0 {
+		t.Errorf("expected empty export, got %q, %v", b, err)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	srv := mailheaptest.NewServer(t)
+	c := New(srv.URL, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := c.UploadEml(ctx, strings.NewReader(eml("wanted before"))); err != nil {
+		t.Fatal(err)
+	}
+	sub, err := c.Subscribe(ctx, Filter{Subject: "wanted"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	// the imported mail gets a lower ID than the mails stored before
+	old := "Date: Tue, 15 Jun 2010 12:00:00 +0000\r\nSubject: wanted old\r\n\r\nHello\r\n"
+	res, err := c.Upload(ctx, "date", UploadFile{Name: "other.eml", Body: strings.NewReader(eml("other"))},
+		UploadFile{Name: "old.eml", Body: strings.NewReader(old)})
+	if err != nil || res.NumStored != 2 {
+		t.Fatalf("upload failed: %+v, %v", res, err)
+	}
+	if m, err := sub.Next(ctx); err != nil {
+		t.Fatal(err)
+	} else if m.Subject != "wanted old" || m.Id != res.Messages[1].Id || len(m.Mime) != 0 {
+		t.Errorf("unexpected mail %+v", m)
+	}
+}
+
+// jsonFields returns the JSON names of the fields of the struct type,
+// including the fields of embedded structs.
+func jsonFields(typ reflect.Type) []string {
+	res := make([]string, 0)
+	for i := range typ.NumField() {
+		f := typ.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if f.Anonymous {
+			res = append(res, jsonFields(f.Type)...)
+		} else if name != "-" {
+			res = append(res, name)
+		}
+	}
+	slices.Sort(res)
+	return res
+}
+
+func TestWireTypes(t *testing.T) {
+	for _, tc := range []struct{ client, server any }{
+		{Mail{}, model.Mail{}},
+		{SeekMailsResult{}, rest.SeekMailsResult{}},
+		{DeleteMailsResult{}, rest.DeleteMailsResult{}},
+		{GetLinksResult{}, rest.GetLinksResult{}},
+		{Link{}, rest.LinkResult{}},
+		{LinkCheck{}, linkcheck.Result{}},
+		{Redirect{}, linkcheck.Redirect{}},
+		{UploadResult{}, rest.UploadResult{}},
+		{UploadMessage{}, rest.UploadMessage{}},
+		{feedCommand{}, rest.FeedCommand{}},
+		{feedMessage{}, rest.FeedMessage{}},
+	} {
+		got, want := jsonFields(reflect.TypeOf(tc.client)), jsonFields(reflect.TypeOf(tc.server))
+		if !slices.Equal(got, want) {
+			t.Errorf("%T: got fields %v, want %v", tc.client, got, want)
+		}
+	}
+}
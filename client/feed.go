@@ -0,0 +1,114 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/coder/websocket"
+)
+
+const (
+	feedProtocol     = "mailheap"
+	feedReadLimit    = 1 << 20
+	feedStatusResync = websocket.StatusCode(4000)
+	feedSubscribeRef = "subscribe"
+)
+
+// ErrResync is returned by Subscription.Next if the server closed the feed
+// because it missed events, so that received mails may have been skipped.
+var ErrResync = errors.New("feed missed events")
+
+type feedCommand struct {
+	Type   string            `json:"type"`
+	Ref    string            `json:"ref,omitempty"`
+	Filter map[string]string `json:"filter,omitempty"`
+	Ids    []int64           `json:"ids,omitempty"`
+	Seen   *bool             `json:"seen,omitempty"`
+}
+
+type feedMessage struct {
+	Type       string  `json:"type"`
+	Ref        string  `json:"ref,omitempty"`
+	Mail       *Mail   `json:"mail,omitempty"`
+	Ids        []int64 `json:"ids,omitempty"`
+	NumUpdated int64   `json:"numUpdated,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// Subscription receives the mails stored after it has been created, in the
+// order the server stored them, regardless of their IDs. Mails are sent
+// without the MIME content.
+type Subscription struct {
+	conn *websocket.Conn
+}
+
+// Subscribe opens the WebSocket feed of the server and returns once the
+// filter is in effect. The subscription must be closed after use.
+func (c *Client) Subscribe(ctx context.Context, f Filter) (*Subscription, error) {
+	u := "ws" + strings.TrimPrefix(c.baseURL, "http") + apiPrefix + "/ws"
+	conn, _, err := websocket.Dial(ctx, u, &websocket.DialOptions{
+		HTTPClient:   c.http,
+		Subprotocols: []string{feedProtocol},
+	})
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadLimit(feedReadLimit)
+	s := &Subscription{conn: conn}
+	q, filter := f.Query(), make(map[string]string)
+	for k := range q {
+		filter[k] = q.Get(k)
+	}
+	b, _ := json.Marshal(feedCommand{Type: "subscribe", Ref: feedSubscribeRef, Filter: filter})
+	if err := conn.Write(ctx, websocket.MessageText, b); err != nil {
+		conn.CloseNow()
+		return nil, err
+	}
+	// mails received before the filter applies are skipped
+	for {
+		m, err := s.read(ctx)
+		if err != nil {
+			conn.CloseNow()
+			return nil, err
+		} else if m.Ref != feedSubscribeRef {
+			continue
+		} else if m.Type == "error" {
+			conn.CloseNow()
+			return nil, fmt.Errorf("%w: %v", ErrBadRequest, m.Error)
+		}
+		return s, nil
+	}
+}
+
+// Next waits for the next received mail. The subscription is closed if ctx
+// is done.
+func (s *Subscription) Next(ctx context.Context) (Mail, error) {
+	for {
+		m, err := s.read(ctx)
+		if err != nil {
+			return Mail{}, err
+		} else if m.Type == "mail.received" && m.Mail != nil {
+			return *m.Mail, nil
+		}
+	}
+}
+
+func (s *Subscription) Close() error {
+	return s.conn.Close(websocket.StatusNormalClosure, "")
+}
+
+func (s *Subscription) read(ctx context.Context) (feedMessage, error) {
+	m := feedMessage{}
+	_, b, err := s.conn.Read(ctx)
+	if websocket.CloseStatus(err) == feedStatusResync {
+		return m, ErrResync
+	} else if err != nil {
+		return m, err
+	} else if err := json.Unmarshal(b, &m); err != nil {
+		return m, fmt.Errorf("%w: %w", ErrUnexpectedReply, err)
+	}
+	return m, nil
+}
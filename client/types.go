@@ -0,0 +1,80 @@
+package client
+
+import "time"
+
+// Mail is the metadata of a mail. From, To, Cc, Bcc, Tags, SpamRules and
+// Trackers hold JSON arrays as returned by the server. Mime is only set if
+// requested.
+type Mail struct {
+	Id        int64     `json:"id"`
+	Created   time.Time `json:"created"`
+	Date      time.Time `json:"date"`
+	Subject   string    `json:"subject"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Cc        string    `json:"cc"`
+	Bcc       string    `json:"bcc"`
+	Size      int32     `json:"size"`
+	SpamScore float64   `json:"spamScore"`
+	SpamRules string    `json:"spamRules,omitempty"`
+	Trackers  string    `json:"trackers"`
+	Seen      bool      `json:"seen"`
+	Starred   bool      `json:"starred"`
+	Tags      string    `json:"tags"`
+	Mailbox   string    `json:"mailbox"`
+	Mime      string    `json:"mime,omitempty"`
+}
+
+// SeekMailsResult is a page of mails, newest first.
+type SeekMailsResult struct {
+	Id    int64  `json:"id"`
+	Total int64  `json:"total"`
+	Limit int    `json:"limit"`
+	Size  int    `json:"size"`
+	Data  []Mail `json:"data"`
+}
+
+type DeleteMailsResult struct {
+	NumDeleted int64 `json:"numDeleted"`
+}
+
+type GetLinksResult struct {
+	Id    int64  `json:"id"`
+	Links []Link `json:"links"`
+}
+
+// Link is a link of a mail. Check is only set if the links were checked.
+type Link struct {
+	Url    string     `json:"url"`
+	Text   string     `json:"text"`
+	Source string     `json:"source"`
+	Check  *LinkCheck `json:"check,omitempty"`
+}
+
+// LinkCheck is the result of requesting a link. Status is the status of the
+// last response, Error is set if no response was received.
+type LinkCheck struct {
+	Status    int        `json:"status,omitempty"`
+	Redirects []Redirect `json:"redirects,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+type Redirect struct {
+	Status   int    `json:"status"`
+	Location string `json:"location"`
+}
+
+type UploadResult struct {
+	NumStored int             `json:"numStored"`
+	NumFailed int             `json:"numFailed"`
+	Messages  []UploadMessage `json:"messages"`
+}
+
+// UploadMessage reports the ID or the error of a single uploaded mail. Entry
+// names the message within an mbox or zip file.
+type UploadMessage struct {
+	File  string `json:"file"`
+	Entry string `json:"entry,omitempty"`
+	Id    int64  `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
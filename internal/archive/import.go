@@ -0,0 +1,235 @@
+package archive
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rntrp/mailheap/internal/config"
+)
+
+// ReadFunc is called for every message found in an archive. The entry is
+// the name of the zip entry or the 1-based position within an mbox file.
+// Returning an error aborts the import of the remaining messages.
+type ReadFunc func(entry string, r io.Reader) error
+
+var (
+	ErrEntryTooLarge  = errors.New("archive entry exceeds MAILHEAP_SMTP_MAX_MESSAGE_BYTES")
+	ErrTooManyEntries = errors.New("archive exceeds the maximum number of entries")
+)
+
+// maxEntries limits the number of messages of a single archive. Entries are
+// limited in size like received mails by MAILHEAP_SMTP_MAX_MESSAGE_BYTES.
+var maxEntries = 100000
+
+const (
+	formatEml  = "eml"
+	formatMbox = "mbox"
+	formatZip  = "zip"
+)
+
+// Read detects whether r is a zip archive, an mbox file or a single message
+// by the file name and the first bytes of the content.
+func Read(name string, r io.ReaderAt, size int64, fn ReadFunc) error {
+	switch detect(name, r) {
+	case formatZip:
+		return ReadZip(r, size, fn)
+	case formatMbox:
+		return ReadMbox(io.NewSectionReader(r, 0, size), fn)
+	default:
+		return fn("", io.NewSectionReader(r, 0, size))
+	}
+}
+
+func detect(name string, r io.ReaderAt) string {
+	if format := detectExt(name); len(format) > 0 {
+		return format
+	}
+	magic := make([]byte, 5)
+	n, _ := r.ReadAt(magic, 0)
+	switch magic = magic[:n]; {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		return formatZip
+	case bytes.Equal(magic, []byte("From ")):
+		return formatMbox
+	default:
+		return formatEml
+	}
+}
+
+// ReadMbox splits an mboxrd file on From_ lines and removes one level of
+// '>' quoting from lines matching ^>+From.
+func ReadMbox(r io.Reader, fn ReadFunc) error {
+	br := bufio.NewReader(r)
+	buf := new(bytes.Buffer)
+	max := config.GetSMTPMaxMessageBytes()
+	cnt := 0
+	emit := func() error {
+		if cnt == 0 {
+			return nil
+		}
+		b := buf.Bytes()
+		// the empty line preceding the next From_ line is not part of the message
+		if bytes.HasSuffix(b, []byte("\r\n\r\n")) {
+			b = b[:len(b)-2]
+		} else if bytes.HasSuffix(b, []byte("\n\n")) {
+			b = b[:len(b)-1]
+		}
+		err := fn(strconv.Itoa(cnt), &limitReader{r: bytes.NewReader(b), n: max})
+		buf.Reset()
+		return err
+	}
+	// lines longer than the buffer are read in pieces, only the first one
+	// starts a line
+	bol := true
+	for {
+		line, err := br.ReadSlice('\n')
+		start := bol
+		bol = err != bufio.ErrBufferFull
+		if len(line) > 0 {
+			if start && bytes.HasPrefix(line, []byte("From ")) {
+				if err := emit(); err != nil {
+					return err
+				} else if cnt++; cnt > maxEntries {
+					return fmt.Errorf("%w: %d", ErrTooManyEntries, maxEntries)
+				}
+			} else if cnt > 0 {
+				if start && isFromLine(string(line)) && line[0] == '>' {
+					line = line[1:]
+				}
+				// keeping one byte beyond the limit suffices to fail the entry
+				if room := max + 1 - int64(buf.Len()); max <= 0 || room > int64(len(line)) {
+					buf.Write(line)
+				} else if room > 0 {
+					buf.Write(line[:room])
+				}
+			}
+		}
+		if err == io.EOF {
+			return emit()
+		} else if err != nil && err != bufio.ErrBufferFull {
+			return err
+		}
+	}
+}
+
+// ReadZip reads all .eml entries of a zip archive in the stored order.
+func ReadZip(r io.ReaderAt, size int64, fn ReadFunc) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	} else if len(zr.File) > maxEntries {
+		return fmt.Errorf("%w: %d", ErrTooManyEntries, maxEntries)
+	}
+	max := config.GetSMTPMaxMessageBytes()
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !strings.EqualFold(path.Ext(f.Name), ".eml") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = fn(f.Name, &limitReader{r: rc, n: max})
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WalkDir imports every file below dir: .eml, .mbox and .zip files as well
+// as messages in the cur and new folders of a Maildir. The entries passed to
+// fn are prefixed with the path relative to dir.
+func WalkDir(dir string, fn ReadFunc) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if d.IsDir() {
+			if d.Name() == "tmp" && isMaildir(filepath.Dir(p)) {
+				return filepath.SkipDir
+			}
+			return nil
+		} else if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		parent := filepath.Dir(p)
+		inMaildir := (filepath.Base(parent) == "cur" || filepath.Base(parent) == "new") &&
+			isMaildir(filepath.Dir(parent))
+		if !inMaildir && len(detectExt(p)) == 0 {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		return Read(p, f, info.Size(), func(entry string, r io.Reader) error {
+			if len(entry) == 0 {
+				return fn(rel, r)
+			}
+			return fn(rel+"#"+entry, r)
+		})
+	})
+}
+
+func detectExt(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".zip":
+		return formatZip
+	case ".mbox", ".mbx":
+		return formatMbox
+	case ".eml":
+		return formatEml
+	}
+	return ""
+}
+
+func isMaildir(dir string) bool {
+	for _, sub := range []string{"cur", "new"} {
+		if info, err := os.Stat(filepath.Join(dir, sub)); err != nil || !info.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+// limitReader fails with ErrEntryTooLarge once more than n bytes have been
+// read. A limit of 0 or less disables the check.
+type limitReader struct {
+	r        io.Reader
+	n        int64
+	read     int64
+	exceeded bool
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.exceeded {
+		return 0, ErrEntryTooLarge
+	}
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.n > 0 && l.read > l.n {
+		l.exceeded = true
+		return n, ErrEntryTooLarge
+	}
+	return n, err
+}
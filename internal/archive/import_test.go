@@ -0,0 +1,132 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/rntrp/mailheap/internal/config"
+)
+
+// limitEntries sets the maximum entry size and count for the test.
+func limitEntries(t *testing.T, size string, entries int) {
+	t.Helper()
+	t.Setenv("MAILHEAP_ENV", "test")
+	t.Setenv("MAILHEAP_SMTP_MAX_MESSAGE_BYTES", size)
+	if err := config.LoadQuietly(); err != nil {
+		t.Fatal(err)
+	}
+	old := maxEntries
+	maxEntries = entries
+	t.Cleanup(func() { maxEntries = old })
+}
+
+func zipOf(t *testing.T, files ...string) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for i := 0; i < len(files); i += 2 {
+		f, err := w.Create(files[i])
+		if err != nil {
+			t.Fatal(err)
+		} else if _, err := io.WriteString(f, files[i+1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+type entry struct {
+	name, data string
+	err        error
+}
+
+func readAll(name string, b []byte) ([]entry, error) {
+	entries := make([]entry, 0)
+	err := Read(name, bytes.NewReader(b), int64(len(b)), func(name string, r io.Reader) error {
+		data, err := io.ReadAll(r)
+		entries = append(entries, entry{name, string(data), err})
+		return nil
+	})
+	return entries, err
+}
+
+func TestReadZip(t *testing.T) {
+	limitEntries(t, "64", 5)
+	b := zipOf(t, "a.eml", "Subject: a\r\n\r\nA\r\n",
+		"dir/", "",
+		"notes.txt", "not a mail",
+		"big.EML", "Subject: big\r\n\r\n"+strings.Repeat("B", 64),
+		"dir/c.eml", "Subject: c\r\n\r\nC\r\n")
+	entries, err := readAll("upload.bin", b)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 3 {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+	if e := entries[0]; e.name != "a.eml" || e.data != "Subject: a\r\n\r\nA\r\n" || e.err != nil {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if e := entries[1]; e.name != "big.EML" || !errors.Is(e.err, ErrEntryTooLarge) {
+		t.Errorf("oversized entry not failed: %+v", e)
+	}
+	if e := entries[2]; e.name != "dir/c.eml" || e.err != nil {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	b = zipOf(t, "1.eml", "", "2.eml", "", "3.eml", "", "4.eml", "", "5.eml", "", "6.eml", "")
+	if entries, err := readAll("mails.zip", b); !errors.Is(err, ErrTooManyEntries) || len(entries) != 0 {
+		t.Errorf("too many entries not rejected: %v %v", len(entries), err)
+	}
+}
+
+func TestReadMboxLimits(t *testing.T) {
+	limitEntries(t, "64", 2)
+	long := strings.Repeat("x", 8192)
+	mbox := "From a@b.c Mon Oct 19 11:39:27 2026\nSubject: a\n\n" + long + "\n\n" +
+		"From a@b.c Mon Oct 19 11:39:27 2026\nSubject: b\n\nB\n\n" +
+		"From a@b.c Mon Oct 19 11:39:27 2026\nSubject: c\n\nC\n"
+	entries, err := readAll("", []byte(mbox))
+	if !errors.Is(err, ErrTooManyEntries) {
+		t.Errorf("too many entries not rejected: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("unexpected entries %+v", entries)
+	} else if e := entries[0]; !errors.Is(e.err, ErrEntryTooLarge) {
+		t.Errorf("oversized entry not failed: %v", e.err)
+	} else if e := entries[1]; e.name != "2" || e.data != "Subject: b\n\nB\n" || e.err != nil {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	// From_ lines are only recognized at the start of a line
+	limitEntries(t, "0", 2)
+	mbox = "From a@b.c Mon Oct 19 11:39:27 2026\nSubject: a\n\n" + long + "From x\n"
+	if entries, err := readAll("", []byte(mbox)); err != nil || len(entries) != 1 ||
+		entries[0].data != "Subject: a\n\n"+long+"From x\n" {
+		t.Errorf("unexpected entries %v: %v", len(entries), err)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	zipped := string(zipOf(t, "a.eml", ""))
+	for _, tc := range []struct {
+		name, content, format string
+	}{
+		{"mails.zip", "", formatZip},
+		{"MAILS.MBOX", "", formatMbox},
+		{"inbox.mbx", "", formatMbox},
+		{"mail.eml", "From a@b.c Mon Oct 19 11:39:27 2026\n", formatEml},
+		{"upload", zipped, formatZip},
+		{"upload", "From a@b.c Mon Oct 19 11:39:27 2026\n", formatMbox},
+		{"upload", "From: a@b.c\r\n", formatEml},
+		{"upload", "", formatEml},
+	} {
+		if got := detect(tc.name, strings.NewReader(tc.content)); got != tc.format {
+			t.Errorf("%v %.8q: got %v, want %v", tc.name, tc.content, got, tc.format)
+		}
+	}
+}
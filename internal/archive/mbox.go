@@ -0,0 +1,44 @@
+package archive
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+)
+
+// MboxWriter writes messages in the mboxrd format: lines matching ^>*From
+// are quoted with an additional '>' and line endings are normalized to LF.
+type MboxWriter struct {
+	w *bufio.Writer
+}
+
+func NewMboxWriter(w io.Writer) *MboxWriter {
+	return &MboxWriter{w: bufio.NewWriter(w)}
+}
+
+func (m *MboxWriter) Write(sender string, t time.Time, mime string) error {
+	if len(sender) == 0 {
+		sender = "MAILER-DAEMON"
+	}
+	m.w.WriteString("From " + sender + " " + t.UTC().Format(time.ANSIC) + "\n")
+	for line := range strings.Lines(mime) {
+		line = strings.TrimRight(line, "\r\n")
+		if isFromLine(line) {
+			m.w.WriteByte('>')
+		}
+		m.w.WriteString(line)
+		if err := m.w.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	return m.w.WriteByte('\n')
+}
+
+func (m *MboxWriter) Flush() error {
+	return m.w.Flush()
+}
+
+func isFromLine(line string) bool {
+	return strings.HasPrefix(strings.TrimLeft(line, ">"), "From ")
+}
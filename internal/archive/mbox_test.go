@@ -0,0 +1,45 @@
+package archive
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestMboxRoundTrip(t *testing.T) {
+	mails := []string{
+		"Subject: a\r\n\r\nFrom the start\r\n>From quoted\r\n",
+		"Subject: b\n\nbody\n\n",
+	}
+	expected := []string{
+		"Subject: a\n\nFrom the start\n>From quoted\n",
+		"Subject: b\n\nbody\n\n",
+	}
+	buf := new(bytes.Buffer)
+	w := NewMboxWriter(buf)
+	for _, m := range mails {
+		if err := w.Write("a@b.c", time.Now(), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	read := make([]string, 0)
+	err := ReadMbox(buf, func(entry string, r io.Reader) error {
+		b, err := io.ReadAll(r)
+		read = append(read, string(b))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(read) != len(expected) {
+		t.Fatalf("expected %v mails, got %v", len(expected), len(read))
+	}
+	for i := range expected {
+		if read[i] != expected[i] {
+			t.Errorf("mail %v: expected %q, got %q", i, expected[i], read[i])
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package archive
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+const ManifestName = "manifest.json"
+
+type ManifestEntry struct {
+	Id      int64     `json:"id"`
+	File    string    `json:"file"`
+	Created time.Time `json:"created"`
+	Date    time.Time `json:"date"`
+	Subject string    `json:"subject"`
+	From    []string  `json:"from"`
+	To      []string  `json:"to"`
+	Size    int32     `json:"size"`
+}
+
+// ZipWriter writes one .eml file per message and appends a manifest
+// describing all entries on Close.
+type ZipWriter struct {
+	w        *zip.Writer
+	manifest []ManifestEntry
+}
+
+func NewZipWriter(w io.Writer) *ZipWriter {
+	return &ZipWriter{w: zip.NewWriter(w), manifest: make([]ManifestEntry, 0)}
+}
+
+func (z *ZipWriter) Write(e ManifestEntry, mime string) error {
+	e.File = fmt.Sprintf("%v.eml", e.Id)
+	f, err := z.w.CreateHeader(&zip.FileHeader{
+		Name:     e.File,
+		Method:   zip.Deflate,
+		Modified: e.Created,
+	})
+	if err != nil {
+		return err
+	} else if _, err := io.WriteString(f, mime); err != nil {
+		return err
+	}
+	z.manifest = append(z.manifest, e)
+	return nil
+}
+
+func (z *ZipWriter) Close() error {
+	f, err := z.w.Create(ManifestName)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(z.manifest); err != nil {
+		return err
+	}
+	return z.w.Close()
+}
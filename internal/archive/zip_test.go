@@ -0,0 +1,66 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestZipWriter(t *testing.T) {
+	created := time.Date(2026, 10, 19, 11, 39, 27, 0, time.UTC)
+	buf := new(bytes.Buffer)
+	z := NewZipWriter(buf)
+	for _, e := range []ManifestEntry{
+		{Id: 7, Created: created, Subject: "a", From: []string{"alice@example.com"}, Size: 20},
+		{Id: 3, Created: created.Add(time.Hour), Subject: "b", To: []string{}, Size: 21},
+	} {
+		if err := z.Write(e, "Subject: "+e.Subject+"\r\n\r\nHello\r\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	names := make([]string, 0)
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, f.Name)
+		files[f.Name] = string(b)
+		if f.Name == "7.eml" && !f.Modified.Equal(created) {
+			t.Errorf("unexpected modification time %v", f.Modified)
+		}
+	}
+	if len(names) != 3 || names[0] != "7.eml" || names[1] != "3.eml" || names[2] != ManifestName {
+		t.Fatalf("unexpected entries %v", names)
+	} else if files["3.eml"] != "Subject: b\r\n\r\nHello\r\n" {
+		t.Errorf("unexpected mail %q", files["3.eml"])
+	}
+	manifest := make([]ManifestEntry, 0)
+	if err := json.Unmarshal([]byte(files[ManifestName]), &manifest); err != nil {
+		t.Fatal(err)
+	} else if len(manifest) != 2 {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+	if e := manifest[0]; e.Id != 7 || e.File != "7.eml" || !e.Created.Equal(created) || e.Subject != "a" ||
+		len(e.From) != 1 || e.From[0] != "alice@example.com" || e.Size != 20 {
+		t.Errorf("unexpected entry %+v", e)
+	} else if e := manifest[1]; e.Id != 3 || e.File != "3.eml" || e.Subject != "b" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+}
@@ -0,0 +1,25 @@
+package cli
+
+import (
+	"flag"
+	"strings"
+
+	"github.com/rntrp/mailheap/client"
+	"github.com/rntrp/mailheap/internal/config"
+)
+
+// apiFlags registers the -url flag and returns a constructor of the REST API
+// client of a running instance.
+func apiFlags(fs *flag.FlagSet) func() *client.Client {
+	base := fs.String("url", "", "base `URL` of the instance (default derived from MAILHEAP_HTTP_TCP_ADDRESS)")
+	return func() *client.Client {
+		if len(*base) > 0 {
+			return client.New(*base, nil)
+		}
+		addr := config.GetHTTPTCPAddress()
+		if strings.HasPrefix(addr, ":") {
+			addr = "localhost" + addr
+		}
+		return client.New("http://"+addr, nil)
+	}
+}
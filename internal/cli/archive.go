@@ -0,0 +1,119 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rntrp/mailheap/client"
+	"github.com/rntrp/mailheap/internal/archive"
+	"github.com/rntrp/mailheap/internal/msg"
+)
+
+// importMails uploads .eml, mbox and zip files as they are, since the server
+// detects the format itself. Directories are read locally and each message is
+// uploaded as a separate .eml part.
+func importMails(fs *flag.FlagSet, args []string) error {
+	api := apiFlags(fs)
+	timeSrc := fs.String("time", "now", "`source` of the received time: now, date or received")
+	if err := parse(fs, args); err != nil {
+		return err
+	} else if fs.NArg() == 0 {
+		return usageError{"no files or directories given"}
+	} else if _, err := msg.ParseTimeSource(*timeSrc); err != nil {
+		return usageError{err.Error()}
+	}
+	files := make([]client.UploadFile, 0, fs.NArg())
+	for _, path := range fs.Args() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		} else if info.IsDir() {
+			if files, err = appendDir(files, path); err != nil {
+				return err
+			}
+			continue
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		files = append(files, client.UploadFile{Name: filepath.Base(path), Body: f})
+	}
+	result, err := api().Upload(context.Background(), *timeSrc, files...)
+	if err != nil && result.NumFailed == 0 {
+		return err
+	}
+	for _, m := range result.Messages {
+		name := m.File
+		if len(m.Entry) > 0 && m.Entry != m.File {
+			name += ":" + m.Entry
+		}
+		if len(m.Error) > 0 {
+			fmt.Fprintf(stderr, "%v: %v\n", name, m.Error)
+		} else {
+			fmt.Fprintf(stdout, "%v\t%v\n", m.Id, name)
+		}
+	}
+	if result.NumFailed > 0 {
+		return fmt.Errorf("%v of %v mails failed", result.NumFailed,
+			result.NumFailed+result.NumStored)
+	}
+	return nil
+}
+
+func appendDir(files []client.UploadFile, dir string) ([]client.UploadFile, error) {
+	err := archive.WalkDir(dir, func(entry string, r io.Reader) error {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(entry)
+		if !strings.HasSuffix(strings.ToLower(name), ".eml") {
+			name += ".eml"
+		}
+		files = append(files, client.UploadFile{Name: name, Body: bytes.NewReader(b)})
+		return nil
+	})
+	return files, err
+}
+
+func export(fs *flag.FlagSet, args []string) error {
+	api := apiFlags(fs)
+	filter := filterFlags(fs)
+	format := fs.String("format", "mbox", "archive `format`: mbox or zip")
+	out := fs.String("o", "", "output `file` instead of stdout")
+	if err := parse(fs, args); err != nil {
+		return err
+	} else if fs.NArg() > 0 {
+		return usageError{"unexpected arguments"}
+	} else if *format != "mbox" && *format != "zip" {
+		return usageError{"unknown format: " + *format}
+	}
+	f, err := filter()
+	if err != nil {
+		return err
+	}
+	r, err := api().Export(context.Background(), *format, f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if len(*out) == 0 {
+		_, err = io.Copy(stdout, r)
+		return err
+	}
+	file, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, r)
+	return errors.Join(err, file.Close())
+}
@@ -0,0 +1,201 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rntrp/mailheap/client"
+	"github.com/rntrp/mailheap/internal/config"
+)
+
+// Exit codes of the subcommands.
+const (
+	ExitOK       = 0
+	ExitError    = 1
+	ExitUsage    = 2
+	ExitNotFound = 3
+	ExitTimeout  = 4
+)
+
+var (
+	errNotFound = client.ErrNotFound
+	errTimeout  = errors.New("timed out")
+)
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(fs *flag.FlagSet, args []string) error
+	// config binds the config values as flags
+	config bool
+}
+
+var stdout io.Writer = os.Stdout
+var stderr io.Writer = os.Stderr
+
+// Run executes the subcommand given by args and returns the exit code. Without
+// a subcommand or if the first argument is a flag, the servers are started.
+func Run(args []string, serve func()) int {
+	commands := []command{
+		{"serve", "[config flags]", "start the SMTP, HTTP, IMAP and POP3 servers",
+			func(fs *flag.FlagSet, args []string) error {
+				if err := parseFlags(fs, args); err != nil {
+					return err
+				} else if fs.NArg() > 0 {
+					return usageError{"unexpected arguments"}
+				}
+				if err := config.Load(); err != nil {
+					return err
+				}
+				serve()
+				return nil
+			}, true},
+		{"config", "check [config flags]", "validate the config without starting the servers", checkConfig, true},
+		{"send", "[flags] [file.eml ...]", "submit .eml files or compose a mail to an SMTP server", send, true},
+		{"list", "[flags]", "list mails of a running instance", list, false},
+		{"get", "[flags] <id>", "print a mail of a running instance", get, false},
+		{"delete", "[flags] <id ...>|-all", "delete mails of a running instance", del, false},
+		{"wait", "[flags]", "wait for a matching mail to arrive at a running instance", wait, false},
+		{"import", "[flags] <file|dir ...>", "upload .eml, mbox and zip files or directories", importMails, false},
+		{"export", "[flags]", "download mails as mbox or zip archive", export, false},
+	}
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		usage(commands)
+		return ExitOK
+	}
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		fs := flag.NewFlagSet("mailheap "+cmd.name, flag.ContinueOnError)
+		fs.SetOutput(stderr)
+		fs.Usage = func() {
+			fmt.Fprintf(stderr, "Usage: mailheap %v %v\n\n%v\n\nFlags:\n", cmd.name, cmd.args, cmd.summary)
+			fs.PrintDefaults()
+		}
+		if cmd.config {
+			config.BindFlags(fs)
+		}
+		err := cmd.run(fs, args)
+		switch {
+		case err == nil:
+			return ExitOK
+		case errors.Is(err, flag.ErrHelp):
+			return ExitOK
+		case errors.As(err, new(usageError)):
+			fmt.Fprintln(stderr, "mailheap "+cmd.name+": "+err.Error())
+			fs.Usage()
+			return ExitUsage
+		case errors.Is(err, errNotFound):
+			fmt.Fprintln(stderr, "mailheap "+cmd.name+": "+err.Error())
+			return ExitNotFound
+		case errors.Is(err, errTimeout):
+			fmt.Fprintln(stderr, "mailheap "+cmd.name+": "+err.Error())
+			return ExitTimeout
+		default:
+			fmt.Fprintln(stderr, "mailheap "+cmd.name+": "+err.Error())
+			return ExitError
+		}
+	}
+	fmt.Fprintf(stderr, "mailheap: unknown command %q\n\n", name)
+	usage(commands)
+	return ExitUsage
+}
+
+func usage(commands []command) {
+	fmt.Fprintln(stderr, "Usage: mailheap <command> [flags]\n\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(stderr, "  %-8v %v\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(stderr, "\nserve, config check and send accept the config values as flags, e.g.\n"+
+		"-smtp-address for MAILHEAP_SMTP_ADDRESS. The other commands read the\n"+
+		"config from the environment and the config file only. Run 'mailheap <command> -h' for details.\n\n"+
+		"Exit codes: %v ok, %v error, %v usage, %v not found, %v timeout\n",
+		ExitOK, ExitError, ExitUsage, ExitNotFound, ExitTimeout)
+}
+
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string {
+	return e.msg
+}
+
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return err
+	} else if err != nil {
+		return usageError{err.Error()}
+	}
+	return nil
+}
+
+// parse parses the flags and loads the config without logging it, so that
+// the output of the client commands can be processed by scripts.
+func parse(fs *flag.FlagSet, args []string) error {
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	return config.LoadQuietly()
+}
+
+// filterFlags registers the flags of the REST API filter query parameters.
+func filterFlags(fs *flag.FlagSet) func() (client.Filter, error) {
+	f := client.Filter{}
+	fs.StringVar(&f.To, "to", "", "recipient has the `address`")
+	fs.StringVar(&f.From, "from", "", "sender contains `text`")
+	fs.StringVar(&f.Subject, "subject", "", "subject contains `text`")
+	fs.StringVar(&f.Tag, "tag", "", "mail has the `tag`")
+	fs.StringVar(&f.Mailbox, "mailbox", "", "mail was delivered to the `mailbox`")
+	seen := fs.String("seen", "", "mail has (not) been seen (`bool`)")
+	starred := fs.String("starred", "", "mail has (not) been starred (`bool`)")
+	since := fs.String("since", "", "mail received at or after the RFC 3339 `time`")
+	until := fs.String("until", "", "mail received before the RFC 3339 `time`")
+	return func() (client.Filter, error) {
+		var err error
+		if f.Seen, err = parseOptBool("seen", *seen); err != nil {
+			return f, err
+		} else if f.Starred, err = parseOptBool("starred", *starred); err != nil {
+			return f, err
+		} else if f.Since, err = parseOptTime("since", *since); err != nil {
+			return f, err
+		} else if f.Until, err = parseOptTime("until", *until); err != nil {
+			return f, err
+		}
+		return f, nil
+	}
+}
+
+func parseOptBool(name, s string) (*bool, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return nil, usageError{"-" + name + " must be a boolean"}
+	}
+	return &b, nil
+}
+
+func parseOptTime(name, s string) (time.Time, error) {
+	if len(s) == 0 {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, usageError{"-" + name + " must be an RFC 3339 timestamp"}
+	}
+	return t, nil
+}
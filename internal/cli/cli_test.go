@@ -0,0 +1,139 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rntrp/mailheap/client"
+	"github.com/rntrp/mailheap/mailheaptest"
+)
+
+func sendMail(t *testing.T, srv *mailheaptest.Server, subject string) {
+	t.Helper()
+	body := "Date: " + time.Now().Format(time.RFC1123Z) + "\r\nFrom: alice@example.com\r\n" +
+		"To: bob@example.com\r\nSubject: " + subject + "\r\n\r\nHello\r\n"
+	err := smtp.SendMail(srv.SMTPAddr, nil, "alice@example.com", []string{"bob@example.com"}, []byte(body))
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// run executes the command and returns the exit code and the output.
+func run(args ...string) (int, string, string) {
+	out, errOut := new(bytes.Buffer), new(bytes.Buffer)
+	oldOut, oldErr := stdout, stderr
+	stdout, stderr = out, errOut
+	defer func() { stdout, stderr = oldOut, oldErr }()
+	code := Run(args, func() { panic("serve called") })
+	return code, out.String(), errOut.String()
+}
+
+func TestMails(t *testing.T) {
+	srv := mailheaptest.NewServer(t)
+	sendMail(t, srv, "first")
+	first := srv.WaitForMail(5*time.Second, mailheaptest.Subject("first"))
+	sendMail(t, srv, "second")
+	srv.WaitForMail(5*time.Second, mailheaptest.Subject("second"))
+	msgs := srv.Messages()
+	code, out, _ := run("list", "-url", srv.URL)
+	if lines := strings.Split(strings.TrimSpace(out), "\n"); code != ExitOK || len(lines) != 2 {
+		t.Fatalf("unexpected list %v %q", code, out)
+	} else if !strings.HasPrefix(lines[0], fmt.Sprint(msgs[1].Id)+"\t") ||
+		!strings.HasSuffix(lines[0], "\talice@example.com\tsecond") {
+		t.Errorf("unexpected line %q", lines[0])
+	}
+	if code, out, _ := run("list", "-url", srv.URL, "-subject", "first", "-json"); code != ExitOK ||
+		!strings.Contains(out, `"subject":"first"`) || strings.Contains(out, `"subject":"second"`) {
+		t.Errorf("unexpected list %v %q", code, out)
+	}
+	if code, out, _ := run("get", "-url", srv.URL, fmt.Sprint(first.Id)); code != ExitOK ||
+		!strings.Contains(out, "Subject: first\r\n") {
+		t.Errorf("unexpected mail %v %q", code, out)
+	}
+	if code, _, errOut := run("get", "-url", srv.URL, "1"); code != ExitNotFound || len(errOut) == 0 {
+		t.Errorf("unexpected exit code %v", code)
+	}
+	if code, out, _ := run("delete", "-url", srv.URL, fmt.Sprint(first.Id)); code != ExitOK || out != "1\n" {
+		t.Errorf("unexpected deletion %v %q", code, out)
+	}
+	if code, out, _ := run("delete", "-url", srv.URL, fmt.Sprint(first.Id)); code != ExitNotFound || out != "0\n" {
+		t.Errorf("unexpected deletion %v %q", code, out)
+	}
+	if code, out, _ := run("delete", "-url", srv.URL, "-all"); code != ExitOK || out != "1\n" {
+		t.Errorf("unexpected deletion %v %q", code, out)
+	}
+}
+
+func TestWait(t *testing.T) {
+	srv := mailheaptest.NewServer(t)
+	sendMail(t, srv, "old")
+	old := srv.WaitForMail(5*time.Second, mailheaptest.Subject("old"))
+	if code, out, _ := run("wait", "-url", srv.URL, "-existing", "-timeout", "1s"); code != ExitOK ||
+		out != fmt.Sprintln(old.Id) {
+		t.Errorf("unexpected wait %v %q", code, out)
+	}
+	if code, _, errOut := run("wait", "-url", srv.URL, "-timeout", "300ms"); code != ExitTimeout ||
+		!strings.Contains(errOut, "timed out") {
+		t.Errorf("unexpected exit code %v %q", code, errOut)
+	}
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		sendMail(t, srv, "other")
+		sendMail(t, srv, "new")
+	}()
+	code, out, _ := run("wait", "-url", srv.URL, "-subject", "new", "-timeout", "5s", "-format", "eml")
+	if code != ExitOK || !strings.Contains(out, "Subject: new\r\n") {
+		t.Errorf("unexpected wait %v %q", code, out)
+	}
+	// imported mails are found despite the low ID of their old date
+	imported := make(chan int64, 1)
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		res, err := client.New(srv.URL, nil).Upload(context.Background(), "date", client.UploadFile{
+			Name: "old.eml",
+			Body: strings.NewReader("Date: Tue, 15 Jun 2010 12:00:00 +0000\r\nSubject: imported\r\n\r\nHello\r\n"),
+		})
+		if err != nil || res.NumStored != 1 {
+			t.Errorf("import failed: %+v, %v", res, err)
+			imported <- 0
+			return
+		}
+		imported <- res.Messages[0].Id
+	}()
+	code, out, _ = run("wait", "-url", srv.URL, "-timeout", "5s")
+	if id := <-imported; code != ExitOK || out != fmt.Sprintln(id) {
+		t.Errorf("unexpected wait %v %q, want %v", code, out, id)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	for _, args := range [][]string{
+		{"unknown"},
+		{"list", "unexpected"},
+		{"list", "-seen", "maybe"},
+		{"get"},
+		{"get", "x"},
+		{"delete"},
+		{"delete", "-all", "1"},
+		{"wait", "-format", "xml"},
+		// config values are flags of serve, config check and send only
+		{"list", "-http-tcp-address", ":8080"},
+	} {
+		if code, _, errOut := run(args...); code != ExitUsage || len(errOut) == 0 {
+			t.Errorf("%v: unexpected exit code %v", args, code)
+		}
+	}
+	if code, _, _ := run("help"); code != ExitOK {
+		t.Errorf("unexpected exit code %v", code)
+	}
+	// the flags are set as environment variables, which are restored afterwards
+	t.Setenv("MAILHEAP_SMTP_ADDRESS", "")
+	if code, out, _ := run("config", "check", "-smtp-address", ":2525"); code != ExitOK || len(out) == 0 {
+		t.Errorf("unexpected exit code %v", code)
+	}
+}
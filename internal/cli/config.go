@@ -0,0 +1,28 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/rntrp/mailheap/internal/config"
+)
+
+// checkConfig resolves the config like serve does and reports all invalid
+// values, e.g. for use in CI before deploying a config file.
+func checkConfig(fs *flag.FlagSet, args []string) error {
+	if len(args) == 0 || args[0] != "check" {
+		return usageError{"expected subcommand check"}
+	} else if err := parseFlags(fs, args[1:]); err != nil {
+		return err
+	} else if fs.NArg() > 0 {
+		return usageError{"unexpected arguments"}
+	} else if err := config.LoadQuietly(); err != nil {
+		return err
+	}
+	if f := config.GetConfigFile(); len(f) > 0 {
+		fmt.Fprintln(stdout, "config file "+f+" is valid")
+	} else {
+		fmt.Fprintln(stdout, "config is valid")
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rntrp/mailheap/client"
+)
+
+func list(fs *flag.FlagSet, args []string) error {
+	api := apiFlags(fs)
+	filter := filterFlags(fs)
+	limit := fs.Int("limit", 20, "maximum number of mails, 0 for all")
+	asJson := fs.Bool("json", false, "print the mails as JSON array")
+	if err := parse(fs, args); err != nil {
+		return err
+	} else if fs.NArg() > 0 {
+		return usageError{"unexpected arguments"}
+	}
+	f, err := filter()
+	if err != nil {
+		return err
+	}
+	mails, err := seek(api(), f, *limit)
+	if err != nil {
+		return err
+	} else if *asJson {
+		return json.NewEncoder(stdout).Encode(mails)
+	}
+	for _, m := range mails {
+		printMail(m)
+	}
+	return nil
+}
+
+// seek returns the matching mails, newest first. A limit of 0 returns all.
+func seek(c *client.Client, f client.Filter, limit int) ([]client.Mail, error) {
+	mails := make([]client.Mail, 0)
+	for m, err := range c.Mails(context.Background(), f) {
+		if err != nil {
+			return nil, err
+		}
+		m.Mime = ""
+		mails = append(mails, m)
+		if limit > 0 && len(mails) >= limit {
+			break
+		}
+	}
+	return mails, nil
+}
+
+func printMail(m client.Mail) {
+	from := make([]string, 0)
+	json.Unmarshal([]byte(m.From), &from)
+	fmt.Fprintf(stdout, "%v\t%v\t%v\t%v\n", m.Id, m.Created.Format(time.RFC3339),
+		strings.Join(from, ", "), m.Subject)
+}
+
+func get(fs *flag.FlagSet, args []string) error {
+	api := apiFlags(fs)
+	format := fs.String("format", "eml", "output `format`: eml, html, json or links")
+	if err := parse(fs, args); err != nil {
+		return err
+	} else if fs.NArg() != 1 {
+		return usageError{"exactly one ID expected"}
+	}
+	id, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+	if err != nil {
+		return usageError{"invalid ID: " + fs.Arg(0)}
+	}
+	c, ctx := api(), context.Background()
+	var b []byte
+	switch *format {
+	case "eml":
+		b, err = c.GetEml(ctx, id)
+	case "html":
+		b, err = c.GetHtml(ctx, id)
+	case "json":
+		var m client.Mail
+		if m, err = c.GetMail(ctx, id); err == nil {
+			return json.NewEncoder(stdout).Encode(m)
+		}
+	case "links":
+		var links client.GetLinksResult
+		if links, err = c.GetLinks(ctx, id, false); err == nil {
+			return json.NewEncoder(stdout).Encode(links)
+		}
+	default:
+		return usageError{"unknown format: " + *format}
+	}
+	if err != nil {
+		return err
+	}
+	_, err = stdout.Write(b)
+	return err
+}
+
+func del(fs *flag.FlagSet, args []string) error {
+	api := apiFlags(fs)
+	all := fs.Bool("all", false, "delete all mails")
+	if err := parse(fs, args); err != nil {
+		return err
+	} else if *all == (fs.NArg() > 0) {
+		return usageError{"either IDs or -all expected"}
+	}
+	c, ctx := api(), context.Background()
+	if *all {
+		n, err := c.DeleteAllMails(ctx)
+		if err == nil {
+			fmt.Fprintln(stdout, n)
+		}
+		return err
+	}
+	ids := make([]int64, 0, fs.NArg())
+	for _, arg := range fs.Args() {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return usageError{"invalid ID: " + arg}
+		}
+		ids = append(ids, id)
+	}
+	n, err := c.DeleteMails(ctx, ids...)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, n)
+	if n == 0 {
+		return fmt.Errorf("%w: no mail deleted", errNotFound)
+	}
+	return nil
+}
+
+func wait(fs *flag.FlagSet, args []string) error {
+	api := apiFlags(fs)
+	filter := filterFlags(fs)
+	timeout := fs.Duration("timeout", 30*time.Second, "maximum `duration` to wait")
+	existing := fs.Bool("existing", false, "also match mails received before waiting")
+	format := fs.String("format", "id", "output `format`: id, json or eml")
+	start := time.Now()
+	if err := parse(fs, args); err != nil {
+		return err
+	} else if fs.NArg() > 0 {
+		return usageError{"unexpected arguments"}
+	}
+	switch *format {
+	case "id", "json", "eml":
+	default:
+		return usageError{"unknown format: " + *format}
+	}
+	f, err := filter()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), start.Add(*timeout))
+	defer cancel()
+	timedOut := func(err error) error {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%w: no matching mail within %v", errTimeout, *timeout)
+		}
+		return err
+	}
+	// the feed reports mails in the order they are stored, whereas the IDs of
+	// imported mails depend on their date; subscribing first does not miss
+	// mails stored while looking for existing ones
+	c := api()
+	sub, err := c.Subscribe(ctx, f)
+	if err != nil {
+		return timedOut(err)
+	}
+	defer sub.Close()
+	if *existing {
+		page, err := c.SeekMails(ctx, 0, 1, f)
+		if err != nil {
+			return timedOut(err)
+		} else if len(page.Data) > 0 {
+			return printWaited(c, page.Data[0], *format)
+		}
+	}
+	m, err := sub.Next(ctx)
+	if err != nil {
+		return timedOut(err)
+	}
+	return printWaited(c, m, *format)
+}
+
+func printWaited(c *client.Client, m client.Mail, format string) error {
+	switch format {
+	case "json":
+		return json.NewEncoder(stdout).Encode(m)
+	case "eml":
+		b, err := c.GetEml(context.Background(), m.Id)
+		if err != nil {
+			return err
+		}
+		_, err = stdout.Write(b)
+		return err
+	default:
+		_, err := fmt.Fprintln(stdout, m.Id)
+		return err
+	}
+}
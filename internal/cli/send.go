@@ -0,0 +1,200 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+	"github.com/google/uuid"
+	"github.com/rntrp/mailheap/internal/config"
+)
+
+type sendFlags struct {
+	addr     *string
+	from     *string
+	to       *string
+	subject  *string
+	body     *string
+	username *string
+	password *string
+	starttls *bool
+	insecure *bool
+}
+
+// send submits the given .eml files or, without files, composes a plain text
+// mail. The envelope of .eml files is taken from their headers unless given
+// by -from and -to.
+func send(fs *flag.FlagSet, args []string) error {
+	f := sendFlags{
+		addr:     fs.String("smtp", "", "`address` of the SMTP server (default derived from -smtp-address)"),
+		from:     fs.String("from", "", "envelope and header sender `address`"),
+		to:       fs.String("to", "", "comma separated recipient `addresses`"),
+		subject:  fs.String("subject", "", "subject of the composed mail"),
+		body:     fs.String("body", "", "`text` of the composed mail, - reads stdin"),
+		username: fs.String("username", "", "SMTP AUTH PLAIN username"),
+		password: fs.String("password", "", "SMTP AUTH PLAIN password"),
+		starttls: fs.Bool("starttls", false, "upgrade the connection via STARTTLS"),
+		insecure: fs.Bool("insecure", false, "skip verification of the TLS certificate"),
+	}
+	if err := parse(fs, args); err != nil {
+		return err
+	} else if fs.NArg() > 0 && (len(*f.subject) > 0 || len(*f.body) > 0) {
+		return usageError{"-subject and -body cannot be combined with files"}
+	}
+	if fs.NArg() == 0 {
+		if len(*f.from) == 0 || len(*f.to) == 0 {
+			return usageError{"-from and -to are required to compose a mail"}
+		}
+		b, err := f.compose()
+		if err != nil {
+			return err
+		}
+		return f.submit("composed mail", b)
+	}
+	for _, path := range fs.Args() {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		} else if err := f.submit(path, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *sendFlags) submit(name string, b []byte) error {
+	from, to := *f.from, splitAddrs(*f.to)
+	if len(from) == 0 || len(to) == 0 {
+		m, err := mail.ReadMessage(bytes.NewReader(b))
+		if err != nil {
+			return fmt.Errorf("%v: %w", name, err)
+		}
+		if len(from) == 0 {
+			if addrs := headerAddrs(m.Header, "From"); len(addrs) > 0 {
+				from = addrs[0]
+			}
+		}
+		if len(to) == 0 {
+			to = headerAddrs(m.Header, "To", "Cc", "Bcc")
+		}
+	}
+	if len(to) == 0 {
+		return fmt.Errorf("%v: no recipients", name)
+	}
+	c, err := f.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if len(*f.username) > 0 {
+		if err := c.Auth(sasl.NewPlainClient("", *f.username, *f.password)); err != nil {
+			return err
+		}
+	}
+	if err := c.SendMail(from, to, bytes.NewReader(b)); err != nil {
+		return fmt.Errorf("%v: %w", name, err)
+	}
+	fmt.Fprintf(stdout, "%v\t%v\n", name, strings.Join(to, ", "))
+	return c.Quit()
+}
+
+func (f *sendFlags) dial() (*smtp.Client, error) {
+	addr := *f.addr
+	if len(addr) == 0 {
+		addr = config.GetSMTPAddress()
+		if strings.HasPrefix(addr, ":") {
+			addr = "localhost" + addr
+		}
+	}
+	if !*f.starttls {
+		return smtp.Dial(addr)
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	return smtp.DialStartTLS(addr, &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: *f.insecure,
+	})
+}
+
+func (f *sendFlags) compose() ([]byte, error) {
+	text := *f.body
+	if text == "-" {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		text = string(b)
+	}
+	from, err := mail.ParseAddress(*f.from)
+	if err != nil {
+		return nil, usageError{"invalid -from: " + err.Error()}
+	}
+	to, rcpts := make([]string, 0), make([]string, 0)
+	for _, addr := range splitAddrs(*f.to) {
+		a, err := mail.ParseAddress(addr)
+		if err != nil {
+			return nil, usageError{"invalid -to: " + err.Error()}
+		}
+		to, rcpts = append(to, a.String()), append(rcpts, a.Address)
+	}
+	*f.from, *f.to = from.Address, strings.Join(rcpts, ",")
+	domain := "localhost"
+	if i := strings.LastIndexByte(from.Address, '@'); i >= 0 {
+		domain = from.Address[i+1:]
+	}
+	b := new(bytes.Buffer)
+	fmt.Fprintf(b, "Date: %v\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(b, "Message-Id: <%v@%v>\r\n", uuid.NewString(), domain)
+	fmt.Fprintf(b, "From: %v\r\n", from.String())
+	fmt.Fprintf(b, "To: %v\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(b, "Subject: %v\r\n", mime.QEncoding.Encode("utf-8", *f.subject))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(b)
+	text = strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\n", "\r\n")
+	if _, err := qp.Write([]byte(text)); err != nil {
+		return nil, err
+	} else if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func splitAddrs(s string) []string {
+	addrs := make([]string, 0)
+	for _, addr := range strings.Split(s, ",") {
+		if addr = strings.TrimSpace(addr); len(addr) > 0 {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+func headerAddrs(h mail.Header, keys ...string) []string {
+	addrs := make([]string, 0)
+	for _, key := range keys {
+		list, err := h.AddressList(key)
+		if err != nil {
+			continue
+		}
+		for _, addr := range list {
+			addrs = append(addrs, addr.Address)
+		}
+	}
+	return addrs
+}
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
@@ -12,27 +14,86 @@ import (
 
 var defaultEnv = "development"
 
-func Load() {
+// Load resolves the config from environment variables, .env files and the
+// config file and logs it. All invalid values are reported in the returned
+// error; they are replaced by their defaults.
+func Load() error {
+	if err := load(true); err != nil {
+		return err
+	}
+	get().print()
+	return nil
+}
+
+// LoadQuietly resolves the config like Load, but without logging it.
+func LoadQuietly() error {
+	return load(false)
+}
+
+// LoadDefaults resolves the config from environment variables and defaults
+// only, ignoring any .env and config files.
+func LoadDefaults() error {
+	mu.Lock()
+	defer mu.Unlock()
+	next := new(values)
+	loadDotEnv(next)
+	fileValues, loadedFile, overrides = map[string]string{}, "", map[string]string{}
+	err := loadEnv(next)
+	cur.Store(next)
+	return err
+}
+
+func load(verbose bool) error {
+	mu.Lock()
+	defer mu.Unlock()
+	next := new(values)
 	// https://github.com/joho/godotenv#precedence--conventions
-	loadDotEnv()
-	tryLoad(v.MAILHEAP_ENV_DIR, ".env."+v.MAILHEAP_ENV+".local")
-	if v.MAILHEAP_ENV != "test" {
-		tryLoad(v.MAILHEAP_ENV_DIR, ".env.local")
+	loadDotEnv(next)
+	tryLoad(next.MAILHEAP_ENV_DIR, ".env."+next.MAILHEAP_ENV+".local", verbose)
+	if next.MAILHEAP_ENV != "test" {
+		tryLoad(next.MAILHEAP_ENV_DIR, ".env.local", verbose)
 	}
-	tryLoad(v.MAILHEAP_ENV_DIR, ".env."+v.MAILHEAP_ENV)
-	tryLoad(v.MAILHEAP_ENV_DIR, ".env")
-	loadEnv()
-	v.print()
+	tryLoad(next.MAILHEAP_ENV_DIR, ".env."+next.MAILHEAP_ENV, verbose)
+	tryLoad(next.MAILHEAP_ENV_DIR, ".env", verbose)
+	overrides = map[string]string{}
+	fileErr := loadFile(next.MAILHEAP_ENV_DIR, verbose)
+	err := errors.Join(fileErr, loadEnv(next))
+	cur.Store(next)
+	return err
 }
 
-func tryLoad(path, file string) {
+func tryLoad(path, file string, verbose bool) {
 	f := filepath.Join(path, file)
-	if err := godotenv.Load(f); err == nil {
+	if err := godotenv.Load(f); err == nil && verbose {
+		log.Println("Loaded config from " + f)
+	}
+}
+
+// loadedFile is the config file read by the last load.
+var loadedFile string
+
+func loadFile(dir string, verbose bool) error {
+	fileValues, loadedFile = map[string]string{}, ""
+	f, ok := os.LookupEnv("MAILHEAP_CONFIG_FILE")
+	if !ok {
+		f = filepath.Join(dir, defaultFile)
+		if _, err := os.Stat(f); err != nil {
+			return nil
+		}
+	} else if len(f) == 0 {
+		return nil
+	}
+	values, err := readFile(f)
+	if values != nil {
+		fileValues, loadedFile = values, f
+	}
+	if err == nil && verbose {
 		log.Println("Loaded config from " + f)
 	}
+	return err
 }
 
-func loadDotEnv() {
+func loadDotEnv(v *values) {
 	v.MAILHEAP_ENV = os.Getenv("MAILHEAP_ENV")
 	if len(v.MAILHEAP_ENV) == 0 {
 		v.MAILHEAP_ENV = defaultEnv
@@ -40,7 +101,11 @@ func loadDotEnv() {
 	v.MAILHEAP_ENV_DIR = os.Getenv("MAILHEAP_ENV_DIR")
 }
 
-func loadEnv() {
+// loadEnv resolves the values and returns all parsing and validation errors.
+func loadEnv(v *values) error {
+	errs = make([]error, 0)
+	v.MAILHEAP_CONFIG_FILE = parseString("MAILHEAP_CONFIG_FILE", loadedFile)
+	v.MAILHEAP_CONFIG_WATCH_INTERVAL = parseDuration("MAILHEAP_CONFIG_WATCH_INTERVAL", 5*time.Second)
 	v.MAILHEAP_TEMP_DIR = parseString("MAILHEAP_TEMP_DIR", os.TempDir())
 	v.MAILHEAP_DB_LOCATION = parseString("MAILHEAP_DB_LOCATION", filepath.Join(os.TempDir(), "mailheap.db"))
 	v.MAILHEAP_SHUTDOWN_TIMEOUT = parseDuration("MAILHEAP_SHUTDOWN_TIMEOUT", 0)
@@ -52,11 +117,13 @@ func loadEnv() {
 	v.MAILHEAP_HTTP_UPLOAD_MEMORY_BUFFER_SIZE = parseInt64("MAILHEAP_HTTP_UPLOAD_MEMORY_BUFFER_SIZE", 10<<20)
 	v.MAILHEAP_HTTP_ENABLE_PROMETHEUS = parseBool("MAILHEAP_HTTP_ENABLE_PROMETHEUS", false)
 	v.MAILHEAP_HTTP_ENABLE_SHUTDOWN = parseBool("MAILHEAP_HTTP_ENABLE_SHUTDOWN", false)
+	v.MAILHEAP_HTTP_ENABLE_ADMIN = parseBool("MAILHEAP_HTTP_ENABLE_ADMIN", false)
 	v.MAILHEAP_SMTP_AUTH_REQUIRED = parseBool("MAILHEAP_SMTP_AUTH_REQUIRED", false)
 	v.MAILHEAP_SMTP_USERNAME = parseString("MAILHEAP_SMTP_USERNAME", "username")
 	v.MAILHEAP_SMTP_PASSWORD = parseString("MAILHEAP_SMTP_PASSWORD", "password")
 	v.MAILHEAP_SMTP_NETWORK_TYPE = parseString("MAILHEAP_SMTP_NETWORK_TYPE", "tcp")
 	v.MAILHEAP_SMTP_ADDRESS = parseString("MAILHEAP_SMTP_ADDRESS", ":2525")
+	v.MAILHEAP_SMTP_LISTENERS = parseString("MAILHEAP_SMTP_LISTENERS", "")
 	v.MAILHEAP_SMTP_DOMAIN = parseString("MAILHEAP_SMTP_DOMAIN", "localhost")
 	v.MAILHEAP_SMTP_READ_TIMEOUT = parseDuration("MAILHEAP_SMTP_READ_TIMEOUT", 10*time.Second)
 	v.MAILHEAP_SMTP_WRITE_TIMEOUT = parseDuration("MAILHEAP_SMTP_WRITE_TIMEOUT", 10*time.Second)
@@ -66,35 +133,92 @@ func loadEnv() {
 	v.MAILHEAP_SMTP_ALLOW_INSECURE_AUTH = parseBool("MAILHEAP_SMTP_ALLOW_INSECURE_AUTH", false)
 	v.MAILHEAP_SMTP_ENABLE_SMTPUTF8 = parseBool("MAILHEAP_SMTP_ENABLE_SMTPUTF8", false)
 	v.MAILHEAP_SMTP_ENABLE_LMTP = parseBool("MAILHEAP_SMTP_ENABLE_LMTP", false)
+	v.MAILHEAP_SMTP_LMTP_REJECT_RECIPIENTS = parseString("MAILHEAP_SMTP_LMTP_REJECT_RECIPIENTS", "")
+	v.MAILHEAP_SMTP_LMTP_DEFER_RECIPIENTS = parseString("MAILHEAP_SMTP_LMTP_DEFER_RECIPIENTS", "")
 	v.MAILHEAP_SMTP_ENABLE_REQUIRETLS = parseBool("MAILHEAP_SMTP_ENABLE_REQUIRETLS", false)
 	v.MAILHEAP_SMTP_ENABLE_BINARYMIME = parseBool("MAILHEAP_SMTP_ENABLE_BINARYMIME", false)
 	v.MAILHEAP_SMTP_ENABLE_DSN = parseBool("MAILHEAP_SMTP_ENABLE_DSN", false)
+	v.MAILHEAP_DSN_MODE = parseString("MAILHEAP_DSN_MODE", "off")
+	v.MAILHEAP_DSN_RELAY_ADDRESS = parseString("MAILHEAP_DSN_RELAY_ADDRESS", "")
+	v.MAILHEAP_DSN_FAILURE_RECIPIENTS = parseString("MAILHEAP_DSN_FAILURE_RECIPIENTS", "")
+	v.MAILHEAP_DSN_DELAY_RECIPIENTS = parseString("MAILHEAP_DSN_DELAY_RECIPIENTS", "")
+	v.MAILHEAP_SPAM_ENABLE = parseBool("MAILHEAP_SPAM_ENABLE", false)
+	v.MAILHEAP_SPAM_SPAMD_ADDRESS = parseString("MAILHEAP_SPAM_SPAMD_ADDRESS", "")
+	v.MAILHEAP_SPAM_SPAMD_TIMEOUT = parseDuration("MAILHEAP_SPAM_SPAMD_TIMEOUT", 10*time.Second)
+	v.MAILHEAP_LINKCHECK_ENABLE = parseBool("MAILHEAP_LINKCHECK_ENABLE", false)
+	v.MAILHEAP_LINKCHECK_ALLOWED_HOSTS = parseString("MAILHEAP_LINKCHECK_ALLOWED_HOSTS", "")
+	v.MAILHEAP_LINKCHECK_TIMEOUT = parseDuration("MAILHEAP_LINKCHECK_TIMEOUT", 5*time.Second)
+	v.MAILHEAP_LINKCHECK_MAX_REDIRECTS = parseInt64("MAILHEAP_LINKCHECK_MAX_REDIRECTS", 10)
+	v.MAILHEAP_RENDER_CACHE_SIZE = parseInt64("MAILHEAP_RENDER_CACHE_SIZE", 64)
+	v.MAILHEAP_IMPORT_DIR = parseString("MAILHEAP_IMPORT_DIR", "")
+	v.MAILHEAP_IMPORT_TIME_SOURCE = parseString("MAILHEAP_IMPORT_TIME_SOURCE", "now")
+	v.MAILHEAP_TLS_CERT_FILE = parseString("MAILHEAP_TLS_CERT_FILE", "")
+	v.MAILHEAP_TLS_KEY_FILE = parseString("MAILHEAP_TLS_KEY_FILE", "")
+	v.MAILHEAP_IMAP_ENABLE = parseBool("MAILHEAP_IMAP_ENABLE", false)
+	v.MAILHEAP_IMAP_ADDRESS = parseString("MAILHEAP_IMAP_ADDRESS", ":1143")
+	v.MAILHEAP_IMAP_USERNAME = parseString("MAILHEAP_IMAP_USERNAME", "username")
+	v.MAILHEAP_IMAP_PASSWORD = parseString("MAILHEAP_IMAP_PASSWORD", "password")
+	v.MAILHEAP_IMAP_ALLOW_INSECURE_AUTH = parseBool("MAILHEAP_IMAP_ALLOW_INSECURE_AUTH", true)
+	v.MAILHEAP_POP3_ENABLE = parseBool("MAILHEAP_POP3_ENABLE", false)
+	v.MAILHEAP_POP3_ADDRESS = parseString("MAILHEAP_POP3_ADDRESS", ":1110")
+	v.MAILHEAP_POP3_USERNAME = parseString("MAILHEAP_POP3_USERNAME", "username")
+	v.MAILHEAP_POP3_PASSWORD = parseString("MAILHEAP_POP3_PASSWORD", "password")
+	v.MAILHEAP_POP3_ALLOW_INSECURE_AUTH = parseBool("MAILHEAP_POP3_ALLOW_INSECURE_AUTH", true)
+	v.MAILHEAP_POP3_READ_TIMEOUT = parseDuration("MAILHEAP_POP3_READ_TIMEOUT", 10*time.Minute)
+	v.MAILHEAP_RULES_FILE = parseString("MAILHEAP_RULES_FILE", "")
+	v.MAILHEAP_WEBHOOKS_FILE = parseString("MAILHEAP_WEBHOOKS_FILE", "")
+	v.MAILHEAP_WEBHOOK_TIMEOUT = parseDuration("MAILHEAP_WEBHOOK_TIMEOUT", 10*time.Second)
+	v.MAILHEAP_WEBHOOK_MAX_ATTEMPTS = parseInt64("MAILHEAP_WEBHOOK_MAX_ATTEMPTS", 5)
+	v.MAILHEAP_WEBHOOK_BACKOFF = parseDuration("MAILHEAP_WEBHOOK_BACKOFF", time.Second)
+	v.validate()
+	return errors.Join(errs...)
 }
 
+// errs collects the errors of the parse functions during loadEnv.
+var errs []error
+
+func invalid(env, s, kind string) {
+	errs = append(errs, fmt.Errorf("%v: invalid %v: %q", env, kind, s))
+}
+
+// Empty values of non-string types are treated as unset.
+
 func parseBool(env string, def bool) bool {
-	if b, err := strconv.ParseBool(os.Getenv(env)); err == nil {
+	s, _ := lookup(env)
+	if len(s) == 0 {
+		return def
+	} else if b, err := strconv.ParseBool(s); err == nil {
 		return b
 	}
+	invalid(env, s, "boolean")
 	return def
 }
 
 func parseString(env, def string) string {
-	if s, ok := os.LookupEnv(env); ok {
+	if s, ok := lookup(env); ok {
 		return s
 	}
 	return def
 }
 
 func parseInt64(env string, def int64) int64 {
-	if i, err := strconv.ParseInt(os.Getenv(env), 10, 64); err == nil {
+	s, _ := lookup(env)
+	if len(s) == 0 {
+		return def
+	} else if i, err := strconv.ParseInt(s, 10, 64); err == nil {
 		return i
 	}
+	invalid(env, s, "integer")
 	return def
 }
 
 func parseDuration(env string, def time.Duration) time.Duration {
-	if d, err := time.ParseDuration(os.Getenv(env)); err == nil {
+	s, _ := lookup(env)
+	if len(s) == 0 {
+		return def
+	} else if d, err := time.ParseDuration(s); err == nil {
 		return d
 	}
+	invalid(env, s, "duration")
 	return def
 }
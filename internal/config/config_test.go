@@ -0,0 +1,144 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+	f := filepath.Join(dir, "mailheap.yaml")
+	err := os.WriteFile(f, []byte(`
+smtp:
+  address: :2626
+  read-timeout: 5s
+  max_recipients: 10
+logging:
+  level: debug
+storage:
+  location: /tmp/test.db
+linkcheck:
+  allowed-hosts: [example.com, example.org]
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MAILHEAP_ENV", "test")
+	t.Setenv("MAILHEAP_ENV_DIR", dir)
+	t.Setenv("MAILHEAP_SMTP_MAX_RECIPIENTS", "20")
+	if err := LoadQuietly(); err != nil {
+		t.Fatal(err)
+	}
+	if GetConfigFile() != f {
+		t.Errorf("config file %q", GetConfigFile())
+	}
+	if GetSMTPAddress() != ":2626" || GetSMTPReadTimeout() != 5*time.Second {
+		t.Errorf("smtp section not applied: %v, %v", GetSMTPAddress(), GetSMTPReadTimeout())
+	}
+	if GetSMTPMaxRecipients() != 20 {
+		t.Errorf("environment does not override file: %v", GetSMTPMaxRecipients())
+	}
+	if GetLogLevel() != "debug" || GetDBLocation() != "/tmp/test.db" {
+		t.Errorf("section aliases not applied: %v, %v", GetLogLevel(), GetDBLocation())
+	}
+	if hosts := GetLinkCheckAllowedHosts(); len(hosts) != 2 || hosts[1] != "example.org" {
+		t.Errorf("list not applied: %v", hosts)
+	}
+}
+
+func TestLoadReportsAllErrors(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "invalid.yaml")
+	err := os.WriteFile(f, []byte(`
+smtp:
+  adress: :2626
+  read-timeout: soon
+logging:
+  level: loud
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MAILHEAP_ENV", "test")
+	t.Setenv("MAILHEAP_CONFIG_FILE", f)
+	t.Setenv("MAILHEAP_WEBHOOK_MAX_ATTEMPTS", "0")
+	err = LoadQuietly()
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	for _, s := range []string{
+		"unknown key smtp.adress",
+		"MAILHEAP_SMTP_READ_TIMEOUT",
+		"MAILHEAP_LOG_LEVEL",
+		"MAILHEAP_WEBHOOK_MAX_ATTEMPTS",
+	} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("%q missing in %v", s, err)
+		}
+	}
+	if GetSMTPReadTimeout() != 10*time.Second {
+		t.Errorf("invalid value not replaced by default: %v", GetSMTPReadTimeout())
+	}
+}
+
+func TestReloadAndPatch(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "mailheap.yaml")
+	write := func(s string) {
+		if err := os.WriteFile(f, []byte(s), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("smtp:\n  address: :2626\n  max-recipients: 10\n")
+	t.Setenv("MAILHEAP_ENV", "test")
+	t.Setenv("MAILHEAP_CONFIG_FILE", f)
+	if err := LoadQuietly(); err != nil {
+		t.Fatal(err)
+	}
+	write("smtp:\n  address: :2727\n  max-recipients: 20\n")
+	if err := Reload(); err != nil {
+		t.Fatal(err)
+	} else if GetSMTPMaxRecipients() != 20 || GetSMTPAddress() != ":2626" {
+		t.Errorf("reload applied %v, %v", GetSMTPMaxRecipients(), GetSMTPAddress())
+	}
+	write("smtp:\n  max-recipients: many\n")
+	if err := Reload(); err == nil || GetSMTPMaxRecipients() != 20 {
+		t.Errorf("invalid reload applied: %v, %v", err, GetSMTPMaxRecipients())
+	}
+	s := "30"
+	if err := Patch(map[string]*string{"MAILHEAP_SMTP_ADDRESS": &s}); !errors.Is(err, ErrRestartRequired) {
+		t.Errorf("expected restart required, got %v", err)
+	} else if err := Patch(map[string]*string{"MAILHEAP_SMTP_MAX_RECIPIENTS": &s}); err != nil {
+		t.Fatal(err)
+	} else if GetSMTPMaxRecipients() != 30 || Settings()["MAILHEAP_SMTP_MAX_RECIPIENTS"].Source != "api" {
+		t.Errorf("patch applied %v", Settings()["MAILHEAP_SMTP_MAX_RECIPIENTS"])
+	} else if err := Patch(map[string]*string{"MAILHEAP_SMTP_MAX_RECIPIENTS": nil}); err != nil {
+		t.Fatal(err)
+	} else if GetSMTPMaxRecipients() != 20 {
+		t.Errorf("patch not reset: %v", GetSMTPMaxRecipients())
+	}
+}
+
+func TestParseSMTPListener(t *testing.T) {
+	l, err := ParseSMTPListener("smtp://joe:s3cret@:587?tls=required&auth=required&max-size=1024&mailbox=sub")
+	if err != nil {
+		t.Fatal(err)
+	} else if l.Addr != ":587" || !l.RequireTLS || l.AuthRequired == nil || !*l.AuthRequired ||
+		l.Username != "joe" || l.Password != "s3cret" || l.MaxMessageBytes != 1024 || l.Mailbox != "sub" {
+		t.Errorf("unexpected listener %+v", l)
+	} else if s := l.String(); strings.Contains(s, "s3cret") {
+		t.Errorf("credentials in %v", s)
+	}
+	if l, err = ParseSMTPListener("lmtp+unix:///run/mailheap.sock"); err != nil {
+		t.Fatal(err)
+	} else if l.Network != "unix" || l.Addr != "/run/mailheap.sock" || !l.LMTP {
+		t.Errorf("unexpected listener %+v", l)
+	}
+	for _, spec := range []string{"http://:80", "smtp://", "smtp://:25?tls=maybe", "smtp://:25?size=1"} {
+		if _, err := ParseSMTPListener(spec); err == nil {
+			t.Errorf("expected error for %v", spec)
+		}
+	}
+}
@@ -4,13 +4,17 @@ import (
 	"fmt"
 	"log"
 	"reflect"
+	"regexp"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
 type values struct {
 	MAILHEAP_ENV                            string
 	MAILHEAP_ENV_DIR                        string
+	MAILHEAP_CONFIG_FILE                    string
+	MAILHEAP_CONFIG_WATCH_INTERVAL          time.Duration
 	MAILHEAP_TEMP_DIR                       string
 	MAILHEAP_DB_LOCATION                    string
 	MAILHEAP_SHUTDOWN_TIMEOUT               time.Duration
@@ -22,11 +26,13 @@ type values struct {
 	MAILHEAP_HTTP_UPLOAD_MEMORY_BUFFER_SIZE int64
 	MAILHEAP_HTTP_ENABLE_PROMETHEUS         bool
 	MAILHEAP_HTTP_ENABLE_SHUTDOWN           bool
+	MAILHEAP_HTTP_ENABLE_ADMIN              bool
 	MAILHEAP_SMTP_AUTH_REQUIRED             bool
 	MAILHEAP_SMTP_USERNAME                  string
 	MAILHEAP_SMTP_PASSWORD                  string
 	MAILHEAP_SMTP_NETWORK_TYPE              string
 	MAILHEAP_SMTP_ADDRESS                   string
+	MAILHEAP_SMTP_LISTENERS                 string
 	MAILHEAP_SMTP_DOMAIN                    string
 	MAILHEAP_SMTP_READ_TIMEOUT              time.Duration
 	MAILHEAP_SMTP_WRITE_TIMEOUT             time.Duration
@@ -36,15 +42,60 @@ type values struct {
 	MAILHEAP_SMTP_ALLOW_INSECURE_AUTH       bool
 	MAILHEAP_SMTP_ENABLE_SMTPUTF8           bool
 	MAILHEAP_SMTP_ENABLE_LMTP               bool
+	MAILHEAP_SMTP_LMTP_REJECT_RECIPIENTS    string
+	MAILHEAP_SMTP_LMTP_DEFER_RECIPIENTS     string
 	MAILHEAP_SMTP_ENABLE_REQUIRETLS         bool
 	MAILHEAP_SMTP_ENABLE_BINARYMIME         bool
 	MAILHEAP_SMTP_ENABLE_DSN                bool
+	MAILHEAP_DSN_MODE                       string
+	MAILHEAP_DSN_RELAY_ADDRESS              string
+	MAILHEAP_DSN_FAILURE_RECIPIENTS         string
+	MAILHEAP_DSN_DELAY_RECIPIENTS           string
+	MAILHEAP_SPAM_ENABLE                    bool
+	MAILHEAP_SPAM_SPAMD_ADDRESS             string
+	MAILHEAP_SPAM_SPAMD_TIMEOUT             time.Duration
+	MAILHEAP_LINKCHECK_ENABLE               bool
+	MAILHEAP_LINKCHECK_ALLOWED_HOSTS        string
+	MAILHEAP_LINKCHECK_TIMEOUT              time.Duration
+	MAILHEAP_LINKCHECK_MAX_REDIRECTS        int64
+	MAILHEAP_RENDER_CACHE_SIZE              int64
+	MAILHEAP_IMPORT_DIR                     string
+	MAILHEAP_IMPORT_TIME_SOURCE             string
+	MAILHEAP_TLS_CERT_FILE                  string
+	MAILHEAP_TLS_KEY_FILE                   string
+	MAILHEAP_IMAP_ENABLE                    bool
+	MAILHEAP_IMAP_ADDRESS                   string
+	MAILHEAP_IMAP_USERNAME                  string
+	MAILHEAP_IMAP_PASSWORD                  string
+	MAILHEAP_IMAP_ALLOW_INSECURE_AUTH       bool
+	MAILHEAP_POP3_ENABLE                    bool
+	MAILHEAP_POP3_ADDRESS                   string
+	MAILHEAP_POP3_USERNAME                  string
+	MAILHEAP_POP3_PASSWORD                  string
+	MAILHEAP_POP3_ALLOW_INSECURE_AUTH       bool
+	MAILHEAP_POP3_READ_TIMEOUT              time.Duration
+	MAILHEAP_RULES_FILE                     string
+	MAILHEAP_WEBHOOKS_FILE                  string
+	MAILHEAP_WEBHOOK_TIMEOUT                time.Duration
+	MAILHEAP_WEBHOOK_MAX_ATTEMPTS           int64
+	MAILHEAP_WEBHOOK_BACKOFF                time.Duration
+}
+
+// cur holds the resolved values. They are replaced as a whole on reload, so
+// that the getters are safe for concurrent use.
+var cur atomic.Pointer[values]
+
+func get() *values {
+	if v := cur.Load(); v != nil {
+		return v
+	}
+	return new(values)
 }
 
-var v values
-
 var secrets = map[string]bool{
 	"MAILHEAP_SMTP_PASSWORD": true,
+	"MAILHEAP_IMAP_PASSWORD": true,
+	"MAILHEAP_POP3_PASSWORD": true,
 }
 
 func (v *values) print() {
@@ -62,7 +113,9 @@ func (v *values) print() {
 }
 
 func obfuscate(key string, value any) any {
-	if secrets[key] {
+	if key == "MAILHEAP_SMTP_LISTENERS" {
+		return redactListeners(value.(string))
+	} else if secrets[key] {
 		buf := new(strings.Builder)
 		for i, r := range value.(string) {
 			if i == 0 {
@@ -77,121 +130,299 @@ func obfuscate(key string, value any) any {
 }
 
 func GetEnv() string {
-	return v.MAILHEAP_ENV
+	return get().MAILHEAP_ENV
 }
 
 func GetEnvDir() string {
-	return v.MAILHEAP_ENV_DIR
+	return get().MAILHEAP_ENV_DIR
+}
+
+func GetConfigFile() string {
+	return get().MAILHEAP_CONFIG_FILE
+}
+
+func GetConfigWatchInterval() time.Duration {
+	return get().MAILHEAP_CONFIG_WATCH_INTERVAL
 }
 
 func GetTempDir() string {
-	return v.MAILHEAP_TEMP_DIR
+	return get().MAILHEAP_TEMP_DIR
 }
 
 func GetDBLocation() string {
-	return v.MAILHEAP_DB_LOCATION
+	return get().MAILHEAP_DB_LOCATION
 }
 
 func GetLogServiceName() string {
-	return v.MAILHEAP_LOG_SERVICE_NAME
+	return get().MAILHEAP_LOG_SERVICE_NAME
 }
 
 func GetLogLevel() string {
-	return v.MAILHEAP_LOG_LEVEL
+	return get().MAILHEAP_LOG_LEVEL
 }
 
 func GetLogFormat() string {
-	return v.MAILHEAP_LOG_FORMAT
+	return get().MAILHEAP_LOG_FORMAT
 }
 
 func GetHTTPTCPAddress() string {
-	return v.MAILHEAP_HTTP_TCP_ADDRESS
+	return get().MAILHEAP_HTTP_TCP_ADDRESS
 }
 
 func GetHTTPMaxRequestSize() int64 {
-	return v.MAILHEAP_HTTP_MAX_REQUEST_SIZE
+	return get().MAILHEAP_HTTP_MAX_REQUEST_SIZE
 }
 
 func GetHTTPUploadMemoryBufferSize() int64 {
-	return v.MAILHEAP_HTTP_UPLOAD_MEMORY_BUFFER_SIZE
+	return get().MAILHEAP_HTTP_UPLOAD_MEMORY_BUFFER_SIZE
 }
 
 func IsHTTPEnablePrometheus() bool {
-	return v.MAILHEAP_HTTP_ENABLE_PROMETHEUS
+	return get().MAILHEAP_HTTP_ENABLE_PROMETHEUS
 }
 
 func IsHTTPEnableShutdown() bool {
-	return v.MAILHEAP_HTTP_ENABLE_SHUTDOWN
+	return get().MAILHEAP_HTTP_ENABLE_SHUTDOWN
+}
+
+func IsHTTPEnableAdmin() bool {
+	return get().MAILHEAP_HTTP_ENABLE_ADMIN
 }
 
 func GetShutdownTimeout() time.Duration {
-	return v.MAILHEAP_SHUTDOWN_TIMEOUT
+	return get().MAILHEAP_SHUTDOWN_TIMEOUT
 }
 
 func IsSMTPAuthRequired() bool {
-	return v.MAILHEAP_SMTP_AUTH_REQUIRED
+	return get().MAILHEAP_SMTP_AUTH_REQUIRED
 }
 
 func GetSMTPUsername() string {
-	return v.MAILHEAP_SMTP_USERNAME
+	return get().MAILHEAP_SMTP_USERNAME
 }
 
 func GetSMTPPassword() string {
-	return v.MAILHEAP_SMTP_PASSWORD
+	return get().MAILHEAP_SMTP_PASSWORD
 }
 
 func GetSMTPNetworkType() string {
-	return v.MAILHEAP_SMTP_NETWORK_TYPE
+	return get().MAILHEAP_SMTP_NETWORK_TYPE
 }
 
 func GetSMTPAddress() string {
-	return v.MAILHEAP_SMTP_ADDRESS
+	return get().MAILHEAP_SMTP_ADDRESS
 }
 
 func GetSMTPDomain() string {
-	return v.MAILHEAP_SMTP_DOMAIN
+	return get().MAILHEAP_SMTP_DOMAIN
 }
 
 func GetSMTPReadTimeout() time.Duration {
-	return v.MAILHEAP_SMTP_READ_TIMEOUT
+	return get().MAILHEAP_SMTP_READ_TIMEOUT
 }
 
 func GetSMTPWriteTimeout() time.Duration {
-	return v.MAILHEAP_SMTP_WRITE_TIMEOUT
+	return get().MAILHEAP_SMTP_WRITE_TIMEOUT
 }
 
 func GetSMTPMaxMessageBytes() int64 {
-	return v.MAILHEAP_SMTP_MAX_MESSAGE_BYTES
+	return get().MAILHEAP_SMTP_MAX_MESSAGE_BYTES
 }
 
 func GetSMTPMaxRecipients() int64 {
-	return v.MAILHEAP_SMTP_MAX_RECIPIENTS
+	return get().MAILHEAP_SMTP_MAX_RECIPIENTS
 }
 
 func GetSMTPMaxLineLength() int64 {
-	return v.MAILHEAP_SMTP_MAX_LINE_LENGTH
+	return get().MAILHEAP_SMTP_MAX_LINE_LENGTH
 }
 
 func IsSMTPAllowInsecureAuth() bool {
-	return v.MAILHEAP_SMTP_ALLOW_INSECURE_AUTH
+	return get().MAILHEAP_SMTP_ALLOW_INSECURE_AUTH
 }
 
 func IsSMTPEnableSMTPUTF8() bool {
-	return v.MAILHEAP_SMTP_ENABLE_SMTPUTF8
+	return get().MAILHEAP_SMTP_ENABLE_SMTPUTF8
 }
 
 func IsSMTPEnableLMTP() bool {
-	return v.MAILHEAP_SMTP_ENABLE_LMTP
+	return get().MAILHEAP_SMTP_ENABLE_LMTP
+}
+
+// GetSMTPLMTPRejectRecipients returns the pattern of LMTP recipients to fail
+// permanently after DATA or nil if unset.
+func GetSMTPLMTPRejectRecipients() *regexp.Regexp {
+	return compilePattern(get().MAILHEAP_SMTP_LMTP_REJECT_RECIPIENTS)
+}
+
+// GetSMTPLMTPDeferRecipients returns the pattern of LMTP recipients to fail
+// temporarily after DATA or nil if unset.
+func GetSMTPLMTPDeferRecipients() *regexp.Regexp {
+	return compilePattern(get().MAILHEAP_SMTP_LMTP_DEFER_RECIPIENTS)
+}
+
+func compilePattern(s string) *regexp.Regexp {
+	if len(s) == 0 {
+		return nil
+	}
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return nil
+	}
+	return re
 }
 
 func IsSMTPEnableREQUIRETLS() bool {
-	return v.MAILHEAP_SMTP_ENABLE_REQUIRETLS
+	return get().MAILHEAP_SMTP_ENABLE_REQUIRETLS
 }
 
 func IsSMTPEnableBINARYMIME() bool {
-	return v.MAILHEAP_SMTP_ENABLE_BINARYMIME
+	return get().MAILHEAP_SMTP_ENABLE_BINARYMIME
 }
 
 func IsSMTPEnableDSN() bool {
-	return v.MAILHEAP_SMTP_ENABLE_DSN
+	return get().MAILHEAP_SMTP_ENABLE_DSN
+}
+
+// GetDSNMode returns off, store or relay.
+func GetDSNMode() string {
+	return strings.ToLower(get().MAILHEAP_DSN_MODE)
+}
+
+func GetDSNRelayAddress() string {
+	return get().MAILHEAP_DSN_RELAY_ADDRESS
+}
+
+// GetDSNFailureRecipients returns the pattern of accepted recipients to
+// report as failed or nil if unset.
+func GetDSNFailureRecipients() *regexp.Regexp {
+	return compilePattern(get().MAILHEAP_DSN_FAILURE_RECIPIENTS)
+}
+
+// GetDSNDelayRecipients returns the pattern of accepted recipients to report
+// as delayed or nil if unset.
+func GetDSNDelayRecipients() *regexp.Regexp {
+	return compilePattern(get().MAILHEAP_DSN_DELAY_RECIPIENTS)
+}
+
+func IsSpamEnable() bool {
+	return get().MAILHEAP_SPAM_ENABLE
+}
+
+func GetSpamSpamdAddress() string {
+	return get().MAILHEAP_SPAM_SPAMD_ADDRESS
+}
+
+func GetSpamSpamdTimeout() time.Duration {
+	return get().MAILHEAP_SPAM_SPAMD_TIMEOUT
+}
+
+func IsLinkCheckEnable() bool {
+	return get().MAILHEAP_LINKCHECK_ENABLE
+}
+
+func GetLinkCheckAllowedHosts() []string {
+	return splitList(get().MAILHEAP_LINKCHECK_ALLOWED_HOSTS)
+}
+
+func GetLinkCheckTimeout() time.Duration {
+	return get().MAILHEAP_LINKCHECK_TIMEOUT
+}
+
+func GetLinkCheckMaxRedirects() int64 {
+	return get().MAILHEAP_LINKCHECK_MAX_REDIRECTS
+}
+
+func GetRenderCacheSize() int64 {
+	return get().MAILHEAP_RENDER_CACHE_SIZE
+}
+
+func GetImportDir() string {
+	return get().MAILHEAP_IMPORT_DIR
+}
+
+func GetImportTimeSource() string {
+	return get().MAILHEAP_IMPORT_TIME_SOURCE
+}
+
+func GetTLSCertFile() string {
+	return get().MAILHEAP_TLS_CERT_FILE
+}
+
+func GetTLSKeyFile() string {
+	return get().MAILHEAP_TLS_KEY_FILE
+}
+
+func IsIMAPEnable() bool {
+	return get().MAILHEAP_IMAP_ENABLE
+}
+
+func GetIMAPAddress() string {
+	return get().MAILHEAP_IMAP_ADDRESS
+}
+
+func GetIMAPUsername() string {
+	return get().MAILHEAP_IMAP_USERNAME
+}
+
+func GetIMAPPassword() string {
+	return get().MAILHEAP_IMAP_PASSWORD
+}
+
+func IsIMAPAllowInsecureAuth() bool {
+	return get().MAILHEAP_IMAP_ALLOW_INSECURE_AUTH
+}
+
+func IsPOP3Enable() bool {
+	return get().MAILHEAP_POP3_ENABLE
+}
+
+func GetPOP3Address() string {
+	return get().MAILHEAP_POP3_ADDRESS
+}
+
+func GetPOP3Username() string {
+	return get().MAILHEAP_POP3_USERNAME
+}
+
+func GetPOP3Password() string {
+	return get().MAILHEAP_POP3_PASSWORD
+}
+
+func IsPOP3AllowInsecureAuth() bool {
+	return get().MAILHEAP_POP3_ALLOW_INSECURE_AUTH
+}
+
+func GetPOP3ReadTimeout() time.Duration {
+	return get().MAILHEAP_POP3_READ_TIMEOUT
+}
+
+func GetRulesFile() string {
+	return get().MAILHEAP_RULES_FILE
+}
+
+func GetWebhooksFile() string {
+	return get().MAILHEAP_WEBHOOKS_FILE
+}
+
+func GetWebhookTimeout() time.Duration {
+	return get().MAILHEAP_WEBHOOK_TIMEOUT
+}
+
+func GetWebhookMaxAttempts() int64 {
+	return get().MAILHEAP_WEBHOOK_MAX_ATTEMPTS
+}
+
+func GetWebhookBackoff() time.Duration {
+	return get().MAILHEAP_WEBHOOK_BACKOFF
+}
+
+func splitList(s string) []string {
+	list := make([]string, 0)
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); len(e) > 0 {
+			list = append(list, e)
+		}
+	}
+	return list
 }
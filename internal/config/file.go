@@ -0,0 +1,122 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultFile is read from MAILHEAP_ENV_DIR if MAILHEAP_CONFIG_FILE is unset.
+const defaultFile = "mailheap.yaml"
+
+// sections maps section names of the config file to the infix of the
+// environment variables, e.g. logging.level to MAILHEAP_LOG_LEVEL.
+var sections = map[string]string{
+	"logging": "LOG",
+	"storage": "DB",
+}
+
+// fileValues holds the values of the config file by environment variable name.
+// Environment variables take precedence over them.
+var fileValues = map[string]string{}
+
+func lookup(env string) (string, bool) {
+	if s, ok := overrides[env]; ok {
+		return s, true
+	} else if s, ok := os.LookupEnv(env); ok {
+		return s, true
+	}
+	s, ok := fileValues[env]
+	return s, ok
+}
+
+// readFile parses a YAML config file with nested sections, e.g.
+//
+//	smtp:
+//	  address: :2525
+//	  read-timeout: 10s
+//	logging:
+//	  level: debug
+//
+// Keys are matched case insensitively, dashes are treated as underscores and
+// sequences are joined by commas. Unknown keys are reported as errors.
+func readFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc := yaml.Node{}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	res := make(map[string]string)
+	if len(doc.Content) == 0 {
+		return res, nil
+	}
+	known := make(map[string]bool)
+	valType := reflect.TypeFor[values]()
+	for i := range valType.NumField() {
+		known[valType.Field(i).Name] = true
+	}
+	errs := make([]error, 0)
+	var walk func(node *yaml.Node, key, name string)
+	walk = func(node *yaml.Node, key, name string) {
+		switch node.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				k := node.Content[i].Value
+				n := strings.ToUpper(strings.ReplaceAll(k, "-", "_"))
+				if len(key) == 0 {
+					if s, ok := sections[strings.ToLower(k)]; ok {
+						n = s
+					}
+					walk(node.Content[i+1], k, "MAILHEAP_"+n)
+				} else {
+					walk(node.Content[i+1], key+"."+k, name+"_"+n)
+				}
+			}
+			return
+		case yaml.AliasNode:
+			walk(node.Alias, key, name)
+			return
+		}
+		if len(key) == 0 {
+			errs = append(errs, fmt.Errorf("%v:%v: expected a mapping", path, node.Line))
+			return
+		} else if !known[name] {
+			errs = append(errs, fmt.Errorf("%v:%v: unknown key %v", path, node.Line, key))
+			return
+		}
+		switch name {
+		case "MAILHEAP_ENV", "MAILHEAP_ENV_DIR", "MAILHEAP_CONFIG_FILE":
+			errs = append(errs, fmt.Errorf("%v:%v: %v can only be set as environment variable",
+				path, node.Line, key))
+			return
+		}
+		switch node.Kind {
+		case yaml.ScalarNode:
+			if node.Tag == "!!null" {
+				res[name] = ""
+			} else {
+				res[name] = node.Value
+			}
+		case yaml.SequenceNode:
+			list := make([]string, 0, len(node.Content))
+			for _, e := range node.Content {
+				if e.Kind != yaml.ScalarNode {
+					errs = append(errs, fmt.Errorf("%v:%v: %v must be a list of scalars",
+						path, e.Line, key))
+					return
+				}
+				list = append(list, e.Value)
+			}
+			res[name] = strings.Join(list, ",")
+		}
+	}
+	walk(doc.Content[0], "", "")
+	return res, errors.Join(errs...)
+}
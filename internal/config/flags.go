@@ -0,0 +1,60 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BindFlags registers a flag for each config value, e.g. -smtp-address for
+// MAILHEAP_SMTP_ADDRESS. Flags set the environment variable, so they take
+// precedence over .env files when Load is called afterwards.
+func BindFlags(fs *flag.FlagSet) {
+	valType := reflect.TypeFor[values]()
+	for i := range valType.NumField() {
+		field := valType.Field(i)
+		f := &envFlag{env: field.Name, kind: field.Type}
+		fs.Var(f, FlagName(field.Name), "sets "+field.Name)
+	}
+}
+
+// FlagName converts the name of an environment variable to a flag name.
+func FlagName(env string) string {
+	name := strings.TrimPrefix(env, "MAILHEAP_")
+	return strings.ReplaceAll(strings.ToLower(name), "_", "-")
+}
+
+type envFlag struct {
+	env  string
+	kind reflect.Type
+}
+
+func (f *envFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return os.Getenv(f.env)
+}
+
+func (f *envFlag) Set(s string) error {
+	var err error
+	switch f.kind {
+	case reflect.TypeFor[bool]():
+		_, err = strconv.ParseBool(s)
+	case reflect.TypeFor[int64]():
+		_, err = strconv.ParseInt(s, 10, 64)
+	case reflect.TypeFor[time.Duration]():
+		_, err = time.ParseDuration(s)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Setenv(f.env, s)
+}
+
+func (f *envFlag) IsBoolFlag() bool {
+	return f.kind == reflect.TypeFor[bool]()
+}
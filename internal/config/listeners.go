@@ -0,0 +1,162 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// SMTPListener is an SMTP or LMTP listener of MAILHEAP_SMTP_LISTENERS, given
+// as URL with optional credentials and query parameters, e.g.
+//
+//	smtp://:25
+//	smtp://user:secret@:587?tls=required&auth=required
+//	smtps://:465?max-size=10485760&mailbox=secure
+//	lmtp+unix:///run/mailheap.sock?mailbox=lmtp
+//
+// Unset fields fall back to the global MAILHEAP_SMTP_* values.
+type SMTPListener struct {
+	Network         string
+	Addr            string
+	LMTP            bool
+	ImplicitTLS     bool
+	RequireTLS      bool
+	AuthRequired    *bool
+	Username        string
+	Password        string
+	MaxMessageBytes int64
+	Mailbox         string
+}
+
+// String returns the listener as URL without credentials.
+func (l SMTPListener) String() string {
+	u := url.URL{Scheme: "smtp"}
+	if l.LMTP {
+		u.Scheme = "lmtp"
+	}
+	if l.ImplicitTLS {
+		u.Scheme += "s"
+	}
+	if l.Network == "unix" {
+		u.Scheme += "+unix"
+		u.Path = l.Addr
+	} else {
+		u.Host = l.Addr
+	}
+	q := url.Values{}
+	if l.RequireTLS {
+		q.Set("tls", "required")
+	}
+	if l.AuthRequired != nil && *l.AuthRequired {
+		q.Set("auth", "required")
+	} else if l.AuthRequired != nil {
+		q.Set("auth", "optional")
+	}
+	if l.MaxMessageBytes > 0 {
+		q.Set("max-size", strconv.FormatInt(l.MaxMessageBytes, 10))
+	}
+	if len(l.Mailbox) > 0 {
+		q.Set("mailbox", l.Mailbox)
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func ParseSMTPListener(spec string) (SMTPListener, error) {
+	l := SMTPListener{Network: "tcp"}
+	u, err := url.Parse(spec)
+	if err != nil {
+		return l, err
+	}
+	scheme, unix := strings.CutSuffix(u.Scheme, "+unix")
+	switch scheme {
+	case "smtp":
+	case "smtps":
+		l.ImplicitTLS = true
+	case "lmtp":
+		l.LMTP = true
+	case "lmtps":
+		l.LMTP, l.ImplicitTLS = true, true
+	default:
+		return l, fmt.Errorf("unknown scheme %q", u.Scheme)
+	}
+	if unix {
+		l.Network, l.Addr = "unix", u.Host+u.Path
+	} else if len(u.Path) > 0 {
+		return l, fmt.Errorf("unexpected path %q", u.Path)
+	} else {
+		l.Addr = u.Host
+	}
+	if len(l.Addr) == 0 {
+		return l, errors.New("missing address")
+	}
+	if u.User != nil {
+		l.Username = u.User.Username()
+		l.Password, _ = u.User.Password()
+	}
+	for k, values := range u.Query() {
+		s := values[len(values)-1]
+		switch k {
+		case "tls":
+			switch s {
+			case "required":
+				l.RequireTLS = true
+			case "optional":
+			default:
+				return l, fmt.Errorf("tls must be required or optional, got %q", s)
+			}
+		case "auth":
+			switch s {
+			case "required", "optional":
+				required := s == "required"
+				l.AuthRequired = &required
+			default:
+				return l, fmt.Errorf("auth must be required or optional, got %q", s)
+			}
+		case "max-size":
+			if l.MaxMessageBytes, err = strconv.ParseInt(s, 10, 64); err != nil || l.MaxMessageBytes < 0 {
+				return l, fmt.Errorf("invalid max-size %q", s)
+			}
+		case "mailbox":
+			l.Mailbox = s
+		default:
+			return l, fmt.Errorf("unknown parameter %q", k)
+		}
+	}
+	return l, nil
+}
+
+// GetSMTPListeners returns the listeners of MAILHEAP_SMTP_LISTENERS or, if
+// unset, the single listener given by MAILHEAP_SMTP_NETWORK_TYPE,
+// MAILHEAP_SMTP_ADDRESS and MAILHEAP_SMTP_ENABLE_LMTP.
+func GetSMTPListeners() []SMTPListener {
+	v := get()
+	specs := splitList(v.MAILHEAP_SMTP_LISTENERS)
+	if len(specs) == 0 {
+		return []SMTPListener{{
+			Network: strings.ToLower(v.MAILHEAP_SMTP_NETWORK_TYPE),
+			Addr:    v.MAILHEAP_SMTP_ADDRESS,
+			LMTP:    v.MAILHEAP_SMTP_ENABLE_LMTP,
+		}}
+	}
+	listeners := make([]SMTPListener, 0, len(specs))
+	for _, spec := range specs {
+		if l, err := ParseSMTPListener(spec); err == nil {
+			listeners = append(listeners, l)
+		}
+	}
+	return listeners
+}
+
+// redactListeners masks the passwords of the listener URLs.
+func redactListeners(s string) string {
+	specs := splitList(s)
+	for i, spec := range specs {
+		if u, err := url.Parse(spec); err == nil {
+			specs[i] = u.Redacted()
+		}
+	}
+	return strings.Join(specs, ",")
+}
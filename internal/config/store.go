@@ -0,0 +1,204 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+var (
+	// mu serializes loading, so that the sources are consistent with cur.
+	mu sync.Mutex
+	// overrides holds the values set by Patch by environment variable name.
+	// They take precedence over all other sources until the next Load.
+	overrides = map[string]string{}
+	listeners []func()
+)
+
+// reloadable lists the values which take effect without restart. Changes to
+// other values are ignored by Reload and rejected by Patch.
+var reloadable = map[string]bool{
+	"MAILHEAP_LOG_LEVEL":                      true,
+	"MAILHEAP_HTTP_MAX_REQUEST_SIZE":          true,
+	"MAILHEAP_HTTP_UPLOAD_MEMORY_BUFFER_SIZE": true,
+	"MAILHEAP_HTTP_ENABLE_PROMETHEUS":         true,
+	"MAILHEAP_HTTP_ENABLE_SHUTDOWN":           true,
+	"MAILHEAP_SMTP_AUTH_REQUIRED":             true,
+	"MAILHEAP_SMTP_USERNAME":                  true,
+	"MAILHEAP_SMTP_PASSWORD":                  true,
+	"MAILHEAP_SMTP_MAX_MESSAGE_BYTES":         true,
+	"MAILHEAP_SMTP_MAX_RECIPIENTS":            true,
+	"MAILHEAP_SMTP_LMTP_REJECT_RECIPIENTS":    true,
+	"MAILHEAP_SMTP_LMTP_DEFER_RECIPIENTS":     true,
+	"MAILHEAP_DSN_MODE":                       true,
+	"MAILHEAP_DSN_RELAY_ADDRESS":              true,
+	"MAILHEAP_DSN_FAILURE_RECIPIENTS":         true,
+	"MAILHEAP_DSN_DELAY_RECIPIENTS":           true,
+}
+
+var (
+	ErrUnknownSetting  = errors.New("unknown setting")
+	ErrRestartRequired = errors.New("setting requires a restart")
+)
+
+// Setting describes a resolved config value. Source is one of default, env
+// (including .env files and flags), file or api.
+type Setting struct {
+	Value      any    `json:"value"`
+	Source     string `json:"source"`
+	Reloadable bool   `json:"reloadable"`
+}
+
+// OnChange registers fn to be called after the values have been changed by
+// Reload or Patch.
+func OnChange(fn func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	listeners = append(listeners, fn)
+}
+
+func notify() {
+	mu.Lock()
+	fns := listeners
+	mu.Unlock()
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+// Reload reads the config file again and applies the changed values which are
+// reloadable. If the config is invalid, the current values are kept.
+func Reload() error {
+	mu.Lock()
+	prevFile, prevValues := loadedFile, fileValues
+	next := new(values)
+	loadDotEnv(next)
+	fileErr := loadFile(next.MAILHEAP_ENV_DIR, false)
+	if err := errors.Join(fileErr, loadEnv(next)); err != nil {
+		loadedFile, fileValues = prevFile, prevValues
+		mu.Unlock()
+		return err
+	}
+	changed, ignored := diff(get(), next)
+	cur.Store(next)
+	file := loadedFile
+	mu.Unlock()
+	if len(ignored) > 0 {
+		slog.Warn("Config changes require a restart", "settings", ignored)
+	}
+	slog.Info("Config reloaded", "file", file, "changed", changed)
+	if len(changed) > 0 {
+		notify()
+	}
+	return nil
+}
+
+// Patch sets or, for nil values, resets reloadable values at runtime. The
+// changes are applied all at once or not at all.
+func Patch(changes map[string]*string) error {
+	mu.Lock()
+	prev := make(map[string]string, len(overrides))
+	for k, s := range overrides {
+		prev[k] = s
+	}
+	for k, s := range changes {
+		if _, ok := reflect.TypeFor[values]().FieldByName(k); !ok {
+			mu.Unlock()
+			return fmt.Errorf("%w: %v", ErrUnknownSetting, k)
+		} else if !reloadable[k] {
+			mu.Unlock()
+			return fmt.Errorf("%w: %v", ErrRestartRequired, k)
+		} else if s == nil {
+			delete(overrides, k)
+		} else {
+			overrides[k] = *s
+		}
+	}
+	next := new(values)
+	loadDotEnv(next)
+	if err := loadEnv(next); err != nil {
+		overrides = prev
+		mu.Unlock()
+		return err
+	}
+	changed, _ := diff(get(), next)
+	cur.Store(next)
+	mu.Unlock()
+	slog.Info("Config patched", "changed", changed)
+	if len(changed) > 0 {
+		notify()
+	}
+	return nil
+}
+
+// diff returns the names of the changed reloadable values and of the changed
+// values requiring a restart. The latter are reset to the current values.
+func diff(old, next *values) (changed, ignored []string) {
+	o, n := reflect.ValueOf(old).Elem(), reflect.ValueOf(next).Elem()
+	for i := range o.NumField() {
+		name := o.Type().Field(i).Name
+		if o.Field(i).Equal(n.Field(i)) {
+			continue
+		} else if reloadable[name] {
+			changed = append(changed, name)
+		} else {
+			n.Field(i).Set(o.Field(i))
+			ignored = append(ignored, name)
+		}
+	}
+	return changed, ignored
+}
+
+// Settings returns the current values by environment variable name. Secrets
+// are obfuscated.
+func Settings() map[string]Setting {
+	mu.Lock()
+	defer mu.Unlock()
+	res := make(map[string]Setting)
+	val := reflect.ValueOf(get()).Elem()
+	for i := range val.NumField() {
+		name := val.Type().Field(i).Name
+		s := Setting{Source: "default", Reloadable: reloadable[name]}
+		if _, ok := overrides[name]; ok {
+			s.Source = "api"
+		} else if _, ok := os.LookupEnv(name); ok {
+			s.Source = "env"
+		} else if _, ok := fileValues[name]; ok {
+			s.Source = "file"
+		}
+		switch value := val.Field(i).Interface().(type) {
+		case time.Duration:
+			s.Value = value.String()
+		default:
+			s.Value = obfuscate(name, value)
+		}
+		res[name] = s
+	}
+	return res
+}
+
+// Watch reloads the config whenever the modification time of the config file
+// changes. It polls in the given interval and never returns.
+func Watch(interval time.Duration) {
+	modTime := func() time.Time {
+		if f := GetConfigFile(); len(f) > 0 {
+			if info, err := os.Stat(f); err == nil {
+				return info.ModTime()
+			}
+		}
+		return time.Time{}
+	}
+	last := modTime()
+	for range time.Tick(interval) {
+		if t := modTime(); !t.IsZero() && !t.Equal(last) {
+			last = t
+			if err := Reload(); err != nil {
+				slog.Error("Config reload failed", "error", err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// validate checks the ranges and combinations of the parsed values.
+func (v *values) validate() {
+	oneOf := func(env, s string, allowed ...string) {
+		if !slices.Contains(allowed, strings.ToUpper(s)) {
+			errs = append(errs, fmt.Errorf("%v: %q is not one of %v", env, s,
+				strings.Join(allowed, ", ")))
+		}
+	}
+	oneOf("MAILHEAP_LOG_LEVEL", v.MAILHEAP_LOG_LEVEL, "DEBUG", "INFO", "WARN", "ERROR")
+	oneOf("MAILHEAP_LOG_FORMAT", v.MAILHEAP_LOG_FORMAT, "SIMPLE", "TEXT", "JSON")
+	oneOf("MAILHEAP_SMTP_NETWORK_TYPE", v.MAILHEAP_SMTP_NETWORK_TYPE, "TCP", "UNIX")
+	oneOf("MAILHEAP_DSN_MODE", v.MAILHEAP_DSN_MODE, "OFF", "STORE", "RELAY")
+	if len(v.MAILHEAP_IMPORT_TIME_SOURCE) > 0 {
+		oneOf("MAILHEAP_IMPORT_TIME_SOURCE", v.MAILHEAP_IMPORT_TIME_SOURCE, "NOW", "DATE", "RECEIVED")
+	}
+	address := func(env, s string) {
+		if _, _, err := net.SplitHostPort(s); err != nil {
+			errs = append(errs, fmt.Errorf("%v: invalid address %q", env, s))
+		}
+	}
+	address("MAILHEAP_HTTP_TCP_ADDRESS", v.MAILHEAP_HTTP_TCP_ADDRESS)
+	if strings.EqualFold(v.MAILHEAP_SMTP_NETWORK_TYPE, "tcp") {
+		address("MAILHEAP_SMTP_ADDRESS", v.MAILHEAP_SMTP_ADDRESS)
+	}
+	for _, spec := range splitList(v.MAILHEAP_SMTP_LISTENERS) {
+		l, err := ParseSMTPListener(spec)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("MAILHEAP_SMTP_LISTENERS: %v: %w", redactListeners(spec), err))
+		} else if l.Network == "tcp" {
+			address("MAILHEAP_SMTP_LISTENERS", l.Addr)
+		}
+		if (l.ImplicitTLS || l.RequireTLS) && len(v.MAILHEAP_TLS_CERT_FILE) == 0 {
+			errs = append(errs, fmt.Errorf("MAILHEAP_SMTP_LISTENERS: %v requires MAILHEAP_TLS_CERT_FILE",
+				redactListeners(spec)))
+		}
+	}
+	pattern := func(env, s string) {
+		if _, err := regexp.Compile(s); err != nil {
+			errs = append(errs, fmt.Errorf("%v: invalid pattern: %w", env, err))
+		}
+	}
+	pattern("MAILHEAP_SMTP_LMTP_REJECT_RECIPIENTS", v.MAILHEAP_SMTP_LMTP_REJECT_RECIPIENTS)
+	pattern("MAILHEAP_SMTP_LMTP_DEFER_RECIPIENTS", v.MAILHEAP_SMTP_LMTP_DEFER_RECIPIENTS)
+	pattern("MAILHEAP_DSN_FAILURE_RECIPIENTS", v.MAILHEAP_DSN_FAILURE_RECIPIENTS)
+	pattern("MAILHEAP_DSN_DELAY_RECIPIENTS", v.MAILHEAP_DSN_DELAY_RECIPIENTS)
+	if strings.EqualFold(v.MAILHEAP_DSN_MODE, "relay") {
+		address("MAILHEAP_DSN_RELAY_ADDRESS", v.MAILHEAP_DSN_RELAY_ADDRESS)
+	}
+	if v.MAILHEAP_IMAP_ENABLE {
+		address("MAILHEAP_IMAP_ADDRESS", v.MAILHEAP_IMAP_ADDRESS)
+	}
+	if v.MAILHEAP_POP3_ENABLE {
+		address("MAILHEAP_POP3_ADDRESS", v.MAILHEAP_POP3_ADDRESS)
+	}
+	if v.MAILHEAP_SPAM_ENABLE {
+		address("MAILHEAP_SPAM_SPAMD_ADDRESS", v.MAILHEAP_SPAM_SPAMD_ADDRESS)
+	}
+	atLeast := func(env string, i, min int64) {
+		if i < min {
+			errs = append(errs, fmt.Errorf("%v: must be at least %v, got %v", env, min, i))
+		}
+	}
+	atLeast("MAILHEAP_HTTP_UPLOAD_MEMORY_BUFFER_SIZE", v.MAILHEAP_HTTP_UPLOAD_MEMORY_BUFFER_SIZE, 0)
+	atLeast("MAILHEAP_SMTP_MAX_MESSAGE_BYTES", v.MAILHEAP_SMTP_MAX_MESSAGE_BYTES, 0)
+	atLeast("MAILHEAP_SMTP_MAX_RECIPIENTS", v.MAILHEAP_SMTP_MAX_RECIPIENTS, 0)
+	atLeast("MAILHEAP_SMTP_MAX_LINE_LENGTH", v.MAILHEAP_SMTP_MAX_LINE_LENGTH, 0)
+	atLeast("MAILHEAP_LINKCHECK_MAX_REDIRECTS", v.MAILHEAP_LINKCHECK_MAX_REDIRECTS, 0)
+	atLeast("MAILHEAP_RENDER_CACHE_SIZE", v.MAILHEAP_RENDER_CACHE_SIZE, 0)
+	atLeast("MAILHEAP_WEBHOOK_MAX_ATTEMPTS", v.MAILHEAP_WEBHOOK_MAX_ATTEMPTS, 1)
+	nonNegative := func(env string, d time.Duration) {
+		if d < 0 {
+			errs = append(errs, fmt.Errorf("%v: must not be negative, got %v", env, d))
+		}
+	}
+	nonNegative("MAILHEAP_CONFIG_WATCH_INTERVAL", v.MAILHEAP_CONFIG_WATCH_INTERVAL)
+	nonNegative("MAILHEAP_SHUTDOWN_TIMEOUT", v.MAILHEAP_SHUTDOWN_TIMEOUT)
+	nonNegative("MAILHEAP_SMTP_READ_TIMEOUT", v.MAILHEAP_SMTP_READ_TIMEOUT)
+	nonNegative("MAILHEAP_SMTP_WRITE_TIMEOUT", v.MAILHEAP_SMTP_WRITE_TIMEOUT)
+	nonNegative("MAILHEAP_SPAM_SPAMD_TIMEOUT", v.MAILHEAP_SPAM_SPAMD_TIMEOUT)
+	nonNegative("MAILHEAP_LINKCHECK_TIMEOUT", v.MAILHEAP_LINKCHECK_TIMEOUT)
+	nonNegative("MAILHEAP_POP3_READ_TIMEOUT", v.MAILHEAP_POP3_READ_TIMEOUT)
+	nonNegative("MAILHEAP_WEBHOOK_TIMEOUT", v.MAILHEAP_WEBHOOK_TIMEOUT)
+	nonNegative("MAILHEAP_WEBHOOK_BACKOFF", v.MAILHEAP_WEBHOOK_BACKOFF)
+	if (len(v.MAILHEAP_TLS_CERT_FILE) == 0) != (len(v.MAILHEAP_TLS_KEY_FILE) == 0) {
+		errs = append(errs, errors.New("MAILHEAP_TLS_CERT_FILE and MAILHEAP_TLS_KEY_FILE must be set together"))
+	}
+}
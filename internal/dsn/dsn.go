@@ -0,0 +1,208 @@
+package dsn
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/google/uuid"
+)
+
+// Action is the per-recipient outcome of a delivery attempt as defined by
+// RFC 3464 section 2.3.3.
+type Action string
+
+const (
+	Delivered Action = "delivered"
+	Delayed   Action = "delayed"
+	Failed    Action = "failed"
+)
+
+// Recipient is an accepted recipient with the RCPT TO parameters and the
+// outcome to report. Err describes the outcome in case of failure or delay.
+type Recipient struct {
+	Addr    string
+	Options *smtp.RcptOptions
+	Action  Action
+	Err     *smtp.SMTPError
+}
+
+// Notify reports whether the NOTIFY parameter of the recipient requests a
+// report for its action. Without NOTIFY, failures and delays are reported.
+func (r Recipient) Notify() bool {
+	var notify []smtp.DSNNotify
+	if r.Options != nil {
+		notify = r.Options.Notify
+	}
+	if len(notify) == 0 {
+		notify = []smtp.DSNNotify{smtp.DSNNotifyFailure, smtp.DSNNotifyDelayed}
+	}
+	switch r.Action {
+	case Delivered:
+		return slices.Contains(notify, smtp.DSNNotifySuccess)
+	case Delayed:
+		return slices.Contains(notify, smtp.DSNNotifyDelayed)
+	case Failed:
+		return slices.Contains(notify, smtp.DSNNotifyFailure)
+	default:
+		return false
+	}
+}
+
+func (r Recipient) status() string {
+	if r.Err != nil {
+		e := r.Err.EnhancedCode
+		return fmt.Sprintf("%v.%v.%v", e[0], e[1], e[2])
+	}
+	switch r.Action {
+	case Delayed:
+		return "4.0.0"
+	case Failed:
+		return "5.0.0"
+	default:
+		return "2.0.0"
+	}
+}
+
+// Report is a delivery status notification for a received message. From is
+// the envelope sender, which the report is addressed to.
+type Report struct {
+	ReportingMTA string
+	From         string
+	EnvelopeID   string
+	Return       smtp.DSNReturn
+	Arrival      time.Time
+	Recipients   []Recipient
+}
+
+// Notified returns the recipients for which a report is requested. Messages
+// with a null reverse-path never cause a report.
+func (r Report) Notified() []Recipient {
+	if len(r.From) == 0 {
+		return nil
+	}
+	res := make([]Recipient, 0, len(r.Recipients))
+	for _, rcpt := range r.Recipients {
+		if rcpt.Notify() {
+			res = append(res, rcpt)
+		}
+	}
+	return res
+}
+
+// Message renders the report as RFC 3464 multipart/report message for the
+// notified recipients, followed by either the original message or, unless
+// RET=FULL has been requested, only its header. It returns nil if no
+// recipient is notified.
+func (r Report) Message(original []byte) ([]byte, error) {
+	rcpts := r.Notified()
+	if len(rcpts) == 0 {
+		return nil, nil
+	}
+	buf := new(bytes.Buffer)
+	mw := multipart.NewWriter(buf)
+	hdr := []string{
+		"From: Mail Delivery System <MAILER-DAEMON@" + r.ReportingMTA + ">",
+		"To: <" + r.From + ">",
+		"Subject: Delivery Status Notification (" + subject(rcpts) + ")",
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Message-Id: <" + uuid.NewString() + "@" + r.ReportingMTA + ">",
+		"Auto-Submitted: auto-replied",
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/report; report-type=delivery-status; boundary=\"" + mw.Boundary() + "\"",
+	}
+	buf.WriteString(strings.Join(hdr, "\r\n") + "\r\n\r\n")
+	part := func(contentType string, body []byte) error {
+		w, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {contentType}})
+		if err == nil {
+			_, err = w.Write(body)
+		}
+		return err
+	}
+	if err := part("text/plain; charset=utf-8", r.text(rcpts)); err != nil {
+		return nil, err
+	} else if err := part("message/delivery-status", r.status(rcpts)); err != nil {
+		return nil, err
+	}
+	if r.Return == smtp.DSNReturnFull {
+		if err := part("message/rfc822", original); err != nil {
+			return nil, err
+		}
+	} else if err := part("text/rfc822-headers", header(original)); err != nil {
+		return nil, err
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func subject(rcpts []Recipient) string {
+	switch {
+	case slices.ContainsFunc(rcpts, func(r Recipient) bool { return r.Action == Failed }):
+		return "Failure"
+	case slices.ContainsFunc(rcpts, func(r Recipient) bool { return r.Action == Delayed }):
+		return "Delay"
+	default:
+		return "Success"
+	}
+}
+
+func (r Report) text(rcpts []Recipient) []byte {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "This is the mail system at %v.\r\n\r\n", r.ReportingMTA)
+	for _, rcpt := range rcpts {
+		switch rcpt.Action {
+		case Delivered:
+			fmt.Fprintf(buf, "Your message was delivered to <%v>.\r\n", rcpt.Addr)
+		case Delayed:
+			fmt.Fprintf(buf, "Delivery to <%v> has been delayed.\r\n", rcpt.Addr)
+		case Failed:
+			fmt.Fprintf(buf, "Your message could not be delivered to <%v>.\r\n", rcpt.Addr)
+		}
+	}
+	return buf.Bytes()
+}
+
+func (r Report) status(rcpts []Recipient) []byte {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "Reporting-MTA: dns; %v\r\n", r.ReportingMTA)
+	if len(r.EnvelopeID) > 0 {
+		fmt.Fprintf(buf, "Original-Envelope-Id: %v\r\n", r.EnvelopeID)
+	}
+	if !r.Arrival.IsZero() {
+		fmt.Fprintf(buf, "Arrival-Date: %v\r\n", r.Arrival.Format(time.RFC1123Z))
+	}
+	for _, rcpt := range rcpts {
+		buf.WriteString("\r\n")
+		if o := rcpt.Options; o != nil && len(o.OriginalRecipient) > 0 {
+			typ := o.OriginalRecipientType
+			if len(typ) == 0 {
+				typ = smtp.DSNAddressTypeRFC822
+			}
+			fmt.Fprintf(buf, "Original-Recipient: %v; %v\r\n", strings.ToLower(string(typ)), o.OriginalRecipient)
+		}
+		fmt.Fprintf(buf, "Final-Recipient: rfc822; %v\r\n", rcpt.Addr)
+		fmt.Fprintf(buf, "Action: %v\r\n", rcpt.Action)
+		fmt.Fprintf(buf, "Status: %v\r\n", rcpt.status())
+		if rcpt.Err != nil {
+			fmt.Fprintf(buf, "Diagnostic-Code: smtp; %v %v\r\n", rcpt.Err.Code, rcpt.Err.Message)
+		}
+	}
+	return buf.Bytes()
+}
+
+// header returns the header of the message up to the first empty line.
+func header(msg []byte) []byte {
+	if i := bytes.Index(msg, []byte("\r\n\r\n")); i >= 0 {
+		return msg[:i+2]
+	} else if i := bytes.Index(msg, []byte("\n\n")); i >= 0 {
+		return msg[:i+1]
+	}
+	return msg
+}
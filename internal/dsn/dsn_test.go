@@ -0,0 +1,92 @@
+package dsn
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+const eml = "From: alice@example.com\r\n" +
+	"To: bob@example.com\r\n" +
+	"Date: Mon, 19 Oct 2026 11:39:27 -0000\r\n" +
+	"Subject: Hello\r\n\r\n" +
+	"Hello Bob\r\n"
+
+func TestMessage(t *testing.T) {
+	r := Report{
+		ReportingMTA: "mx.example.com",
+		From:         "alice@example.com",
+		EnvelopeID:   "env-1",
+		Arrival:      time.Now(),
+		Recipients: []Recipient{
+			{Addr: "bob@example.com", Action: Delivered},
+			{Addr: "carol@example.com", Action: Failed, Err: &smtp.SMTPError{
+				Code: 550, EnhancedCode: smtp.EnhancedCode{5, 1, 1}, Message: "Mailbox unavailable",
+			}},
+			{Addr: "dave@example.com", Action: Failed, Options: &smtp.RcptOptions{
+				Notify: []smtp.DSNNotify{smtp.DSNNotifyNever},
+			}},
+		},
+	}
+	b, err := r.Message([]byte(eml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := mail.ReadMessage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	} else if m.Header.Get("To") != "<alice@example.com>" || !strings.HasSuffix(m.Header.Get("Subject"), "(Failure)") {
+		t.Errorf("unexpected header %v", m.Header)
+	}
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" || params["report-type"] != "delivery-status" {
+		t.Fatalf("unexpected content type %v, %v", mediaType, params)
+	}
+	parts := make(map[string]string)
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(p)
+		parts[p.Header.Get("Content-Type")] = string(body)
+	}
+	status := parts["message/delivery-status"]
+	for _, s := range []string{
+		"Original-Envelope-Id: env-1",
+		"Final-Recipient: rfc822; carol@example.com\r\nAction: failed\r\nStatus: 5.1.1",
+		"Diagnostic-Code: smtp; 550 Mailbox unavailable",
+	} {
+		if !strings.Contains(status, s) {
+			t.Errorf("%q missing in %v", s, status)
+		}
+	}
+	if strings.Contains(status, "bob@example.com") || strings.Contains(status, "dave@example.com") {
+		t.Errorf("recipient without notification in %v", status)
+	}
+	if hdr := parts["text/rfc822-headers"]; !strings.Contains(hdr, "Subject: Hello") || strings.Contains(hdr, "Hello Bob") {
+		t.Errorf("unexpected headers %q", hdr)
+	}
+}
+
+func TestNoReport(t *testing.T) {
+	success := &smtp.RcptOptions{Notify: []smtp.DSNNotify{smtp.DSNNotifySuccess}}
+	for _, r := range []Report{
+		{From: "alice@example.com", Recipients: []Recipient{{Addr: "bob@example.com", Action: Delivered}}},
+		{Recipients: []Recipient{{Addr: "bob@example.com", Action: Delivered, Options: success}}},
+	} {
+		if b, err := r.Message([]byte(eml)); err != nil || b != nil {
+			t.Errorf("unexpected report %s, %v", b, err)
+		}
+	}
+}
@@ -49,3 +49,9 @@ func (i *rwDecorator) WriteHeader(statusCode int) {
 	i.status = statusCode
 	i.delegate.WriteHeader(statusCode)
 }
+
+// Unwrap exposes the underlying writer to http.ResponseController, e.g. for
+// hijacking WebSocket connections.
+func (i *rwDecorator) Unwrap() http.ResponseWriter {
+	return i.delegate
+}
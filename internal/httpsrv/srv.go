@@ -4,6 +4,7 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rntrp/mailheap/internal/config"
@@ -11,7 +12,36 @@ import (
 )
 
 func New(ctrl rest.Controller, shutdown chan os.Signal) *http.Server {
-	r := http.NewServeMux()
+	r := newMux(ctrl, shutdown)
+	return &http.Server{Addr: config.GetHTTPTCPAddress(), Handler: logged()(r)}
+}
+
+// mux records the patterns of the registered routes, so that they can be
+// checked against the OpenAPI document.
+type mux struct {
+	*http.ServeMux
+	patterns []string
+}
+
+func (m *mux) Handle(pattern string, handler http.Handler) {
+	m.patterns = append(m.patterns, pattern)
+	m.ServeMux.Handle(pattern, handler)
+}
+
+func (m *mux) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	m.Handle(pattern, http.HandlerFunc(handler))
+}
+
+// handleApi registers the handler below ApiV1Prefix and as deprecated alias
+// at the unversioned path.
+func (m *mux) handleApi(pattern string, handler http.HandlerFunc) {
+	method, path, _ := strings.Cut(pattern, " ")
+	m.HandleFunc(method+" "+rest.ApiV1Prefix+path, rest.V1(handler))
+	m.HandleFunc(pattern, rest.Deprecated(handler))
+}
+
+func newMux(ctrl rest.Controller, shutdown chan os.Signal) *mux {
+	r := &mux{ServeMux: http.NewServeMux()}
 	r.HandleFunc("GET /", ctrl.Index)
 	r.HandleFunc("GET /index.html", ctrl.Index)
 	r.HandleFunc("GET /favicon.ico", ctrl.IndexFaviconIco)
@@ -19,21 +49,52 @@ func New(ctrl rest.Controller, shutdown chan os.Signal) *http.Server {
 	r.HandleFunc("GET /index.css", ctrl.IndexCss)
 	r.HandleFunc("GET /index.js", ctrl.IndexJs)
 	r.HandleFunc("GET /index.jsmimeparser.min.js", ctrl.IndexJsMimeParser)
-	r.HandleFunc("GET /mail/{id}", ctrl.GetEml)
-	r.HandleFunc("DELETE /mails", ctrl.DeleteMails)
-	r.HandleFunc("GET /mails/{id}", ctrl.SeekMails)
-	r.HandleFunc("POST /upload", ctrl.UploadMail)
+	r.HandleFunc("GET /docs", ctrl.Docs)
+	r.HandleFunc("GET /docs.js", ctrl.DocsJs)
+	r.HandleFunc("GET /openapi.json", ctrl.OpenApi)
+	r.handleApi("GET /mail/{id}", ctrl.GetEml)
+	r.handleApi("PATCH /mail/{id}", ctrl.PatchMail)
+	r.handleApi("GET /mail/{id}/cid/{cid}", ctrl.GetCid)
+	r.handleApi("GET /mail/{id}/html", ctrl.GetHtml)
+	r.handleApi("GET /mail/{id}/links", ctrl.GetLinks)
+	r.handleApi("GET /mail/{id}/render.pdf", ctrl.RenderPdf)
+	r.handleApi("GET /mail/{id}/render.png", ctrl.RenderPng)
+	r.handleApi("DELETE /mails", ctrl.DeleteMails)
+	r.handleApi("PATCH /mails", ctrl.PatchMails)
+	r.handleApi("GET /mails/export.mbox", ctrl.ExportMbox)
+	r.handleApi("GET /mails/export.zip", ctrl.ExportZip)
+	r.handleApi("GET /mails/extract", ctrl.ExtractMail)
+	r.handleApi("GET /mails/{id}", ctrl.SeekMails)
+	r.handleApi("GET /rules", ctrl.GetRules)
+	r.handleApi("PUT /rules", ctrl.PutRules)
+	r.handleApi("PUT /rules/{name}", ctrl.PutRule)
+	r.handleApi("DELETE /rules/{name}", ctrl.DeleteRule)
+	r.handleApi("POST /upload", ctrl.UploadMail)
+	r.handleApi("GET /webhooks", ctrl.GetWebhooks)
+	r.handleApi("GET /webhooks/deliveries", ctrl.GetWebhookDeliveries)
+	r.handleApi("GET /ws", ctrl.Feed)
 	r.HandleFunc("GET /health", rest.Live)
-	if config.IsHTTPEnablePrometheus() {
-		r.Handle("/metrics", promhttp.Handler())
-	}
-	if config.IsHTTPEnableShutdown() {
-		r.HandleFunc("POST /shutdown", shutdownFn(shutdown))
-	}
-	return &http.Server{Addr: config.GetHTTPTCPAddress(), Handler: logged()(r)}
+	r.HandleFunc("GET "+rest.ApiV1Prefix+"/settings", rest.V1(ctrl.GetSettings))
+	r.HandleFunc("PATCH "+rest.ApiV1Prefix+"/settings", rest.V1(ctrl.PatchSettings))
+	r.HandleFunc("POST "+rest.ApiV1Prefix+"/settings/reload", rest.V1(ctrl.ReloadSettings))
+	r.Handle("GET /metrics", enabled(config.IsHTTPEnablePrometheus, promhttp.Handler()))
+	r.Handle("POST /shutdown", enabled(config.IsHTTPEnableShutdown, shutdownFn(shutdown)))
+	return r
+}
+
+// enabled checks the config on each request, so that the handler can be
+// switched on and off at runtime.
+func enabled(isEnabled func() bool, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isEnabled() {
+			http.NotFound(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
 }
 
-func shutdownFn(sig chan os.Signal) func(http.ResponseWriter, *http.Request) {
+func shutdownFn(sig chan os.Signal) http.HandlerFunc {
 	return func(w http.ResponseWriter, _ *http.Request) {
 		slog.Info("Shutdown endpoint call")
 		w.WriteHeader(http.StatusAccepted)
@@ -0,0 +1,131 @@
+package httpsrv
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/rntrp/mailheap/internal/config"
+	"github.com/rntrp/mailheap/internal/msg"
+	"github.com/rntrp/mailheap/internal/rest"
+	"github.com/rntrp/mailheap/internal/rules"
+	"github.com/rntrp/mailheap/internal/storage"
+)
+
+var methods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+func TestOpenApiDescribesAllRoutes(t *testing.T) {
+	if err := config.LoadDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	rest.InitIndex()
+	m := newMux(rest.New(nil, nil, nil, nil, nil), make(chan os.Signal, 1))
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /openapi.json: %v", rec.Code)
+	}
+	spec := struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+		t.Fatal(err)
+	}
+	described := make(map[string]bool)
+	for path, item := range spec.Paths {
+		for method := range item {
+			if slices.Contains(methods, method) {
+				described[strings.ToUpper(method)+" "+path] = true
+			}
+		}
+	}
+	registered := make(map[string]bool)
+	for _, pattern := range m.patterns {
+		registered[pattern] = true
+		if !described[pattern] {
+			t.Errorf("route %q is not described in openapi.json", pattern)
+		}
+	}
+	for op := range described {
+		if !registered[op] {
+			t.Errorf("operation %q of openapi.json is not registered", op)
+		}
+	}
+}
+
+func TestDeprecatedRoutes(t *testing.T) {
+	if err := config.LoadDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	st, err := storage.NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Shutdown()
+	engine, _ := rules.Load("")
+	svc := msg.NewAddMailSvc(st, nil, engine)
+	m := newMux(rest.New(st, svc, nil, engine, nil), make(chan os.Signal, 1))
+	del := func(path, accept string, csrf bool) *httptest.ResponseRecorder {
+		id, err := svc.StoreMail(strings.NewReader("Date: Mon, 19 Oct 2026 11:39:27 -0000\r\n" +
+			"Subject: delete me\r\n\r\nHello\r\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("%v?id=%v&csrf-token=t", path, id), nil)
+		if csrf {
+			r.Header.Set("X-Csrf-Token", "t")
+		}
+		if len(accept) > 0 {
+			r.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, r)
+		return w
+	}
+
+	w := del("/api/v1/mails", "", true)
+	if w.Code != http.StatusOK || w.Body.String() != `{"numDeleted":1}` {
+		t.Errorf("v1: unexpected response %v %q", w.Code, w.Body.String())
+	} else if w.Header().Get("Deprecation") != "" || w.Header().Get("Link") != "" {
+		t.Errorf("v1: deprecation headers %v", w.Header())
+	}
+	w = del("/mails", "", true)
+	if w.Code != http.StatusOK || w.Body.String() != `{"NumDeleted":1}` {
+		t.Errorf("legacy: unexpected response %v %q", w.Code, w.Body.String())
+	} else if w.Header().Get("Deprecation") != "true" ||
+		w.Header().Get("Link") != `</api/v1/mails>; rel="successor-version"` {
+		t.Errorf("legacy: unexpected deprecation headers %v", w.Header())
+	}
+
+	w = del("/api/v1/mails", "", false)
+	apiErr := rest.ApiError{}
+	if w.Code != http.StatusForbidden || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("v1 error: unexpected response %v %v", w.Code, w.Header())
+	} else if err := json.Unmarshal(w.Body.Bytes(), &apiErr); err != nil {
+		t.Error(err)
+	} else if apiErr.Code != "forbidden" || apiErr.Message != "Invalid CSRF token" || apiErr.Details != nil {
+		t.Errorf("v1 error: unexpected body %+v", apiErr)
+	}
+	w = del("/api/v1/mails", "application/problem+json, application/json", false)
+	problem := rest.Problem{}
+	if w.Code != http.StatusForbidden || w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("problem: unexpected response %v %v", w.Code, w.Header())
+	} else if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+		t.Error(err)
+	} else if problem != (rest.Problem{Type: "about:blank", Title: "Forbidden", Status: http.StatusForbidden,
+		Detail: "Invalid CSRF token", Instance: "/api/v1/mails", Code: "forbidden"}) {
+		t.Errorf("problem: unexpected body %+v", problem)
+	}
+	w = del("/mails", "application/problem+json", false)
+	if w.Code != http.StatusForbidden || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") ||
+		w.Body.String() != "Invalid CSRF token\n" {
+		t.Errorf("legacy error: unexpected response %v %v %q", w.Code, w.Header(), w.Body.String())
+	} else if w.Header().Get("Deprecation") != "true" {
+		t.Errorf("legacy error: deprecation header missing")
+	}
+}
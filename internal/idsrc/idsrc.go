@@ -31,11 +31,25 @@ func New() IdSrc {
 
 func Decode(id int64) (time.Time, uint8, error) {
 	if t := id >> cntOffset; t >= 0 && t <= maxEpochMilli {
-		return time.UnixMilli(t), uint8(id & maxCnt), nil
+		return time.UnixMilli(t + epochOffset), uint8(id & maxCnt), nil
 	}
 	return time.Time{}, 0, ErrInvalidID
 }
 
+// Encode builds the ID for the given time and counter value, as Gen would
+// have generated it at that time. The time is truncated to milliseconds.
+func Encode(t time.Time, cnt uint8) (int64, error) {
+	if ms := t.UnixMilli() - epochOffset; ms >= 0 && ms <= maxEpochMilli {
+		return ms<<cntOffset | int64(cnt), nil
+	}
+	return 0, ErrInvalidID
+}
+
+// Epoch returns the earliest time which can be encoded into an ID.
+func Epoch() time.Time {
+	return time.UnixMilli(epochOffset)
+}
+
 func (d *idSrcData) Gen() (int64, error) {
 	d.mtx.Lock()
 	defer d.mtx.Unlock()
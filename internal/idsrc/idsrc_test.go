@@ -14,6 +14,23 @@ func TestGenCurrentTime(t *testing.T) {
 	}
 }
 
+func TestDecodeGen(t *testing.T) {
+	// Decode used to return the time since the epoch offset instead of the
+	// time since 1970
+	before := time.Now().Add(-time.Millisecond)
+	id, err := New().Gen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, cnt, err := Decode(id)
+	if err != nil || decoded.Before(before) || decoded.After(time.Now()) || cnt != 0 {
+		t.Errorf("unexpected time %v, counter %v", decoded, cnt)
+	}
+	if _, _, err := Decode(-1); err != ErrInvalidID {
+		t.Fail()
+	}
+}
+
 func TestGenUnique(t *testing.T) {
 	src := New()
 	var wg sync.WaitGroup
@@ -43,3 +60,16 @@ func TestGenUnique(t *testing.T) {
 		}
 	}
 }
+
+func TestEncodeDecode(t *testing.T) {
+	now := time.UnixMilli(time.Now().UnixMilli())
+	id, err := Encode(now, 42)
+	if err != nil {
+		t.Fatal(err)
+	} else if decoded, cnt, err := Decode(id); err != nil || !decoded.Equal(now) || cnt != 42 {
+		t.Fail()
+	}
+	if _, err := Encode(Epoch().Add(-time.Millisecond), 0); err != ErrInvalidID {
+		t.Fail()
+	}
+}
@@ -0,0 +1,451 @@
+package imapsrv
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/rntrp/mailheap/internal/model"
+	"github.com/rntrp/mailheap/internal/msg"
+	"github.com/rntrp/mailheap/internal/storage"
+)
+
+const (
+	inbox         = "INBOX"
+	delimiter     = "/"
+	updateTimeout = 5 * time.Second
+)
+
+var ErrReadOnlyMailboxes = errors.New("mailboxes cannot be modified")
+
+type entry struct {
+	uid   uint32
+	mail  model.Mail
+	flags []string
+}
+
+// mailbox holds the messages of all folders shared by all IMAP sessions.
+// INBOX contains all mails, the other folders are named after the mailbox of
+// their mails, so they share the UIDs of INBOX. \Seen, \Flagged and keywords
+// map to the seen, starred and tags state of the mails; UIDs and other flags
+// are kept in memory, so UIDVALIDITY changes with every restart. Each
+// selected folder is a session with its own sequence numbers.
+type mailbox struct {
+	storage     storage.MailStorage
+	storeMail   msg.StoreMailSvc
+	updates     chan backend.Update
+	uidValidity uint32
+	mtx         sync.Mutex
+	msgs        []*entry // in ascending UID order
+	byId        map[int64]*entry
+	nextUid     uint32
+	sessions    map[sessionKey]*session
+	lastSession int
+}
+
+type sessionKey struct {
+	user   *user
+	folder string
+}
+
+func newMailbox(s storage.MailStorage, a msg.StoreMailSvc) *mailbox {
+	return &mailbox{
+		storage:     s,
+		storeMail:   a,
+		updates:     make(chan backend.Update),
+		uidValidity: uint32(time.Now().Unix()),
+		msgs:        make([]*entry, 0),
+		byId:        make(map[int64]*entry),
+		nextUid:     1,
+		sessions:    make(map[sessionKey]*session),
+	}
+}
+
+// follow applies the storage events until stop is closed or the storage is
+// shut down. If the storage drops the subscription, the mailbox subscribes
+// again and reloads all mails.
+func (m *mailbox) follow(events <-chan storage.Event, unsubscribe func(), stop <-chan struct{}) {
+	defer func() { unsubscribe() }()
+	for {
+		var err error
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			} else if e.Type == storage.EventOverflow {
+				events, unsubscribe = m.storage.Subscribe()
+				err = m.resync()
+			} else {
+				err = m.handle(e)
+			}
+		case <-stop:
+			return
+		}
+		if err != nil {
+			slog.Error("IMAP: updating mailbox failed", "error", err.Error())
+		}
+	}
+}
+
+// handle applies a storage event and notifies the sessions. Only the mails
+// named by the event are loaded from the storage.
+func (m *mailbox) handle(e storage.Event) error {
+	switch {
+	case e.Type == storage.EventDeleted:
+		m.remove(e.Ids)
+		return nil
+	case len(e.Ids) == 0:
+		return nil
+	}
+	_, err := m.refresh(e.Ids...)
+	return err
+}
+
+// refresh loads the given mails from the storage, appending unknown ones and
+// updating the flags of known ones.
+func (m *mailbox) refresh(ids ...int64) ([]*entry, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	mails := make([]model.Mail, 0, len(ids))
+	err := m.storage.WalkMails(storage.Filter{Ids: ids}, func(mail model.Mail) error {
+		mail.Mime = ""
+		mails = append(mails, mail)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.mtx.Lock()
+	entries, changed := m.apply(mails)
+	m.mtx.Unlock()
+	m.notify(changed, nil)
+	return entries, nil
+}
+
+// apply adds or updates entries for the mails. The caller must hold m.mtx.
+func (m *mailbox) apply(mails []model.Mail) ([]*entry, []uint32) {
+	entries := make([]*entry, 0, len(mails))
+	changed := make([]uint32, 0)
+	for _, mail := range mails {
+		e, ok := m.byId[mail.Id]
+		if !ok {
+			// new mails are appended regardless of their ID, since UIDs must
+			// ascend with the sequence numbers
+			e = &entry{uid: m.nextUid, mail: mail, flags: flagsOf(mail)}
+			m.nextUid++
+			m.msgs = append(m.msgs, e)
+			m.byId[mail.Id] = e
+		} else if flags := append(flagsOf(mail), sessionFlags(e.flags)...); !sameFlags(e.flags, flags) {
+			e.mail = mail
+			e.flags = flags
+			changed = append(changed, e.uid)
+		} else {
+			e.mail = mail
+		}
+		entries = append(entries, e)
+	}
+	return entries, changed
+}
+
+// resync reloads all mails after missed events, dropping the entries of
+// deleted mails.
+func (m *mailbox) resync() error {
+	mails, err := m.storage.ListMails()
+	if err != nil {
+		return err
+	}
+	ids := make(map[int64]bool, len(mails))
+	for _, mail := range mails {
+		ids[mail.Id] = true
+	}
+	m.mtx.Lock()
+	for id := range m.byId {
+		if !ids[id] {
+			delete(m.byId, id)
+		}
+	}
+	m.msgs = slices.DeleteFunc(m.msgs, func(e *entry) bool {
+		return !ids[e.mail.Id]
+	})
+	_, changed := m.apply(mails)
+	m.mtx.Unlock()
+	m.notify(changed, nil)
+	return nil
+}
+
+// remove drops the entries of the mails, or all entries if ids is nil.
+func (m *mailbox) remove(ids []int64) {
+	m.mtx.Lock()
+	if ids == nil {
+		clear(m.byId)
+		m.msgs = m.msgs[:0]
+	} else {
+		for _, id := range ids {
+			delete(m.byId, id)
+		}
+		m.msgs = slices.DeleteFunc(m.msgs, func(e *entry) bool {
+			return m.byId[e.mail.Id] != e
+		})
+	}
+	m.mtx.Unlock()
+	m.notify(nil, nil)
+}
+
+// lookup returns the entry with the UID. The caller must hold m.mtx.
+func (m *mailbox) lookup(uid uint32) (*entry, bool) {
+	i, ok := slices.BinarySearchFunc(m.msgs, uid, func(e *entry, uid uint32) int {
+		return cmp.Compare(e.uid, uid)
+	})
+	if !ok {
+		return nil, false
+	}
+	return m.msgs[i], true
+}
+
+// folders returns the distinct mailboxes of the mails besides INBOX, sorted
+// by name.
+func (m *mailbox) folders() []string {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	res := make([]string, 0)
+	for _, e := range m.msgs {
+		if f := e.mail.Mailbox; len(f) > 0 && !isInbox(f) && !slices.Contains(res, f) {
+			res = append(res, f)
+		}
+	}
+	slices.Sort(res)
+	return res
+}
+
+// lookupFolder resolves the IMAP name of a folder. Folders other than INBOX
+// only exist while they contain mails.
+func (m *mailbox) lookupFolder(name string) (string, bool) {
+	if isInbox(name) {
+		return "", true
+	}
+	return name, slices.Contains(m.folders(), name)
+}
+
+// newSession creates a session of the user for the folder, which is empty
+// for INBOX. It does not see any messages before it is opened.
+func (m *mailbox) newSession(u *user, folder string) *session {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.lastSession++
+	return &session{
+		mbox:    m,
+		user:    u,
+		folder:  folder,
+		name:    fmt.Sprintf("%v#%v", folderName(folder), m.lastSession),
+		uids:    make([]uint32, 0),
+		changed: make(map[uint32]bool),
+	}
+}
+
+// open starts the delivery of updates to the session, seeing all current
+// messages of its folder. A connection only receives updates for the session
+// of a folder it opened last, as STATUS should not be used on the selected
+// folder.
+func (m *mailbox) open(s *session) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	key := sessionKey{user: s.user, folder: s.folder}
+	if s.done != nil {
+		return // opened before
+	} else if prev, ok := m.sessions[key]; ok {
+		prev.stop()
+	}
+	s.uids = s.uids[:0]
+	for _, e := range m.msgs {
+		if s.contains(e) {
+			s.uids = append(s.uids, e.uid)
+		}
+	}
+	s.open = true
+	s.kick = make(chan struct{}, 1)
+	s.done = make(chan struct{})
+	m.sessions[key] = s
+	go s.run()
+}
+
+// close stops the sessions of the user.
+func (m *mailbox) close(u *user) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	for key, s := range m.sessions {
+		if key.user == u {
+			s.stop()
+			delete(m.sessions, key)
+		}
+	}
+}
+
+// notify tells all sessions but except about new and expunged messages as
+// well as about the messages with changed flags.
+func (m *mailbox) notify(changed []uint32, except *session) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	for _, s := range m.sessions {
+		if s == except {
+			continue
+		}
+		for _, uid := range changed {
+			s.changed[uid] = true
+		}
+		select {
+		case s.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// send passes the updates to the server one after the other, so that they
+// reach the client in order.
+func (m *mailbox) send(updates []backend.Update) {
+	for _, u := range updates {
+		// Done creates the channel lazily, which must not race with the server
+		done := u.Done()
+		select {
+		case m.updates <- u:
+			select {
+			case <-done:
+			case <-time.After(updateTimeout):
+			}
+		case <-time.After(updateTimeout):
+		}
+	}
+}
+
+// update changes the flags of the message with the UID and returns them.
+func (m *mailbox) update(uid uint32, op imap.FlagsOp, flags []string) ([]string, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	e, ok := m.lookup(uid)
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	err := m.updateFlags(e, op, flags)
+	return slices.Clone(e.flags), err
+}
+
+// updateFlags changes the flags of a message and persists the changes of
+// \Seen, \Flagged and keywords. The caller must hold m.mtx.
+func (m *mailbox) updateFlags(msg *entry, op imap.FlagsOp, flags []string) error {
+	old := msg.flags
+	msg.flags = applyFlags(old, op, flags)
+	u := storage.StateUpdate{}
+	if seen := slices.Contains(msg.flags, imap.SeenFlag); seen != slices.Contains(old, imap.SeenFlag) {
+		u.Seen = &seen
+	}
+	if starred := slices.Contains(msg.flags, imap.FlaggedFlag); starred != slices.Contains(old, imap.FlaggedFlag) {
+		u.Starred = &starred
+	}
+	for _, f := range msg.flags {
+		if isKeyword(f) && !containsFold(old, f) {
+			u.AddTags = append(u.AddTags, f)
+		}
+	}
+	for _, f := range old {
+		if isKeyword(f) && !containsFold(msg.flags, f) {
+			u.RemoveTags = append(u.RemoveTags, f)
+		}
+	}
+	if u.Seen == nil && u.Starred == nil && len(u.AddTags) == 0 && len(u.RemoveTags) == 0 {
+		return nil
+	}
+	_, err := m.storage.UpdateState(storage.Filter{Ids: []int64{msg.mail.Id}}, u)
+	return err
+}
+
+// flagsOf derives the persistent flags from the state of the mail. Tags which
+// are no valid IMAP atoms cannot be represented as keywords and are omitted.
+func flagsOf(mail model.Mail) []string {
+	flags := make([]string, 0)
+	if mail.Seen {
+		flags = append(flags, imap.SeenFlag)
+	}
+	if mail.Starred {
+		flags = append(flags, imap.FlaggedFlag)
+	}
+	var tags []string
+	json.Unmarshal([]byte(mail.Tags), &tags)
+	for _, t := range tags {
+		if isKeyword(t) && !containsFold(flags, t) {
+			flags = append(flags, t)
+		}
+	}
+	return flags
+}
+
+// applyFlags is like backendutil.UpdateFlags, but compares the flags
+// case-insensitively, since keywords are canonicalized to lower case.
+func applyFlags(current []string, op imap.FlagsOp, flags []string) []string {
+	res := make([]string, 0, len(current)+len(flags))
+	switch op {
+	case imap.SetFlags:
+		current = nil
+	case imap.RemoveFlags:
+		for _, f := range current {
+			if !containsFold(flags, f) {
+				res = append(res, f)
+			}
+		}
+		return res
+	}
+	for _, f := range append(slices.Clone(current), flags...) {
+		if f != imap.RecentFlag && !containsFold(res, f) {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+// sessionFlags returns the flags which are only kept in memory.
+func sessionFlags(flags []string) []string {
+	res := make([]string, 0)
+	for _, f := range flags {
+		if f != imap.SeenFlag && f != imap.FlaggedFlag && !isKeyword(f) {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+func isKeyword(flag string) bool {
+	if len(flag) == 0 || strings.HasPrefix(flag, "\\") {
+		return false
+	}
+	for _, r := range flag {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune(`(){%*"\]`, r) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsFold(flags []string, flag string) bool {
+	return slices.ContainsFunc(flags, func(f string) bool {
+		return strings.EqualFold(f, flag)
+	})
+}
+
+func sameFlags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, f := range a {
+		if !containsFold(b, f) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+package imapsrv
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/rntrp/mailheap/internal/model"
+	"github.com/rntrp/mailheap/internal/storage"
+)
+
+func TestFlagsOf(t *testing.T) {
+	for _, tc := range []struct {
+		mail  model.Mail
+		flags []string
+	}{
+		{model.Mail{Tags: `[]`}, []string{}},
+		{model.Mail{Seen: true, Starred: true, Tags: `["invoice","Invoice","two words","\\Deleted","(x)","ümlaut"]`},
+			[]string{imap.SeenFlag, imap.FlaggedFlag, "invoice"}},
+		{model.Mail{Starred: true, Tags: `not json`}, []string{imap.FlaggedFlag}},
+	} {
+		if got := flagsOf(tc.mail); !slices.Equal(got, tc.flags) {
+			t.Errorf("%+v: got %v, want %v", tc.mail, got, tc.flags)
+		}
+	}
+}
+
+func TestApplyFlags(t *testing.T) {
+	current := []string{imap.SeenFlag, "invoice"}
+	for _, tc := range []struct {
+		op    imap.FlagsOp
+		flags []string
+		want  []string
+	}{
+		{imap.AddFlags, []string{"INVOICE", imap.FlaggedFlag, imap.RecentFlag}, []string{imap.SeenFlag, "invoice", imap.FlaggedFlag}},
+		{imap.RemoveFlags, []string{"Invoice", "missing"}, []string{imap.SeenFlag}},
+		{imap.SetFlags, []string{imap.AnsweredFlag, "a", "A"}, []string{imap.AnsweredFlag, "a"}},
+		{imap.SetFlags, []string{}, []string{}},
+	} {
+		if got := applyFlags(current, tc.op, tc.flags); !slices.Equal(got, tc.want) {
+			t.Errorf("%v %v: got %v, want %v", tc.op, tc.flags, got, tc.want)
+		}
+	}
+	if !slices.Equal(current, []string{imap.SeenFlag, "invoice"}) {
+		t.Errorf("current flags modified: %v", current)
+	}
+}
+
+func TestResync(t *testing.T) {
+	st, err := storage.NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Shutdown()
+	ids := make([]int64, 0)
+	for range 3 {
+		id, err := st.AddMail(model.Mail{Created: time.Now(), Tags: `[]`})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	m := newMailbox(st, nil)
+	m.apply([]model.Mail{{Id: ids[0], Tags: `[]`}, {Id: ids[1], Tags: `[]`}})
+	// a deleted mail, which gets removed, and a missed one, which gets UID 4
+	m.apply([]model.Mail{{Id: ids[0] - 1, Tags: `[]`}})
+	seen := true
+	if _, err := st.UpdateState(storage.Filter{Ids: ids[:1]}, storage.StateUpdate{Seen: &seen}); err != nil {
+		t.Fatal(err)
+	} else if err := m.resync(); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]int64, 0)
+	for _, e := range m.msgs {
+		got = append(got, e.mail.Id)
+	}
+	if !slices.Equal(got, ids) || len(m.byId) != 3 {
+		t.Errorf("unexpected mails %v", got)
+	} else if !slices.Equal(m.msgs[0].flags, []string{imap.SeenFlag}) {
+		t.Errorf("flags not updated: %v", m.msgs[0].flags)
+	} else if m.msgs[2].uid != 4 {
+		t.Errorf("unexpected UID %v", m.msgs[2].uid)
+	}
+}
@@ -0,0 +1,437 @@
+package imapsrv
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/backend/backendutil"
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/textproto"
+	"github.com/rntrp/mailheap/internal/storage"
+)
+
+// session is a folder as seen by one connection. The sequence numbers of a
+// session only change when its client is told about expunged and new
+// messages. Expunges are held back while a FETCH, STORE or SEARCH is running.
+type session struct {
+	mbox *mailbox
+	user *user
+	// folder is the mailbox of the mails in the session, or empty for INBOX
+	// containing all mails.
+	folder string
+	// name is unique per session, so that the server routes the updates of
+	// a session to its connection only.
+	name    string
+	sendMtx sync.Mutex
+	kick    chan struct{}
+	done    chan struct{}
+	// guarded by mbox.mtx
+	uids    []uint32
+	changed map[uint32]bool
+	busy    int
+	open    bool
+}
+
+func (s *session) run() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.kick:
+			s.flush(false)
+		}
+	}
+}
+
+// contains reports whether the message belongs to the folder of the session.
+func (s *session) contains(e *entry) bool {
+	return len(s.folder) == 0 || e.mail.Mailbox == s.folder
+}
+
+// stop ends the delivery of updates. The caller must hold s.mbox.mtx.
+func (s *session) stop() {
+	if s.open {
+		s.open = false
+		close(s.done)
+	}
+}
+
+// flush sends the pending updates of the session. Expunges are only sent if
+// expunge is set or no command is running.
+func (s *session) flush(expunge bool) {
+	s.sendMtx.Lock()
+	defer s.sendMtx.Unlock()
+	s.mbox.mtx.Lock()
+	updates := s.pending(expunge || s.busy == 0)
+	s.mbox.mtx.Unlock()
+	s.mbox.send(updates)
+}
+
+// pending brings the view of the session up to date and returns the updates
+// telling the client about it. The caller must hold s.mbox.mtx.
+func (s *session) pending(expunge bool) []backend.Update {
+	m := s.mbox
+	updates := make([]backend.Update, 0)
+	if expunge {
+		for i := len(s.uids) - 1; i >= 0; i-- {
+			if _, ok := m.lookup(s.uids[i]); !ok {
+				s.uids = slices.Delete(s.uids, i, i+1)
+				updates = append(updates, &backend.ExpungeUpdate{
+					Update: backend.NewUpdate("", s.name),
+					SeqNum: uint32(i + 1),
+				})
+			}
+		}
+	}
+	for _, uid := range slices.Sorted(maps.Keys(s.changed)) {
+		i, ok := slices.BinarySearch(s.uids, uid)
+		e, found := m.lookup(uid)
+		if !ok || !found {
+			continue
+		}
+		fetched := imap.NewMessage(uint32(i+1), []imap.FetchItem{imap.FetchFlags, imap.FetchUid})
+		fetched.Flags = slices.Clone(e.flags)
+		fetched.Uid = uid
+		updates = append(updates, &backend.MessageUpdate{
+			Update:  backend.NewUpdate("", s.name),
+			Message: fetched,
+		})
+	}
+	clear(s.changed)
+	last := uint32(0)
+	if len(s.uids) > 0 {
+		last = s.uids[len(s.uids)-1]
+	}
+	added := false
+	for _, e := range m.msgs {
+		if e.uid > last && s.contains(e) {
+			s.uids = append(s.uids, e.uid)
+			added = true
+		}
+	}
+	if added {
+		status := imap.NewMailboxStatus(folderName(s.folder), []imap.StatusItem{imap.StatusMessages})
+		status.Messages = uint32(len(s.uids))
+		updates = append(updates, &backend.MailboxUpdate{
+			Update:        backend.NewUpdate("", s.name),
+			MailboxStatus: status,
+		})
+	}
+	return updates
+}
+
+// begin marks a command as running, which must not be interleaved with
+// expunges.
+func (s *session) begin() {
+	s.mbox.mtx.Lock()
+	defer s.mbox.mtx.Unlock()
+	s.busy++
+}
+
+func (s *session) end() {
+	s.mbox.mtx.Lock()
+	defer s.mbox.mtx.Unlock()
+	s.busy--
+}
+
+type seqMessage struct {
+	seqNum uint32
+	msg    entry
+}
+
+// match returns copies of the messages contained in seqSet. Messages which
+// are expunged, but not yet reported to the client, are skipped.
+func (s *session) match(uid bool, seqSet *imap.SeqSet) []seqMessage {
+	s.mbox.mtx.Lock()
+	defer s.mbox.mtx.Unlock()
+	res := make([]seqMessage, 0)
+	for i, u := range s.uids {
+		seqNum := uint32(i + 1)
+		id := seqNum
+		if uid {
+			id = u
+		}
+		if seqSet != nil && !seqSet.Contains(id) {
+			continue
+		} else if e, ok := s.mbox.lookup(u); ok {
+			cp := *e
+			cp.flags = slices.Clone(e.flags)
+			res = append(res, seqMessage{seqNum: seqNum, msg: cp})
+		}
+	}
+	return res
+}
+
+func (s *session) Name() string {
+	return s.name
+}
+
+func (s *session) Info() (*imap.MailboxInfo, error) {
+	return &imap.MailboxInfo{Delimiter: delimiter, Name: folderName(s.folder)}, nil
+}
+
+// Status starts the delivery of updates to the session, as the server asks
+// for the status when selecting the mailbox.
+func (s *session) Status(items []imap.StatusItem) (*imap.MailboxStatus, error) {
+	s.mbox.open(s)
+	s.mbox.mtx.Lock()
+	defer s.mbox.mtx.Unlock()
+	status := imap.NewMailboxStatus(folderName(s.folder), items)
+	status.Flags = []string{imap.SeenFlag, imap.FlaggedFlag, imap.DeletedFlag}
+	status.PermanentFlags = []string{imap.SeenFlag, imap.FlaggedFlag, imap.DeletedFlag,
+		imap.TryCreateFlag}
+	unseen := uint32(0)
+	for i, uid := range s.uids {
+		if e, ok := s.mbox.lookup(uid); ok && !slices.Contains(e.flags, imap.SeenFlag) {
+			if unseen == 0 {
+				status.UnseenSeqNum = uint32(i + 1)
+			}
+			unseen++
+		}
+	}
+	for _, item := range items {
+		switch item {
+		case imap.StatusMessages:
+			status.Messages = uint32(len(s.uids))
+		case imap.StatusUidNext:
+			status.UidNext = s.mbox.nextUid
+		case imap.StatusUidValidity:
+			status.UidValidity = s.mbox.uidValidity
+		case imap.StatusRecent:
+			status.Recent = 0
+		case imap.StatusUnseen:
+			status.Unseen = unseen
+		}
+	}
+	return status, nil
+}
+
+func (s *session) SetSubscribed(subscribed bool) error {
+	return nil
+}
+
+func (s *session) Check() error {
+	return nil
+}
+
+// Poll reports the pending updates including expunges, which is what NOOP
+// is used for.
+func (s *session) Poll() error {
+	s.flush(true)
+	return nil
+}
+
+func (s *session) ListMessages(uid bool, seqSet *imap.SeqSet, items []imap.FetchItem,
+	ch chan<- *imap.Message) error {
+	s.begin()
+	defer s.end()
+	defer close(ch)
+	seen := make([]uint32, 0)
+	defer func() { s.mbox.notify(seen, s) }()
+	for _, sm := range s.match(uid, seqSet) {
+		fetched, markSeen, err := s.mbox.fetch(sm, items)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue // deleted in the meantime
+		} else if err != nil {
+			return err
+		}
+		if markSeen {
+			// the client is told about the implicit \Seen with the message
+			flags, err := s.mbox.update(sm.msg.uid, imap.AddFlags, []string{imap.SeenFlag})
+			if errors.Is(err, storage.ErrNotFound) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			fetched.Items[imap.FetchFlags] = nil
+			fetched.Flags = flags
+			seen = append(seen, sm.msg.uid)
+		}
+		ch <- fetched
+	}
+	return nil
+}
+
+// fetch builds the FETCH response for a message. The MIME content is only
+// loaded from the storage if any of the items requires it. Fetching a body
+// section without PEEK sets the \Seen flag.
+func (m *mailbox) fetch(sm seqMessage, items []imap.FetchItem) (*imap.Message, bool, error) {
+	fetched := imap.NewMessage(sm.seqNum, items)
+	markSeen := false
+	var raw []byte
+	read := func() (textproto.Header, io.Reader, error) {
+		if raw == nil {
+			mime, err := m.storage.GetMime(sm.msg.mail.Id)
+			if err != nil {
+				return textproto.Header{}, nil, err
+			}
+			raw = []byte(mime)
+		}
+		body := bufio.NewReader(bytes.NewReader(raw))
+		hdr, err := textproto.ReadHeader(body)
+		return hdr, body, err
+	}
+	for _, item := range items {
+		switch item {
+		case imap.FetchEnvelope:
+			hdr, _, err := read()
+			if err != nil {
+				return nil, false, err
+			}
+			fetched.Envelope, _ = backendutil.FetchEnvelope(hdr)
+		case imap.FetchBody, imap.FetchBodyStructure:
+			hdr, body, err := read()
+			if err != nil {
+				return nil, false, err
+			}
+			fetched.BodyStructure, _ = backendutil.FetchBodyStructure(hdr, body,
+				item == imap.FetchBodyStructure)
+		case imap.FetchFlags:
+			fetched.Flags = sm.msg.flags
+		case imap.FetchInternalDate:
+			fetched.InternalDate = sm.msg.mail.Created
+		case imap.FetchRFC822Size:
+			fetched.Size = uint32(sm.msg.mail.Size)
+		case imap.FetchUid:
+			fetched.Uid = sm.msg.uid
+		default:
+			section, err := imap.ParseBodySectionName(item)
+			if err != nil {
+				break
+			}
+			hdr, body, err := read()
+			if err != nil {
+				return nil, false, err
+			}
+			l, _ := backendutil.FetchBodySection(hdr, body, section)
+			fetched.Body[section] = l
+			markSeen = markSeen || !section.Peek
+		}
+	}
+	return fetched, markSeen && !slices.Contains(sm.msg.flags, imap.SeenFlag), nil
+}
+
+func (s *session) SearchMessages(uid bool, criteria *imap.SearchCriteria) ([]uint32, error) {
+	s.begin()
+	defer s.end()
+	ids := make([]uint32, 0)
+	for _, sm := range s.match(uid, nil) {
+		mime, err := s.mbox.storage.GetMime(sm.msg.mail.Id)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		// unknown charsets yield an error, but still a usable entity
+		e, _ := message.Read(bytes.NewReader([]byte(mime)))
+		if e == nil {
+			continue
+		}
+		ok, err := backendutil.Match(e, sm.seqNum, sm.msg.uid, sm.msg.mail.Created,
+			sm.msg.flags, criteria)
+		if err != nil || !ok {
+			continue
+		} else if uid {
+			ids = append(ids, sm.msg.uid)
+		} else {
+			ids = append(ids, sm.seqNum)
+		}
+	}
+	return ids, nil
+}
+
+func (s *session) CreateMessage(flags []string, date time.Time, body imap.Literal) error {
+	id, err := s.mbox.storeMail.DeliverMail(body, s.folder)
+	if err != nil {
+		return err
+	}
+	entries, err := s.mbox.refresh(id)
+	if err != nil || len(entries) == 0 {
+		return err
+	} else if _, err := s.mbox.update(entries[0].uid, imap.SetFlags, flags); err != nil {
+		return err
+	}
+	s.mbox.notify([]uint32{entries[0].uid}, nil)
+	return nil
+}
+
+// UpdateMessagesFlags sends the new flags to all sessions, including this
+// one unless the STORE is silent.
+func (s *session) UpdateMessagesFlags(uid bool, seqSet *imap.SeqSet, op imap.FlagsOp,
+	flags []string) error {
+	s.begin()
+	defer s.end()
+	changed := make([]uint32, 0)
+	err := func() error {
+		s.mbox.mtx.Lock()
+		defer s.mbox.mtx.Unlock()
+		for i, u := range s.uids {
+			id := uint32(i + 1)
+			if uid {
+				id = u
+			}
+			if e, ok := s.mbox.lookup(u); ok && seqSet.Contains(id) {
+				if err := s.mbox.updateFlags(e, op, flags); err != nil {
+					return err
+				}
+				changed = append(changed, u)
+			}
+		}
+		return nil
+	}()
+	s.mbox.notify(changed, nil)
+	s.flush(false)
+	return err
+}
+
+// CopyMessages stores copies of the messages in the mailbox of the folder,
+// as the mailbox of a mail cannot be changed.
+func (s *session) CopyMessages(uid bool, seqSet *imap.SeqSet, dest string) error {
+	folder, ok := s.mbox.lookupFolder(dest)
+	if !ok {
+		return backend.ErrNoSuchMailbox
+	}
+	ids := make([]int64, 0)
+	for _, sm := range s.match(uid, seqSet) {
+		mime, err := s.mbox.storage.GetMime(sm.msg.mail.Id)
+		if err != nil {
+			return err
+		}
+		id, err := s.mbox.storeMail.DeliverMail(bytes.NewReader([]byte(mime)), folder)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	if _, err := s.mbox.refresh(ids...); err != nil {
+		return err
+	}
+	s.flush(false)
+	return nil
+}
+
+// Expunge deletes all messages flagged as \Deleted from the storage.
+func (s *session) Expunge() error {
+	ids := make([]int64, 0)
+	for _, sm := range s.match(false, nil) {
+		if slices.Contains(sm.msg.flags, imap.DeletedFlag) {
+			ids = append(ids, sm.msg.mail.Id)
+		}
+	}
+	if len(ids) > 0 {
+		if _, err := s.mbox.storage.DeleteMails(ids...); err != nil {
+			return err
+		}
+		s.mbox.remove(ids)
+	}
+	s.flush(true)
+	return nil
+}
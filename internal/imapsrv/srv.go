@@ -0,0 +1,148 @@
+package imapsrv
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"log/slog"
+	"net"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/server"
+	"github.com/rntrp/mailheap/internal/config"
+	"github.com/rntrp/mailheap/internal/msg"
+	"github.com/rntrp/mailheap/internal/storage"
+)
+
+var ErrServerClosed = errors.New("imap: server closed")
+
+type Server struct {
+	srv  *server.Server
+	mbox *mailbox
+	stop chan struct{}
+}
+
+// New creates an IMAP server exposing all stored mails as INBOX and the mails
+// of each mailbox as folder of the same name. The folders are kept in sync
+// with the storage events, so that IDLE sessions get notified about new,
+// changed and deleted mails.
+func New(s storage.MailStorage, a msg.StoreMailSvc, tlsConfig *tls.Config) (*Server, error) {
+	mbox := newMailbox(s, a)
+	mails, err := s.ListMails()
+	if err != nil {
+		return nil, err
+	}
+	mbox.apply(mails)
+	events, unsubscribe := s.Subscribe()
+	stop := make(chan struct{})
+	go mbox.follow(events, unsubscribe, stop)
+	srv := server.New(&bkd{
+		username: config.GetIMAPUsername(),
+		password: config.GetIMAPPassword(),
+		mbox:     mbox,
+	})
+	srv.Addr = config.GetIMAPAddress()
+	srv.AllowInsecureAuth = config.IsIMAPAllowInsecureAuth()
+	srv.TLSConfig = tlsConfig
+	srv.ErrorLog = log.New(slogWriter{}, "", 0)
+	return &Server{srv: srv, mbox: mbox, stop: stop}, nil
+}
+
+func (s *Server) Addr() string {
+	return s.srv.Addr
+}
+
+func (s *Server) ListenAndServe() error {
+	err := s.srv.ListenAndServe()
+	if errors.Is(err, net.ErrClosed) {
+		return ErrServerClosed
+	}
+	return err
+}
+
+func (s *Server) Shutdown(_ context.Context) error {
+	close(s.stop)
+	return s.srv.Close()
+}
+
+type slogWriter struct{}
+
+func (slogWriter) Write(p []byte) (int, error) {
+	slog.Warn("IMAP: " + strings.TrimSpace(string(p)))
+	return len(p), nil
+}
+
+type bkd struct {
+	username string
+	password string
+	mbox     *mailbox
+}
+
+func (b *bkd) Login(info *imap.ConnInfo, username, password string) (backend.User, error) {
+	if username != b.username || password != b.password {
+		return nil, backend.ErrInvalidCredentials
+	}
+	slog.Info("IMAP login", "remote", info.RemoteAddr.String(), "user", username)
+	return &user{name: username, mbox: b.mbox}, nil
+}
+
+func (b *bkd) Updates() <-chan backend.Update {
+	return b.mbox.updates
+}
+
+type user struct {
+	name string
+	mbox *mailbox
+}
+
+func (u *user) Username() string {
+	return u.name
+}
+
+func (u *user) ListMailboxes(subscribed bool) ([]backend.Mailbox, error) {
+	res := []backend.Mailbox{u.mbox.newSession(u, "")}
+	for _, f := range u.mbox.folders() {
+		res = append(res, u.mbox.newSession(u, f))
+	}
+	return res, nil
+}
+
+func (u *user) GetMailbox(name string) (backend.Mailbox, error) {
+	folder, ok := u.mbox.lookupFolder(name)
+	if !ok {
+		return nil, backend.ErrNoSuchMailbox
+	}
+	return u.mbox.newSession(u, folder), nil
+}
+
+func (u *user) CreateMailbox(name string) error {
+	return ErrReadOnlyMailboxes
+}
+
+func (u *user) DeleteMailbox(name string) error {
+	return ErrReadOnlyMailboxes
+}
+
+func (u *user) RenameMailbox(existingName, newName string) error {
+	return ErrReadOnlyMailboxes
+}
+
+func (u *user) Logout() error {
+	u.mbox.close(u)
+	return nil
+}
+
+func isInbox(name string) bool {
+	return strings.EqualFold(name, inbox)
+}
+
+// folderName returns the IMAP name of the folder.
+func folderName(folder string) string {
+	if len(folder) == 0 {
+		return inbox
+	}
+	return folder
+}
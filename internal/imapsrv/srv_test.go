@@ -0,0 +1,286 @@
+package imapsrv
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/client"
+	"github.com/rntrp/mailheap/internal/config"
+	"github.com/rntrp/mailheap/internal/model"
+	"github.com/rntrp/mailheap/internal/msg"
+	"github.com/rntrp/mailheap/internal/rules"
+	"github.com/rntrp/mailheap/internal/storage"
+)
+
+func eml(subject string) *strings.Reader {
+	return strings.NewReader("From: alice@example.com\r\n" +
+		"To: bob@example.com\r\n" +
+		"Date: Mon, 19 Oct 2026 11:39:27 -0000\r\n" +
+		"Subject: " + subject + "\r\n\r\nHello\r\n")
+}
+
+func newTestServer(t *testing.T, mails int) (string, storage.MailStorage, msg.StoreMailSvc) {
+	t.Helper()
+	if err := config.LoadDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	st, err := storage.NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, _ := rules.Load("")
+	svc := msg.NewAddMailSvc(st, nil, engine)
+	for i := range mails {
+		if _, err := svc.StoreMail(eml(fmt.Sprint(i + 1))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := New(st, svc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.srv.Serve(l)
+	t.Cleanup(func() {
+		s.Shutdown(context.Background())
+		st.Shutdown()
+	})
+	return l.Addr().String(), st, svc
+}
+
+// dial logs in and selects INBOX. Unilateral responses are passed to the
+// returned channel.
+func dial(t *testing.T, addr string) (*client.Client, chan client.Update) {
+	t.Helper()
+	c, err := client.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Logout() })
+	updates := make(chan client.Update, 64)
+	c.Updates = updates
+	if err := c.Login(config.GetIMAPUsername(), config.GetIMAPPassword()); err != nil {
+		t.Fatal(err)
+	} else if _, err := c.Select(inbox, false); err != nil {
+		t.Fatal(err)
+	}
+	return c, updates
+}
+
+// next waits for the next update of the given type.
+func next[T client.Update](t *testing.T, updates chan client.Update) T {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case u := <-updates:
+			if u, ok := u.(T); ok {
+				return u
+			}
+		case <-timeout:
+			var u T
+			t.Fatalf("no %T received", u)
+			return u
+		}
+	}
+}
+
+func fetch(t *testing.T, c *client.Client, seqs string, items ...imap.FetchItem) []*imap.Message {
+	t.Helper()
+	seqSet, err := imap.ParseSeqSet(seqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan *imap.Message, 16)
+	if err := c.Fetch(seqSet, items, ch); err != nil {
+		t.Fatal(err)
+	}
+	res := make([]*imap.Message, 0)
+	for m := range ch {
+		res = append(res, m)
+	}
+	return res
+}
+
+func uids(msgs []*imap.Message) []uint32 {
+	res := make([]uint32, 0, len(msgs))
+	for _, m := range msgs {
+		res = append(res, m.Uid)
+	}
+	return res
+}
+
+func TestSessions(t *testing.T) {
+	addr, _, svc := newTestServer(t, 3)
+	a, aUpdates := dial(t, addr)
+	b, bUpdates := dial(t, addr)
+	seqSet, _ := imap.ParseSeqSet("2")
+	if err := a.Store(seqSet, imap.FormatFlagsOp(imap.AddFlags, true), []any{imap.DeletedFlag}, nil); err != nil {
+		t.Fatal(err)
+	}
+	expunged := make(chan uint32, 4)
+	if err := a.Expunge(expunged); err != nil {
+		t.Fatal(err)
+	} else if n := <-expunged; n != 2 {
+		t.Errorf("unexpected expunge %v", n)
+	}
+	if u := next[*client.ExpungeUpdate](t, bUpdates); u.SeqNum != 2 {
+		t.Errorf("unexpected expunge %v", u.SeqNum)
+	}
+	if got := uids(fetch(t, b, "1:*", imap.FetchUid)); !slices.Equal(got, []uint32{1, 3}) {
+		t.Errorf("unexpected UIDs %v", got)
+	}
+	if _, err := svc.StoreMail(eml("4")); err != nil {
+		t.Fatal(err)
+	}
+	// the status in the update is shared with the client, so the new
+	// messages are fetched instead
+	next[*client.MailboxUpdate](t, aUpdates)
+	next[*client.MailboxUpdate](t, bUpdates)
+	for _, c := range []*client.Client{a, b} {
+		if got := uids(fetch(t, c, "1:*", imap.FetchUid)); !slices.Equal(got, []uint32{1, 3, 4}) {
+			t.Errorf("unexpected UIDs %v", got)
+		}
+	}
+}
+
+func TestImplicitSeen(t *testing.T) {
+	addr, st, _ := newTestServer(t, 2)
+	a, _ := dial(t, addr)
+	b, bUpdates := dial(t, addr)
+	peek := &imap.BodySectionName{Peek: true}
+	if msgs := fetch(t, a, "1", peek.FetchItem(), imap.FetchFlags); len(msgs) != 1 || len(msgs[0].Flags) > 0 {
+		t.Fatalf("unexpected messages %v", msgs)
+	}
+	section := new(imap.BodySectionName)
+	msgs := fetch(t, a, "2", section.FetchItem())
+	if len(msgs) != 1 || !slices.Equal(msgs[0].Flags, []string{imap.SeenFlag}) {
+		t.Fatalf("\\Seen not reported: %v", msgs)
+	} else if msgs[0].GetBody(section) == nil {
+		t.Errorf("body missing")
+	}
+	if u := next[*client.MessageUpdate](t, bUpdates); u.Message.SeqNum != 2 ||
+		!slices.Equal(u.Message.Flags, []string{imap.SeenFlag}) {
+		t.Errorf("unexpected update %v %v", u.Message.SeqNum, u.Message.Flags)
+	}
+	if got := fetch(t, b, "1:*", imap.FetchFlags); len(got) != 2 || len(got[0].Flags) > 0 || len(got[1].Flags) != 1 {
+		t.Errorf("unexpected flags %v", got)
+	}
+	mails, err := st.ListMails()
+	if err != nil {
+		t.Fatal(err)
+	} else if mails[0].Seen || !mails[1].Seen {
+		t.Errorf("\\Seen not persisted")
+	}
+}
+
+func TestStorageEvents(t *testing.T) {
+	addr, st, _ := newTestServer(t, 2)
+	_, updates := dial(t, addr)
+	seen := true
+	mails, _ := st.ListMails()
+	if _, err := st.UpdateState(storage.Filter{Ids: []int64{mails[1].Id}},
+		storage.StateUpdate{Seen: &seen, AddTags: []string{"urgent"}}); err != nil {
+		t.Fatal(err)
+	}
+	u := next[*client.MessageUpdate](t, updates)
+	if u.Message.SeqNum != 2 || !slices.Equal(u.Message.Flags, []string{imap.SeenFlag, "urgent"}) {
+		t.Errorf("unexpected update %v %v", u.Message.SeqNum, u.Message.Flags)
+	}
+	if _, err := st.DeleteMails(mails[0].Id); err != nil {
+		t.Fatal(err)
+	} else if u := next[*client.ExpungeUpdate](t, updates); u.SeqNum != 1 {
+		t.Errorf("unexpected expunge %v", u.SeqNum)
+	}
+}
+
+func TestExpungeDeferred(t *testing.T) {
+	m := newMailbox(nil, nil)
+	for _, id := range []int64{1, 2, 3} {
+		m.apply([]model.Mail{{Id: id}})
+	}
+	s := m.newSession(new(user), "")
+	s.uids = []uint32{1, 2, 3}
+	m.remove([]int64{2})
+	m.msgs[1].flags = []string{imap.FlaggedFlag}
+	s.changed[3] = true
+	updates := s.pending(false)
+	if len(updates) != 1 {
+		t.Fatalf("unexpected updates %v", updates)
+	} else if u, ok := updates[0].(*backend.MessageUpdate); !ok || u.Message.SeqNum != 3 {
+		t.Errorf("unexpected update %v", updates[0])
+	}
+	updates = s.pending(true)
+	if len(updates) != 1 {
+		t.Fatalf("unexpected updates %v", updates)
+	} else if u, ok := updates[0].(*backend.ExpungeUpdate); !ok || u.SeqNum != 2 {
+		t.Errorf("unexpected update %v", updates[0])
+	} else if !slices.Equal(s.uids, []uint32{1, 3}) {
+		t.Errorf("unexpected view %v", s.uids)
+	}
+}
+
+func TestFolders(t *testing.T) {
+	addr, st, svc := newTestServer(t, 1)
+	c, updates := dial(t, addr)
+	for _, f := range []string{"work", "news", "work"} {
+		if _, err := svc.DeliverMail(eml(f), f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// INBOX contains the mails of all folders
+	for n := uint32(0); n != 4; {
+		n = next[*client.MailboxUpdate](t, updates).Mailbox.Messages
+	}
+	ch := make(chan *imap.MailboxInfo, 8)
+	if err := c.List("", "*", ch); err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for info := range ch {
+		names = append(names, info.Name)
+	}
+	if !slices.Equal(names, []string{inbox, "news", "work"}) {
+		t.Errorf("unexpected folders %v", names)
+	}
+	if _, err := c.Select("missing", false); err == nil {
+		t.Errorf("unknown folder selected")
+	}
+	status, err := c.Select("work", false)
+	if err != nil {
+		t.Fatal(err)
+	} else if status.Name != "work" || status.Messages != 2 {
+		t.Errorf("unexpected status %v %v", status.Name, status.Messages)
+	}
+	for _, m := range fetch(t, c, "1:*", imap.FetchEnvelope) {
+		if m.Envelope.Subject != "work" {
+			t.Errorf("unexpected mail %v in work", m.Envelope.Subject)
+		}
+	}
+	seqSet, _ := imap.ParseSeqSet("1")
+	if err := c.Copy(seqSet, "news"); err != nil {
+		t.Fatal(err)
+	} else if n, _ := st.CountMails(storage.Filter{Mailbox: "news"}); n != 2 {
+		t.Errorf("copy not stored in news: %v", n)
+	}
+	// the copy in news is not announced to the selected work folder
+	if _, err := svc.DeliverMail(eml("work"), "work"); err != nil {
+		t.Fatal(err)
+	}
+	for n := uint32(0); n != 3; {
+		u := next[*client.MailboxUpdate](t, updates)
+		if n = u.Mailbox.Messages; u.Mailbox.Name != "work" || n > 3 {
+			t.Fatalf("unexpected update %v %v", u.Mailbox.Name, n)
+		}
+	}
+}
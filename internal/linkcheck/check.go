@@ -0,0 +1,120 @@
+package linkcheck
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var ErrHostNotAllowed = errors.New("host is not in the link checker allowlist")
+var ErrTooManyRedirects = errors.New("too many redirects")
+
+type Checker interface {
+	Check(ctx context.Context, rawURL string) Result
+}
+
+type Result struct {
+	Status    int        `json:"status,omitempty"`
+	Redirects []Redirect `json:"redirects,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+type Redirect struct {
+	Status   int    `json:"status"`
+	Location string `json:"location"`
+}
+
+type checker struct {
+	client       *http.Client
+	allowed      []string
+	maxRedirects int
+}
+
+// New returns a Checker which issues HEAD requests against links whose host
+// matches one of allowedHosts. Entries may contain a port ("localhost:8080")
+// or a leading wildcard ("*.example.com"). Redirects are followed manually,
+// so that each hop is reported and checked against the allowlist as well.
+func New(allowedHosts []string, timeout time.Duration, maxRedirects int) Checker {
+	allowed := make([]string, 0, len(allowedHosts))
+	for _, h := range allowedHosts {
+		if h = strings.ToLower(strings.TrimSpace(h)); len(h) > 0 {
+			allowed = append(allowed, h)
+		}
+	}
+	return &checker{
+		client: &http.Client{
+			Timeout: timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		allowed:      allowed,
+		maxRedirects: maxRedirects,
+	}
+}
+
+func (c *checker) Check(ctx context.Context, rawURL string) Result {
+	res := Result{}
+	u, err := url.Parse(rawURL)
+	for hop := 0; ; hop++ {
+		if err != nil {
+			res.Error = err.Error()
+			return res
+		} else if !c.isAllowed(u) {
+			res.Error = ErrHostNotAllowed.Error()
+			return res
+		}
+		status, location, err := c.head(ctx, u)
+		if err != nil {
+			res.Error = err.Error()
+			return res
+		}
+		res.Status = status
+		if status < 300 || status >= 400 || len(location) == 0 {
+			return res
+		}
+		next, err := u.Parse(location)
+		if err == nil {
+			res.Redirects = append(res.Redirects, Redirect{Status: status, Location: next.String()})
+		}
+		if hop >= c.maxRedirects {
+			res.Error = ErrTooManyRedirects.Error()
+			return res
+		}
+		u = next
+	}
+}
+
+func (c *checker) head(ctx context.Context, u *url.URL) (int, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u.String(), nil)
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("User-Agent", "mailheap-linkcheck")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, resp.Header.Get("Location"), nil
+}
+
+func (c *checker) isAllowed(u *url.URL) bool {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	host := strings.ToLower(u.Host)
+	hostname := strings.ToLower(u.Hostname())
+	for _, a := range c.allowed {
+		switch {
+		case a == host, a == hostname:
+			return true
+		case strings.HasPrefix(a, "*.") && strings.HasSuffix(hostname, a[1:]):
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package linkcheck
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("expected HEAD, got %v", r.Method)
+		}
+	})
+	mux.HandleFunc("/gone", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	})
+	mux.HandleFunc("/hop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ok", http.StatusFound)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/away", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://example.invalid/", http.StatusSeeOther)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	host, _ := url.Parse(srv.URL)
+	c := New([]string{host.Host}, 5*time.Second, 3)
+	ctx := context.Background()
+
+	if res := c.Check(ctx, srv.URL+"/ok"); res.Status != http.StatusOK || len(res.Error) > 0 {
+		t.Errorf("/ok: %+v", res)
+	}
+	if res := c.Check(ctx, srv.URL+"/gone"); res.Status != http.StatusGone {
+		t.Errorf("/gone: %+v", res)
+	}
+	res := c.Check(ctx, srv.URL+"/hop")
+	if res.Status != http.StatusOK || len(res.Redirects) != 1 ||
+		res.Redirects[0].Status != http.StatusFound ||
+		res.Redirects[0].Location != srv.URL+"/ok" {
+		t.Errorf("/hop: %+v", res)
+	}
+	if res := c.Check(ctx, srv.URL+"/loop"); res.Error != ErrTooManyRedirects.Error() ||
+		len(res.Redirects) != 4 {
+		t.Errorf("/loop: %+v", res)
+	}
+	if res := c.Check(ctx, srv.URL+"/away"); res.Error != ErrHostNotAllowed.Error() ||
+		len(res.Redirects) != 1 {
+		t.Errorf("/away: %+v", res)
+	}
+	if res := c.Check(ctx, "https://example.com/"); res.Error != ErrHostNotAllowed.Error() ||
+		res.Status != 0 {
+		t.Errorf("not allowed: %+v", res)
+	}
+}
+
+func TestIsAllowedWildcard(t *testing.T) {
+	c := New([]string{"*.example.com", "localhost"}, time.Second, 0).(*checker)
+	for s, want := range map[string]bool{
+		"https://mail.example.com/x": true,
+		"https://example.com/x":      false,
+		"http://localhost:8080/":     true,
+		"ftp://localhost/":           false,
+		"https://evil-example.com/":  false,
+	} {
+		u, _ := url.Parse(s)
+		if got := c.isAllowed(u); got != want {
+			t.Errorf("%v: got %v, want %v", s, got, want)
+		}
+	}
+}
@@ -13,21 +13,33 @@ import (
 	"github.com/rntrp/mailheap/internal/config"
 )
 
-func Logger() *slog.Logger {
-	var leveler slog.Leveler
-	switch strings.ToUpper(config.GetLogLevel()) {
+// level is shared by all loggers, so that config changes apply to them.
+var level = new(slog.LevelVar)
+
+func init() {
+	config.OnChange(func() {
+		level.Set(parseLevel(config.GetLogLevel()))
+	})
+}
+
+func parseLevel(s string) slog.Level {
+	switch strings.ToUpper(s) {
 	case "DEBUG":
-		leveler = slog.LevelDebug
+		return slog.LevelDebug
 	case "WARN":
-		leveler = slog.LevelWarn
+		return slog.LevelWarn
 	case "ERROR":
-		leveler = slog.LevelError
+		return slog.LevelError
 	default:
-		leveler = slog.LevelInfo
+		return slog.LevelInfo
 	}
+}
+
+func Logger() *slog.Logger {
+	level.Set(parseLevel(config.GetLogLevel()))
 	opts := &slog.HandlerOptions{
 		AddSource: true,
-		Level:     leveler,
+		Level:     level,
 	}
 	w := os.Stdout
 	switch strings.ToUpper(config.GetLogFormat()) {
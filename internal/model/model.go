@@ -2,20 +2,56 @@ package model
 
 import "time"
 
-var BasicMail = []string{"id", "created", "date", "subject", "from", "to", "cc", "bcc", "size"}
+var BasicMail = []string{"id", "created", "date", "subject", "from", "to", "cc", "bcc", "size", "spam_score",
+	"seen", "starred", "tags", "mailbox"}
 
 const Id = "id"
 const Mime = "mime"
 
 type Mail struct {
-	Id      int64     `gorm:"primaryKey;autoIncrement:false" json:"id"`
-	Created time.Time `gorm:"index" json:"created"`
-	Date    time.Time `gorm:"index" json:"date"`
-	Subject string    `gorm:"text" json:"subject"`
-	From    string    `gorm:"text" json:"from"`
-	To      string    `gorm:"text" json:"to"`
-	Cc      string    `gorm:"text" json:"cc"`
-	Bcc     string    `gorm:"text" json:"bcc"`
-	Size    int32     `gorm:"index" json:"size"`
-	Mime    string    `gorm:"text" json:"mime,omitempty"`
+	Id        int64     `gorm:"primaryKey;autoIncrement:false" json:"id"`
+	Created   time.Time `gorm:"index" json:"created"`
+	Date      time.Time `gorm:"index" json:"date"`
+	Subject   string    `gorm:"text" json:"subject"`
+	From      string    `gorm:"text" json:"from"`
+	To        string    `gorm:"text" json:"to"`
+	Cc        string    `gorm:"text" json:"cc"`
+	Bcc       string    `gorm:"text" json:"bcc"`
+	Size      int32     `gorm:"index" json:"size"`
+	SpamScore float64   `gorm:"index" json:"spamScore"`
+	SpamRules string    `gorm:"text" json:"spamRules,omitempty"`
+	Trackers  string    `gorm:"text" json:"trackers"`
+	Seen      bool      `gorm:"index" json:"seen"`
+	Starred   bool      `gorm:"index" json:"starred"`
+	Tags      string    `gorm:"text;default:'[]'" json:"tags"`
+	Mailbox   string    `gorm:"index" json:"mailbox"`
+	Mime      string    `gorm:"text" json:"mime,omitempty"`
+	Links     []Link    `gorm:"foreignKey:MailId" json:"-"`
+}
+
+type Link struct {
+	Id     int64  `gorm:"primaryKey" json:"-"`
+	MailId int64  `gorm:"index" json:"-"`
+	Url    string `gorm:"text" json:"url"`
+	Text   string `gorm:"text" json:"text"`
+	Source string `json:"source"`
+}
+
+// IdMark records the highest ID of a deleted mail within a block of 256 IDs,
+// so that imports do not hand out the IDs of deleted mails again.
+type IdMark struct {
+	Block int64 `gorm:"primaryKey;autoIncrement:false"`
+	Last  int64
+}
+
+type Delivery struct {
+	Id       int64     `gorm:"primaryKey" json:"id"`
+	Created  time.Time `gorm:"index" json:"created"`
+	Webhook  string    `gorm:"index" json:"webhook"`
+	MailId   int64     `gorm:"index" json:"mailId"`
+	Url      string    `gorm:"text" json:"url"`
+	Attempt  int       `json:"attempt"`
+	Status   int       `json:"status"`
+	Error    string    `gorm:"text" json:"error,omitempty"`
+	Duration int64     `json:"durationMs"`
 }
@@ -0,0 +1,170 @@
+package msg
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+type Content struct {
+	Header mail.Header
+	Text   string
+	Html   string
+	Parts  []Part
+}
+
+type Part struct {
+	ContentType string
+	ContentId   string
+	Disposition string
+	FileName    string
+	Data        []byte
+}
+
+func (c *Content) Attachments() []Part {
+	att := make([]Part, 0, len(c.Parts))
+	for _, p := range c.Parts {
+		if p.Disposition == "attachment" || len(p.FileName) > 0 {
+			att = append(att, p)
+		}
+	}
+	return att
+}
+
+func (c *Content) PartByContentId(cid string) (Part, bool) {
+	cid = strings.Trim(cid, "<>")
+	for _, p := range c.Parts {
+		if len(p.ContentId) > 0 && strings.EqualFold(p.ContentId, cid) {
+			return p, true
+		}
+	}
+	return Part{}, false
+}
+
+const maxPartDepth = 16
+
+func Decode(raw []byte) (*Content, error) {
+	m, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("parsing RFC 822 message failed: %w", err)
+	}
+	c := &Content{Header: m.Header}
+	hdr := textproto.MIMEHeader(m.Header)
+	if err := c.walk(hdr, m.Body, 0); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Content) walk(hdr textproto.MIMEHeader, body io.Reader, depth int) error {
+	if depth > maxPartDepth {
+		return fmt.Errorf("MIME structure exceeds %d levels", maxPartDepth)
+	}
+	mediaType, params, err := mime.ParseMediaType(hdr.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("reading %v part failed: %w", mediaType, err)
+			} else if err := c.walk(p.Header, p, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	data, err := io.ReadAll(transferDecoder(hdr.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return fmt.Errorf("decoding %v part failed: %w", mediaType, err)
+	}
+	disposition, dparams, _ := mime.ParseMediaType(hdr.Get("Content-Disposition"))
+	fileName := dparams["filename"]
+	if len(fileName) == 0 {
+		fileName = params["name"]
+	}
+	if wd := new(mime.WordDecoder); len(fileName) > 0 {
+		if dec, err := wd.DecodeHeader(fileName); err == nil {
+			fileName = dec
+		}
+	}
+	if disposition != "attachment" && len(fileName) == 0 {
+		switch {
+		case mediaType == "text/plain" && len(c.Text) == 0:
+			c.Text = charsetDecode(params["charset"], data)
+			return nil
+		case mediaType == "text/html" && len(c.Html) == 0:
+			c.Html = charsetDecode(params["charset"], data)
+			return nil
+		}
+	}
+	c.Parts = append(c.Parts, Part{
+		ContentType: mediaType,
+		ContentId:   strings.Trim(hdr.Get("Content-Id"), " <>"),
+		Disposition: disposition,
+		FileName:    fileName,
+		Data:        data,
+	})
+	return nil
+}
+
+func transferDecoder(cte string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(cte)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &base64Cleaner{r: r})
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	default:
+		return r
+	}
+}
+
+// base64Cleaner strips line breaks and other whitespace, which are legal
+// in base64 transfer encoding, but not accepted by encoding/base64.
+type base64Cleaner struct {
+	r io.Reader
+}
+
+func (b *base64Cleaner) Read(p []byte) (int, error) {
+	for {
+		n, err := b.r.Read(p)
+		j := 0
+		for _, c := range p[:n] {
+			if c != '\r' && c != '\n' && c != ' ' && c != '\t' {
+				p[j] = c
+				j++
+			}
+		}
+		if j > 0 || err != nil {
+			return j, err
+		}
+	}
+}
+
+func charsetDecode(charset string, data []byte) string {
+	charset = strings.ToLower(strings.TrimSpace(charset))
+	if len(charset) == 0 || charset == "utf-8" || charset == "us-ascii" {
+		return string(data)
+	}
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return string(data)
+	}
+	dec, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return string(data)
+	}
+	return string(dec)
+}
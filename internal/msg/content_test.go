@@ -0,0 +1,46 @@
+package msg
+
+import "testing"
+
+func TestDecodeCharset(t *testing.T) {
+	for _, tc := range []struct {
+		name, hdr, body, text string
+	}{
+		{"utf-8", "Content-Type: text/plain; charset=UTF-8\r\n", "Grüße", "Grüße"},
+		{"no charset", "", "Hello", "Hello"},
+		{"latin-1 quoted-printable", "Content-Type: text/plain; charset=\"ISO-8859-1\"\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n", "Gr=FC=DFe", "Grüße"},
+		{"windows-1252 base64", "Content-Type: text/plain; charset=windows-1252\r\n" +
+			"Content-Transfer-Encoding: base64\r\n", "gCA1\r\nMA==", "€ 50"},
+		{"koi8-r", "Content-Type: text/plain; charset=koi8-r\r\n", "\xf0\xd2\xc9\xd7\xc5\xd4", "Привет"},
+		{"unknown charset", "Content-Type: text/plain; charset=x-unknown\r\n", "Hello", "Hello"},
+	} {
+		c, err := Decode([]byte("From: alice@example.com\r\n" + tc.hdr + "\r\n" + tc.body))
+		if err != nil {
+			t.Fatalf("%v: %v", tc.name, err)
+		} else if c.Text != tc.text {
+			t.Errorf("%v: got %q, want %q", tc.name, c.Text, tc.text)
+		}
+	}
+}
+
+func TestDecodeParts(t *testing.T) {
+	c, err := Decode([]byte("From: alice@example.com\r\n" +
+		"Content-Type: multipart/mixed; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/html; charset=iso-8859-15\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n\r\n<p>=A4 5</p>\r\n" +
+		"--b\r\nContent-Type: text/plain; name=\"=?utf-8?q?Gr=C3=BC=C3=9Fe.txt?=\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n\r\nSGk=\r\n" +
+		"--b\r\nContent-Type: image/png\r\nContent-Id: <logo@example.com>\r\n\r\nPNG\r\n" +
+		"--b--\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	} else if c.Html != "<p>€ 5</p>" || len(c.Text) > 0 {
+		t.Errorf("unexpected bodies %q %q", c.Text, c.Html)
+	} else if att := c.Attachments(); len(att) != 1 || att[0].FileName != "Grüße.txt" ||
+		string(att[0].Data) != "Hi" {
+		t.Errorf("unexpected attachments %+v", att)
+	} else if p, ok := c.PartByContentId("<LOGO@example.com>"); !ok || string(p.Data) != "PNG" {
+		t.Errorf("inline part not found")
+	}
+}
@@ -0,0 +1,103 @@
+package msg
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/rntrp/mailheap/internal/model"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	LinkSourceText = "text"
+	LinkSourceHtml = "html"
+)
+
+var urlPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"'()\[\]]+`)
+
+// ExtractLinks collects absolute http(s) URLs from the plain text body and
+// the anchors of the HTML body in order of appearance, without duplicates.
+func ExtractLinks(c *Content) []model.Link {
+	links := make([]model.Link, 0)
+	seen := make(map[string]bool)
+	add := func(l model.Link) {
+		if key := l.Source + " " + l.Url; !seen[key] {
+			seen[key] = true
+			links = append(links, l)
+		}
+	}
+	for _, s := range urlPattern.FindAllString(c.Text, -1) {
+		if raw, ok := httpURL(s); ok {
+			add(model.Link{Url: raw, Source: LinkSourceText})
+		}
+	}
+	for _, l := range ExtractAnchors(c.Html) {
+		add(l)
+	}
+	return links
+}
+
+// ExtractAnchors returns the absolute http(s) URLs of the anchors and image
+// map areas of an HTML body along with their text. Unlike ExtractLinks, it
+// keeps duplicate URLs, since their texts may differ.
+func ExtractAnchors(src string) []model.Link {
+	links := make([]model.Link, 0)
+	if len(src) == 0 {
+		return links
+	}
+	add := func(href, text string) {
+		if raw, ok := httpURL(href); ok {
+			links = append(links, model.Link{Url: raw, Text: text, Source: LinkSourceHtml})
+		}
+	}
+	z := html.NewTokenizer(strings.NewReader(src))
+	var href *string
+	text := new(strings.Builder)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return links
+		case html.TextToken:
+			if href != nil {
+				text.Write(z.Text())
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			a := atom.Lookup(name)
+			if a != atom.A && a != atom.Area {
+				continue
+			} else if href != nil {
+				add(*href, strings.Join(strings.Fields(text.String()), " "))
+				href = nil
+			}
+			for hasAttr {
+				var k, v []byte
+				k, v, hasAttr = z.TagAttr()
+				if string(k) == "href" {
+					s := string(v)
+					href = &s
+					text.Reset()
+				}
+			}
+			if a == atom.Area && href != nil {
+				add(*href, "")
+				href = nil
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); atom.Lookup(name) == atom.A && href != nil {
+				add(*href, strings.Join(strings.Fields(text.String()), " "))
+				href = nil
+			}
+		}
+	}
+}
+
+// httpURL trims whitespace and trailing punctuation from raw and reports
+// whether the result is an absolute http(s) URL.
+func httpURL(raw string) (string, bool) {
+	raw = strings.TrimRight(strings.TrimSpace(raw), ".,;:!?")
+	u, err := url.Parse(raw)
+	return raw, err == nil && len(u.Host) > 0 && (u.Scheme == "http" || u.Scheme == "https")
+}
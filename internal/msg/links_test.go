@@ -0,0 +1,50 @@
+package msg
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/rntrp/mailheap/internal/model"
+)
+
+const linksHtml = `<p><a href="https://example.com/a">First
+	 link</a> <a href=" https://example.com/a ">Again</a>
+<a href="mailto:bob@example.com">Mail</a> <a href="/relative">Relative</a>
+<a href="https://example.com/b">Unclosed <a href="https://example.com/c">Next</a>
+<map><area href="http://example.com/area" alt="Area"></map>
+<a name="anchor">No href</a> <a href="javascript:alert(1)">Script</a></p>`
+
+func TestExtractLinks(t *testing.T) {
+	c := &Content{
+		Text: "Visit https://example.com/a, or (https://example.com/t?q=1).\n" +
+			"Again: https://example.com/a! ftp://example.com/f http://",
+		Html: linksHtml,
+	}
+	want := []model.Link{
+		{Url: "https://example.com/a", Source: LinkSourceText},
+		{Url: "https://example.com/t?q=1", Source: LinkSourceText},
+		{Url: "https://example.com/a", Text: "First link", Source: LinkSourceHtml},
+		{Url: "https://example.com/b", Text: "Unclosed", Source: LinkSourceHtml},
+		{Url: "https://example.com/c", Text: "Next", Source: LinkSourceHtml},
+		{Url: "http://example.com/area", Source: LinkSourceHtml},
+	}
+	if got := ExtractLinks(c); !slices.Equal(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+	if got := ExtractLinks(&Content{}); got == nil || len(got) != 0 {
+		t.Errorf("unexpected links %v", got)
+	}
+}
+
+func TestExtractAnchors(t *testing.T) {
+	want := []model.Link{
+		{Url: "https://example.com/a", Text: "First link", Source: LinkSourceHtml},
+		{Url: "https://example.com/a", Text: "Again", Source: LinkSourceHtml},
+		{Url: "https://example.com/b", Text: "Unclosed", Source: LinkSourceHtml},
+		{Url: "https://example.com/c", Text: "Next", Source: LinkSourceHtml},
+		{Url: "http://example.com/area", Source: LinkSourceHtml},
+	}
+	if got := ExtractAnchors(linksHtml); !slices.Equal(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
@@ -5,32 +5,140 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"mime"
 	"net/mail"
 	"strings"
 	"time"
 
 	"github.com/rntrp/mailheap/internal/model"
+	"github.com/rntrp/mailheap/internal/preview"
 	"github.com/rntrp/mailheap/internal/storage"
 )
 
 type StoreMailSvc interface {
-	StoreMail(r io.Reader) error
+	DeliverMail(r io.Reader, mailbox string) (int64, error)
+	ImportMail(r io.Reader, t TimeSource) (int64, error)
+	StoreMail(r io.Reader) (int64, error)
 }
 
-func NewAddMailSvc(storage storage.MailStorage) StoreMailSvc {
-	return &svc{storage: storage}
+// TimeSource determines the Created time of imported mails.
+type TimeSource string
+
+const (
+	TimeNow      TimeSource = "now"
+	TimeDate     TimeSource = "date"
+	TimeReceived TimeSource = "received"
+)
+
+func ParseTimeSource(s string) (TimeSource, error) {
+	switch t := TimeSource(strings.ToLower(s)); t {
+	case "":
+		return TimeNow, nil
+	case TimeNow, TimeDate, TimeReceived:
+		return t, nil
+	default:
+		return "", fmt.Errorf("unknown time source: %v", s)
+	}
+}
+
+// Stage inspects the decoded content of an incoming mail before it is stored
+// and may amend the metadata of the mail. Failing stages do not prevent the
+// mail from being stored.
+type Stage interface {
+	Process(m *model.Mail, c *Content) error
+}
+
+// Listener is notified about every mail received by StoreMail after it has
+// been stored successfully.
+type Listener interface {
+	MailStored(m model.Mail)
+}
+
+func NewAddMailSvc(storage storage.MailStorage, listeners []Listener, stages ...Stage) StoreMailSvc {
+	return &svc{storage: storage, listeners: listeners, stages: stages}
 }
 
 type svc struct {
-	storage storage.MailStorage
+	storage   storage.MailStorage
+	listeners []Listener
+	stages    []Stage
+}
+
+func (s svc) StoreMail(r io.Reader) (int64, error) {
+	return s.DeliverMail(r, "")
+}
+
+// DeliverMail stores the mail like StoreMail in the given mailbox. The empty
+// mailbox is the default one.
+func (s svc) DeliverMail(r io.Reader, mailbox string) (int64, error) {
+	mail, err := readMail(r)
+	if err != nil {
+		return 0, err
+	}
+	mail.Mailbox = mailbox
+	s.process(&mail)
+	if mail.Id, err = s.storage.AddMail(mail); err != nil {
+		return 0, err
+	}
+	for _, l := range s.listeners {
+		l.MailStored(mail)
+	}
+	return mail.Id, nil
 }
 
-func (s svc) StoreMail(r io.Reader) error {
-	if mail, err := readMail(r); err != nil {
-		return err
-	} else {
-		return s.storage.AddMail(mail)
+func (s svc) ImportMail(r io.Reader, t TimeSource) (int64, error) {
+	if t == TimeNow {
+		return s.StoreMail(r)
+	}
+	mail, err := readMail(r)
+	if err != nil {
+		return 0, err
+	}
+	created := mail.Date
+	if t == TimeReceived {
+		if received, ok := receivedTime(mail.Mime); ok {
+			created = received
+		}
+	}
+	mail.Created = created
+	s.process(&mail)
+	return s.storage.ImportMail(mail)
+}
+
+// receivedTime returns the date of the topmost Received header, i.e. the
+// time the mail was received by the last hop.
+func receivedTime(mime string) (time.Time, bool) {
+	msg, err := mail.ReadMessage(strings.NewReader(mime))
+	if err != nil {
+		return time.Time{}, false
+	}
+	received := msg.Header["Received"]
+	if len(received) == 0 {
+		return time.Time{}, false
+	}
+	i := strings.LastIndexByte(received[0], ';')
+	if i < 0 {
+		return time.Time{}, false
+	}
+	t, err := mail.ParseDate(strings.TrimSpace(received[0][i+1:]))
+	return t, err == nil
+}
+
+func (s svc) process(m *model.Mail) {
+	c, err := Decode([]byte(m.Mime))
+	if err != nil {
+		slog.Warn("Decoding mail content failed", "error", err.Error())
+		return
+	}
+	m.Links = ExtractLinks(c)
+	if b, err := json.Marshal(preview.DetectTrackers(c.Html)); err == nil {
+		m.Trackers = string(b)
+	}
+	for _, stage := range s.stages {
+		if err := stage.Process(m, c); err != nil {
+			slog.Warn("Mail processing stage failed", "error", err.Error())
+		}
 	}
 }
 
@@ -0,0 +1,45 @@
+package msg
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rntrp/mailheap/internal/storage"
+)
+
+func TestImportMailBeforeEpoch(t *testing.T) {
+	st, err := storage.NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Shutdown()
+	svc := NewAddMailSvc(st, nil)
+	importMail := func(date string) int64 {
+		t.Helper()
+		id, err := svc.ImportMail(strings.NewReader("From: alice@example.com\r\n"+
+			"Date: "+date+"\r\nSubject: Old\r\n\r\nHello\r\n"), TimeDate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	newer := importMail("Tue, 15 Jun 2010 08:00:00 +0000")
+	older := importMail("Mon, 14 Jun 2010 08:00:00 +0000")
+	if older >= newer {
+		t.Errorf("older mail %v imported after newer mail %v", older, newer)
+	}
+	mails, err := st.ListMails()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int64]time.Time{
+		newer: time.Date(2010, 6, 15, 8, 0, 0, 0, time.UTC),
+		older: time.Date(2010, 6, 14, 8, 0, 0, 0, time.UTC),
+	}
+	for _, m := range mails {
+		if !m.Created.Equal(want[m.Id]) {
+			t.Errorf("mail %v created %v, want %v", m.Id, m.Created, want[m.Id])
+		}
+	}
+}
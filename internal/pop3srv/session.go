@@ -0,0 +1,409 @@
+package pop3srv
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rntrp/mailheap/internal/model"
+	"github.com/rntrp/mailheap/internal/storage"
+)
+
+// maxLineLength is the limit of command lines including CRLF set by
+// RFC 2449.
+const maxLineLength = 255
+
+var errLineTooLong = errors.New("line too long")
+
+type state int
+
+const (
+	stateAuthorization state = iota
+	stateTransaction
+)
+
+type session struct {
+	srv      *Server
+	uuid     uuid.UUID
+	conn     net.Conn
+	r        *bufio.Reader
+	w        *bufio.Writer
+	tls      bool
+	state    state
+	user     string
+	mails    []model.Mail
+	sizes    []int64
+	deleted  []bool
+	quitting bool
+}
+
+func newSession(srv *Server, c net.Conn) *session {
+	_, isTLS := c.(*tls.Conn)
+	return &session{
+		srv:  srv,
+		uuid: uuid.New(),
+		conn: c,
+		r:    bufio.NewReaderSize(c, maxLineLength),
+		w:    bufio.NewWriter(c),
+		tls:  isTLS,
+	}
+}
+
+func (s *session) serve() {
+	slog.Info("POP3 connection", "uuid", s.uuid.String(),
+		"remote", s.conn.RemoteAddr().String())
+	s.ok("mailheap POP3 server ready")
+	for !s.quitting && s.w.Flush() == nil {
+		if s.srv.ReadTimeout > 0 {
+			s.conn.SetReadDeadline(time.Now().Add(s.srv.ReadTimeout))
+		}
+		line, err := s.readLine()
+		if errors.Is(err, errLineTooLong) {
+			s.err("line too long")
+			continue
+		} else if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		cmd = strings.ToUpper(cmd)
+		if cmd != "PASS" {
+			slog.Info("POP3 command", "uuid", s.uuid.String(), "command", cmd, "arg", arg)
+		}
+		s.handle(cmd, arg)
+	}
+	s.w.Flush()
+}
+
+// readLine reads a command line. Longer lines than maxLineLength are skipped
+// up to the next line feed and yield errLineTooLong.
+func (s *session) readLine() (string, error) {
+	line, err := s.r.ReadSlice('\n')
+	if err != bufio.ErrBufferFull {
+		return string(line), err
+	}
+	for err == bufio.ErrBufferFull {
+		_, err = s.r.ReadSlice('\n')
+	}
+	if err != nil {
+		return "", err
+	}
+	return "", errLineTooLong
+}
+
+func (s *session) handle(cmd, arg string) {
+	switch cmd {
+	case "CAPA":
+		s.capa()
+		return
+	case "QUIT":
+		s.quit()
+		return
+	case "NOOP":
+		if s.state == stateTransaction {
+			s.ok("")
+			return
+		}
+	}
+	if s.state == stateAuthorization {
+		switch cmd {
+		case "STLS":
+			s.stls()
+		case "USER":
+			s.userCmd(arg)
+		case "PASS":
+			s.pass(arg)
+		default:
+			s.err("command not valid in this state")
+		}
+		return
+	}
+	switch cmd {
+	case "STAT":
+		s.stat()
+	case "LIST":
+		s.list(arg)
+	case "UIDL":
+		s.uidl(arg)
+	case "RETR":
+		s.retr(arg)
+	case "TOP":
+		s.top(arg)
+	case "DELE":
+		s.dele(arg)
+	case "RSET":
+		s.rset()
+	default:
+		s.err("command not valid in this state")
+	}
+}
+
+func (s *session) ok(msg string) {
+	if len(msg) == 0 {
+		s.w.WriteString("+OK\r\n")
+	} else {
+		s.w.WriteString("+OK " + msg + "\r\n")
+	}
+}
+
+func (s *session) err(msg string) {
+	s.w.WriteString("-ERR " + msg + "\r\n")
+}
+
+func (s *session) capa() {
+	s.ok("capability list follows")
+	if s.tls || s.srv.AllowInsecureAuth {
+		s.w.WriteString("USER\r\n")
+	}
+	s.w.WriteString("TOP\r\nUIDL\r\nRESP-CODES\r\n")
+	if s.srv.TLSConfig != nil && !s.tls {
+		s.w.WriteString("STLS\r\n")
+	}
+	s.w.WriteString("IMPLEMENTATION mailheap\r\n.\r\n")
+}
+
+func (s *session) stls() {
+	if s.srv.TLSConfig == nil {
+		s.err("STLS not supported")
+		return
+	} else if s.tls {
+		s.err("TLS already active")
+		return
+	}
+	s.ok("begin TLS negotiation")
+	if err := s.w.Flush(); err != nil {
+		s.quitting = true
+		return
+	}
+	c := tls.Server(s.conn, s.srv.TLSConfig)
+	if err := c.Handshake(); err != nil {
+		slog.Warn("POP3 TLS handshake failed", "uuid", s.uuid.String(), "error", err.Error())
+		s.quitting = true
+		return
+	}
+	s.conn = c
+	s.r = bufio.NewReaderSize(c, maxLineLength)
+	s.w = bufio.NewWriter(c)
+	s.tls = true
+}
+
+func (s *session) userCmd(arg string) {
+	if !s.tls && !s.srv.AllowInsecureAuth {
+		s.err("[AUTH] authentication requires TLS")
+		return
+	}
+	s.user = arg
+	s.ok("")
+}
+
+func (s *session) pass(arg string) {
+	if len(s.user) == 0 {
+		s.err("USER required first")
+		return
+	} else if s.user != s.srv.Username || arg != s.srv.Password {
+		slog.Warn("POP3 login failed", "uuid", s.uuid.String(), "user", s.user)
+		s.user = ""
+		s.err("[AUTH] invalid credentials")
+		return
+	}
+	mails, err := s.srv.storage.ListMails()
+	if err != nil {
+		slog.Error("POP3: listing mails failed", "uuid", s.uuid.String(), "error", err.Error())
+		s.err("[SYS/TEMP] maildrop not available")
+		return
+	}
+	s.mails = mails
+	s.sizes = make([]int64, len(mails))
+	for i := range s.sizes {
+		s.sizes[i] = -1
+	}
+	s.deleted = make([]bool, len(mails))
+	s.state = stateTransaction
+	slog.Info("POP3 login", "uuid", s.uuid.String(), "user", s.user)
+	s.ok(fmt.Sprintf("maildrop has %v messages", len(mails)))
+}
+
+// message resolves a 1-based message number which has not been deleted.
+func (s *session) message(arg string) (int, bool) {
+	n, err := strconv.Atoi(strings.TrimSpace(arg))
+	if err != nil || n < 1 || n > len(s.mails) {
+		s.err("no such message")
+		return 0, false
+	} else if s.deleted[n-1] {
+		s.err("message already deleted")
+		return 0, false
+	}
+	return n - 1, true
+}
+
+func (s *session) stat() {
+	cnt, size := 0, int64(0)
+	for i := range s.mails {
+		if !s.deleted[i] {
+			cnt++
+			size += s.size(i)
+		}
+	}
+	s.ok(fmt.Sprintf("%v %v", cnt, size))
+}
+
+func (s *session) list(arg string) {
+	s.listing(arg, func(i int) string {
+		return strconv.FormatInt(s.size(i), 10)
+	})
+}
+
+// size returns the size of the message as sent by RETR, loading the MIME
+// content on first use. Falls back to the stored size if the message is not
+// available anymore.
+func (s *session) size(i int) int64 {
+	if s.sizes[i] >= 0 {
+		return s.sizes[i]
+	}
+	mime, err := s.srv.storage.GetMime(s.mails[i].Id)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			slog.Error("POP3: loading mail failed", "uuid", s.uuid.String(), "error", err.Error())
+		}
+		return int64(s.mails[i].Size)
+	}
+	s.sizes[i] = wireSize(mime)
+	return s.sizes[i]
+}
+
+// wireSize counts the lines of mime terminated by CRLF. As of RFC 1939, the
+// size excludes the stuffed dots and the termination octet, both of which
+// the client removes.
+func wireSize(mime string) int64 {
+	size := int64(0)
+	for line := range strings.Lines(mime) {
+		size += int64(len(strings.TrimRight(line, "\r\n"))) + 2
+	}
+	return size
+}
+
+func (s *session) uidl(arg string) {
+	s.listing(arg, func(i int) string {
+		return strconv.FormatInt(s.mails[i].Id, 10)
+	})
+}
+
+func (s *session) listing(arg string, value func(int) string) {
+	if len(arg) > 0 {
+		if i, ok := s.message(arg); ok {
+			s.ok(fmt.Sprintf("%v %v", i+1, value(i)))
+		}
+		return
+	}
+	s.ok("")
+	for i := range s.mails {
+		if !s.deleted[i] {
+			fmt.Fprintf(s.w, "%v %v\r\n", i+1, value(i))
+		}
+	}
+	s.w.WriteString(".\r\n")
+}
+
+func (s *session) retr(arg string) {
+	i, ok := s.message(arg)
+	if !ok || !s.send(i, -1) || s.mails[i].Seen {
+		return
+	}
+	seen := true
+	f := storage.Filter{Ids: []int64{s.mails[i].Id}}
+	if _, err := s.srv.storage.UpdateState(f, storage.StateUpdate{Seen: &seen}); err != nil {
+		slog.Warn("POP3: marking mail as seen failed", "uuid", s.uuid.String(), "error", err.Error())
+		return
+	}
+	s.mails[i].Seen = true
+}
+
+func (s *session) top(arg string) {
+	msg, lines, _ := strings.Cut(strings.TrimSpace(arg), " ")
+	n, err := strconv.Atoi(strings.TrimSpace(lines))
+	if err != nil || n < 0 {
+		s.err("invalid number of lines")
+		return
+	}
+	if i, ok := s.message(msg); ok {
+		s.send(i, n)
+	}
+}
+
+// send writes the message as a dot-stuffed multi-line response. If lines is
+// not negative, only the header and that many lines of the body are sent.
+// Reports whether the message has been sent.
+func (s *session) send(i, lines int) bool {
+	mime, err := s.srv.storage.GetMime(s.mails[i].Id)
+	if errors.Is(err, storage.ErrNotFound) {
+		s.err("message has been deleted by another client")
+		return false
+	} else if err != nil {
+		slog.Error("POP3: loading mail failed", "uuid", s.uuid.String(), "error", err.Error())
+		s.err("[SYS/TEMP] message not available")
+		return false
+	}
+	s.ok("message follows")
+	body := false
+	for line := range strings.Lines(mime) {
+		line = strings.TrimRight(line, "\r\n")
+		if body {
+			if lines == 0 {
+				break
+			} else if lines > 0 {
+				lines--
+			}
+		} else if len(line) == 0 {
+			body = true
+		}
+		if strings.HasPrefix(line, ".") {
+			s.w.WriteByte('.')
+		}
+		s.w.WriteString(line)
+		s.w.WriteString("\r\n")
+	}
+	s.w.WriteString(".\r\n")
+	return true
+}
+
+func (s *session) dele(arg string) {
+	if i, ok := s.message(arg); ok {
+		s.deleted[i] = true
+		s.ok(fmt.Sprintf("message %v deleted", i+1))
+	}
+}
+
+func (s *session) rset() {
+	for i := range s.deleted {
+		s.deleted[i] = false
+	}
+	s.stat()
+}
+
+func (s *session) quit() {
+	s.quitting = true
+	if s.state != stateTransaction {
+		s.ok("bye")
+		return
+	}
+	ids := make([]int64, 0)
+	for i, m := range s.mails {
+		if s.deleted[i] {
+			ids = append(ids, m.Id)
+		}
+	}
+	if len(ids) > 0 {
+		if _, err := s.srv.storage.DeleteMails(ids...); err != nil {
+			slog.Error("POP3: deleting mails failed", "uuid", s.uuid.String(), "error", err.Error())
+			s.err("[SYS/TEMP] some deleted messages not removed")
+			return
+		}
+	}
+	s.ok(fmt.Sprintf("bye, %v messages deleted", len(ids)))
+}
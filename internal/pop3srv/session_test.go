@@ -0,0 +1,202 @@
+package pop3srv
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/rntrp/mailheap/internal/config"
+	"github.com/rntrp/mailheap/internal/msg"
+	"github.com/rntrp/mailheap/internal/storage"
+)
+
+const eml = "From: alice@example.com\r\n" +
+	"To: bob@example.com\r\n" +
+	"Date: Mon, 19 Oct 2026 11:39:27 -0000\r\n" +
+	"Subject: Hello\r\n\r\n" +
+	"Hello Bob\r\n" +
+	".hidden\r\n" +
+	"Bye\r\n"
+
+type client struct {
+	t *testing.T
+	r *bufio.Reader
+	c net.Conn
+}
+
+func dial(t *testing.T, mails int) (*client, storage.MailStorage) {
+	t.Helper()
+	if err := config.LoadDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	st, err := storage.NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := msg.NewAddMailSvc(st, nil)
+	for range mails {
+		if _, err := svc.StoreMail(strings.NewReader(eml)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := New(st, nil)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+		srv.Shutdown(context.Background())
+		st.Shutdown()
+	})
+	cl := &client{t: t, r: bufio.NewReader(c), c: c}
+	if greeting := cl.line(); !strings.HasPrefix(greeting, "+OK") {
+		t.Fatalf("unexpected greeting %q", greeting)
+	}
+	return cl, st
+}
+
+func (c *client) line() string {
+	c.t.Helper()
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return strings.TrimSuffix(line, "\r\n")
+}
+
+// cmd sends a command and expects the given status line.
+func (c *client) cmd(line, want string) {
+	c.t.Helper()
+	if _, err := c.c.Write([]byte(line + "\r\n")); err != nil {
+		c.t.Fatal(err)
+	} else if got := c.line(); got != want {
+		c.t.Errorf("%v: got %q, want %q", line, got, want)
+	}
+}
+
+// multi reads the lines of a multi-line response.
+func (c *client) multi() []string {
+	c.t.Helper()
+	lines := make([]string, 0)
+	for line := c.line(); line != "."; line = c.line() {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func (c *client) login() {
+	c.t.Helper()
+	c.cmd("USER "+config.GetPOP3Username(), "+OK")
+	c.cmd("PASS "+config.GetPOP3Password(), "+OK maildrop has 2 messages")
+}
+
+func TestAuthorization(t *testing.T) {
+	c, _ := dial(t, 2)
+	c.cmd("STAT", "-ERR command not valid in this state")
+	c.cmd("PASS password", "-ERR USER required first")
+	c.cmd("USER "+config.GetPOP3Username(), "+OK")
+	c.cmd("PASS wrong", "-ERR [AUTH] invalid credentials")
+	c.cmd("PASS "+config.GetPOP3Password(), "-ERR USER required first")
+	c.cmd("STLS", "-ERR STLS not supported")
+	c.cmd("USER "+strings.Repeat("x", 300), "-ERR line too long")
+	c.login()
+	c.cmd("USER x", "-ERR command not valid in this state")
+	c.cmd("NOOP", "+OK")
+	c.cmd("QUIT", "+OK bye, 0 messages deleted")
+}
+
+func TestTransaction(t *testing.T) {
+	c, st := dial(t, 2)
+	c.login()
+	mails, err := st.ListMails()
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := len(eml)
+	c.cmd("STAT", "+OK 2 "+fmt.Sprint(2*size))
+	c.cmd("LIST", "+OK")
+	if got := strings.Join(c.multi(), "|"); got != "1 "+fmt.Sprint(size)+"|2 "+fmt.Sprint(size) {
+		t.Errorf("unexpected listing %q", got)
+	}
+	c.cmd("LIST 2", "+OK 2 "+fmt.Sprint(size))
+	c.cmd("LIST 3", "-ERR no such message")
+	c.cmd("UIDL x", "-ERR no such message")
+	c.cmd("UIDL", "+OK")
+	if got := strings.Join(c.multi(), "|"); got != "1 "+fmt.Sprint(mails[0].Id)+"|2 "+fmt.Sprint(mails[1].Id) {
+		t.Errorf("unexpected listing %q", got)
+	}
+	c.cmd("TOP 1 1", "+OK message follows")
+	if got := c.multi(); len(got) != 6 || got[5] != "Hello Bob" {
+		t.Errorf("unexpected top %q", got)
+	}
+	c.cmd("RETR 1", "+OK message follows")
+	if got := c.multi(); len(got) != 8 || got[6] != "..hidden" {
+		t.Errorf("unexpected message %q", got)
+	}
+	c.cmd("DELE 1", "+OK message 1 deleted")
+	c.cmd("RETR 1", "-ERR message already deleted")
+	c.cmd("DELE 1", "-ERR message already deleted")
+	c.cmd("STAT", "+OK 1 "+fmt.Sprint(size))
+	c.cmd("RSET", "+OK 2 "+fmt.Sprint(2*size))
+	c.cmd("DELE 2", "+OK message 2 deleted")
+	c.cmd("FOO", "-ERR command not valid in this state")
+	c.cmd("QUIT", "+OK bye, 1 messages deleted")
+	mails, err = st.ListMails()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(mails) != 1 || !mails[0].Seen {
+		t.Errorf("unexpected mails %+v", mails)
+	}
+}
+
+func TestDeletedByOtherClient(t *testing.T) {
+	c, st := dial(t, 2)
+	c.login()
+	mails, _ := st.ListMails()
+	if _, err := st.DeleteMails(mails[0].Id); err != nil {
+		t.Fatal(err)
+	}
+	c.cmd("RETR 1", "-ERR message has been deleted by another client")
+	c.cmd("STAT", "+OK 2 "+fmt.Sprint(2*len(eml)))
+}
+
+func TestTransmittedSize(t *testing.T) {
+	c, st := dial(t, 0)
+	svc := msg.NewAddMailSvc(st, nil)
+	for _, mime := range []string{strings.ReplaceAll(eml, "\r\n", "\n"), eml + "no newline"} {
+		if _, err := svc.StoreMail(strings.NewReader(mime)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.login()
+	c.cmd("LIST", "+OK")
+	want := fmt.Sprintf("1 %v|2 %v", len(eml), len(eml)+len("no newline\r\n"))
+	if got := strings.Join(c.multi(), "|"); got != want {
+		t.Errorf("unexpected listing %q, want %q", got, want)
+	}
+}
+
+func TestCapa(t *testing.T) {
+	c, _ := dial(t, 0)
+	c.cmd("CAPA", "+OK capability list follows")
+	if got := c.multi(); len(got) == 0 || got[0] != "USER" {
+		t.Errorf("USER not advertised: %q", got)
+	}
+	t.Setenv("MAILHEAP_POP3_ALLOW_INSECURE_AUTH", "false")
+	c, _ = dial(t, 0)
+	c.cmd("CAPA", "+OK capability list follows")
+	if got := c.multi(); slices.Contains(got, "USER") {
+		t.Errorf("USER advertised without TLS: %q", got)
+	}
+	c.cmd("USER x", "-ERR [AUTH] authentication requires TLS")
+}
@@ -0,0 +1,131 @@
+package pop3srv
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rntrp/mailheap/internal/config"
+	"github.com/rntrp/mailheap/internal/storage"
+)
+
+var ErrServerClosed = errors.New("pop3: server closed")
+
+type Server struct {
+	Addr              string
+	Username          string
+	Password          string
+	TLSConfig         *tls.Config
+	AllowInsecureAuth bool
+	ReadTimeout       time.Duration
+
+	storage  storage.MailStorage
+	mtx      sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+func New(s storage.MailStorage, tlsConfig *tls.Config) *Server {
+	return &Server{
+		Addr:              config.GetPOP3Address(),
+		Username:          config.GetPOP3Username(),
+		Password:          config.GetPOP3Password(),
+		TLSConfig:         tlsConfig,
+		AllowInsecureAuth: config.IsPOP3AllowInsecureAuth(),
+		ReadTimeout:       config.GetPOP3ReadTimeout(),
+		storage:           s,
+		conns:             make(map[net.Conn]struct{}),
+	}
+}
+
+func (s *Server) ListenAndServe() error {
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+func (s *Server) Serve(l net.Listener) error {
+	s.mtx.Lock()
+	if s.closed {
+		s.mtx.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listener = l
+	s.mtx.Unlock()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			s.mtx.Lock()
+			closed := s.closed
+			s.mtx.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		if !s.track(c) {
+			c.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer s.untrack(c)
+			newSession(s, c).serve()
+		}()
+	}
+}
+
+func (s *Server) track(c net.Conn) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[c] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) untrack(c net.Conn) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	c.Close()
+	if _, ok := s.conns[c]; ok {
+		delete(s.conns, c)
+		s.wg.Done()
+	}
+}
+
+// Shutdown stops accepting connections, closes the active ones and waits
+// for their sessions to end until the context expires. Pending deletions of
+// unfinished sessions are discarded, as required by RFC 1939.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mtx.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mtx.Unlock()
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		return errors.Join(err, ctx.Err())
+	}
+}
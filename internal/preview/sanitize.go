@@ -0,0 +1,212 @@
+package preview
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+type Options struct {
+	// CidURL maps a Content-ID to the URL the referencing attribute should
+	// point to instead of the cid: URL.
+	CidURL func(cid string) string
+	// BlockRemote strips references to remote images, media, fonts and
+	// stylesheets, so that previews do not reveal the reader to the sender.
+	BlockRemote bool
+}
+
+var droppedElements = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Noscript: true,
+	atom.Iframe:   true,
+	atom.Frame:    true,
+	atom.Frameset: true,
+	atom.Object:   true,
+	atom.Embed:    true,
+	atom.Applet:   true,
+	atom.Base:     true,
+}
+
+var urlAttributes = map[string]bool{
+	"action":     true,
+	"background": true,
+	"formaction": true,
+	"href":       true,
+	"poster":     true,
+	"src":        true,
+	"srcset":     true,
+	"xlink:href": true,
+}
+
+// imageAttributes may contain data:image/ URLs, which cannot run scripts
+// when loaded as image. All other data: URLs are removed.
+var imageAttributes = map[string]bool{
+	"background": true,
+	"poster":     true,
+	"src":        true,
+	"srcset":     true,
+}
+
+// droppedForeignElements lists SVG animation elements, which can set the
+// href of their parent to a javascript: URL.
+var droppedForeignElements = map[string]bool{
+	"animate":          true,
+	"animatemotion":    true,
+	"animatetransform": true,
+	"set":              true,
+}
+
+var remoteAttributes = map[string]bool{
+	"background": true,
+	"poster":     true,
+	"src":        true,
+	"srcset":     true,
+}
+
+var cssCidURL = regexp.MustCompile(`(?i)url\(\s*['"]?cid:([^'")\s]+)['"]?\s*\)`)
+var cssRemoteURL = regexp.MustCompile(`(?i)url\(\s*['"]?(https?:)?//[^)]*\)`)
+var cssImport = regexp.MustCompile(`(?i)@import\s+[^;]*;?`)
+
+// Sanitize parses an HTML mail body and removes active content such as
+// scripts, frames, plugins, SVG animations, event handler attributes as well
+// as javascript: and non-image data: URLs.
+// References to inline parts via cid: URLs are rewritten with opts.CidURL.
+func Sanitize(src string, opts Options) (string, error) {
+	doc, err := html.Parse(strings.NewReader(src))
+	if err != nil {
+		return "", err
+	}
+	sanitize(doc, &opts)
+	buf := new(bytes.Buffer)
+	if err := html.Render(buf, doc); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func sanitize(n *html.Node, opts *Options) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch {
+		case c.Type == html.ElementNode && droppedElements[c.DataAtom]:
+			n.RemoveChild(c)
+		case c.Type == html.ElementNode && len(c.Namespace) > 0 &&
+			droppedForeignElements[strings.ToLower(c.Data)]:
+			n.RemoveChild(c)
+		case c.Type == html.ElementNode && c.DataAtom == atom.Meta && isRefresh(c):
+			n.RemoveChild(c)
+		case c.Type == html.ElementNode && c.DataAtom == atom.Link && opts.BlockRemote:
+			n.RemoveChild(c)
+		case c.Type == html.ElementNode:
+			sanitizeAttributes(c, opts)
+			sanitize(c, opts)
+		case c.Type == html.TextNode && n.DataAtom == atom.Style:
+			c.Data = rewriteCss(c.Data, opts)
+		case c.Type == html.CommentNode:
+			n.RemoveChild(c)
+		default:
+			sanitize(c, opts)
+		}
+		c = next
+	}
+}
+
+func sanitizeAttributes(n *html.Node, opts *Options) {
+	attr := n.Attr[:0]
+	for _, a := range n.Attr {
+		key := strings.ToLower(a.Key)
+		if len(a.Namespace) > 0 {
+			key = a.Namespace + ":" + key
+		}
+		switch {
+		case strings.HasPrefix(key, "on"):
+			continue
+		case key == "style":
+			a.Val = rewriteCss(a.Val, opts)
+		case opts.BlockRemote && remoteAttributes[key] && hasRemoteURL(key, a.Val):
+			a.Key, a.Namespace = "data-blocked-"+key, ""
+		case urlAttributes[key]:
+			if !safeURLs(key, a.Val) {
+				continue
+			}
+			val := strings.TrimSpace(a.Val)
+			if strings.HasPrefix(strings.ToLower(val), "cid:") && opts.CidURL != nil {
+				a.Val = opts.CidURL(val[len("cid:"):])
+			}
+		}
+		attr = append(attr, a)
+	}
+	n.Attr = attr
+}
+
+func rewriteCss(css string, opts *Options) string {
+	if opts.BlockRemote {
+		css = cssImport.ReplaceAllString(css, "")
+		css = cssRemoteURL.ReplaceAllString(css, "none")
+	}
+	if opts.CidURL == nil {
+		return css
+	}
+	return cssCidURL.ReplaceAllStringFunc(css, func(s string) string {
+		m := cssCidURL.FindStringSubmatch(s)
+		return "url('" + opts.CidURL(m[1]) + "')"
+	})
+}
+
+func hasRemoteURL(key, val string) bool {
+	if key != "srcset" {
+		return isRemote(val)
+	}
+	for _, candidate := range strings.Split(val, ",") {
+		if f := strings.Fields(candidate); len(f) > 0 && isRemote(f[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// safeURLs checks the URL, or each candidate URL of a srcset, against the
+// schemes which may run scripts.
+func safeURLs(key, val string) bool {
+	if key != "srcset" {
+		return safeURL(key, val)
+	}
+	for _, candidate := range strings.Split(val, ",") {
+		if f := strings.Fields(candidate); len(f) > 0 && !safeURL(key, f[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+// safeURL normalizes the URL like browsers do, i.e. removes tabs and line
+// breaks as well as leading control characters, before checking the scheme.
+func safeURL(key, val string) bool {
+	val = strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' {
+			return -1
+		}
+		return r
+	}, val)
+	val = strings.ToLower(strings.TrimLeftFunc(val, func(r rune) bool { return r <= ' ' }))
+	switch {
+	case strings.HasPrefix(val, "javascript:"), strings.HasPrefix(val, "vbscript:"):
+		return false
+	case strings.HasPrefix(val, "data:"):
+		return imageAttributes[key] && strings.HasPrefix(val, "data:image/")
+	default:
+		return true
+	}
+}
+
+func isRefresh(n *html.Node) bool {
+	for _, a := range n.Attr {
+		if strings.EqualFold(a.Key, "http-equiv") && strings.EqualFold(strings.TrimSpace(a.Val), "refresh") {
+			return true
+		}
+	}
+	return false
+}
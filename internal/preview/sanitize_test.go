@@ -0,0 +1,126 @@
+package preview
+
+import (
+	"strings"
+	"testing"
+)
+
+func cidURL(cid string) string {
+	return "/api/v1/mail/1/cid/" + cid
+}
+
+func TestSanitize(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		src     string
+		opts    Options
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "script",
+			src:     `<p>a</p><script>alert(1)</script><SCRIPT src="x.js"></SCRIPT><noscript>n</noscript>`,
+			want:    []string{"<p>a</p>"},
+			notWant: []string{"script", "alert", "x.js", "<noscript"},
+		},
+		{
+			name:    "frames and plugins",
+			src:     `<iframe src="https://evil.example"></iframe><object data="x"></object><embed src="x"><base href="https://evil.example/">`,
+			notWant: []string{"iframe", "object", "embed", "base", "evil"},
+		},
+		{
+			name:    "event handlers",
+			src:     `<img src="a.png" onerror="alert(1)"><body ONLOAD="alert(2)"><a href="#" onClick="x()" title="t">l</a>`,
+			want:    []string{`src="a.png"`, `title="t"`},
+			notWant: []string{"alert", "onerror", "onload", "onclick", "x()"},
+		},
+		{
+			name:    "javascript urls",
+			src:     `<a href="javascript:alert(1)">a</a><a href=" JaVaScRiPt:alert(2)">b</a><a href="java&#09;script:alert(3)">c</a><form action="javascript:x"><button formaction="vbscript:y">`,
+			notWant: []string{"alert", "href", "action", "javascript", "vbscript"},
+		},
+		{
+			name:    "data urls",
+			src:     `<a href="data:text/html,<script>alert(1)</script>">a</a><a href="data:image/png;base64,AAAA">b</a><img src="data:image/png;base64,AAAA"><img src="data:text/html;base64,AAAA">`,
+			want:    []string{`<img src="data:image/png;base64,AAAA"/>`, "<a>a</a><a>b</a>"},
+			notWant: []string{"text/html", "alert"},
+		},
+		{
+			name:    "srcset",
+			src:     `<img srcset="a.png 1x, javascript:alert(1) 2x"><img srcset="b.png 1x, data:image/png;base64,AA 2x">`,
+			want:    []string{`srcset="b.png 1x, data:image/png;base64,AA 2x"`},
+			notWant: []string{"javascript", "a.png"},
+		},
+		{
+			name:    "meta refresh",
+			src:     `<head><meta http-equiv=" Refresh " content="0; url=https://evil.example"><meta charset="utf-8"></head>`,
+			want:    []string{`<meta charset="utf-8"/>`},
+			notWant: []string{"refresh", "evil"},
+		},
+		{
+			name:    "comments",
+			src:     `<p>a<!-- <script>alert(1)</script> --></p><!--[if IE]><script>x</script><![endif]-->`,
+			want:    []string{"<p>a</p>"},
+			notWant: []string{"<!--", "alert", "endif"},
+		},
+		{
+			name: "style element and attribute",
+			src: `<style>@import url(https://evil.example/a.css); body { background: url('https://evil.example/t.png') } ` +
+				`.logo { background: url(cid:logo@x) }</style><p style="background-image: url(//evil.example/p.gif); color: red">p</p>`,
+			opts:    Options{CidURL: cidURL, BlockRemote: true},
+			want:    []string{"url('/api/v1/mail/1/cid/logo@x')", "background: none", "color: red"},
+			notWant: []string{"evil", "@import", "cid:"},
+		},
+		{
+			name: "remote content kept unless blocked",
+			src:  `<style>body { background: url(https://example.com/t.png) }</style><img src="https://example.com/a.png">`,
+			want: []string{"url(https://example.com/t.png)", `src="https://example.com/a.png"`},
+		},
+		{
+			name:    "remote content blocked",
+			src:     `<img src="https://example.com/a.png" srcset="https://example.com/b.png 2x"><video poster="//example.com/p.png"></video><link rel="stylesheet" href="https://example.com/a.css"><a href="https://example.com/">l</a>`,
+			opts:    Options{BlockRemote: true},
+			want:    []string{`data-blocked-src="https://example.com/a.png"`, `data-blocked-srcset=`, `data-blocked-poster=`, `<a href="https://example.com/">`},
+			notWant: []string{" src=", " srcset=", " poster=", "<link", "a.css"},
+		},
+		{
+			name:    "cid rewrite",
+			src:     `<img src="cid:image001.png@01D0"><table><tr><td background="CID:bg"></td></tr></table><a href="cid:doc">d</a>`,
+			opts:    Options{CidURL: cidURL},
+			want:    []string{`src="/api/v1/mail/1/cid/image001.png@01D0"`, `background="/api/v1/mail/1/cid/bg"`, `href="/api/v1/mail/1/cid/doc"`},
+			notWant: []string{`"cid:`, `"CID:`},
+		},
+		{
+			name: "svg",
+			src: `<svg onload="alert(1)"><script>alert(2)</script><a xlink:href="javascript:alert(3)"><text>t</text></a>` +
+				`<animate attributeName="href" to="javascript:alert(4)"/><set attributeName="href" to="javascript:alert(5)"/>` +
+				`<use href="data:image/svg+xml;base64,AAAA#x"/><foreignObject><iframe src="x"></iframe></foreignObject><circle r="1"/></svg>`,
+			want:    []string{"<svg>", "<text>t</text>", `<circle r="1">`},
+			notWant: []string{"alert", "script", "animate", "<set", "iframe", "data:", "javascript"},
+		},
+		{
+			name:    "mathml",
+			src:     `<math><maction actiontype="statusline" xlink:href="javascript:alert(1)">x</maction><mi href="javascript:alert(2)">y</mi></math>`,
+			want:    []string{"<math>", "x</maction>", "y</mi>"},
+			notWant: []string{"alert", "javascript"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := Sanitize(tc.src, tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lower := strings.ToLower(res)
+			for _, s := range tc.want {
+				if !strings.Contains(res, s) {
+					t.Errorf("%q missing in %v", s, res)
+				}
+			}
+			for _, s := range tc.notWant {
+				if strings.Contains(lower, strings.ToLower(s)) {
+					t.Errorf("%q found in %v", s, res)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package preview
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+type Tracker struct {
+	Url    string `json:"url"`
+	Reason string `json:"reason"`
+}
+
+const (
+	ReasonPixel  = "pixel"
+	ReasonHidden = "hidden"
+	ReasonDomain = "domain"
+)
+
+var trackerDomains = []string{
+	"doubleclick.net",
+	"google-analytics.com",
+	"list-manage.com",
+	"mailchimp.com",
+	"mandrillapp.com",
+	"mailgun.org",
+	"mcsv.net",
+	"mktoresp.com",
+	"mixpanel.com",
+	"hubspotlinks.com",
+	"hs-analytics.net",
+	"sendgrid.net",
+	"sparkpostmail.com",
+	"exct.net",
+	"klclick.com",
+	"pardot.com",
+	"returnpath.net",
+	"mailtrack.io",
+	"getnotify.com",
+	"bananatag.com",
+	"yesware.com",
+	"streak.com",
+}
+
+var tinySize = regexp.MustCompile(`(?i)^\s*[01](px)?\s*$`)
+var tinyStyle = regexp.MustCompile(`(?i)(^|;)\s*(width|height|max-width|max-height)\s*:\s*[01](px)?\s*(;|$)`)
+var hiddenStyle = regexp.MustCompile(`(?i)(display\s*:\s*none|visibility\s*:\s*hidden|opacity\s*:\s*0(\.0+)?\s*(;|$))`)
+
+// DetectTrackers reports remote images of an HTML body which are either
+// sized 1x1 or smaller, hidden, or served from a known tracking domain.
+func DetectTrackers(src string) []Tracker {
+	trackers := make([]Tracker, 0)
+	if len(src) == 0 {
+		return trackers
+	}
+	z := html.NewTokenizer(strings.NewReader(src))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return trackers
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			if atom.Lookup(name) != atom.Img {
+				continue
+			}
+			attr := make(map[string]string)
+			for hasAttr {
+				var k, v []byte
+				k, v, hasAttr = z.TagAttr()
+				attr[strings.ToLower(string(k))] = string(v)
+			}
+			if t, ok := classify(attr); ok {
+				trackers = append(trackers, t)
+			}
+		}
+	}
+}
+
+func classify(attr map[string]string) (Tracker, bool) {
+	src := strings.TrimSpace(attr["src"])
+	if !isRemote(src) {
+		return Tracker{}, false
+	}
+	t := Tracker{Url: src}
+	style := attr["style"]
+	switch {
+	case isTrackerDomain(src):
+		t.Reason = ReasonDomain
+	case tinySize.MatchString(attr["width"]) && tinySize.MatchString(attr["height"]):
+		t.Reason = ReasonPixel
+	case tinyStyle.MatchString(style):
+		t.Reason = ReasonPixel
+	case hiddenStyle.MatchString(style):
+		t.Reason = ReasonHidden
+	default:
+		return t, false
+	}
+	return t, true
+}
+
+func isTrackerDomain(src string) bool {
+	if strings.HasPrefix(src, "//") {
+		src = "https:" + src
+	}
+	u, err := url.Parse(src)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, d := range trackerDomains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+func isRemote(src string) bool {
+	lower := strings.ToLower(strings.TrimSpace(src))
+	return strings.HasPrefix(lower, "http:") ||
+		strings.HasPrefix(lower, "https:") ||
+		strings.HasPrefix(lower, "//")
+}
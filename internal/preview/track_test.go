@@ -0,0 +1,32 @@
+package preview
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDetectTrackers(t *testing.T) {
+	src := `<p>Hello</p>
+<img src="https://example.com/logo.png" width="120" height="40">
+<img src="https://example.com/open.gif" width="1" height="1">
+<IMG SRC="https://example.com/o.gif" style="width: 0px; height: 0px">
+<img src="https://example.com/h.gif" style="display:none">
+<img src="//ea.sendgrid.net/wf/open?upn=x" />
+<img src="http://pixel.mailchimp.com.example.org/a.png">
+<img src="cid:logo@example.com" width="1" height="1">
+<img src="data:image/gif;base64,R0lGOD" style="display: none">
+<img src="https://example.com/wide.png" width="1" height="300">
+<img src="https://example.com/faded.png" style="opacity: 0.5">`
+	want := []Tracker{
+		{"https://example.com/open.gif", ReasonPixel},
+		{"https://example.com/o.gif", ReasonPixel},
+		{"https://example.com/h.gif", ReasonHidden},
+		{"//ea.sendgrid.net/wf/open?upn=x", ReasonDomain},
+	}
+	if got := DetectTrackers(src); !slices.Equal(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got := DetectTrackers(""); got == nil || len(got) != 0 {
+		t.Errorf("unexpected trackers %v", got)
+	}
+}
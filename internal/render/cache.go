@@ -0,0 +1,68 @@
+package render
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Cache keeps the most recently used renderings in memory. Entries of
+// deleted mails are removed with RemoveFunc.
+type Cache struct {
+	mtx     sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key  string
+	data []byte
+}
+
+func NewCache(size int) *Cache {
+	return &Cache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *Cache) Get(key string) ([]byte, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+		return e.Value.(*cacheEntry).data, true
+	}
+	return nil, false
+}
+
+func (c *Cache) Put(key string, data []byte) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.size <= 0 {
+		return
+	} else if e, ok := c.entries[key]; ok {
+		e.Value.(*cacheEntry).data = data
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, data: data})
+	for c.order.Len() > c.size {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.entries, last.Value.(*cacheEntry).key)
+	}
+}
+
+// RemoveFunc removes all entries whose key fn returns true for.
+func (c *Cache) RemoveFunc(fn func(key string) bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for key, e := range c.entries {
+		if fn(key) {
+			c.order.Remove(e)
+			delete(c.entries, key)
+		}
+	}
+}
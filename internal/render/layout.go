@@ -0,0 +1,501 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/image/font"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+type itemKind int
+
+const (
+	itemText itemKind = iota
+	itemImage
+	itemBreak
+	itemRule
+	itemBgStart
+	itemBgEnd
+)
+
+type item struct {
+	kind   itemKind
+	text   string
+	st     style
+	img    image.Image
+	w, h   int
+	space  int
+	indent int
+	bg     color.RGBA
+}
+
+var skippedElements = map[atom.Atom]bool{
+	atom.Head:     true,
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Title:    true,
+	atom.Meta:     true,
+	atom.Link:     true,
+	atom.Noscript: true,
+	atom.Template: true,
+}
+
+// blockElements maps block-level elements to the vertical space in em around
+// them. Table rows and cells are laid out as blocks, i.e. tables linearize.
+var blockElements = map[atom.Atom]float64{
+	atom.Address:    0,
+	atom.Article:    0,
+	atom.Aside:      0,
+	atom.Blockquote: 0.8,
+	atom.Body:       0,
+	atom.Center:     0,
+	atom.Dd:         0,
+	atom.Div:        0,
+	atom.Dl:         0.8,
+	atom.Dt:         0,
+	atom.Figure:     0.8,
+	atom.Footer:     0,
+	atom.Form:       0,
+	atom.H1:         0.67,
+	atom.H2:         0.83,
+	atom.H3:         1,
+	atom.H4:         1.33,
+	atom.H5:         1.67,
+	atom.H6:         2.33,
+	atom.Header:     0,
+	atom.Li:         0,
+	atom.Main:       0,
+	atom.Nav:        0,
+	atom.Ol:         0.8,
+	atom.P:          0.8,
+	atom.Pre:        0.8,
+	atom.Section:    0,
+	atom.Table:      0,
+	atom.Td:         0,
+	atom.Th:         0,
+	atom.Tr:         0.2,
+	atom.Ul:         0.8,
+}
+
+var headingSizes = map[atom.Atom]float64{
+	atom.H1: 2, atom.H2: 1.5, atom.H3: 1.17, atom.H4: 1, atom.H5: 0.83, atom.H6: 0.67,
+}
+
+const indentStep = 24
+
+type listState struct {
+	ordered bool
+	n       int
+}
+
+type builder struct {
+	items  []item
+	indent int
+	lists  []listState
+	pageBg *color.RGBA
+	image  func(src string) (image.Image, bool)
+}
+
+func (b *builder) walk(n *html.Node, st style) {
+	switch n.Type {
+	case html.DocumentNode:
+		b.children(n, st)
+	case html.TextNode:
+		if len(n.Data) > 0 {
+			b.items = append(b.items, item{kind: itemText, text: n.Data, st: st, indent: b.indent})
+		}
+	case html.ElementNode:
+		b.element(n, st)
+	}
+}
+
+func (b *builder) children(n *html.Node, st style) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.walk(c, st)
+	}
+}
+
+func (b *builder) element(n *html.Node, st style) {
+	a := n.DataAtom
+	switch {
+	case skippedElements[a]:
+		return
+	case a == atom.Br:
+		b.items = append(b.items, item{kind: itemBreak, st: st, space: -1})
+		return
+	case a == atom.Hr:
+		b.brk(st, 0.5)
+		b.items = append(b.items, item{kind: itemRule, st: st, indent: b.indent})
+		b.brk(st, 0.5)
+		return
+	case a == atom.Img:
+		b.img(n, st)
+		return
+	}
+	st, bg := inherit(n, st)
+	space, block := blockElements[a]
+	if block {
+		b.brk(st, space)
+	}
+	switch {
+	case bg != nil && (a == atom.Body || a == atom.Html) && b.pageBg == nil:
+		b.pageBg = bg
+		bg = nil
+	case bg != nil:
+		b.items = append(b.items, item{kind: itemBgStart, bg: *bg, indent: b.indent})
+	}
+	indent := b.indent
+	switch a {
+	case atom.Ul, atom.Ol:
+		b.lists = append(b.lists, listState{ordered: a == atom.Ol})
+		b.indent += indentStep
+	case atom.Blockquote, atom.Dd:
+		b.indent += indentStep
+	case atom.Li:
+		marker := "• "
+		if len(b.lists) > 0 {
+			l := &b.lists[len(b.lists)-1]
+			l.n++
+			if l.ordered {
+				marker = strconv.Itoa(l.n) + ". "
+			}
+		}
+		b.items = append(b.items, item{kind: itemText, text: marker, st: st, indent: b.indent})
+	}
+	b.children(n, st)
+	b.indent = indent
+	if a == atom.Ul || a == atom.Ol {
+		b.lists = b.lists[:len(b.lists)-1]
+	}
+	if bg != nil {
+		b.items = append(b.items, item{kind: itemBgEnd})
+	}
+	if block {
+		b.brk(st, space)
+	}
+}
+
+func (b *builder) brk(st style, em float64) {
+	b.items = append(b.items, item{kind: itemBreak, st: st, space: int(em * st.size)})
+}
+
+func (b *builder) img(n *html.Node, st style) {
+	var src, alt string
+	w, h := -1, -1
+	for _, a := range n.Attr {
+		switch strings.ToLower(a.Key) {
+		case "src":
+			src = strings.TrimSpace(a.Val)
+		case "alt":
+			alt = a.Val
+		case "width":
+			w = atoiPx(a.Val)
+		case "height":
+			h = atoiPx(a.Val)
+		case "style":
+			for _, decl := range strings.Split(a.Val, ";") {
+				prop, val, _ := strings.Cut(decl, ":")
+				switch strings.ToLower(strings.TrimSpace(prop)) {
+				case "width":
+					w = atoiPx(val)
+				case "height":
+					h = atoiPx(val)
+				}
+			}
+		}
+	}
+	var img image.Image
+	if b.image != nil {
+		img, _ = b.image(src)
+	}
+	if img != nil {
+		bounds := img.Bounds()
+		switch {
+		case w < 0 && h < 0:
+			w, h = bounds.Dx(), bounds.Dy()
+		case h < 0 && bounds.Dx() > 0:
+			h = w * bounds.Dy() / bounds.Dx()
+		case w < 0 && bounds.Dy() > 0:
+			w = h * bounds.Dx() / bounds.Dy()
+		}
+	} else if w < 0 || h < 0 {
+		if alt = strings.TrimSpace(alt); len(alt) > 0 {
+			b.items = append(b.items, item{kind: itemText, text: "[" + alt + "]", st: st, indent: b.indent})
+		}
+		return
+	}
+	if w > 0 && h > 0 {
+		b.items = append(b.items, item{kind: itemImage, img: img, w: w, h: h, st: st, indent: b.indent})
+	}
+}
+
+func atoiPx(s string) int {
+	s = strings.TrimSuffix(strings.TrimSpace(strings.ToLower(s)), "px")
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+		return n
+	}
+	return -1
+}
+
+func inherit(n *html.Node, st style) (style, *color.RGBA) {
+	switch n.DataAtom {
+	case atom.B, atom.Strong, atom.Th:
+		st.bold = true
+	case atom.I, atom.Em, atom.Cite, atom.Var:
+		st.italic = true
+	case atom.U, atom.Ins:
+		st.underline = true
+	case atom.Code, atom.Kbd, atom.Samp, atom.Tt:
+		st.mono = true
+	case atom.Pre:
+		st.mono, st.pre = true, true
+	case atom.A:
+		st.color, st.underline = linkColor, true
+	case atom.Small:
+		st.size *= 0.83
+	case atom.Big:
+		st.size *= 1.2
+	case atom.Center:
+		st.align = alignCenter
+	}
+	if size, ok := headingSizes[n.DataAtom]; ok {
+		st.size, st.bold = defaultStyle.size*size, true
+	}
+	var bg *color.RGBA
+	for _, a := range n.Attr {
+		switch strings.ToLower(a.Key) {
+		case "align":
+			st.align = parseAlign(a.Val, st.align)
+		case "color":
+			if c, ok := parseColor(a.Val); ok && n.DataAtom == atom.Font {
+				st.color = c
+			}
+		case "bgcolor":
+			if c, ok := parseColor(a.Val); ok {
+				bg = &c
+			}
+		}
+	}
+	for _, a := range n.Attr {
+		if strings.EqualFold(a.Key, "style") {
+			if c := st.applyCss(a.Val); c != nil {
+				bg = c
+			}
+		}
+	}
+	return st, bg
+}
+
+type frag struct {
+	x    int
+	w    int
+	text string
+	st   style
+	img  image.Image
+	h    int
+	rule bool
+}
+
+type line struct {
+	y       int
+	ascent  int
+	descent int
+	indent  int
+	align   int
+	width   int
+	frags   []frag
+}
+
+type bgRect struct {
+	x0, y0, y1 int
+	c          color.RGBA
+}
+
+type layout struct {
+	faces   faceSet
+	avail   int
+	lines   []*line
+	cur     *line
+	y       int
+	pending int
+	bgs     []bgRect
+	open    []int
+	height  int
+	limit   int
+}
+
+func (l *layout) run(items []item) error {
+	for _, it := range items {
+		if l.y > l.limit {
+			break
+		}
+		var err error
+		switch it.kind {
+		case itemText:
+			err = l.text(it)
+		case itemImage:
+			w, h := it.w, it.h
+			if max := l.avail - it.indent; w > max && max > 0 {
+				w, h = max, h*max/w
+			}
+			l.place(frag{w: w, h: h, img: it.img, st: it.st}, it, h, 0)
+		case itemRule:
+			l.flush(false, it.st)
+			l.place(frag{w: l.avail - it.indent, rule: true, st: it.st}, it, 5, 4)
+			l.flush(false, it.st)
+		case itemBreak:
+			if it.space < 0 {
+				err = l.flush(true, it.st)
+			} else {
+				l.flush(false, it.st)
+				l.pending = max(l.pending, it.space)
+			}
+		case itemBgStart:
+			l.flush(false, it.st)
+			l.y += l.pending
+			l.pending = 0
+			l.open = append(l.open, len(l.bgs))
+			l.bgs = append(l.bgs, bgRect{x0: it.indent, y0: l.y, c: it.bg})
+		case itemBgEnd:
+			l.flush(false, it.st)
+			if len(l.open) > 0 {
+				l.bgs[l.open[len(l.open)-1]].y1 = l.y
+				l.open = l.open[:len(l.open)-1]
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	l.flush(false, defaultStyle)
+	for _, i := range l.open {
+		l.bgs[i].y1 = l.y
+	}
+	l.height = l.y
+	return nil
+}
+
+// text breaks a text item into words and places them onto lines. Outside of
+// preformatted blocks, whitespace collapses and leading spaces are dropped.
+func (l *layout) text(it item) error {
+	face, err := l.faces.get(it.st)
+	if err != nil {
+		return err
+	}
+	for _, tok := range tokenize(it.text, it.st.pre) {
+		switch {
+		case tok == "\n":
+			if err := l.flush(true, it.st); err != nil {
+				return err
+			}
+		case tok == " " && !it.st.pre && (l.cur == nil || len(l.cur.frags) == 0 ||
+			l.cur.frags[len(l.cur.frags)-1].text == " "):
+		default:
+			w := font.MeasureString(face, tok).Ceil()
+			max := l.avail - it.indent
+			if l.cur != nil && l.cur.width+w > max && tok != " " && len(l.cur.frags) > 0 {
+				l.flush(false, it.st)
+			}
+			for w > max && len([]rune(tok)) > 1 {
+				head, tail := splitToFit(face, tok, max)
+				l.placeText(face, head, it)
+				l.flush(false, it.st)
+				tok = tail
+				w = font.MeasureString(face, tok).Ceil()
+			}
+			l.placeText(face, tok, it)
+		}
+	}
+	return nil
+}
+
+func (l *layout) placeText(face font.Face, s string, it item) {
+	m := face.Metrics()
+	w := font.MeasureString(face, s).Ceil()
+	l.place(frag{w: w, text: s, st: it.st}, it, m.Ascent.Ceil(), (m.Height - m.Ascent).Ceil())
+}
+
+func (l *layout) place(f frag, it item, ascent, descent int) {
+	if l.cur == nil {
+		l.y += l.pending
+		l.pending = 0
+		l.cur = &line{y: l.y, indent: it.indent, align: it.st.align}
+	}
+	f.x = l.cur.width
+	l.cur.width += f.w
+	l.cur.ascent = max(l.cur.ascent, ascent)
+	l.cur.descent = max(l.cur.descent, descent)
+	l.cur.frags = append(l.cur.frags, f)
+}
+
+// flush terminates the current line. With force, an empty line of the
+// height of the given style is emitted, as done for consecutive <br>s. Only
+// then the font face is needed, which may fail to load.
+func (l *layout) flush(force bool, st style) error {
+	if l.cur == nil && force {
+		face, err := l.faces.get(st)
+		if err != nil {
+			return err
+		}
+		l.y += l.pending
+		l.pending = 0
+		l.y += face.Metrics().Height.Ceil()
+		return nil
+	} else if l.cur == nil {
+		return nil
+	}
+	for len(l.cur.frags) > 0 && l.cur.frags[len(l.cur.frags)-1].text == " " && !st.pre {
+		last := l.cur.frags[len(l.cur.frags)-1]
+		l.cur.width -= last.w
+		l.cur.frags = l.cur.frags[:len(l.cur.frags)-1]
+	}
+	l.lines = append(l.lines, l.cur)
+	l.y += l.cur.ascent + l.cur.descent
+	l.cur = nil
+	return nil
+}
+
+func tokenize(s string, pre bool) []string {
+	tokens := make([]string, 0)
+	word := new(strings.Builder)
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+	for _, r := range s {
+		switch {
+		case pre && r == '\n':
+			flush()
+			tokens = append(tokens, "\n")
+		case pre && r == '\t':
+			flush()
+			tokens = append(tokens, "    ")
+		case r == '\r':
+		case unicode.IsSpace(r) && r != '\u00a0':
+			flush()
+			if pre || len(tokens) == 0 || tokens[len(tokens)-1] != " " {
+				tokens = append(tokens, " ")
+			}
+		default:
+			word.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+func splitToFit(face font.Face, s string, width int) (string, string) {
+	runes := []rune(s)
+	n := 1
+	for n < len(runes) && font.MeasureString(face, string(runes[:n+1])).Ceil() <= width {
+		n++
+	}
+	return string(runes[:n]), string(runes[n:])
+}
@@ -0,0 +1,106 @@
+package render
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+)
+
+// PDF renders src like PNG and embeds the result into a PDF document. The
+// rendering is split into pages with the aspect ratio of ISO 216 paper.
+func PDF(src Source, width int) ([]byte, error) {
+	img, err := Render(src, width)
+	if err != nil {
+		return nil, err
+	}
+	w := img.Bounds().Dx()
+	pageHeight := w * 1414 / 1000
+	pages := make([]*image.RGBA, 0)
+	for y := 0; y < img.Bounds().Dy(); y += pageHeight {
+		rect := image.Rect(0, y, w, min(y+pageHeight, img.Bounds().Dy()))
+		pages = append(pages, img.SubImage(rect).(*image.RGBA))
+	}
+	return writePDF(pages, w, pageHeight)
+}
+
+type pdfWriter struct {
+	buf     bytes.Buffer
+	offsets []int
+}
+
+func (p *pdfWriter) object(body func()) {
+	p.offsets = append(p.offsets, p.buf.Len())
+	fmt.Fprintf(&p.buf, "%d 0 obj\n", len(p.offsets))
+	body()
+	p.buf.WriteString("\nendobj\n")
+}
+
+func (p *pdfWriter) stream(dict string, data []byte) {
+	fmt.Fprintf(&p.buf, "<< %s /Length %d >>\nstream\n", dict, len(data))
+	p.buf.Write(data)
+	p.buf.WriteString("\nendstream")
+}
+
+// writePDF writes a PDF 1.4 document with one page per image. Objects are
+// numbered 1: catalog, 2: page tree, then page, content and image per page.
+func writePDF(pages []*image.RGBA, width, height int) ([]byte, error) {
+	p := new(pdfWriter)
+	p.buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	p.object(func() { p.buf.WriteString("<< /Type /Catalog /Pages 2 0 R >>") })
+	p.object(func() {
+		p.buf.WriteString("<< /Type /Pages /Kids [")
+		for i := range pages {
+			fmt.Fprintf(&p.buf, " %d 0 R", 3+3*i)
+		}
+		fmt.Fprintf(&p.buf, " ] /Count %d >>", len(pages))
+	})
+	for i, page := range pages {
+		h := page.Bounds().Dy()
+		pageObj := 3 + 3*i
+		p.object(func() {
+			fmt.Fprintf(&p.buf, "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] "+
+				"/Resources << /XObject << /Im0 %d 0 R >> >> /Contents %d 0 R >>",
+				width, height, pageObj+2, pageObj+1)
+		})
+		content := fmt.Sprintf("q %d 0 0 %d 0 %d cm /Im0 Do Q", width, h, height-h)
+		p.object(func() { p.stream("", []byte(content)) })
+		data, err := deflateRGB(page)
+		if err != nil {
+			return nil, err
+		}
+		p.object(func() {
+			p.stream(fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d "+
+				"/ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode",
+				width, h), data)
+		})
+	}
+	xref := p.buf.Len()
+	fmt.Fprintf(&p.buf, "xref\n0 %d\n0000000000 65535 f \n", len(p.offsets)+1)
+	for _, off := range p.offsets {
+		fmt.Fprintf(&p.buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&p.buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n",
+		len(p.offsets)+1, xref)
+	return p.buf.Bytes(), nil
+}
+
+func deflateRGB(img *image.RGBA) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	zw := zlib.NewWriter(buf)
+	b := img.Bounds()
+	row := make([]byte, 3*b.Dx())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		off := img.PixOffset(b.Min.X, y)
+		for x := 0; x < b.Dx(); x++ {
+			copy(row[3*x:3*x+3], img.Pix[off+4*x:off+4*x+3])
+		}
+		if _, err := zw.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,131 @@
+package render
+
+import (
+	"bytes"
+	"html"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+
+	_ "image/gif"
+	_ "image/jpeg"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+	nethtml "golang.org/x/net/html"
+)
+
+const (
+	margin    = 16
+	maxHeight = 16384
+	MinWidth  = 240
+	MaxWidth  = 2400
+)
+
+var white = color.RGBA{0xff, 0xff, 0xff, 0xff}
+var ruleColor = color.RGBA{0xcc, 0xcc, 0xcc, 0xff}
+var placeholderColor = color.RGBA{0xe8, 0xe8, 0xe8, 0xff}
+
+type Source struct {
+	Html string
+	Text string
+	// Image resolves the src attribute of an <img> element, e.g. cid: URLs
+	// of inline parts. Unresolved images are drawn as placeholders.
+	Image func(src string) (image.Image, bool)
+}
+
+// Render lays out the HTML body of src, or the plain text body if there is
+// no HTML, on a page of the given width. This is a deliberately simple
+// block/inline layout supporting common inline styles, not a browser engine:
+// stylesheets are ignored, tables are linearized and the page height is
+// capped at 16384 pixels.
+func Render(src Source, width int) (*image.RGBA, error) {
+	width = min(max(width, MinWidth), MaxWidth)
+	doc := src.Html
+	if len(strings.TrimSpace(doc)) == 0 {
+		doc = "<pre>" + html.EscapeString(src.Text) + "</pre>"
+	}
+	root, err := nethtml.Parse(strings.NewReader(doc))
+	if err != nil {
+		return nil, err
+	}
+	b := &builder{image: src.Image}
+	b.walk(root, defaultStyle)
+	l := &layout{faces: make(faceSet), avail: width - 2*margin, limit: maxHeight}
+	if err := l.run(b.items); err != nil {
+		return nil, err
+	}
+	height := min(l.height+2*margin, maxHeight)
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	bg := white
+	if b.pageBg != nil {
+		bg = *b.pageBg
+	}
+	draw.Draw(img, img.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	for _, r := range l.bgs {
+		rect := image.Rect(margin+r.x0, margin+r.y0, width-margin, margin+r.y1)
+		draw.Draw(img, rect, image.NewUniform(r.c), image.Point{}, draw.Src)
+	}
+	for _, ln := range l.lines {
+		if err := l.draw(img, ln); err != nil {
+			return nil, err
+		}
+	}
+	return img, nil
+}
+
+func (l *layout) draw(img *image.RGBA, ln *line) error {
+	x0 := margin + ln.indent
+	switch free := l.avail - ln.indent - ln.width; ln.align {
+	case alignCenter:
+		x0 += max(free, 0) / 2
+	case alignRight:
+		x0 += max(free, 0)
+	}
+	baseline := margin + ln.y + ln.ascent
+	for _, f := range ln.frags {
+		x := x0 + f.x
+		switch {
+		case f.rule:
+			rect := image.Rect(x, baseline-2, x+f.w, baseline-1)
+			draw.Draw(img, rect, image.NewUniform(ruleColor), image.Point{}, draw.Src)
+		case f.img != nil:
+			rect := image.Rect(x, baseline-f.h, x+f.w, baseline)
+			draw.ApproxBiLinear.Scale(img, rect, f.img, f.img.Bounds(), draw.Over, nil)
+		case f.h > 0:
+			rect := image.Rect(x, baseline-f.h, x+f.w, baseline)
+			draw.Draw(img, rect, image.NewUniform(placeholderColor), image.Point{}, draw.Src)
+		default:
+			face, err := l.faces.get(f.st)
+			if err != nil {
+				return err
+			}
+			d := &font.Drawer{
+				Dst:  img,
+				Src:  image.NewUniform(f.st.color),
+				Face: face,
+				Dot:  fixed.P(x, baseline),
+			}
+			d.DrawString(f.text)
+			if f.st.underline && f.text != " " {
+				rect := image.Rect(x, baseline+2, x+f.w, baseline+3)
+				draw.Draw(img, rect, image.NewUniform(f.st.color), image.Point{}, draw.Src)
+			}
+		}
+	}
+	return nil
+}
+
+func PNG(src Source, width int) ([]byte, error) {
+	img, err := Render(src, width)
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,243 @@
+package render
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	nethtml "golang.org/x/net/html"
+)
+
+func layoutOf(t *testing.T, doc string, width int) *layout {
+	t.Helper()
+	root, err := nethtml.Parse(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := new(builder)
+	b.walk(root, defaultStyle)
+	l := &layout{faces: make(faceSet), avail: width - 2*margin, limit: maxHeight}
+	if err := l.run(b.items); err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func lineText(ln *line) string {
+	buf := new(strings.Builder)
+	for _, f := range ln.frags {
+		buf.WriteString(f.text)
+	}
+	return buf.String()
+}
+
+func TestLayoutWrapping(t *testing.T) {
+	words := strings.Repeat("lorem ipsum dolor ", 30)
+	l := layoutOf(t, "<p>"+words+"</p><p>"+strings.Repeat("x", 200)+"</p>", MinWidth)
+	if len(l.lines) < 4 {
+		t.Fatalf("expected wrapped lines, got %v", len(l.lines))
+	}
+	joined := make([]string, 0, len(l.lines))
+	for i, ln := range l.lines {
+		if ln.width > l.avail {
+			t.Errorf("line %v exceeds the width: %v > %v", i, ln.width, l.avail)
+		} else if strings.HasPrefix(lineText(ln), " ") || strings.HasSuffix(lineText(ln), " ") {
+			t.Errorf("line %v is not trimmed: %q", i, lineText(ln))
+		}
+		if i > 0 && ln.y <= l.lines[i-1].y {
+			t.Errorf("line %v does not advance: %v <= %v", i, ln.y, l.lines[i-1].y)
+		}
+		joined = append(joined, lineText(ln))
+	}
+	if text := strings.Join(joined, ""); strings.Count(text, "x") != 200 ||
+		strings.Count(text, "lorem") != 30 {
+		t.Errorf("text lost while wrapping: %q", text)
+	}
+}
+
+func TestLayoutWhitespace(t *testing.T) {
+	l := layoutOf(t, "<p>  a \n\t b  </p><pre>c\n  d</pre>", 800)
+	var texts []string
+	for _, ln := range l.lines {
+		texts = append(texts, lineText(ln))
+	}
+	if strings.Join(texts, "|") != "a b|c|  d" {
+		t.Errorf("unexpected lines %q", texts)
+	}
+}
+
+func TestLayoutAlignment(t *testing.T) {
+	l := layoutOf(t, `<p>left</p><p style="text-align: center">center</p>`+
+		`<div align="right">right</div><center>legacy</center>`, 800)
+	want := []int{alignLeft, alignCenter, alignRight, alignCenter}
+	if len(l.lines) != len(want) {
+		t.Fatalf("unexpected lines %v", len(l.lines))
+	}
+	for i, ln := range l.lines {
+		if ln.align != want[i] {
+			t.Errorf("%v: align %v, want %v", lineText(ln), ln.align, want[i])
+		}
+	}
+}
+
+func TestLayoutIndent(t *testing.T) {
+	l := layoutOf(t, "<ol><li>one</li><li>two<ul><li>nested</li></ul></li></ol>", 800)
+	var texts []string
+	for _, ln := range l.lines {
+		texts = append(texts, strconv.Itoa(ln.indent)+":"+lineText(ln))
+	}
+	if strings.Join(texts, "|") != "24:1. one|24:2. two|48:• nested" {
+		t.Errorf("unexpected lines %q", texts)
+	}
+}
+
+func TestRenderSize(t *testing.T) {
+	for _, tc := range []struct{ width, want int }{
+		{0, MinWidth}, {500, 500}, {10000, MaxWidth},
+	} {
+		img, err := Render(Source{Text: "Hello"}, tc.width)
+		if err != nil {
+			t.Fatal(err)
+		} else if img.Bounds().Dx() != tc.want {
+			t.Errorf("width %v rendered as %v", tc.width, img.Bounds().Dx())
+		}
+	}
+	img, err := Render(Source{Text: strings.Repeat("line\n", 5000)}, 400)
+	if err != nil {
+		t.Fatal(err)
+	} else if img.Bounds().Dy() != maxHeight {
+		t.Errorf("height not capped: %v", img.Bounds().Dy())
+	}
+}
+
+func TestRenderPixels(t *testing.T) {
+	img, err := Render(Source{
+		Html: `<body bgcolor="#00ff00"><p style="color: red">Hello</p><img src="cid:a">`,
+		Image: func(src string) (image.Image, bool) {
+			if src != "cid:a" {
+				return nil, false
+			}
+			blue := image.NewRGBA(image.Rect(0, 0, 10, 10))
+			for i := 0; i < len(blue.Pix); i += 4 {
+				copy(blue.Pix[i:], []byte{0, 0, 0xff, 0xff})
+			}
+			return blue, true
+		},
+	}, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[color.RGBA]int)
+	for y := range img.Bounds().Dy() {
+		for x := range img.Bounds().Dx() {
+			counts[img.RGBAAt(x, y)]++
+		}
+	}
+	if counts[color.RGBA{0, 0xff, 0, 0xff}] == 0 {
+		t.Errorf("page background missing")
+	} else if counts[color.RGBA{0xff, 0, 0, 0xff}] == 0 {
+		t.Errorf("red text missing")
+	} else if counts[color.RGBA{0, 0, 0xff, 0xff}] != 100 {
+		t.Errorf("inline image not drawn 1:1: %v pixels", counts[color.RGBA{0, 0, 0xff, 0xff}])
+	}
+}
+
+func TestPNG(t *testing.T) {
+	src := Source{Html: "<h1>Hello</h1><p>World</p>"}
+	b, err := PNG(src, 640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := png.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	} else if cfg.Width != 640 || cfg.Height <= 2*margin {
+		t.Errorf("unexpected size %vx%v", cfg.Width, cfg.Height)
+	}
+	if again, err := PNG(src, 640); err != nil || !bytes.Equal(b, again) {
+		t.Errorf("rendering is not deterministic")
+	}
+}
+
+func TestPDF(t *testing.T) {
+	for _, tc := range []struct {
+		text  string
+		pages int
+	}{
+		{"Hello", 1},
+		{strings.Repeat("line\n", 90), 3},
+	} {
+		img, err := Render(Source{Text: tc.text}, 400)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pageHeight := 400 * 1414 / 1000
+		if want := (img.Bounds().Dy() + pageHeight - 1) / pageHeight; want != tc.pages {
+			t.Fatalf("expected %v pages for height %v, got %v", tc.pages, img.Bounds().Dy(), want)
+		}
+		b, err := PDF(Source{Text: tc.text}, 400)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(b, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(b, []byte("%%EOF\n")) {
+			t.Errorf("invalid PDF envelope")
+		} else if !bytes.Contains(b, []byte("/Count "+strconv.Itoa(tc.pages)+" >>")) {
+			t.Errorf("expected %v pages", tc.pages)
+		} else if n := bytes.Count(b, []byte("/MediaBox [0 0 400 565]")); n != tc.pages {
+			t.Errorf("unexpected media boxes: %v", n)
+		}
+		// every xref entry must point to the start of its object
+		xref := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(b)
+		if xref == nil {
+			t.Fatal("startxref missing")
+		}
+		off, _ := strconv.Atoi(string(xref[1]))
+		entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(b[off:], -1)
+		if len(entries) != 2+3*tc.pages {
+			t.Errorf("unexpected xref entries: %v", len(entries))
+		}
+		for i, e := range entries {
+			pos, _ := strconv.Atoi(string(e[1]))
+			if !bytes.HasPrefix(b[pos:], []byte(strconv.Itoa(i+1)+" 0 obj\n")) {
+				t.Errorf("xref entry %v points to %q", i+1, b[pos:min(pos+10, len(b))])
+			}
+		}
+	}
+}
+
+func TestCache(t *testing.T) {
+	c := NewCache(2)
+	c.Put("a", []byte("a"))
+	c.Put("b", []byte("b"))
+	c.Get("a")
+	c.Put("c", []byte("c"))
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("least recently used entry not evicted")
+	} else if b, ok := c.Get("a"); !ok || string(b) != "a" {
+		t.Errorf("recently used entry evicted")
+	}
+	c = NewCache(0)
+	if c.Put("a", []byte("a")); len(c.entries) != 0 {
+		t.Errorf("disabled cache stores entries")
+	}
+}
+
+func TestCacheRemoveFunc(t *testing.T) {
+	c := NewCache(4)
+	for _, key := range []string{"1-800.png", "1-300.pdf", "12-800.png"} {
+		c.Put(key, []byte(key))
+	}
+	c.RemoveFunc(func(key string) bool { return strings.HasPrefix(key, "1-") })
+	if _, ok := c.Get("1-800.png"); ok {
+		t.Errorf("entry not removed")
+	} else if _, ok := c.Get("12-800.png"); !ok {
+		t.Errorf("other entry removed")
+	} else if c.order.Len() != 1 {
+		t.Errorf("unexpected entries %v", c.order.Len())
+	}
+}
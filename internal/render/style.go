@@ -0,0 +1,258 @@
+package render
+
+import (
+	"image/color"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gobolditalic"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+)
+
+const (
+	alignLeft = iota
+	alignCenter
+	alignRight
+)
+
+type style struct {
+	bold      bool
+	italic    bool
+	mono      bool
+	pre       bool
+	underline bool
+	size      float64
+	color     color.RGBA
+	align     int
+}
+
+var defaultStyle = style{size: 14, color: color.RGBA{0x22, 0x22, 0x22, 0xff}}
+
+var linkColor = color.RGBA{0x1a, 0x0d, 0xab, 0xff}
+
+type faceKey struct {
+	bold, italic, mono bool
+	size               float64
+}
+
+var fonts = struct {
+	sync.Mutex
+	parsed map[string]*opentype.Font
+}{parsed: make(map[string]*opentype.Font)}
+
+// faceSet caches the font faces of a single rendering; faces must not be
+// shared between goroutines, in contrast to the parsed fonts.
+type faceSet map[faceKey]font.Face
+
+func (fs faceSet) get(s style) (font.Face, error) {
+	key := faceKey{bold: s.bold, italic: s.italic, mono: s.mono, size: s.size}
+	if f, ok := fs[key]; ok {
+		return f, nil
+	}
+	f, err := parsedFont(s)
+	if err != nil {
+		return nil, err
+	}
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{
+		Size:    s.size,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+	if err != nil {
+		return nil, err
+	}
+	fs[key] = face
+	return face, nil
+}
+
+func parsedFont(s style) (*opentype.Font, error) {
+	var name string
+	var ttf []byte
+	switch {
+	case s.mono && s.bold:
+		name, ttf = "gomonobold", gomonobold.TTF
+	case s.mono:
+		name, ttf = "gomono", gomono.TTF
+	case s.bold && s.italic:
+		name, ttf = "gobolditalic", gobolditalic.TTF
+	case s.bold:
+		name, ttf = "gobold", gobold.TTF
+	case s.italic:
+		name, ttf = "goitalic", goitalic.TTF
+	default:
+		name, ttf = "goregular", goregular.TTF
+	}
+	fonts.Lock()
+	defer fonts.Unlock()
+	if f, ok := fonts.parsed[name]; ok {
+		return f, nil
+	}
+	f, err := opentype.Parse(ttf)
+	if err != nil {
+		return nil, err
+	}
+	fonts.parsed[name] = f
+	return f, nil
+}
+
+// applyCss applies the subset of inline CSS declarations understood by the
+// renderer. Unknown properties are ignored.
+func (s *style) applyCss(css string) (bg *color.RGBA) {
+	for _, decl := range strings.Split(css, ";") {
+		prop, val, ok := strings.Cut(decl, ":")
+		if !ok {
+			continue
+		}
+		prop = strings.ToLower(strings.TrimSpace(prop))
+		val = strings.ToLower(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(val), "!important")))
+		switch prop {
+		case "color":
+			if c, ok := parseColor(val); ok {
+				s.color = c
+			}
+		case "background-color", "background":
+			if c, ok := parseColor(strings.Fields(val + " ")[0]); ok {
+				bg = &c
+			}
+		case "font-weight":
+			s.bold = val == "bold" || val == "bolder" || (len(val) == 3 && val >= "600")
+		case "font-style":
+			s.italic = val == "italic" || val == "oblique"
+		case "font-family":
+			s.mono = strings.Contains(val, "monospace") || strings.Contains(val, "courier")
+		case "font-size":
+			if size, ok := parseSize(val, s.size); ok {
+				s.size = size
+			}
+		case "text-align":
+			s.align = parseAlign(val, s.align)
+		case "text-decoration", "text-decoration-line":
+			s.underline = strings.Contains(val, "underline")
+		}
+	}
+	return bg
+}
+
+func parseAlign(val string, def int) int {
+	switch strings.ToLower(strings.TrimSpace(val)) {
+	case "center", "middle":
+		return alignCenter
+	case "right", "end":
+		return alignRight
+	case "left", "start", "justify":
+		return alignLeft
+	default:
+		return def
+	}
+}
+
+func parseSize(val string, parent float64) (float64, bool) {
+	var size float64
+	var err error
+	switch {
+	case strings.HasSuffix(val, "px"):
+		size, err = strconv.ParseFloat(strings.TrimSuffix(val, "px"), 64)
+	case strings.HasSuffix(val, "pt"):
+		size, err = strconv.ParseFloat(strings.TrimSuffix(val, "pt"), 64)
+		size *= 4.0 / 3.0
+	case strings.HasSuffix(val, "rem"):
+		size, err = strconv.ParseFloat(strings.TrimSuffix(val, "rem"), 64)
+		size *= defaultStyle.size
+	case strings.HasSuffix(val, "em"):
+		size, err = strconv.ParseFloat(strings.TrimSuffix(val, "em"), 64)
+		size *= parent
+	case strings.HasSuffix(val, "%"):
+		size, err = strconv.ParseFloat(strings.TrimSuffix(val, "%"), 64)
+		size *= parent / 100
+	default:
+		switch val {
+		case "xx-small":
+			return 9, true
+		case "x-small":
+			return 10, true
+		case "small":
+			return 13, true
+		case "medium":
+			return 16, true
+		case "large":
+			return 18, true
+		case "x-large":
+			return 24, true
+		case "xx-large":
+			return 32, true
+		}
+		return 0, false
+	}
+	if err != nil || size < 4 || size > 96 {
+		return 0, false
+	}
+	return size, true
+}
+
+var namedColors = map[string]color.RGBA{
+	"black":   {0x00, 0x00, 0x00, 0xff},
+	"white":   {0xff, 0xff, 0xff, 0xff},
+	"gray":    {0x80, 0x80, 0x80, 0xff},
+	"grey":    {0x80, 0x80, 0x80, 0xff},
+	"silver":  {0xc0, 0xc0, 0xc0, 0xff},
+	"red":     {0xff, 0x00, 0x00, 0xff},
+	"maroon":  {0x80, 0x00, 0x00, 0xff},
+	"orange":  {0xff, 0xa5, 0x00, 0xff},
+	"yellow":  {0xff, 0xff, 0x00, 0xff},
+	"olive":   {0x80, 0x80, 0x00, 0xff},
+	"lime":    {0x00, 0xff, 0x00, 0xff},
+	"green":   {0x00, 0x80, 0x00, 0xff},
+	"teal":    {0x00, 0x80, 0x80, 0xff},
+	"aqua":    {0x00, 0xff, 0xff, 0xff},
+	"cyan":    {0x00, 0xff, 0xff, 0xff},
+	"blue":    {0x00, 0x00, 0xff, 0xff},
+	"navy":    {0x00, 0x00, 0x80, 0xff},
+	"purple":  {0x80, 0x00, 0x80, 0xff},
+	"fuchsia": {0xff, 0x00, 0xff, 0xff},
+	"magenta": {0xff, 0x00, 0xff, 0xff},
+}
+
+func parseColor(val string) (color.RGBA, bool) {
+	val = strings.ToLower(strings.TrimSpace(val))
+	if c, ok := namedColors[val]; ok {
+		return c, true
+	}
+	switch {
+	case strings.HasPrefix(val, "#") && len(val) == 4:
+		v, err := strconv.ParseUint(val[1:], 16, 16)
+		if err != nil {
+			return color.RGBA{}, false
+		}
+		r, g, b := uint8(v>>8&0xf), uint8(v>>4&0xf), uint8(v&0xf)
+		return color.RGBA{r<<4 | r, g<<4 | g, b<<4 | b, 0xff}, true
+	case strings.HasPrefix(val, "#") && len(val) == 7:
+		v, err := strconv.ParseUint(val[1:], 16, 32)
+		if err != nil {
+			return color.RGBA{}, false
+		}
+		return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, true
+	case (strings.HasPrefix(val, "rgb(") || strings.HasPrefix(val, "rgba(")) && strings.HasSuffix(val, ")"):
+		args := val[strings.IndexByte(val, '(')+1 : len(val)-1]
+		f := strings.FieldsFunc(args, func(r rune) bool { return r == ',' || r == ' ' || r == '/' })
+		if len(f) < 3 {
+			return color.RGBA{}, false
+		}
+		var rgb [3]uint8
+		for i := range rgb {
+			n, err := strconv.Atoi(f[i])
+			if err != nil || n < 0 || n > 255 {
+				return color.RGBA{}, false
+			}
+			rgb[i] = uint8(n)
+		}
+		return color.RGBA{rgb[0], rgb[1], rgb[2], 0xff}, true
+	}
+	return color.RGBA{}, false
+}
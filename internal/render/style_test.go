@@ -0,0 +1,75 @@
+package render
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestParseColor(t *testing.T) {
+	for _, tc := range []struct {
+		val string
+		c   color.RGBA
+		ok  bool
+	}{
+		{"red", color.RGBA{0xff, 0, 0, 0xff}, true},
+		{" Navy ", color.RGBA{0, 0, 0x80, 0xff}, true},
+		{"#abc", color.RGBA{0xaa, 0xbb, 0xcc, 0xff}, true},
+		{"#102030", color.RGBA{0x10, 0x20, 0x30, 0xff}, true},
+		{"rgb(1, 2, 3)", color.RGBA{1, 2, 3, 0xff}, true},
+		{"rgba(1 2 3 / 50%)", color.RGBA{1, 2, 3, 0xff}, true},
+		{"rgb(256, 0, 0)", color.RGBA{}, false},
+		{"rgb(1, 2)", color.RGBA{}, false},
+		{"#abcd", color.RGBA{}, false},
+		{"#ggg", color.RGBA{}, false},
+		{"transparent", color.RGBA{}, false},
+	} {
+		if c, ok := parseColor(tc.val); c != tc.c || ok != tc.ok {
+			t.Errorf("parseColor(%q) = %v, %v", tc.val, c, ok)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for _, tc := range []struct {
+		val  string
+		size float64
+		ok   bool
+	}{
+		{"12px", 12, true},
+		{"12pt", 16, true},
+		{"2em", 40, true},
+		{"2rem", 28, true},
+		{"50%", 10, true},
+		{"large", 18, true},
+		{"3px", 0, false},
+		{"200px", 0, false},
+		{"big", 0, false},
+		{"px", 0, false},
+	} {
+		if size, ok := parseSize(tc.val, 20); size != tc.size || ok != tc.ok {
+			t.Errorf("parseSize(%q) = %v, %v", tc.val, size, ok)
+		}
+	}
+}
+
+func TestApplyCss(t *testing.T) {
+	st := defaultStyle
+	bg := st.applyCss("color: #ff0000; background: white url(x.png) no-repeat; " +
+		"font-weight: 700; font-style: italic; font-family: Courier New, monospace; " +
+		"font-size: 2em !important; text-align: center; text-decoration: underline; " +
+		"unknown: value; invalid")
+	want := style{bold: true, italic: true, mono: true, underline: true, size: 28,
+		color: color.RGBA{0xff, 0, 0, 0xff}, align: alignCenter}
+	if st != want {
+		t.Errorf("unexpected style %+v", st)
+	}
+	if bg == nil || *bg != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("unexpected background %v", bg)
+	}
+	st = want
+	if bg := st.applyCss("font-weight: normal; font-size: huge; color: none"); bg != nil {
+		t.Errorf("unexpected background %v", bg)
+	} else if st.bold || st.size != 28 || st.color != want.color {
+		t.Errorf("unexpected style %+v", st)
+	}
+}
@@ -0,0 +1,102 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ApiV1Prefix is the path prefix of the versioned API. The routes without
+// prefix are deprecated aliases, which keep their plain text errors.
+const ApiV1Prefix = "/api/v1"
+
+type apiVersionKey struct{}
+
+// V1 marks requests as requests of the versioned API.
+func V1(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h(w, r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, 1)))
+	}
+}
+
+// Deprecated adds the headers of RFC 9745 and RFC 8594 pointing to the
+// successor route of the versioned API.
+func Deprecated(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Deprecation", "true")
+		w.Header().Add("Link", "<"+ApiV1Prefix+r.URL.Path+`>; rel="successor-version"`)
+		h(w, r)
+	}
+}
+
+func isV1(r *http.Request) bool {
+	v, _ := r.Context().Value(apiVersionKey{}).(int)
+	return v == 1
+}
+
+// ApiError is the error body of the versioned API. Code is derived from the
+// HTTP status, e.g. "not_found".
+type ApiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+}
+
+// Problem is an RFC 9457 problem detail, sent instead of ApiError if the
+// client accepts application/problem+json.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     string `json:"code"`
+	Details  any    `json:"details,omitempty"`
+}
+
+func errorCode(status int) string {
+	return strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+}
+
+func httpError(w http.ResponseWriter, r *http.Request, msg string, status int) {
+	httpErrorDetails(w, r, msg, status, nil)
+}
+
+// httpErrorDetails writes the error as plain text for the deprecated routes
+// and as ApiError or Problem for the versioned API.
+func httpErrorDetails(w http.ResponseWriter, r *http.Request, msg string, status int, details any) {
+	if !isV1(r) {
+		http.Error(w, msg, status)
+		return
+	}
+	var v any = ApiError{Code: errorCode(status), Message: msg, Details: details}
+	contentType := "application/json"
+	if strings.Contains(r.Header.Get("Accept"), "application/problem+json") {
+		contentType = "application/problem+json"
+		v = Problem{
+			Type:     "about:blank",
+			Title:    http.StatusText(status),
+			Status:   status,
+			Detail:   msg,
+			Instance: r.URL.Path,
+			Code:     errorCode(status),
+			Details:  details,
+		}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("Marshalling error response failed", "error", err.Error())
+		http.Error(w, msg, status)
+		return
+	}
+	h := w.Header()
+	h.Del("Content-Disposition")
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Length", strconv.Itoa(len(b)))
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(b)
+}
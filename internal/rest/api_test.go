@@ -0,0 +1,33 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHttpErrorDetails(t *testing.T) {
+	details := UploadResult{NumFailed: 1, Messages: []UploadMessage{{File: "a.eml", Error: "no date"}}}
+	for _, accept := range []string{"", "application/problem+json"} {
+		var body struct {
+			Code    string       `json:"code"`
+			Details UploadResult `json:"details"`
+		}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/upload", nil)
+		r.Header.Set("Accept", accept)
+		w.Header().Set("Content-Disposition", "attachment")
+		V1(func(w http.ResponseWriter, r *http.Request) {
+			httpErrorDetails(w, r, "no mail could be stored", http.StatusBadRequest, details)
+		})(w, r)
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		} else if w.Code != http.StatusBadRequest || w.Header().Get("Content-Disposition") != "" {
+			t.Errorf("%q: unexpected response %v %v", accept, w.Code, w.Header())
+		} else if body.Code != "bad_request" || body.Details.NumFailed != 1 ||
+			len(body.Details.Messages) != 1 || body.Details.Messages[0] != details.Messages[0] {
+			t.Errorf("%q: unexpected body %+v", accept, body)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package rest
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"math"
@@ -9,11 +10,16 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/rntrp/mailheap/internal/config"
+	"github.com/rntrp/mailheap/internal/linkcheck"
 	"github.com/rntrp/mailheap/internal/model"
 	"github.com/rntrp/mailheap/internal/msg"
+	"github.com/rntrp/mailheap/internal/render"
+	"github.com/rntrp/mailheap/internal/rules"
 	"github.com/rntrp/mailheap/internal/storage"
+	"github.com/rntrp/mailheap/internal/webhook"
 )
 
 type Controller interface {
@@ -23,36 +29,79 @@ type Controller interface {
 	IndexCss(w http.ResponseWriter, r *http.Request)
 	IndexJs(w http.ResponseWriter, r *http.Request)
 	IndexJsMimeParser(w http.ResponseWriter, r *http.Request)
+	RenderPdf(w http.ResponseWriter, r *http.Request)
+	RenderPng(w http.ResponseWriter, r *http.Request)
+	GetCid(w http.ResponseWriter, r *http.Request)
 	GetEml(w http.ResponseWriter, r *http.Request)
+	GetHtml(w http.ResponseWriter, r *http.Request)
+	GetLinks(w http.ResponseWriter, r *http.Request)
 	DeleteMails(w http.ResponseWriter, r *http.Request)
+	PatchMail(w http.ResponseWriter, r *http.Request)
+	PatchMails(w http.ResponseWriter, r *http.Request)
+	ExportMbox(w http.ResponseWriter, r *http.Request)
+	ExportZip(w http.ResponseWriter, r *http.Request)
+	Docs(w http.ResponseWriter, r *http.Request)
+	DocsJs(w http.ResponseWriter, r *http.Request)
+	ExtractMail(w http.ResponseWriter, r *http.Request)
+	Feed(w http.ResponseWriter, r *http.Request)
+	GetRules(w http.ResponseWriter, r *http.Request)
+	GetSettings(w http.ResponseWriter, r *http.Request)
+	GetWebhooks(w http.ResponseWriter, r *http.Request)
+	GetWebhookDeliveries(w http.ResponseWriter, r *http.Request)
+	OpenApi(w http.ResponseWriter, r *http.Request)
+	PatchSettings(w http.ResponseWriter, r *http.Request)
+	PutRules(w http.ResponseWriter, r *http.Request)
+	ReloadSettings(w http.ResponseWriter, r *http.Request)
+	PutRule(w http.ResponseWriter, r *http.Request)
+	DeleteRule(w http.ResponseWriter, r *http.Request)
 	SeekMails(w http.ResponseWriter, r *http.Request)
 	UploadMail(w http.ResponseWriter, r *http.Request)
 }
 
-func New(s storage.MailStorage, a msg.StoreMailSvc) Controller {
-	return &ctrl{storage: s, storeMail: a}
+func New(s storage.MailStorage, a msg.StoreMailSvc, l linkcheck.Checker, e *rules.Engine,
+	d *webhook.Dispatcher) Controller {
+	c := &ctrl{
+		storage:   s,
+		storeMail: a,
+		linkCheck: l,
+		rules:     e,
+		webhooks:  d,
+		renders:   render.NewCache(int(config.GetRenderCacheSize())),
+	}
+	if s != nil {
+		events, _ := s.Subscribe()
+		go c.evictRenders(events)
+	}
+	return c
 }
 
 type ctrl struct {
 	storage   storage.MailStorage
 	storeMail msg.StoreMailSvc
+	linkCheck linkcheck.Checker
+	rules     *rules.Engine
+	webhooks  *webhook.Dispatcher
+	renders   *render.Cache
 }
 
 func (c *ctrl) GetEml(w http.ResponseWriter, r *http.Request) {
 	addSecurityHeaders(w.Header())
 	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
 	if err != nil {
-		http.Error(w, "numeric ID could not be parsed", http.StatusBadRequest)
+		httpError(w, r, "numeric ID could not be parsed", http.StatusBadRequest)
 		return
 	}
 	eml, err := c.storage.GetMime(id)
-	if err != nil {
+	if errors.Is(err, storage.ErrNotFound) {
+		httpError(w, r, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	} else if err != nil {
 		slog.Error("Get mail failed", "error", err.Error())
-		http.Error(w, http.StatusText(http.StatusInternalServerError),
+		httpError(w, r, http.StatusText(http.StatusInternalServerError),
 			http.StatusInternalServerError)
 		return
 	} else if len(eml) == 0 {
-		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		httpError(w, r, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 		return
 	}
 	b := []byte(eml)
@@ -62,46 +111,100 @@ func (c *ctrl) GetEml(w http.ResponseWriter, r *http.Request) {
 	w.Write(b)
 }
 
+type GetLinksResult struct {
+	Id    int64        `json:"id"`
+	Links []LinkResult `json:"links"`
+}
+
+type LinkResult struct {
+	model.Link
+	Check *linkcheck.Result `json:"check,omitempty"`
+}
+
+func (c *ctrl) GetLinks(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w.Header())
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		httpError(w, r, "numeric ID could not be parsed", http.StatusBadRequest)
+		return
+	}
+	check, _ := strconv.ParseBool(r.URL.Query().Get("check"))
+	if check && c.linkCheck == nil {
+		httpError(w, r, "link checker is disabled", http.StatusBadRequest)
+		return
+	}
+	links, err := c.storage.GetLinks(id)
+	if errors.Is(err, storage.ErrNotFound) {
+		httpError(w, r, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	} else if err != nil {
+		slog.Error("Get links failed", "error", err.Error())
+		httpError(w, r, http.StatusText(http.StatusInternalServerError),
+			http.StatusInternalServerError)
+		return
+	}
+	res := GetLinksResult{Id: id, Links: make([]LinkResult, len(links))}
+	for i, l := range links {
+		res.Links[i].Link = l
+		if check {
+			checked := c.linkCheck.Check(r.Context(), l.Url)
+			res.Links[i].Check = &checked
+		}
+	}
+	b, err := json.Marshal(res)
+	if err != nil {
+		slog.Error("Marshalling get links result failed", "error", err.Error())
+		httpError(w, r, http.StatusText(http.StatusInternalServerError),
+			http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Add("Content-Length", strconv.Itoa(len(b)))
+	w.Write(b)
+}
+
 type DeleteMailsResult struct {
+	NumDeleted int64 `json:"numDeleted"`
+}
+
+// legacyDeleteMailsResult keeps the field name of the deprecated route.
+type legacyDeleteMailsResult struct {
 	NumDeleted int64
 }
 
 func (c *ctrl) DeleteMails(w http.ResponseWriter, r *http.Request) {
 	addSecurityHeaders(w.Header())
-	csrfTokenQuery := r.URL.Query().Get("csrf-token")
-	csrfTokenHeader := r.Header.Get("X-Csrf-Token")
-	if len(csrfTokenQuery) == 0 || csrfTokenQuery != csrfTokenHeader {
-		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+	if !validCsrfToken(r) {
+		httpError(w, r, "Invalid CSRF token", http.StatusForbidden)
 		return
 	}
 	var numDeleted int64
 	var err error
 	if idQuery, ok := r.URL.Query()["id"]; ok {
 		if len(idQuery) != 1 {
-			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			httpError(w, r, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 			return
 		}
-		str := strings.Split(idQuery[0], ",")
-		ids := make([]int64, 0, len(str))
-		for _, s := range str {
-			if id, err := strconv.ParseInt(s, 10, 64); err != nil {
-				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
-				return
-			} else {
-				ids = append(ids, id)
-			}
+		var ids []int64
+		if ids, err = parseIds(idQuery[0]); err != nil {
+			httpError(w, r, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
 		}
 		numDeleted, err = c.storage.DeleteMails(ids...)
 	} else {
 		numDeleted, err = c.storage.DeleteAllMails()
 	}
+	var res any = DeleteMailsResult{NumDeleted: numDeleted}
+	if !isV1(r) {
+		res = legacyDeleteMailsResult{NumDeleted: numDeleted}
+	}
 	if err != nil {
 		slog.Error("Delete mail failed", "error", err.Error())
-		http.Error(w, http.StatusText(http.StatusInternalServerError),
+		httpError(w, r, http.StatusText(http.StatusInternalServerError),
 			http.StatusInternalServerError)
-	} else if b, err := json.Marshal(DeleteMailsResult{NumDeleted: numDeleted}); err != nil {
+	} else if b, err := json.Marshal(res); err != nil {
 		slog.Error("Marshalling delete mail result failed", "error", err.Error())
-		http.Error(w, http.StatusText(http.StatusInternalServerError),
+		httpError(w, r, http.StatusText(http.StatusInternalServerError),
 			http.StatusInternalServerError)
 	} else {
 		w.Header().Add("Content-Type", "application/json")
@@ -122,23 +225,28 @@ func (c *ctrl) SeekMails(w http.ResponseWriter, r *http.Request) {
 	addSecurityHeaders(w.Header())
 	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
 	if err != nil {
-		http.Error(w, "numeric ID could not be parsed", http.StatusBadRequest)
+		httpError(w, r, "numeric ID could not be parsed", http.StatusBadRequest)
 		return
 	} else if id <= 0 {
 		id = math.MaxInt64
 	}
-	total, err := c.storage.CountMails()
+	f, err := parseFilter(r.URL.Query())
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	total, err := c.storage.CountMails(f)
 	if err != nil {
 		slog.Error("Counting mails failed", "error", err.Error())
-		http.Error(w, http.StatusText(http.StatusInternalServerError),
+		httpError(w, r, http.StatusText(http.StatusInternalServerError),
 			http.StatusInternalServerError)
 		return
 	}
 	limit := parseLimit(r.URL.Query())
-	mails, err := c.storage.SeekMails(id, limit)
+	mails, err := c.storage.SeekMails(id, limit, f)
 	if err != nil {
 		slog.Error("Seeking mails failed", "error", err.Error())
-		http.Error(w, http.StatusText(http.StatusInternalServerError),
+		httpError(w, r, http.StatusText(http.StatusInternalServerError),
 			http.StatusInternalServerError)
 		return
 	}
@@ -150,7 +258,7 @@ func (c *ctrl) SeekMails(w http.ResponseWriter, r *http.Request) {
 		Data:  mails})
 	if err != nil {
 		slog.Error("Marshalling seek mails result failed", "error", err.Error())
-		http.Error(w, http.StatusText(http.StatusInternalServerError),
+		httpError(w, r, http.StatusText(http.StatusInternalServerError),
 			http.StatusInternalServerError)
 		return
 	}
@@ -159,6 +267,60 @@ func (c *ctrl) SeekMails(w http.ResponseWriter, r *http.Request) {
 	w.Write(b)
 }
 
+func parseIds(s string) ([]int64, error) {
+	str := strings.Split(s, ",")
+	ids := make([]int64, 0, len(str))
+	for _, s := range str {
+		if id, err := strconv.ParseInt(s, 10, 64); err != nil {
+			return nil, err
+		} else {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func parseFilter(query url.Values) (storage.Filter, error) {
+	f := storage.Filter{
+		To:      query.Get("to"),
+		From:    query.Get("from"),
+		Subject: query.Get("subject"),
+	}
+	var err error
+	if id := query.Get("id"); len(id) > 0 {
+		if f.Ids, err = parseIds(id); err != nil {
+			return f, errors.New("query parameter 'id' must be a list of numeric IDs")
+		}
+	}
+	if since := query.Get("since"); len(since) > 0 {
+		if f.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return f, errors.New("query parameter 'since' must be an RFC 3339 timestamp")
+		}
+	}
+	if until := query.Get("until"); len(until) > 0 {
+		if f.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return f, errors.New("query parameter 'until' must be an RFC 3339 timestamp")
+		}
+	}
+	if seen := query.Get("seen"); len(seen) > 0 {
+		if b, err := strconv.ParseBool(seen); err != nil {
+			return f, errors.New("query parameter 'seen' must be a boolean")
+		} else {
+			f.Seen = &b
+		}
+	}
+	if starred := query.Get("starred"); len(starred) > 0 {
+		if b, err := strconv.ParseBool(starred); err != nil {
+			return f, errors.New("query parameter 'starred' must be a boolean")
+		} else {
+			f.Starred = &b
+		}
+	}
+	f.Tag = query.Get("tag")
+	f.Mailbox = query.Get("mailbox")
+	return f, nil
+}
+
 func parseLimit(query url.Values) int {
 	const def = 20
 	const min = 10
@@ -182,29 +344,56 @@ func parseLimit(query url.Values) int {
 
 func (c *ctrl) UploadMail(w http.ResponseWriter, r *http.Request) {
 	addSecurityHeaders(w.Header())
-	csrfTokenQuery := r.URL.Query().Get("csrf-token")
-	csrfTokenHeader := r.Header.Get("X-Csrf-Token")
-	if len(csrfTokenQuery) == 0 || csrfTokenQuery != csrfTokenHeader {
-		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+	if !validCsrfToken(r) {
+		httpError(w, r, "Invalid CSRF token", http.StatusForbidden)
 		return
 	} else if !setupFileSizeChecks(w, r) {
 		return
 	}
 	maxMemory := coerceMemoryBufferSize(config.GetHTTPUploadMemoryBufferSize())
 	if err := r.ParseMultipartForm(maxMemory); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		httpError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	} else if r.MultipartForm != nil {
 		defer r.MultipartForm.RemoveAll()
 	}
-	f, _, err := r.FormFile("eml")
+	files := r.MultipartForm.File["eml"]
+	if len(files) == 0 {
+		httpError(w, r, "file 'eml' is missing", http.StatusBadRequest)
+		return
+	}
+	src, err := msg.ParseTimeSource(r.URL.Query().Get("time"))
 	if err != nil {
-		http.Error(w, "file 'eml' is missing", http.StatusBadRequest)
+		httpError(w, r, "query parameter 'time' must be 'now', 'date' or 'received'",
+			http.StatusBadRequest)
 		return
 	}
-	defer f.Close()
-	if err := c.storeMail.StoreMail(f); err != nil {
-		slog.Error("HTTP: failed to store mail", "error", err.Error())
-		http.Error(w, "eml could not be stored", http.StatusBadRequest)
+	res := UploadResult{Messages: make([]UploadMessage, 0, len(files))}
+	for _, fh := range files {
+		res.Messages = append(res.Messages, c.storeUpload(fh, src)...)
+	}
+	for _, m := range res.Messages {
+		if len(m.Error) == 0 {
+			res.NumStored++
+		} else {
+			res.NumFailed++
+		}
+	}
+	if res.NumStored == 0 && isV1(r) {
+		httpErrorDetails(w, r, "no mail could be stored", http.StatusBadRequest, res)
+		return
 	}
+	b, err := json.Marshal(res)
+	if err != nil {
+		slog.Error("Marshalling upload result failed", "error", err.Error())
+		httpError(w, r, http.StatusText(http.StatusInternalServerError),
+			http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Add("Content-Length", strconv.Itoa(len(b)))
+	if res.NumStored == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	w.Write(b)
 }
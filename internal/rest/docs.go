@@ -0,0 +1,67 @@
+package rest
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+//go:embed openapi.json
+var openApiJson []byte
+
+var openApiJsonEtag string
+
+var openApiJsonGz []byte
+
+var openApiJsonGzEtag string
+
+//go:embed docs.html
+var docsHtml []byte
+
+var docsHtmlEtag string
+
+var docsHtmlGz []byte
+
+var docsHtmlGzEtag string
+
+//go:embed docs.js
+var docsJs []byte
+
+var docsJsEtag string
+
+var docsJsGz []byte
+
+var docsJsGzEtag string
+
+func initDocs() {
+	openApiJsonEtag = etag(openApiJson)
+	openApiJsonGz = gz(openApiJson)
+	openApiJsonGzEtag = etag(openApiJsonGz)
+
+	docsHtml = min("text/html", docsHtml)
+	docsHtmlEtag = etag(docsHtml)
+	docsHtmlGz = gz(docsHtml)
+	docsHtmlGzEtag = etag(docsHtmlGz)
+
+	docsJs = min("text/javascript", docsJs)
+	docsJsEtag = etag(docsJs)
+	docsJsGz = gz(docsJs)
+	docsJsGzEtag = etag(docsJsGz)
+}
+
+func (c *ctrl) OpenApi(w http.ResponseWriter, r *http.Request) {
+	addHeaders(w.Header(), "application/json")
+	addSecurityHeaders(w.Header())
+	gzBroker(w, r, openApiJson, openApiJsonGz, openApiJsonEtag, openApiJsonGzEtag)
+}
+
+func (c *ctrl) Docs(w http.ResponseWriter, r *http.Request) {
+	addHeaders(w.Header(), "text/html; charset=utf-8")
+	addSecurityHeaders(w.Header())
+	gzBroker(w, r, docsHtml, docsHtmlGz, docsHtmlEtag, docsHtmlGzEtag)
+}
+
+func (c *ctrl) DocsJs(w http.ResponseWriter, r *http.Request) {
+	addHeaders(w.Header(), "text/javascript; charset=utf-8")
+	addSecurityHeaders(w.Header())
+	gzBroker(w, r, docsJs, docsJsGz, docsJsEtag, docsJsGzEtag)
+}
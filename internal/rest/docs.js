@@ -0,0 +1,124 @@
+"use strict";
+
+const el = (tag, props, ...children) => {
+  const e = document.createElement(tag);
+  Object.assign(e, props);
+  e.append(...children);
+  return e;
+};
+
+const resolve = (spec, obj) => {
+  if (!obj || !obj.$ref) {
+    return obj;
+  }
+  return obj.$ref
+    .slice(2)
+    .split("/")
+    .reduce((o, key) => o[key], spec);
+};
+
+const schemaName = (schema) => {
+  if (!schema) {
+    return "";
+  } else if (schema.$ref) {
+    return schema.$ref.split("/").pop();
+  } else if (schema.type === "array") {
+    return schemaName(schema.items) + "[]";
+  }
+  return schema.format ? schema.type + " (" + schema.format + ")" : schema.type;
+};
+
+const parameters = (spec, params) => {
+  const rows = params.map((p) => {
+    p = resolve(spec, p);
+    return el(
+      "tr",
+      {},
+      el("td", {}, el("code", {}, p.name)),
+      el("td", {}, p.in),
+      el("td", {}, schemaName(p.schema)),
+      el("td", {}, p.required ? "yes" : ""),
+      el("td", {}, p.description || "")
+    );
+  });
+  const head = ["Name", "In", "Type", "Required", "Description"];
+  return el(
+    "table",
+    {},
+    el("tr", {}, ...head.map((h) => el("th", {}, h))),
+    ...rows
+  );
+};
+
+const content = (c) =>
+  Object.entries(c || {}).map(([type, media]) =>
+    el("div", {}, el("code", {}, type), " ", schemaName(media.schema))
+  );
+
+const operation = (spec, path, method, op, common) => {
+  const details = el(
+    "details",
+    {},
+    el(
+      "summary",
+      {},
+      el("span", { className: "method " + method }, method),
+      el("code", {}, path),
+      " " + (op.summary || "")
+    )
+  );
+  if (op.description) {
+    details.append(el("p", {}, op.description));
+  }
+  const params = (common || []).concat(op.parameters || []);
+  if (params.length > 0) {
+    details.append(el("h4", {}, "Parameters"), parameters(spec, params));
+  }
+  if (op.requestBody) {
+    details.append(el("h4", {}, "Request body"), ...content(op.requestBody.content));
+  }
+  details.append(el("h4", {}, "Responses"));
+  for (const [status, res] of Object.entries(op.responses)) {
+    const r = resolve(spec, res);
+    details.append(
+      el("div", {}, el("strong", {}, status + " "), r.description),
+      ...content(r.content)
+    );
+  }
+  return details;
+};
+
+const render = (spec) => {
+  const docs = document.getElementById("docs");
+  docs.replaceChildren(el("p", {}, spec.info.description));
+  const tags = spec.tags.map((t) => t.name);
+  const sections = new Map(tags.map((t) => [t, el("section", {}, el("h2", {}, t))]));
+  for (const [path, item] of Object.entries(spec.paths)) {
+    for (const [method, op] of Object.entries(item)) {
+      if (method === "parameters") {
+        continue;
+      }
+      sections.get(op.tags[0]).append(operation(spec, path, method, op, item.parameters));
+    }
+  }
+  docs.append(...sections.values());
+  const schemas = el("section", {}, el("h2", {}, "Schemas"));
+  for (const [name, schema] of Object.entries(spec.components.schemas)) {
+    schemas.append(
+      el(
+        "details",
+        {},
+        el("summary", {}, el("code", {}, name)),
+        el("pre", {}, JSON.stringify(schema, null, 2))
+      )
+    );
+  }
+  docs.append(schemas);
+};
+
+fetch("openapi.json")
+  .then((res) => res.json())
+  .then(render)
+  .catch((err) => {
+    document.getElementById("docs").textContent = "Loading the API failed: " + err;
+  });
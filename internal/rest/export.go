@@ -0,0 +1,83 @@
+package rest
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/mail"
+
+	"github.com/rntrp/mailheap/internal/archive"
+	"github.com/rntrp/mailheap/internal/model"
+)
+
+func (c *ctrl) ExportMbox(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w.Header())
+	f, err := parseFilter(r.URL.Query())
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Add("Content-Type", "application/mbox")
+	w.Header().Add("Content-Disposition", "attachment; filename=\"mailheap.mbox\"")
+	mbox := archive.NewMboxWriter(w)
+	err = c.storage.WalkMails(f, func(m model.Mail) error {
+		return mbox.Write(sender(m.From), m.Created, m.Mime)
+	})
+	if err == nil {
+		err = mbox.Flush()
+	}
+	if err != nil {
+		// the status is sent already, so aborting the response is the only
+		// way to tell the client that the export is incomplete
+		slog.Error("Exporting mbox failed", "error", err.Error())
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (c *ctrl) ExportZip(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w.Header())
+	f, err := parseFilter(r.URL.Query())
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Add("Content-Type", "application/zip")
+	w.Header().Add("Content-Disposition", "attachment; filename=\"mailheap.zip\"")
+	z := archive.NewZipWriter(w)
+	err = c.storage.WalkMails(f, func(m model.Mail) error {
+		return z.Write(archive.ManifestEntry{
+			Id:      m.Id,
+			Created: m.Created,
+			Date:    m.Date,
+			Subject: m.Subject,
+			From:    addresses(m.From),
+			To:      addresses(m.To),
+			Size:    m.Size,
+		}, m.Mime)
+	})
+	if err == nil {
+		err = z.Close()
+	}
+	if err != nil {
+		// the status is sent already, so aborting the response is the only
+		// way to tell the client that the export is incomplete
+		slog.Error("Exporting zip failed", "error", err.Error())
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func addresses(s string) []string {
+	a := make([]string, 0)
+	json.Unmarshal([]byte(s), &a)
+	return a
+}
+
+func sender(from string) string {
+	if a := addresses(from); len(a) == 0 {
+		return ""
+	} else if addr, err := mail.ParseAddress(a[0]); err != nil {
+		return ""
+	} else {
+		return addr.Address
+	}
+}
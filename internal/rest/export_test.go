@@ -0,0 +1,122 @@
+package rest
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/rntrp/mailheap/internal/archive"
+	"github.com/rntrp/mailheap/internal/model"
+	"github.com/rntrp/mailheap/internal/storage"
+)
+
+func readZip(t *testing.T, b []byte) ([]string, []archive.ManifestEntry) {
+	t.Helper()
+	r, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	manifest := make([]archive.ManifestEntry, 0)
+	for _, f := range r.File {
+		names = append(names, f.Name)
+		if f.Name != archive.ManifestName {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		} else if err := json.Unmarshal(b, &manifest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return names, manifest
+}
+
+func TestExportZip(t *testing.T) {
+	c := newTestCtrl(t)
+	ids := make([]int64, 0)
+	for _, subject := range []string{"keep", "skip", "keep"} {
+		id, err := c.storeMail.StoreMail(testMail(subject))
+		if err != nil {
+			t.Fatal(err)
+		} else if subject == "keep" {
+			ids = append(ids, id)
+		}
+	}
+	w := httptest.NewRecorder()
+	c.ExportZip(w, httptest.NewRequest(http.MethodGet, "/api/v1/mails/export.zip?subject=keep", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("unexpected response %v %v", w.Code, w.Header())
+	}
+	names, manifest := readZip(t, w.Body.Bytes())
+	want := []string{strconv.FormatInt(ids[0], 10) + ".eml", strconv.FormatInt(ids[1], 10) + ".eml",
+		archive.ManifestName}
+	if len(names) != len(want) || names[0] != want[0] || names[1] != want[1] || names[2] != want[2] {
+		t.Errorf("unexpected entries %v, want %v", names, want)
+	}
+	if len(manifest) != 2 {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+	for i, e := range manifest {
+		if e.Id != ids[i] || e.Subject != "keep" || len(e.From) != 1 || e.From[0] != "alice@example.com" {
+			t.Errorf("unexpected entry %+v", e)
+		}
+	}
+}
+
+func TestExportEmpty(t *testing.T) {
+	c := newTestCtrl(t)
+	if _, err := c.storeMail.StoreMail(testMail("other")); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	c.ExportMbox(w, httptest.NewRequest(http.MethodGet, "/api/v1/mails/export.mbox?subject=missing", nil))
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Errorf("unexpected mbox %v %q", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	c.ExportZip(w, httptest.NewRequest(http.MethodGet, "/api/v1/mails/export.zip?subject=missing", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %v", w.Code)
+	} else if names, manifest := readZip(t, w.Body.Bytes()); len(names) != 1 || len(manifest) != 0 {
+		t.Errorf("unexpected zip %v %+v", names, manifest)
+	}
+}
+
+// brokenStorage fails after walking the first mail.
+type brokenStorage struct {
+	storage.MailStorage
+}
+
+func (s brokenStorage) WalkMails(f storage.Filter, fn func(model.Mail) error) error {
+	if err := fn(model.Mail{Id: 1, Mime: "Subject: a\r\n\r\nA\r\n"}); err != nil {
+		return err
+	}
+	return errors.New("database gone")
+}
+
+func TestExportAborted(t *testing.T) {
+	c := &ctrl{storage: brokenStorage{}}
+	for name, export := range map[string]http.HandlerFunc{"mbox": c.ExportMbox, "zip": c.ExportZip} {
+		func() {
+			defer func() {
+				if r := recover(); r != http.ErrAbortHandler {
+					t.Errorf("%v: export not aborted: %v", name, r)
+				}
+			}()
+			export(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/mails/export."+name, nil))
+		}()
+	}
+}
@@ -0,0 +1,111 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/rntrp/mailheap/internal/msg"
+	"github.com/rntrp/mailheap/internal/storage"
+)
+
+type ExtractResult struct {
+	Id      int64          `json:"id"`
+	Matches []ExtractMatch `json:"matches"`
+}
+
+type ExtractMatch struct {
+	Part     string            `json:"part"`
+	Match    string            `json:"match"`
+	Captures []string          `json:"captures"`
+	Groups   map[string]string `json:"groups"`
+}
+
+const (
+	partText = "text"
+	partHtml = "html"
+)
+
+func (c *ctrl) ExtractMail(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w.Header())
+	query := r.URL.Query()
+	pattern := query.Get("pattern")
+	if len(pattern) == 0 {
+		httpError(w, r, "query parameter 'pattern' is missing", http.StatusBadRequest)
+		return
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		httpError(w, r, "invalid pattern: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	part := query.Get("part")
+	if len(part) > 0 && part != partText && part != partHtml {
+		httpError(w, r, "query parameter 'part' must be 'text' or 'html'", http.StatusBadRequest)
+		return
+	}
+	all, _ := strconv.ParseBool(query.Get("all"))
+	mail, err := c.storage.FindLatestMail(storage.Filter{To: query.Get("to")})
+	if errors.Is(err, storage.ErrNotFound) {
+		httpError(w, r, "no matching mail found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		slog.Error("Finding latest mail failed", "error", err.Error())
+		httpError(w, r, http.StatusText(http.StatusInternalServerError),
+			http.StatusInternalServerError)
+		return
+	}
+	content, err := msg.Decode([]byte(mail.Mime))
+	if err != nil {
+		slog.Error("Decoding mail failed", "id", mail.Id, "error", err.Error())
+		httpError(w, r, "mail could not be decoded", http.StatusUnprocessableEntity)
+		return
+	}
+	res := ExtractResult{Id: mail.Id, Matches: make([]ExtractMatch, 0)}
+	for _, p := range []struct{ name, body string }{
+		{partText, content.Text},
+		{partHtml, content.Html},
+	} {
+		if len(part) > 0 && part != p.name {
+			continue
+		}
+		n := 1
+		if all {
+			n = -1
+		}
+		for _, m := range re.FindAllStringSubmatch(p.body, n) {
+			res.Matches = append(res.Matches, newExtractMatch(re, p.name, m))
+		}
+		if !all && len(res.Matches) > 0 {
+			break
+		}
+	}
+	b, err := json.Marshal(res)
+	if err != nil {
+		slog.Error("Marshalling extract result failed", "error", err.Error())
+		httpError(w, r, http.StatusText(http.StatusInternalServerError),
+			http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Add("Content-Length", strconv.Itoa(len(b)))
+	w.Write(b)
+}
+
+func newExtractMatch(re *regexp.Regexp, part string, m []string) ExtractMatch {
+	em := ExtractMatch{
+		Part:     part,
+		Match:    m[0],
+		Captures: m[1:],
+		Groups:   make(map[string]string),
+	}
+	for i, name := range re.SubexpNames() {
+		if i > 0 && len(name) > 0 {
+			em.Groups[name] = m[i]
+		}
+	}
+	return em
+}
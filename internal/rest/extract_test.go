@@ -0,0 +1,86 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func extract(c *ctrl, query url.Values) (*httptest.ResponseRecorder, ExtractResult) {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/mails/extract?"+query.Encode(), nil)
+	w := httptest.NewRecorder()
+	c.ExtractMail(w, r)
+	res := ExtractResult{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+	return w, res
+}
+
+func TestExtractMail(t *testing.T) {
+	c := newTestCtrl(t)
+	store := func(to, cc, body string) int64 {
+		t.Helper()
+		id, err := c.storeMail.StoreMail(strings.NewReader("From: alice@example.com\r\n" +
+			"To: " + to + "\r\nCc: " + cc + "\r\n" +
+			"Date: Mon, 19 Oct 2026 11:39:27 -0000\r\n" +
+			"Subject: Code\r\n" +
+			"MIME-Version: 1.0\r\n" +
+			"Content-Type: multipart/alternative; boundary=b\r\n\r\n" +
+			"--b\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n" + body + "\r\n" +
+			"--b\r\nContent-Type: text/html; charset=utf-8\r\n\r\n<p>" + body + " 999999</p>\r\n" +
+			"--b--\r\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	bob := store("Bob <bob@example.com>", "carol@example.com", "Code 123456, backup 654321")
+	store("jimbob@example.com", "bob@example.com.evil", "Code 111111")
+	carol := store("dave@example.com", "Carol <carol@example.com>", "Code 222222")
+
+	q := url.Values{"to": {"BOB@example.com"}, "pattern": {`(?P<code>\d{6})`}}
+	w, res := extract(c, q)
+	if w.Code != http.StatusOK || res.Id != bob || len(res.Matches) != 1 {
+		t.Fatalf("unexpected result %v %+v", w.Code, res)
+	} else if m := res.Matches[0]; m.Part != partText || m.Match != "123456" || m.Groups["code"] != "123456" ||
+		len(m.Captures) != 1 || m.Captures[0] != "123456" {
+		t.Errorf("unexpected match %+v", m)
+	}
+	q.Set("all", "true")
+	if _, res := extract(c, q); len(res.Matches) != 5 || res.Matches[2].Part != partHtml ||
+		res.Matches[4].Match != "999999" {
+		t.Errorf("unexpected matches %+v", res.Matches)
+	}
+	q.Set("all", "false")
+	q.Set("part", partHtml)
+	if _, res := extract(c, q); len(res.Matches) != 1 || res.Matches[0].Part != partHtml {
+		t.Errorf("unexpected matches %+v", res.Matches)
+	}
+	// recipients in Cc count as well, the newest mail wins
+	if _, res := extract(c, url.Values{"to": {"carol@example.com"}, "pattern": {`\d+`}}); res.Id != carol {
+		t.Errorf("unexpected mail %v", res.Id)
+	}
+	if _, res := extract(c, url.Values{"pattern": {`x*`}}); res.Id != carol || len(res.Matches) != 1 {
+		t.Errorf("unexpected result %+v", res)
+	}
+	if _, res := extract(c, url.Values{"to": {"bob@example.com"}, "pattern": {"nothing"}}); res.Id != bob ||
+		len(res.Matches) != 0 {
+		t.Errorf("unexpected result %+v", res)
+	}
+	for _, tc := range []struct {
+		query url.Values
+		code  int
+	}{
+		{url.Values{"to": {"bob"}, "pattern": {`\d+`}}, http.StatusNotFound},
+		{url.Values{"to": {"example.com"}, "pattern": {`\d+`}}, http.StatusNotFound},
+		{url.Values{"to": {"bob@example.com"}}, http.StatusBadRequest},
+		{url.Values{"to": {"bob@example.com"}, "pattern": {`(`}}, http.StatusBadRequest},
+		{url.Values{"to": {"bob@example.com"}, "pattern": {`\d+`}, "part": {"pdf"}}, http.StatusBadRequest},
+	} {
+		if w, _ := extract(c, tc.query); w.Code != tc.code {
+			t.Errorf("%v: got %v, want %v", tc.query.Encode(), w.Code, tc.code)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/google/uuid"
+	"github.com/rntrp/mailheap/internal/model"
+	"github.com/rntrp/mailheap/internal/storage"
+)
+
+const (
+	feedProtocol      = "mailheap"
+	feedQueueSize     = 64
+	feedReadLimit     = 64 << 10
+	feedWriteTimeout  = 10 * time.Second
+	feedPingInterval  = 30 * time.Second
+	feedEventReceived = "mail.received"
+	feedEventDeleted  = "mail.deleted"
+	feedEventUpdated  = "mail.updated"
+	// feedStatusResync closes feeds which missed storage events; the client
+	// should reload the mails and reconnect.
+	feedStatusResync websocket.StatusCode = 4000
+)
+
+// FeedCommand is sent by the client. Filter takes the same keys as the query
+// of the REST API, e.g. {"to": "alice@example.com", "seen": "false"}.
+type FeedCommand struct {
+	Type   string            `json:"type"`
+	Ref    string            `json:"ref,omitempty"`
+	Filter map[string]string `json:"filter,omitempty"`
+	Ids    []int64           `json:"ids,omitempty"`
+	Seen   *bool             `json:"seen,omitempty"`
+}
+
+type FeedMessage struct {
+	Type       string      `json:"type"`
+	Ref        string      `json:"ref,omitempty"`
+	Mail       *model.Mail `json:"mail,omitempty"`
+	Ids        []int64     `json:"ids,omitempty"`
+	NumUpdated int64       `json:"numUpdated,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// Feed streams storage events over a WebSocket and accepts commands. Like
+// the REST API, commands modifying mails require a CSRF token in the query,
+// repeated in the X-Csrf-Token header. Browsers cannot set headers on
+// WebSocket handshakes, so their feeds are read-only. Handshakes from other
+// origins are rejected by websocket.Accept. Clients which cannot keep up
+// with the events are disconnected, with feedStatusResync if the storage
+// dropped their subscription.
+func (c *ctrl) Feed(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w.Header())
+	writable := validCsrfToken(r)
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+		Subprotocols: []string{feedProtocol},
+	})
+	if err != nil {
+		slog.Warn("WebSocket handshake failed", "error", err.Error())
+		return
+	}
+	conn.SetReadLimit(feedReadLimit)
+	ctx, cancel := context.WithCancel(r.Context())
+	f := &feed{
+		ctrl:     c,
+		conn:     conn,
+		uuid:     uuid.New(),
+		writable: writable,
+		queue:    make(chan FeedMessage, feedQueueSize),
+		cancel:   cancel,
+	}
+	slog.Info("WebSocket feed connected", "uuid", f.uuid.String(), "writable", writable)
+	events, unsubscribe := c.storage.Subscribe()
+	defer unsubscribe()
+	go f.writeLoop(ctx)
+	go f.readLoop(ctx)
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				f.close(websocket.StatusGoingAway, "server shutting down")
+				return
+			} else if e.Type == storage.EventOverflow {
+				slog.Warn("Closing WebSocket feed which missed events", "uuid", f.uuid.String())
+				f.close(feedStatusResync, "resync")
+				return
+			}
+			f.publish(e)
+		case <-ctx.Done():
+			f.close(websocket.StatusNormalClosure, "")
+			return
+		}
+	}
+}
+
+type feed struct {
+	ctrl     *ctrl
+	conn     *websocket.Conn
+	uuid     uuid.UUID
+	writable bool
+	queue    chan FeedMessage
+	cancel   context.CancelFunc
+	mtx      sync.Mutex
+	filter   storage.Filter
+	once     sync.Once
+}
+
+func (f *feed) close(code websocket.StatusCode, reason string) {
+	f.once.Do(func() {
+		f.cancel()
+		f.conn.Close(code, reason)
+		slog.Info("WebSocket feed closed", "uuid", f.uuid.String(), "code", code.String())
+	})
+}
+
+// send enqueues the message without blocking; a full queue means the client
+// is too slow and gets disconnected.
+func (f *feed) send(m FeedMessage) {
+	select {
+	case f.queue <- m:
+	default:
+		slog.Warn("Closing WebSocket feed of slow client", "uuid", f.uuid.String())
+		go f.close(websocket.StatusPolicyViolation, "client too slow")
+	}
+}
+
+func (f *feed) writeLoop(ctx context.Context) {
+	ping := time.NewTicker(feedPingInterval)
+	defer ping.Stop()
+	for {
+		var err error
+		select {
+		case m := <-f.queue:
+			var b []byte
+			if b, err = json.Marshal(m); err == nil {
+				wctx, cancel := context.WithTimeout(ctx, feedWriteTimeout)
+				err = f.conn.Write(wctx, websocket.MessageText, b)
+				cancel()
+			}
+		case <-ping.C:
+			pctx, cancel := context.WithTimeout(ctx, feedWriteTimeout)
+			err = f.conn.Ping(pctx)
+			cancel()
+		case <-ctx.Done():
+			return
+		}
+		if err != nil {
+			f.close(websocket.StatusInternalError, "write failed")
+			return
+		}
+	}
+}
+
+func (f *feed) readLoop(ctx context.Context) {
+	for {
+		typ, b, err := f.conn.Read(ctx)
+		if err != nil {
+			if websocket.CloseStatus(err) == -1 && !errors.Is(err, context.Canceled) {
+				slog.Warn("Reading WebSocket message failed", "uuid", f.uuid.String(), "error", err.Error())
+			}
+			f.close(websocket.StatusNormalClosure, "")
+			return
+		} else if typ != websocket.MessageText {
+			f.close(websocket.StatusUnsupportedData, "text messages only")
+			return
+		}
+		cmd := FeedCommand{}
+		if err := json.Unmarshal(b, &cmd); err != nil {
+			f.send(FeedMessage{Type: "error", Error: "invalid command: " + err.Error()})
+			continue
+		}
+		f.handle(cmd)
+	}
+}
+
+func (f *feed) handle(cmd FeedCommand) {
+	res := FeedMessage{Type: "result", Ref: cmd.Ref}
+	var err error
+	switch cmd.Type {
+	case "subscribe":
+		err = f.subscribe(cmd.Filter)
+	case "delete", "markSeen":
+		if !f.writable {
+			err = errors.New("invalid CSRF token")
+		} else if len(cmd.Ids) == 0 {
+			err = errors.New("no ids given")
+		} else if cmd.Type == "delete" {
+			res.NumUpdated, err = f.ctrl.storage.DeleteMails(cmd.Ids...)
+		} else {
+			seen := cmd.Seen == nil || *cmd.Seen
+			res.NumUpdated, err = f.ctrl.storage.UpdateState(storage.Filter{Ids: cmd.Ids},
+				storage.StateUpdate{Seen: &seen})
+		}
+	default:
+		err = errors.New("unknown command type: " + cmd.Type)
+	}
+	if err != nil {
+		res.Type, res.Error = "error", err.Error()
+	}
+	f.send(res)
+}
+
+func (f *feed) subscribe(filter map[string]string) error {
+	query := url.Values{}
+	for k, v := range filter {
+		query.Set(k, v)
+	}
+	parsed, err := parseFilter(query)
+	if err != nil {
+		return err
+	}
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.filter = parsed
+	return nil
+}
+
+// publish sends received mails which match the filter of the subscription.
+// Deletions and updates are only filtered by the ids of the filter, since
+// the mails may no longer match the other criteria after the change.
+func (f *feed) publish(e storage.Event) {
+	f.mtx.Lock()
+	filter := f.filter
+	f.mtx.Unlock()
+	ids := e.Ids
+	if len(filter.Ids) > 0 && ids != nil {
+		ids = slices.DeleteFunc(slices.Clone(ids), func(id int64) bool {
+			return !slices.Contains(filter.Ids, id)
+		})
+		if len(ids) == 0 {
+			return
+		}
+	}
+	switch e.Type {
+	case storage.EventDeleted:
+		f.send(FeedMessage{Type: feedEventDeleted, Ids: ids})
+	case storage.EventUpdated:
+		f.send(FeedMessage{Type: feedEventUpdated, Ids: ids})
+	case storage.EventAdded:
+		filter.Ids = ids
+		err := f.ctrl.storage.WalkMails(filter, func(m model.Mail) error {
+			m.Mime = ""
+			f.send(FeedMessage{Type: feedEventReceived, Mail: &m})
+			return nil
+		})
+		if err != nil {
+			slog.Error("Loading mails for WebSocket feed failed", "error", err.Error())
+		}
+	}
+}
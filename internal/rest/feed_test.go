@@ -0,0 +1,247 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/rntrp/mailheap/internal/storage"
+)
+
+func testMail(subject string) *strings.Reader {
+	return strings.NewReader("From: alice@example.com\r\n" +
+		"Date: Mon, 19 Oct 2026 11:39:27 -0000\r\n" +
+		"Subject: " + subject + "\r\n\r\nHello\r\n")
+}
+
+type feedClient struct {
+	t    *testing.T
+	ctx  context.Context
+	conn *websocket.Conn
+}
+
+func dialFeed(t *testing.T, c *ctrl, csrf bool) *feedClient {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(c.Feed))
+	t.Cleanup(srv.Close)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	u := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/v1/ws"
+	opts := &websocket.DialOptions{Subprotocols: []string{feedProtocol}}
+	if csrf {
+		u += "?csrf-token=t0k3n"
+		opts.HTTPHeader = http.Header{"X-Csrf-Token": {"t0k3n"}}
+	}
+	conn, _, err := websocket.Dial(ctx, u, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.CloseNow() })
+	if conn.Subprotocol() != feedProtocol {
+		t.Errorf("unexpected subprotocol %q", conn.Subprotocol())
+	}
+	return &feedClient{t: t, ctx: ctx, conn: conn}
+}
+
+func (f *feedClient) send(cmd FeedCommand) {
+	f.t.Helper()
+	b, _ := json.Marshal(cmd)
+	if err := f.conn.Write(f.ctx, websocket.MessageText, b); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func (f *feedClient) read() FeedMessage {
+	f.t.Helper()
+	_, b, err := f.conn.Read(f.ctx)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	m := FeedMessage{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		f.t.Fatal(err)
+	}
+	return m
+}
+
+// readTypes reads n messages, whose order is not defined, by type.
+func (f *feedClient) readTypes(n int) map[string]FeedMessage {
+	f.t.Helper()
+	res := make(map[string]FeedMessage)
+	for range n {
+		m := f.read()
+		res[m.Type] = m
+	}
+	return res
+}
+
+func TestFeedHandshake(t *testing.T) {
+	c := newTestCtrl(t)
+	srv := httptest.NewServer(http.HandlerFunc(c.Feed))
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, res, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http"), &websocket.DialOptions{
+		Subprotocols: []string{feedProtocol},
+		HTTPHeader:   http.Header{"Origin": {"https://evil.example"}},
+	})
+	if err == nil || res == nil || res.StatusCode != http.StatusForbidden {
+		t.Errorf("cross-origin handshake not rejected: %v", err)
+	}
+	id, err := c.storeMail.StoreMail(testMail("Hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ro := dialFeed(t, c, false)
+	ro.send(FeedCommand{Type: "delete", Ref: "1", Ids: []int64{id}})
+	if m := ro.read(); m.Type != "error" || m.Ref != "1" || m.Error != "invalid CSRF token" {
+		t.Errorf("unexpected message %+v", m)
+	}
+	// any page may choose the subprotocols, so they do not carry the token
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+"?csrf-token=t", &websocket.DialOptions{
+		Subprotocols: []string{feedProtocol, "csrf-t"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.CloseNow()
+	sp := &feedClient{t: t, ctx: ctx, conn: conn}
+	sp.send(FeedCommand{Type: "markSeen", Ref: "1", Ids: []int64{id}})
+	if m := sp.read(); m.Type != "error" || m.Error != "invalid CSRF token" {
+		t.Errorf("unexpected message %+v", m)
+	}
+	ro.send(FeedCommand{Type: "unknown"})
+	if m := ro.read(); m.Type != "error" || !strings.HasPrefix(m.Error, "unknown command type") {
+		t.Errorf("unexpected message %+v", m)
+	}
+	rw := dialFeed(t, c, true)
+	rw.send(FeedCommand{Type: "markSeen", Ref: "2"})
+	if m := rw.read(); m.Type != "error" || m.Error != "no ids given" {
+		t.Errorf("unexpected message %+v", m)
+	}
+	rw.send(FeedCommand{Type: "markSeen", Ref: "3", Ids: []int64{id}})
+	if m := ro.read(); m.Type != feedEventUpdated || fmt.Sprint(m.Ids) != fmt.Sprint([]int64{id}) {
+		t.Errorf("unexpected message %+v", m)
+	}
+	msgs := rw.readTypes(2)
+	if m := msgs["result"]; m.Ref != "3" || m.NumUpdated != 1 {
+		t.Errorf("unexpected result %+v", m)
+	} else if m := msgs[feedEventUpdated]; fmt.Sprint(m.Ids) != fmt.Sprint([]int64{id}) {
+		t.Errorf("unexpected update %+v", m)
+	}
+	rw.send(FeedCommand{Type: "delete", Ref: "4", Ids: []int64{id}})
+	msgs = rw.readTypes(2)
+	if m := msgs["result"]; m.Ref != "4" || m.NumUpdated != 1 {
+		t.Errorf("unexpected result %+v", m)
+	} else if m := msgs[feedEventDeleted]; fmt.Sprint(m.Ids) != fmt.Sprint([]int64{id}) {
+		t.Errorf("unexpected deletion %+v", m)
+	}
+}
+
+func TestFeedFilter(t *testing.T) {
+	c := newTestCtrl(t)
+	other, err := c.storeMail.StoreMail(testMail("Other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := dialFeed(t, c, false)
+	f.send(FeedCommand{Type: "subscribe", Ref: "1", Filter: map[string]string{"seen": "maybe"}})
+	if m := f.read(); m.Type != "error" || m.Ref != "1" {
+		t.Errorf("unexpected message %+v", m)
+	}
+	f.send(FeedCommand{Type: "subscribe", Ref: "2", Filter: map[string]string{"subject": "hello"}})
+	if m := f.read(); m.Type != "result" || m.Ref != "2" {
+		t.Errorf("unexpected message %+v", m)
+	}
+	ids := make([]int64, 0)
+	for _, s := range []string{"Other", "Hello"} {
+		id, err := c.storeMail.StoreMail(testMail(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if m := f.read(); m.Type != feedEventReceived || m.Mail == nil || m.Mail.Subject != "Hello" {
+		t.Errorf("unexpected message %+v", m)
+	}
+	f.send(FeedCommand{Type: "subscribe", Ref: "3", Filter: map[string]string{"id": fmt.Sprint(other)}})
+	if m := f.read(); m.Type != "result" {
+		t.Errorf("unexpected message %+v", m)
+	}
+	// deletions and updates are filtered by id only
+	seen := true
+	if _, err := c.storage.UpdateState(storage.Filter{}, storage.StateUpdate{Seen: &seen}); err != nil {
+		t.Fatal(err)
+	} else if m := f.read(); m.Type != feedEventUpdated || fmt.Sprint(m.Ids) != fmt.Sprint([]int64{other}) {
+		t.Errorf("unexpected message %+v", m)
+	}
+	if _, err := c.storage.DeleteMails(ids...); err != nil {
+		t.Fatal(err)
+	} else if _, err := c.storage.DeleteMails(other); err != nil {
+		t.Fatal(err)
+	}
+	if m := f.read(); m.Type != feedEventDeleted || fmt.Sprint(m.Ids) != fmt.Sprint([]int64{other}) {
+		t.Errorf("unexpected message %+v", m)
+	}
+	if _, err := c.storeMail.StoreMail(testMail("Hello")); err != nil {
+		t.Fatal(err)
+	} else if _, err := c.storage.DeleteAllMails(); err != nil {
+		t.Fatal(err)
+	} else if m := f.read(); m.Type != feedEventDeleted || m.Ids != nil {
+		t.Errorf("unexpected message %+v", m)
+	}
+}
+
+func TestFeedSlowClient(t *testing.T) {
+	conns := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conns <- conn
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.CloseNow()
+	_, fcancel := context.WithCancel(ctx)
+	f := &feed{conn: <-conns, queue: make(chan FeedMessage, 1), cancel: fcancel}
+	f.send(FeedMessage{Type: "first"})
+	f.send(FeedMessage{Type: "second"})
+	if _, _, err := client.Read(ctx); websocket.CloseStatus(err) != websocket.StatusPolicyViolation {
+		t.Errorf("slow client not disconnected: %v", err)
+	}
+}
+
+// overflowStorage drops every subscription right away.
+type overflowStorage struct {
+	storage.MailStorage
+}
+
+func (overflowStorage) Subscribe() (<-chan storage.Event, func()) {
+	ch := make(chan storage.Event, 1)
+	ch <- storage.Event{Type: storage.EventOverflow}
+	close(ch)
+	return ch, func() {}
+}
+
+func TestFeedResync(t *testing.T) {
+	c := newTestCtrl(t)
+	f := dialFeed(t, &ctrl{storage: overflowStorage{c.storage}}, false)
+	if _, _, err := f.conn.Read(f.ctx); websocket.CloseStatus(err) != feedStatusResync {
+		t.Errorf("feed not closed for resync: %v", err)
+	}
+}
@@ -92,6 +92,8 @@ func InitIndex() {
 	jsmimeparserEtag = etag(jsmimeparser)
 	jsmimeparserGz = gz(jsmimeparser)
 	jsmimeparserGzEtag = etag(jsmimeparserGz)
+
+	initDocs()
 }
 
 func (c *ctrl) Index(w http.ResponseWriter, r *http.Request) {
@@ -2,20 +2,26 @@
 (() => {
   const LIMIT = 10;
   const FILES = [];
+  const MAILS = new Map();
+  const REMOTE_KEY = "mailheap.remote";
+  const REMOTE = loadRemote();
+  var filter = "";
   var lastId = 0;
   var currentId = 0;
   var currentEml = null;
   async function previewMail(id) {
     resetViews();
-    const response = await fetch("/mail/" + id);
+    const response = await fetch("/api/v1/mail/" + id);
     const eml = await response.text();
     currentId = id;
     currentEml = eml;
     const parsed = jsmimeparser.parseMail(eml);
-    const inlined = inlineImages(parsed.body.html, parsed.attachments);
     const previewHtml = document.getElementById("preview-html");
     previewHtml.title = parsed.subject;
-    previewHtml.srcdoc = inlined;
+    previewHtml.src = htmlUrl(id);
+    updateRemoteToggle();
+    updateStateToggles();
+    showTrackers(MAILS.get(id)?.trackers);
     const previewPlain = document.getElementById("preview-plain");
     previewPlain.textContent = parsed.body.text;
     const headerDelim = eml.search(/(\r?\n){2}/g);
@@ -34,12 +40,157 @@
     } else {
       previewHeaders.classList.remove("hidden");
     }
+    if (MAILS.get(id) && !MAILS.get(id).seen) {
+      await patchMail(id, { seen: true });
+    }
+  }
+  function htmlUrl(id) {
+    const remote = REMOTE.has(id) ? "?remote=true" : "";
+    return "/api/v1/mail/" + id + "/html" + remote;
+  }
+  function toggleRemote() {
+    if (!currentId) {
+      return;
+    } else if (REMOTE.has(currentId)) {
+      REMOTE.delete(currentId);
+    } else {
+      REMOTE.add(currentId);
+    }
+    saveRemote();
+    document.getElementById("preview-html").src = htmlUrl(currentId);
+    updateRemoteToggle();
+  }
+  // the mails with remote content allowed survive reloads of the page
+  function loadRemote() {
+    try {
+      return new Set(JSON.parse(localStorage.getItem(REMOTE_KEY) || "[]"));
+    } catch (e) {
+      return new Set();
+    }
+  }
+  function saveRemote() {
+    try {
+      localStorage.setItem(REMOTE_KEY, JSON.stringify([...REMOTE]));
+    } catch (e) {
+      // storage disabled or full, the toggle applies to this page only
+    }
+  }
+  function updateRemoteToggle() {
+    const toggle = document.getElementById("toggle-remote");
+    const active = REMOTE.has(currentId);
+    toggle.classList.toggle("active", active);
+    toggle.textContent = active ? "Block remote content" : "Load remote content";
+  }
+  function updateStateToggles() {
+    const mail = MAILS.get(currentId);
+    document.getElementById("toggle-seen").textContent =
+      mail && !mail.seen ? "Mark read" : "Mark unread";
+    document.getElementById("toggle-starred").textContent = mail?.starred
+      ? "Unstar"
+      : "Star";
+  }
+  async function patchMail(id, state) {
+    const csrfToken = crypto.randomUUID();
+    const url = "/api/v1/mail/" + id + "?csrf-token=" + csrfToken;
+    const response = await fetch(url, {
+      method: "PATCH",
+      headers: new Headers({
+        "Content-Type": "application/json",
+        "X-Csrf-Token": csrfToken,
+      }),
+      body: JSON.stringify(state),
+    });
+    if (!response.ok) {
+      return;
+    }
+    const mail = MAILS.get(id);
+    if (mail) {
+      Object.assign(mail, state);
+      if (state.tags) {
+        mail.tags = JSON.stringify(state.tags);
+      }
+      updateEmailState(id);
+    }
+    if (id === currentId) {
+      updateStateToggles();
+    }
+  }
+  async function toggleSeen() {
+    const mail = MAILS.get(currentId);
+    if (mail) {
+      await patchMail(currentId, { seen: !mail.seen });
+    }
+  }
+  async function toggleStarred() {
+    const mail = MAILS.get(currentId);
+    if (mail) {
+      await patchMail(currentId, { starred: !mail.starred });
+    }
+  }
+  async function editTags() {
+    const mail = MAILS.get(currentId);
+    if (!mail) {
+      return;
+    }
+    const input = prompt(
+      "Tags (comma separated)",
+      JSON.parse(mail.tags || "[]").join(", ")
+    );
+    if (input !== null) {
+      const tags = input
+        .split(",")
+        .map((t) => t.trim())
+        .filter((t) => t.length > 0);
+      await patchMail(currentId, { tags: tags });
+    }
+  }
+  async function markAllRead(event) {
+    if (!event.isTrusted) {
+      throw "Mark all read event is not trusted";
+    }
+    const csrfToken = crypto.randomUUID();
+    await fetch("/api/v1/mails?seen=false&csrf-token=" + csrfToken, {
+      method: "PATCH",
+      headers: new Headers({
+        "Content-Type": "application/json",
+        "X-Csrf-Token": csrfToken,
+      }),
+      body: JSON.stringify({ seen: true }),
+    });
+    for (const [id, mail] of MAILS) {
+      mail.seen = true;
+      updateEmailState(id);
+    }
+    updateStateToggles();
+  }
+  async function filterMails(query) {
+    filter = query;
+    lastId = 0;
+    MAILS.clear();
+    const list = document.getElementById("mails");
+    list.replaceChildren();
+    list.scrollTop = 0;
+    list.onscrollend = infiniteScroll;
+    await loadMails();
+    document.querySelector("#mails > article:first-child")?.focus();
+  }
+  function showTrackers(trackers) {
+    const p = document.getElementById("preview-trackers");
+    const list = trackers ? JSON.parse(trackers) : [];
+    p.textContent = null;
+    p.removeAttribute("title");
+    p.classList.toggle("hidden", list.length === 0);
+    if (list.length > 0) {
+      p.textContent =
+        list.length + (list.length === 1 ? " tracker" : " trackers") + " detected";
+      p.title = list.map((t) => t.reason + ": " + t.url).join("\n");
+    }
   }
   function resetViews() {
     const previewHtml = document.getElementById("preview-html");
     previewHtml.classList.add("hidden");
     previewHtml.removeAttribute("title");
-    previewHtml.removeAttribute("srcdoc");
+    previewHtml.src = "about:blank";
     const previewPlain = document.getElementById("preview-plain");
     previewPlain.classList.add("hidden");
     previewPlain.textContent = null;
@@ -83,11 +234,15 @@
     }
   }
   async function loadMails() {
-    const response = await fetch("/mails/" + lastId + "?limit=" + LIMIT);
+    const response = await fetch(
+      "/api/v1/mails/" + lastId + "?limit=" + LIMIT + filter
+    );
     const result = await response.json();
     for (const mail of result.data) {
       lastId = mail.id;
+      MAILS.set(mail.id, mail);
       addEmailToList(mail.id, mail.from, mail.to, mail.subject, mail.created);
+      updateEmailState(mail.id);
     }
     document.getElementById("mail-count").textContent = `(${result.total})`;
     return result;
@@ -123,12 +278,31 @@
     const emailInbound = document.createElement("time");
     emailInbound.className = "mail-inbound";
     emailInbound.textContent = inbound ? new Date(inbound).toUTCString() : null;
+    const emailTags = document.createElement("ul");
+    emailTags.className = "mail-tags";
     email.appendChild(emailFrom);
     email.appendChild(emailTo);
     email.appendChild(emailSubject);
+    email.appendChild(emailTags);
     email.appendChild(emailInbound);
     document.getElementById("mails").appendChild(email);
   }
+  function updateEmailState(id) {
+    const mail = MAILS.get(id);
+    const email = document.getElementById(id);
+    if (!mail || !email) {
+      return;
+    }
+    email.classList.toggle("unseen", !mail.seen);
+    email.classList.toggle("starred", mail.starred);
+    const emailTags = email.querySelector(".mail-tags");
+    emailTags.replaceChildren();
+    for (const tag of JSON.parse(mail.tags || "[]")) {
+      const li = document.createElement("li");
+      li.textContent = tag;
+      emailTags.appendChild(li);
+    }
+  }
   function fileAttachments(attachments) {
     while (FILES.length > 0) {
       URL.revokeObjectURL(FILES.pop().blob);
@@ -177,43 +351,16 @@
       footer.classList.remove("hidden");
     }
   }
-  function inlineImages(htmlString, attachments) {
-    const inlineMap = new Map();
-    for (const att of attachments) {
-      if (
-        att.contentId &&
-        att.contentDisposition === "inline" &&
-        ["image/gif", "image/jpeg", "image/png"].includes(att.contentType)
-      ) {
-        inlineMap.set(att.contentId, att);
-      }
-    }
-    const html = document.createElement("html");
-    html.innerHTML = htmlString;
-    for (const img of html.getElementsByTagName("img")) {
-      if (
-        img.src &&
-        img.src.length > 4 &&
-        img.src.substring(0, 4).toLowerCase() === "cid:"
-      ) {
-        const cid = "<" + img.src.substring(4) + ">";
-        const att = inlineMap.get(cid);
-        if (att && att.content) {
-          const b64 = btoa(String.fromCharCode.apply(null, att.content));
-          img.setAttribute("src", "data:" + att.contentType + ";base64," + b64);
-        }
-      }
-    }
-    return html.outerHTML;
-  }
   async function uploadMail(event) {
     if (!event.isTrusted) {
       throw "Upload event is not trusted";
     }
     const formData = new FormData();
-    formData.append("eml", event.target.files[0]);
+    for (const file of event.target.files) {
+      formData.append("eml", file);
+    }
     const csrfToken = crypto.randomUUID();
-    await fetch("/upload?csrf-token=" + csrfToken, {
+    await fetch("/api/v1/upload?csrf-token=" + csrfToken, {
       method: "POST",
       headers: new Headers({ "X-Csrf-Token": csrfToken }),
       body: formData,
@@ -225,15 +372,17 @@
       throw "Delete event is not trusted";
     } else if (confirm("Delete all mails?")) {
       const csrfToken = crypto.randomUUID();
-      await fetch("/mails?csrf-token=" + csrfToken, {
+      await fetch("/api/v1/mails?csrf-token=" + csrfToken, {
         method: "DELETE",
         headers: new Headers({ "X-Csrf-Token": csrfToken }),
       });
+      REMOTE.clear();
+      saveRemote();
       window.location.reload();
       const previewHtml = document.getElementById("preview-html");
       previewHtml.classList.remove("hidden");
       previewHtml.removeAttribute("title");
-      previewHtml.removeAttribute("srcdoc");
+      previewHtml.src = "about:blank";
     }
   }
   window.onload = async function () {
@@ -241,11 +390,19 @@
     await loadMails();
     document.querySelector("#mails > article:first-child")?.focus();
   };
-  document.getElementById("inbox").onclick = () => window.location.reload();
+  document.getElementById("inbox").onclick = () => filterMails("");
+  document.getElementById("unread").onclick = () => filterMails("&seen=false");
+  document.getElementById("starred").onclick = () =>
+    filterMails("&starred=true");
+  document.getElementById("mark-all-read").onclick = markAllRead;
   document.getElementById("upload").onchange = uploadMail;
   document.getElementById("upload-link").onclick = () =>
     document.getElementById("upload").click();
   document.getElementById("delete").onclick = deleteAllMails;
+  document.getElementById("toggle-seen").onclick = toggleSeen;
+  document.getElementById("toggle-starred").onclick = toggleStarred;
+  document.getElementById("edit-tags").onclick = editTags;
+  document.getElementById("toggle-remote").onclick = toggleRemote;
   document.getElementById("show-html").onclick = showHtml;
   document.getElementById("show-plain").onclick = showPlain;
   document.getElementById("show-headers").onclick = showHeaders;
@@ -0,0 +1,99 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/rntrp/mailheap/internal/msg"
+	"github.com/rntrp/mailheap/internal/preview"
+	"github.com/rntrp/mailheap/internal/storage"
+)
+
+func (c *ctrl) GetHtml(w http.ResponseWriter, r *http.Request) {
+	remote, _ := strconv.ParseBool(r.URL.Query().Get("remote"))
+	addPreviewSecurityHeaders(w.Header(), remote)
+	id, content, ok := c.loadContent(w, r)
+	if !ok {
+		return
+	}
+	src := content.Html
+	if len(src) == 0 {
+		src = "<pre>" + html.EscapeString(content.Text) + "</pre>"
+	}
+	out, err := preview.Sanitize(src, preview.Options{
+		CidURL: func(cid string) string {
+			return fmt.Sprintf(ApiV1Prefix+"/mail/%v/cid/%v", id, url.PathEscape(cid))
+		},
+		BlockRemote: !remote,
+	})
+	if err != nil {
+		slog.Error("Sanitizing mail HTML failed", "id", id, "error", err.Error())
+		httpError(w, r, "mail HTML could not be rendered", http.StatusUnprocessableEntity)
+		return
+	}
+	b := []byte(out)
+	w.Header().Add("Content-Type", "text/html; charset=utf-8")
+	w.Header().Add("Content-Length", strconv.Itoa(len(b)))
+	w.Write(b)
+}
+
+func (c *ctrl) GetCid(w http.ResponseWriter, r *http.Request) {
+	addPreviewSecurityHeaders(w.Header(), false)
+	_, content, ok := c.loadContent(w, r)
+	if !ok {
+		return
+	}
+	part, ok := content.PartByContentId(r.PathValue("cid"))
+	if !ok {
+		httpError(w, r, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	disposition := "attachment"
+	if strings.HasPrefix(part.ContentType, "image/") {
+		disposition = "inline"
+	}
+	if len(part.FileName) > 0 {
+		disposition = mime.FormatMediaType(disposition, map[string]string{"filename": part.FileName})
+	}
+	w.Header().Add("Content-Type", part.ContentType)
+	w.Header().Add("Content-Length", strconv.Itoa(len(part.Data)))
+	w.Header().Add("Content-Disposition", disposition)
+	w.Write(part.Data)
+}
+
+func (c *ctrl) loadContent(w http.ResponseWriter, r *http.Request) (int64, *msg.Content, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		httpError(w, r, "numeric ID could not be parsed", http.StatusBadRequest)
+		return id, nil, false
+	}
+	content, ok := c.decodeMail(w, r, id)
+	return id, content, ok
+}
+
+func (c *ctrl) decodeMail(w http.ResponseWriter, r *http.Request, id int64) (*msg.Content, bool) {
+	eml, err := c.storage.GetMime(id)
+	if errors.Is(err, storage.ErrNotFound) {
+		httpError(w, r, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		slog.Error("Get mail failed", "error", err.Error())
+		httpError(w, r, http.StatusText(http.StatusInternalServerError),
+			http.StatusInternalServerError)
+		return nil, false
+	}
+	content, err := msg.Decode([]byte(eml))
+	if err != nil {
+		slog.Error("Decoding mail failed", "id", id, "error", err.Error())
+		httpError(w, r, "mail could not be decoded", http.StatusUnprocessableEntity)
+		return nil, false
+	}
+	return content, true
+}
@@ -0,0 +1,140 @@
+package rest
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/rntrp/mailheap/internal/msg"
+	"github.com/rntrp/mailheap/internal/render"
+	"github.com/rntrp/mailheap/internal/storage"
+)
+
+func (c *ctrl) RenderPng(w http.ResponseWriter, r *http.Request) {
+	c.render(w, r, "png", "image/png", render.PNG)
+}
+
+func (c *ctrl) RenderPdf(w http.ResponseWriter, r *http.Request) {
+	c.render(w, r, "pdf", "application/pdf", render.PDF)
+}
+
+func (c *ctrl) render(w http.ResponseWriter, r *http.Request, ext, contentType string,
+	fn func(render.Source, int) ([]byte, error)) {
+	addSecurityHeaders(w.Header())
+	width := parseWidth(r.URL.Query())
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		httpError(w, r, "numeric ID could not be parsed", http.StatusBadRequest)
+		return
+	}
+	// Mails are immutable and their IDs are never reused, so the ETag and
+	// the cache key only depend on the ID, once the mail is known to exist.
+	if n, err := c.storage.CountMails(storage.Filter{Ids: []int64{id}}); err != nil {
+		slog.Error("Count mail failed", "error", err.Error())
+		httpError(w, r, http.StatusText(http.StatusInternalServerError),
+			http.StatusInternalServerError)
+		return
+	} else if n == 0 {
+		httpError(w, r, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	key := renderKey(id, width, ext)
+	etag := `"` + key + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		w.Header().Add("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	b, ok := c.renders.Get(key)
+	if !ok {
+		content, ok := c.decodeMail(w, r, id)
+		if !ok {
+			return
+		}
+		b, err = fn(render.Source{
+			Html:  content.Html,
+			Text:  content.Text,
+			Image: inlineImage(content),
+		}, width)
+		if err != nil {
+			slog.Error("Rendering mail failed", "id", id, "error", err.Error())
+			httpError(w, r, "mail could not be rendered", http.StatusUnprocessableEntity)
+			return
+		}
+		c.renders.Put(key, b)
+	}
+	w.Header().Add("ETag", etag)
+	w.Header().Add("Content-Type", contentType)
+	w.Header().Add("Content-Length", strconv.Itoa(len(b)))
+	w.Header().Add("Content-Disposition", fmt.Sprintf("inline; filename=\"%v\"", key))
+	w.Write(b)
+}
+
+func renderKey(id int64, width int, ext string) string {
+	return fmt.Sprintf("%v-%v.%v", id, width, ext)
+}
+
+// evictRenders removes the renderings of deleted mails from the cache until
+// the storage is shut down. After missing events, the whole cache is cleared.
+func (c *ctrl) evictRenders(events <-chan storage.Event) {
+	for {
+		e, ok := <-events
+		switch {
+		case !ok:
+			return
+		case e.Type == storage.EventOverflow:
+			events, _ = c.storage.Subscribe()
+			c.renders.RemoveFunc(func(string) bool { return true })
+		case e.Type == storage.EventDeleted:
+			deleted := make(map[string]bool, len(e.Ids))
+			for _, id := range e.Ids {
+				deleted[strconv.FormatInt(id, 10)] = true
+			}
+			c.renders.RemoveFunc(func(key string) bool {
+				id, _, _ := strings.Cut(key, "-")
+				return e.Ids == nil || deleted[id]
+			})
+		}
+	}
+}
+
+// maxImagePixels limits the decoded size of inline images, since the header
+// of a small file may declare huge dimensions.
+const maxImagePixels = 4096 * 4096
+
+func inlineImage(content *msg.Content) func(string) (image.Image, bool) {
+	return func(src string) (image.Image, bool) {
+		if len(src) < 4 || !strings.EqualFold(src[:4], "cid:") {
+			return nil, false
+		} else if part, ok := content.PartByContentId(src[4:]); !ok {
+			return nil, false
+		} else if cfg, _, err := image.DecodeConfig(bytes.NewReader(part.Data)); err != nil ||
+			cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxImagePixels {
+			return nil, false
+		} else if img, _, err := image.Decode(bytes.NewReader(part.Data)); err != nil {
+			return nil, false
+		} else {
+			return img, true
+		}
+	}
+}
+
+func parseWidth(query url.Values) int {
+	const def = 800
+	width, err := strconv.Atoi(query.Get("width"))
+	switch {
+	case err != nil:
+		return def
+	case width < render.MinWidth:
+		return render.MinWidth
+	case width > render.MaxWidth:
+		return render.MaxWidth
+	default:
+		return width
+	}
+}
@@ -0,0 +1,114 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rntrp/mailheap/internal/config"
+	"github.com/rntrp/mailheap/internal/msg"
+	"github.com/rntrp/mailheap/internal/rules"
+	"github.com/rntrp/mailheap/internal/storage"
+)
+
+// pngWithSize encodes a 1x1 image and patches the dimensions in the IHDR
+// chunk, which starts after the 8 byte signature and the chunk header.
+func pngWithSize(t *testing.T, w, h uint32) []byte {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	binary.BigEndian.PutUint32(b[16:], w)
+	binary.BigEndian.PutUint32(b[20:], h)
+	binary.BigEndian.PutUint32(b[29:], crc32.ChecksumIEEE(b[12:29]))
+	return b
+}
+
+func TestInlineImage(t *testing.T) {
+	content := &msg.Content{Parts: []msg.Part{
+		{ContentId: "small", Data: pngWithSize(t, 1, 1)},
+		{ContentId: "huge", Data: pngWithSize(t, 50000, 50000)},
+		{ContentId: "broken", Data: []byte("no image")},
+	}}
+	resolve := inlineImage(content)
+	if img, ok := resolve("cid:small"); !ok || img.Bounds().Dx() != 1 {
+		t.Errorf("small image not resolved")
+	}
+	for _, src := range []string{"cid:huge", "cid:broken", "cid:missing", "https://example.com/a.png"} {
+		if _, ok := resolve(src); ok {
+			t.Errorf("%v resolved", src)
+		}
+	}
+}
+
+func newTestCtrl(t *testing.T) *ctrl {
+	t.Helper()
+	if err := config.LoadDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	st, err := storage.NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Shutdown() })
+	engine, _ := rules.Load("")
+	svc := msg.NewAddMailSvc(st, nil, engine)
+	return New(st, svc, nil, engine, nil).(*ctrl)
+}
+
+func TestRenderCached(t *testing.T) {
+	c := newTestCtrl(t)
+	id, err := c.storeMail.StoreMail(strings.NewReader("From: alice@example.com\r\n" +
+		"Date: Mon, 19 Oct 2026 11:39:27 -0000\r\n" +
+		"Subject: Render\r\n\r\nHello\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(id int64, etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/mail/"+strconv.FormatInt(id, 10)+"/render.png?width=300", nil)
+		r.SetPathValue("id", strconv.FormatInt(id, 10))
+		if len(etag) > 0 {
+			r.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		c.RenderPng(w, r)
+		return w
+	}
+	w := get(id, "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("unexpected response %v %v", w.Code, w.Header())
+	}
+	etag := w.Header().Get("ETag")
+	if _, ok := c.renders.Get(strings.Trim(etag, `"`)); !ok {
+		t.Errorf("%v not cached", etag)
+	}
+	if w := get(id, etag); w.Code != http.StatusNotModified {
+		t.Errorf("expected 304, got %v", w.Code)
+	}
+	// unknown and deleted mails are not found, even with a matching ETag
+	if w := get(id+1, fmt.Sprintf(`"%v-300.png"`, id+1)); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %v", w.Code)
+	}
+	if _, err := c.storage.DeleteMails(id); err != nil {
+		t.Fatal(err)
+	} else if w := get(id, etag); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %v", w.Code)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for _, ok := c.renders.Get(strings.Trim(etag, `"`)); ok; _, ok = c.renders.Get(strings.Trim(etag, `"`)) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%v not evicted", etag)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
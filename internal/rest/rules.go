@@ -0,0 +1,86 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/rntrp/mailheap/internal/rules"
+)
+
+const maxRulesRequestSize = 1 << 20
+
+func (c *ctrl) GetRules(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w.Header())
+	writeJson(w, r, c.rules.Rules())
+}
+
+func (c *ctrl) PutRules(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w.Header())
+	if !validCsrfToken(r) {
+		httpError(w, r, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+	list := make([]rules.Rule, 0)
+	if !decodeRules(w, r, &list) {
+		return
+	} else if err := c.rules.Replace(list); err != nil {
+		c.rulesError(w, r, err)
+		return
+	}
+	writeJson(w, r, c.rules.Rules())
+}
+
+func (c *ctrl) PutRule(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w.Header())
+	if !validCsrfToken(r) {
+		httpError(w, r, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+	rule := rules.Rule{}
+	if !decodeRules(w, r, &rule) {
+		return
+	}
+	rule.Name = r.PathValue("name")
+	if err := c.rules.Put(rule); err != nil {
+		c.rulesError(w, r, err)
+		return
+	}
+	writeJson(w, r, c.rules.Rules())
+}
+
+func (c *ctrl) DeleteRule(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w.Header())
+	if !validCsrfToken(r) {
+		httpError(w, r, "Invalid CSRF token", http.StatusForbidden)
+		return
+	} else if err := c.rules.Delete(r.PathValue("name")); errors.Is(err, rules.ErrNotFound) {
+		httpError(w, r, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	} else if err != nil {
+		c.rulesError(w, r, err)
+		return
+	}
+	writeJson(w, r, c.rules.Rules())
+}
+
+func decodeRules(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRulesRequestSize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		httpError(w, r, "invalid rules: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func (c *ctrl) rulesError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, rules.ErrInvalidRule) {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	slog.Error("Saving rules failed", "error", err.Error())
+	httpError(w, r, http.StatusText(http.StatusInternalServerError),
+		http.StatusInternalServerError)
+}
@@ -4,10 +4,36 @@ import "net/http"
 
 func addSecurityHeaders(hdr http.Header) {
 	hdr.Add("Content-Security-Policy", "default-src 'self'; "+
-		"img-src * data:; "+
+		"img-src 'self' data:; "+
 		"style-src 'self' 'unsafe-inline'; "+
 		"frame-ancestors 'none'; "+
 		"base-uri 'self';")
+	addPermissionHeaders(hdr)
+	hdr.Add("X-Frame-Options", "DENY")
+}
+
+// addPreviewSecurityHeaders is used for rendered mail content, which may be
+// framed by the UI, but must neither run scripts nor submit forms. Unless
+// remote is set, the browser must not load anything from other origins.
+func addPreviewSecurityHeaders(hdr http.Header, remote bool) {
+	src := "'self' data:"
+	if remote {
+		src += " http: https:"
+	}
+	hdr.Add("Content-Security-Policy", "default-src 'none'; "+
+		"img-src "+src+"; "+
+		"media-src "+src+"; "+
+		"style-src 'unsafe-inline' "+src+"; "+
+		"font-src "+src+"; "+
+		"form-action 'none'; "+
+		"frame-ancestors 'self'; "+
+		"base-uri 'none'; "+
+		"sandbox allow-popups allow-popups-to-escape-sandbox;")
+	addPermissionHeaders(hdr)
+	hdr.Add("X-Frame-Options", "SAMEORIGIN")
+}
+
+func addPermissionHeaders(hdr http.Header) {
 	hdr.Add("Permissions-Policy", "accelerometer=(), "+
 		"ambient-light-sensor=(), "+
 		"autoplay=(), "+
@@ -37,5 +63,13 @@ func addSecurityHeaders(hdr http.Header) {
 		"xr-spatial-tracking=()")
 	hdr.Add("Referrer-Policy", "no-referrer")
 	hdr.Add("X-Content-Type-Options", "nosniff")
-	hdr.Add("X-Frame-Options", "DENY")
+}
+
+// validCsrfToken checks the double-submitted token: the token in the query
+// must be repeated in the X-Csrf-Token header, which cross-site forms and
+// simple requests cannot set.
+func validCsrfToken(r *http.Request) bool {
+	csrfTokenQuery := r.URL.Query().Get("csrf-token")
+	csrfTokenHeader := r.Header.Get("X-Csrf-Token")
+	return len(csrfTokenQuery) > 0 && csrfTokenQuery == csrfTokenHeader
 }
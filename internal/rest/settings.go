@@ -0,0 +1,107 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/rntrp/mailheap/internal/config"
+)
+
+const maxSettingsRequestSize = 1 << 20
+
+// SettingsResult lists the resolved config values by environment variable
+// name, e.g. MAILHEAP_SMTP_MAX_MESSAGE_BYTES.
+type SettingsResult struct {
+	File     string                    `json:"file"`
+	Settings map[string]config.Setting `json:"settings"`
+}
+
+func (c *ctrl) GetSettings(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w.Header())
+	if !config.IsHTTPEnableAdmin() {
+		httpError(w, r, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	writeSettings(w, r)
+}
+
+// PatchSettings applies a JSON object of setting names and values. Strings,
+// numbers and booleans are accepted as values, null resets a setting.
+func (c *ctrl) PatchSettings(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w.Header())
+	if !config.IsHTTPEnableAdmin() {
+		httpError(w, r, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	} else if !validCsrfToken(r) {
+		httpError(w, r, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+	raw := make(map[string]json.RawMessage)
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSettingsRequestSize))
+	if err := dec.Decode(&raw); err != nil {
+		httpError(w, r, "invalid settings: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	changes := make(map[string]*string, len(raw))
+	for k, v := range raw {
+		if string(v) == "null" {
+			changes[k] = nil
+			continue
+		}
+		s, err := settingValue(v)
+		if err != nil {
+			httpError(w, r, "invalid value of "+k+": "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		changes[k] = &s
+	}
+	if err := config.Patch(changes); errors.Is(err, config.ErrRestartRequired) {
+		httpError(w, r, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeSettings(w, r)
+}
+
+// settingValue returns a JSON string as it is and numbers and booleans as
+// their JSON text, e.g. 1024 or true.
+func settingValue(v json.RawMessage) (string, error) {
+	var s string
+	if err := json.Unmarshal(v, &s); err == nil {
+		return s, nil
+	}
+	var x any
+	if err := json.Unmarshal(v, &x); err != nil {
+		return "", err
+	}
+	switch x.(type) {
+	case float64, bool:
+		return string(v), nil
+	}
+	return "", errors.New("string, number or boolean expected")
+}
+
+func (c *ctrl) ReloadSettings(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w.Header())
+	if !config.IsHTTPEnableAdmin() {
+		httpError(w, r, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	} else if !validCsrfToken(r) {
+		httpError(w, r, "Invalid CSRF token", http.StatusForbidden)
+		return
+	} else if err := config.Reload(); err != nil {
+		httpError(w, r, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	writeSettings(w, r)
+}
+
+func writeSettings(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, r, SettingsResult{
+		File:     config.GetConfigFile(),
+		Settings: config.Settings(),
+	})
+}
@@ -0,0 +1,51 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rntrp/mailheap/internal/config"
+)
+
+func patchSettings(c *ctrl, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPatch, "/api/v1/settings?csrf-token=t", strings.NewReader(body))
+	r.Header.Set("X-Csrf-Token", "t")
+	w := httptest.NewRecorder()
+	c.PatchSettings(w, r)
+	return w
+}
+
+func TestPatchSettings(t *testing.T) {
+	t.Setenv("MAILHEAP_HTTP_ENABLE_ADMIN", "true")
+	c := newTestCtrl(t)
+	t.Cleanup(func() {
+		patchSettings(c, `{"MAILHEAP_SMTP_USERNAME": null, "MAILHEAP_SMTP_MAX_MESSAGE_BYTES": null,
+			"MAILHEAP_SMTP_AUTH_REQUIRED": null}`)
+	})
+	w := patchSettings(c, `{"MAILHEAP_SMTP_USERNAME": "a\/bé", "MAILHEAP_SMTP_MAX_MESSAGE_BYTES": 1024,
+		"MAILHEAP_SMTP_AUTH_REQUIRED": true}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %v: %v", w.Code, w.Body)
+	} else if got := config.GetSMTPUsername(); got != "a/bé" {
+		t.Errorf("unexpected username %q", got)
+	} else if got := config.GetSMTPMaxMessageBytes(); got != 1024 {
+		t.Errorf("unexpected size %v", got)
+	} else if !config.IsSMTPAuthRequired() {
+		t.Errorf("auth not required")
+	}
+	for _, body := range []string{
+		`{"MAILHEAP_SMTP_USERNAME": {"name": "alice"}}`,
+		`{"MAILHEAP_SMTP_USERNAME": ["alice"]}`,
+		`{"MAILHEAP_SMTP_MAX_MESSAGE_BYTES": "many"}`,
+		`{"MAILHEAP_SMTP_ADDRESS": ":2525"}`,
+	} {
+		if w := patchSettings(c, body); w.Code == http.StatusOK {
+			t.Errorf("%v: accepted", body)
+		}
+	}
+	if got := config.GetSMTPUsername(); got != "a/bé" {
+		t.Errorf("username changed to %q", got)
+	}
+}
@@ -0,0 +1,90 @@
+package rest
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/rntrp/mailheap/internal/storage"
+)
+
+const maxStateRequestSize = 64 << 10
+
+type MailState struct {
+	Seen       *bool    `json:"seen,omitempty"`
+	Starred    *bool    `json:"starred,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	AddTags    []string `json:"addTags,omitempty"`
+	RemoveTags []string `json:"removeTags,omitempty"`
+}
+
+type UpdateMailsResult struct {
+	NumUpdated int64 `json:"numUpdated"`
+}
+
+func (c *ctrl) PatchMail(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w.Header())
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		httpError(w, r, "numeric ID could not be parsed", http.StatusBadRequest)
+		return
+	}
+	c.updateState(w, r, storage.Filter{Ids: []int64{id}}, true)
+}
+
+func (c *ctrl) PatchMails(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w.Header())
+	query := r.URL.Query()
+	f, err := parseFilter(query)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// without a filter, all mails are updated on explicit request only
+	if all, _ := strconv.ParseBool(query.Get("all")); f.IsZero() && !all {
+		httpError(w, r, "a filter or query parameter 'all=true' is required", http.StatusBadRequest)
+		return
+	}
+	c.updateState(w, r, f, false)
+}
+
+func (c *ctrl) updateState(w http.ResponseWriter, r *http.Request, f storage.Filter, single bool) {
+	if !validCsrfToken(r) {
+		httpError(w, r, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+	state := MailState{}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxStateRequestSize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&state); err != nil {
+		httpError(w, r, "invalid mail state: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	cnt, err := c.storage.UpdateState(f, storage.StateUpdate{
+		Seen:       state.Seen,
+		Starred:    state.Starred,
+		Tags:       state.Tags,
+		AddTags:    state.AddTags,
+		RemoveTags: state.RemoveTags,
+	})
+	if err != nil {
+		slog.Error("Updating mail state failed", "error", err.Error())
+		httpError(w, r, http.StatusText(http.StatusInternalServerError),
+			http.StatusInternalServerError)
+		return
+	} else if single && cnt == 0 {
+		httpError(w, r, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	b, err := json.Marshal(UpdateMailsResult{NumUpdated: cnt})
+	if err != nil {
+		slog.Error("Marshalling update mails result failed", "error", err.Error())
+		httpError(w, r, http.StatusText(http.StatusInternalServerError),
+			http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Add("Content-Length", strconv.Itoa(len(b)))
+	w.Write(b)
+}
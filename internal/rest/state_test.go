@@ -0,0 +1,54 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rntrp/mailheap/internal/storage"
+)
+
+func patchMails(c *ctrl, query, body string) (*httptest.ResponseRecorder, UpdateMailsResult) {
+	r := httptest.NewRequest(http.MethodPatch, "/api/v1/mails?csrf-token=t"+query, strings.NewReader(body))
+	r.Header.Set("X-Csrf-Token", "t")
+	w := httptest.NewRecorder()
+	c.PatchMails(w, r)
+	res := UpdateMailsResult{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+	return w, res
+}
+
+func TestPatchMails(t *testing.T) {
+	c := newTestCtrl(t)
+	for _, subject := range []string{"one", "two"} {
+		_, err := c.storeMail.StoreMail(strings.NewReader("From: alice@example.com\r\n" +
+			"To: bob@example.com\r\nDate: Mon, 19 Oct 2026 11:39:27 -0000\r\n" +
+			"Subject: " + subject + "\r\n\r\nHello\r\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, query := range []string{"", "&all=false", "&all=maybe", "&seen=maybe"} {
+		if w, _ := patchMails(c, query, `{"seen": true}`); w.Code != http.StatusBadRequest {
+			t.Errorf("%q: got %v", query, w.Code)
+		}
+	}
+	if n, _ := c.storage.CountMails(storage.Filter{}); n != 2 {
+		t.Fatalf("unexpected count %v", n)
+	}
+	unseen := false
+	if w, res := patchMails(c, "&subject=two", `{"seen": true, "addTags": ["done"]}`); w.Code != http.StatusOK ||
+		res.NumUpdated != 1 {
+		t.Errorf("unexpected update %v %+v", w.Code, res)
+	} else if n, _ := c.storage.CountMails(storage.Filter{Seen: &unseen}); n != 1 {
+		t.Errorf("%v mails unseen", n)
+	}
+	if w, res := patchMails(c, "&all=true", `{"seen": true}`); w.Code != http.StatusOK || res.NumUpdated != 2 {
+		t.Errorf("unexpected update %v %+v", w.Code, res)
+	}
+	if w, _ := patchMails(c, "&tag=done", `{"unknown": true}`); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown field accepted: %v", w.Code)
+	}
+}
@@ -1,11 +1,16 @@
 package rest
 
 import (
+	"io"
+	"log/slog"
 	"math"
+	"mime/multipart"
 	"net/http"
 	"strconv"
 
+	"github.com/rntrp/mailheap/internal/archive"
 	"github.com/rntrp/mailheap/internal/config"
+	"github.com/rntrp/mailheap/internal/msg"
 )
 
 const minValidFileSize = 16
@@ -13,14 +18,14 @@ const minValidFileSize = 16
 func setupFileSizeChecks(w http.ResponseWriter, r *http.Request) bool {
 	clen, err := coerceContentLength(r.Header.Get("Content-Length"))
 	if err == nil && clen < minValidFileSize {
-		http.Error(w, "http: Content-Length too short for a valid eml file",
+		httpError(w, r, "http: Content-Length too short for a valid eml file",
 			http.StatusBadRequest)
 		return false
 	}
 	maxReqSize := config.GetHTTPMaxRequestSize()
 	if maxReqSize >= 0 {
 		if err == nil && clen > maxReqSize {
-			http.Error(w, "http: Content-Length too large",
+			httpError(w, r, "http: Content-Length too large",
 				http.StatusRequestEntityTooLarge)
 			return false
 		}
@@ -41,3 +46,44 @@ func coerceMemoryBufferSize(memoryBufferSize int64) int64 {
 	}
 	return memoryBufferSize
 }
+
+type UploadResult struct {
+	NumStored int             `json:"numStored"`
+	NumFailed int             `json:"numFailed"`
+	Messages  []UploadMessage `json:"messages"`
+}
+
+type UploadMessage struct {
+	File  string `json:"file"`
+	Entry string `json:"entry,omitempty"`
+	Id    int64  `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// storeUpload stores every message of an uploaded .eml, mbox or zip file.
+// Failures are reported per message instead of aborting the upload.
+func (c *ctrl) storeUpload(fh *multipart.FileHeader, src msg.TimeSource) []UploadMessage {
+	msgs := make([]UploadMessage, 0, 1)
+	f, err := fh.Open()
+	if err != nil {
+		return append(msgs, UploadMessage{File: fh.Filename, Error: err.Error()})
+	}
+	defer f.Close()
+	err = archive.Read(fh.Filename, f, fh.Size, func(entry string, r io.Reader) error {
+		m := UploadMessage{File: fh.Filename, Entry: entry}
+		if id, err := c.storeMail.ImportMail(r, src); err != nil {
+			slog.Error("HTTP: failed to store mail", "file", fh.Filename,
+				"entry", entry, "error", err.Error())
+			m.Error = err.Error()
+		} else {
+			m.Id = id
+		}
+		msgs = append(msgs, m)
+		return nil
+	})
+	if err != nil {
+		slog.Error("HTTP: failed to read upload", "file", fh.Filename, "error", err.Error())
+		msgs = append(msgs, UploadMessage{File: fh.Filename, Error: err.Error()})
+	}
+	return msgs
+}
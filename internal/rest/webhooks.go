@@ -0,0 +1,45 @@
+package rest
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+func (c *ctrl) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w.Header())
+	hooks := c.webhooks.Hooks()
+	for i := range hooks {
+		if len(hooks[i].Secret) > 0 {
+			hooks[i].Secret = "***"
+		}
+	}
+	writeJson(w, r, hooks)
+}
+
+func (c *ctrl) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w.Header())
+	query := r.URL.Query()
+	deliveries, err := c.storage.ListDeliveries(query.Get("webhook"), parseLimit(query))
+	if err != nil {
+		slog.Error("Listing webhook deliveries failed", "error", err.Error())
+		httpError(w, r, http.StatusText(http.StatusInternalServerError),
+			http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, r, deliveries)
+}
+
+func writeJson(w http.ResponseWriter, r *http.Request, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("Marshalling response failed", "error", err.Error())
+		httpError(w, r, http.StatusText(http.StatusInternalServerError),
+			http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Add("Content-Length", strconv.Itoa(len(b)))
+	w.Write(b)
+}
@@ -0,0 +1,277 @@
+package rules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/rntrp/mailheap/internal/model"
+	"github.com/rntrp/mailheap/internal/msg"
+)
+
+// Rule labels incoming mail if all of its conditions match. Header and
+// Recipient are regular expressions; Header is matched against the decoded
+// values of the HeaderName header and Recipient against the addresses in To,
+// Cc and Bcc. A HeaderName without Header only requires the header to exist.
+type Rule struct {
+	Name       string   `json:"name"`
+	Labels     []string `json:"labels"`
+	HeaderName string   `json:"headerName,omitempty"`
+	Header     string   `json:"header,omitempty"`
+	Recipient  string   `json:"recipient,omitempty"`
+	MinSize    int64    `json:"minSize,omitempty"`
+	MaxSize    int64    `json:"maxSize,omitempty"`
+	Attachment *bool    `json:"attachment,omitempty"`
+}
+
+var (
+	ErrNotFound    = errors.New("rule not found")
+	ErrInvalidRule = errors.New("invalid rule")
+)
+
+type compiled struct {
+	Rule
+	header    *regexp.Regexp
+	recipient *regexp.Regexp
+}
+
+func compile(r Rule) (compiled, error) {
+	c := compiled{Rule: r}
+	var err error
+	if len(strings.TrimSpace(r.Name)) == 0 {
+		return c, fmt.Errorf("%w: name must not be empty", ErrInvalidRule)
+	} else if len(r.Labels) == 0 || slices.Contains(r.Labels, "") {
+		return c, fmt.Errorf("%w %v: labels must not be empty", ErrInvalidRule, r.Name)
+	} else if len(r.Header) > 0 && len(r.HeaderName) == 0 {
+		return c, fmt.Errorf("%w %v: header pattern without header name", ErrInvalidRule, r.Name)
+	} else if r.MaxSize > 0 && r.MaxSize < r.MinSize {
+		return c, fmt.Errorf("%w %v: maxSize is less than minSize", ErrInvalidRule, r.Name)
+	}
+	if len(r.Header) > 0 {
+		if c.header, err = regexp.Compile(r.Header); err != nil {
+			return c, fmt.Errorf("%w %v: header pattern: %w", ErrInvalidRule, r.Name, err)
+		}
+	}
+	if len(r.Recipient) > 0 {
+		if c.recipient, err = regexp.Compile(r.Recipient); err != nil {
+			return c, fmt.Errorf("%w %v: recipient pattern: %w", ErrInvalidRule, r.Name, err)
+		}
+	}
+	return c, nil
+}
+
+func (c compiled) match(m *model.Mail, content *msg.Content) bool {
+	if c.MinSize > 0 && int64(m.Size) < c.MinSize {
+		return false
+	} else if c.MaxSize > 0 && int64(m.Size) > c.MaxSize {
+		return false
+	} else if c.Attachment != nil && *c.Attachment != (len(content.Attachments()) > 0) {
+		return false
+	}
+	if len(c.HeaderName) > 0 {
+		values := content.Header[textproto.CanonicalMIMEHeaderKey(c.HeaderName)]
+		if !slices.ContainsFunc(values, func(v string) bool {
+			if dec, err := new(mime.WordDecoder).DecodeHeader(v); err == nil {
+				v = dec
+			}
+			return c.header == nil || c.header.MatchString(v)
+		}) {
+			return false
+		}
+	}
+	if c.recipient != nil && !slices.ContainsFunc(recipients(content.Header), c.recipient.MatchString) {
+		return false
+	}
+	return true
+}
+
+func recipients(hdr mail.Header) []string {
+	res := make([]string, 0)
+	for _, key := range []string{"To", "Cc", "Bcc"} {
+		list, err := hdr.AddressList(key)
+		if err != nil {
+			continue
+		}
+		for _, a := range list {
+			res = append(res, a.Address)
+		}
+	}
+	return res
+}
+
+// Engine holds the labelling rules and applies them as a processing stage.
+// If a file is given, the rules are loaded from and persisted to it.
+type Engine struct {
+	mtx   sync.RWMutex
+	file  string
+	rules []compiled
+}
+
+func Load(file string) (*Engine, error) {
+	e := &Engine{file: file, rules: make([]compiled, 0)}
+	if len(file) == 0 {
+		return e, nil
+	}
+	b, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return e, nil
+	} else if err != nil {
+		return nil, err
+	}
+	rules := make([]Rule, 0)
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return nil, fmt.Errorf("parsing rules file %v failed: %w", file, err)
+	}
+	if e.rules, err = compileAll(rules); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func compileAll(rules []Rule) ([]compiled, error) {
+	res := make([]compiled, 0, len(rules))
+	for _, r := range rules {
+		if slices.ContainsFunc(res, func(c compiled) bool { return c.Name == r.Name }) {
+			return nil, fmt.Errorf("%w %v: duplicate name", ErrInvalidRule, r.Name)
+		}
+		c, err := compile(r)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, c)
+	}
+	return res, nil
+}
+
+func (e *Engine) Rules() []Rule {
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+	res := make([]Rule, len(e.rules))
+	for i, c := range e.rules {
+		res[i] = c.Rule
+	}
+	return res
+}
+
+// Replace validates and stores all rules at once.
+func (e *Engine) Replace(rules []Rule) error {
+	compiled, err := compileAll(rules)
+	if err != nil {
+		return err
+	}
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	return e.save(compiled)
+}
+
+// Put adds the rule or replaces the rule with the same name.
+func (e *Engine) Put(r Rule) error {
+	c, err := compile(r)
+	if err != nil {
+		return err
+	}
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	rules := slices.Clone(e.rules)
+	if i := e.index(r.Name); i >= 0 {
+		rules[i] = c
+	} else {
+		rules = append(rules, c)
+	}
+	return e.save(rules)
+}
+
+func (e *Engine) Delete(name string) error {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	i := e.index(name)
+	if i < 0 {
+		return ErrNotFound
+	}
+	return e.save(slices.Delete(slices.Clone(e.rules), i, i+1))
+}
+
+func (e *Engine) index(name string) int {
+	return slices.IndexFunc(e.rules, func(c compiled) bool { return c.Name == name })
+}
+
+// save writes the rules to the file before they take effect, so that a
+// failed write leaves the engine unchanged. The caller must hold e.mtx.
+func (e *Engine) save(rules []compiled) error {
+	if len(e.file) > 0 {
+		list := make([]Rule, len(rules))
+		for i, c := range rules {
+			list[i] = c.Rule
+		}
+		b, err := json.MarshalIndent(list, "", "  ")
+		if err != nil {
+			return err
+		}
+		tmp, err := os.CreateTemp(filepath.Dir(e.file), filepath.Base(e.file)+".*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		if _, err := tmp.Write(b); err != nil {
+			tmp.Close()
+			return err
+		} else if err := tmp.Close(); err != nil {
+			return err
+		} else if err := os.Rename(tmp.Name(), e.file); err != nil {
+			return err
+		}
+	}
+	e.rules = rules
+	return nil
+}
+
+// Labels returns the labels of all matching rules without duplicates.
+func (e *Engine) Labels(m *model.Mail, c *msg.Content) []string {
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+	labels := make([]string, 0)
+	for _, r := range e.rules {
+		if r.match(m, c) {
+			for _, l := range r.Labels {
+				if !slices.Contains(labels, l) {
+					labels = append(labels, l)
+				}
+			}
+		}
+	}
+	return labels
+}
+
+// Process adds the labels of the matching rules to the tags of the mail.
+func (e *Engine) Process(m *model.Mail, c *msg.Content) error {
+	labels := e.Labels(m, c)
+	if len(labels) == 0 {
+		return nil
+	}
+	tags := make([]string, 0)
+	if len(m.Tags) > 0 {
+		if err := json.Unmarshal([]byte(m.Tags), &tags); err != nil {
+			return err
+		}
+	}
+	for _, l := range labels {
+		if !slices.Contains(tags, l) {
+			tags = append(tags, l)
+		}
+	}
+	b, err := json.Marshal(tags)
+	if err != nil {
+		return err
+	}
+	m.Tags = string(b)
+	return nil
+}
@@ -0,0 +1,71 @@
+package rules
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/rntrp/mailheap/internal/model"
+	"github.com/rntrp/mailheap/internal/msg"
+)
+
+const eml = "From: shop@example.com\r\n" +
+	"To: Alice <alice@qa.example.com>\r\n" +
+	"Subject: =?utf-8?q?Reset_your_password?=\r\n" +
+	"Date: Mon, 19 Oct 2026 11:39:27 -0000\r\n" +
+	"Content-Type: multipart/mixed; boundary=b\r\n\r\n" +
+	"--b\r\nContent-Type: text/plain\r\n\r\nHello\r\n" +
+	"--b\r\nContent-Type: text/plain\r\nContent-Disposition: attachment; filename=a.txt\r\n\r\nA\r\n" +
+	"--b--\r\n"
+
+func TestLabels(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rules.json")
+	e, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	yes, no := true, false
+	err = e.Replace([]Rule{
+		{Name: "reset", Labels: []string{"password-reset"}, HeaderName: "subject", Header: "(?i)password"},
+		{Name: "qa", Labels: []string{"qa", "password-reset"}, Recipient: `@qa\.example\.com$`},
+		{Name: "attachment", Labels: []string{"attachment"}, Attachment: &yes},
+		{Name: "plain", Labels: []string{"plain"}, Attachment: &no},
+		{Name: "big", Labels: []string{"big"}, MinSize: 1 << 20},
+		{Name: "missing", Labels: []string{"missing"}, HeaderName: "X-Missing"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := msg.Decode([]byte(eml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &model.Mail{Size: int32(len(eml)), Tags: `["manual"]`}
+	if err := e.Process(m, c); err != nil {
+		t.Fatal(err)
+	} else if m.Tags != `["manual","password-reset","qa","attachment"]` {
+		t.Errorf("unexpected tags: %v", m.Tags)
+	}
+
+	if err := e.Delete("qa"); err != nil {
+		t.Fatal(err)
+	} else if err := e.Delete("qa"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	err = e.Put(Rule{Name: "bad", Labels: []string{"x"}, HeaderName: "Subject", Header: "("})
+	if !errors.Is(err, ErrInvalidRule) {
+		t.Errorf("expected ErrInvalidRule, got %v", err)
+	}
+	reloaded, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, r := range reloaded.Rules() {
+		names = append(names, r.Name)
+	}
+	if !slices.Equal(names, []string{"reset", "attachment", "plain", "big", "missing"}) {
+		t.Errorf("unexpected rules after reload: %v", names)
+	}
+}
@@ -1,45 +1,158 @@
 package smtprecv
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
 	"io"
 	"log/slog"
+	"os"
+	"sync"
 	"time"
 
+	"github.com/emersion/go-sasl"
 	"github.com/emersion/go-smtp"
 	"github.com/google/uuid"
 	"github.com/rntrp/mailheap/internal/config"
+	"github.com/rntrp/mailheap/internal/dsn"
 	"github.com/rntrp/mailheap/internal/msg"
 )
 
+// Server is an SMTP or LMTP server of a single listener.
+type Server struct {
+	*smtp.Server
+	Listener config.SMTPListener
+	relays   sync.WaitGroup
+}
+
+// ListenAndServe listens on the address of the listener, either with TLS from
+// the start or with optional STARTTLS.
+func (s *Server) ListenAndServe() error {
+	if s.Listener.Network == "unix" {
+		removeSocket(s.Addr)
+	}
+	if s.Listener.ImplicitTLS {
+		return s.Server.ListenAndServeTLS()
+	}
+	return s.Server.ListenAndServe()
+}
+
+// Shutdown closes the listener, waits for the open connections and then for
+// the delivery status notifications still being relayed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if err := s.Server.Shutdown(ctx); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go func() {
+		s.relays.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// removeSocket removes a stale socket file left by a previous run.
+func removeSocket(path string) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			slog.Warn("Removing stale socket failed", "path", path, "error", err.Error())
+		}
+	}
+}
+
 type recv struct {
-	username   string
-	password   string
+	listener   *config.SMTPListener
+	relays     *sync.WaitGroup
 	addMailSvc msg.StoreMailSvc
 }
 
-func (b *recv) NewSession(_ *smtp.Conn) (smtp.Session, error) {
+func (b *recv) NewSession(c *smtp.Conn) (smtp.Session, error) {
 	uuid, err := uuid.NewRandom()
 	if err != nil {
 		return nil, err
 	}
+	_, isTLS := c.TLSConnectionState()
 	return &session{
 		uuid:       uuid,
-		username:   b.username,
-		password:   b.password,
+		tls:        isTLS,
+		listener:   b.listener,
+		relays:     b.relays,
 		addMailSvc: b.addMailSvc,
 	}, nil
 }
 
+// session reads the credentials and limits from config on each command, so
+// that changes apply without restart. Values set by the listener take
+// precedence.
 type session struct {
 	uuid       uuid.UUID
 	auth       bool
-	username   string
-	password   string
+	tls        bool
+	from       string
+	mailOpts   *smtp.MailOptions
+	rcpts      []dsn.Recipient
+	listener   *config.SMTPListener
+	relays     *sync.WaitGroup
 	addMailSvc msg.StoreMailSvc
 }
 
+var tlsRequired = &smtp.SMTPError{
+	Code:         530,
+	EnhancedCode: smtp.EnhancedCode{5, 7, 0},
+	Message:      "Must issue a STARTTLS command first",
+}
+
+// check rejects commands before STARTTLS or authentication if required.
+func (s *session) check() error {
+	required := config.IsSMTPAuthRequired()
+	if s.listener.AuthRequired != nil {
+		required = *s.listener.AuthRequired
+	}
+	if s.listener.RequireTLS && !s.tls {
+		return tlsRequired
+	} else if required && !s.auth {
+		return smtp.ErrAuthRequired
+	}
+	return nil
+}
+
+func (s *session) maxMessageBytes() int64 {
+	if s.listener.MaxMessageBytes > 0 {
+		return s.listener.MaxMessageBytes
+	}
+	return config.GetSMTPMaxMessageBytes()
+}
+
+func (s *session) AuthMechanisms() []string {
+	return []string{sasl.Plain}
+}
+
+func (s *session) Auth(mech string) (sasl.Server, error) {
+	if mech != sasl.Plain {
+		return nil, smtp.ErrAuthUnknownMechanism
+	}
+	return sasl.NewPlainServer(func(identity, username, password string) error {
+		if len(identity) > 0 && identity != username {
+			return smtp.ErrAuthFailed
+		}
+		return s.AuthPlain(username, password)
+	}), nil
+}
+
 func (s *session) AuthPlain(username, password string) error {
-	if username != s.username || password != s.password {
+	wantUser, wantPass := config.GetSMTPUsername(), config.GetSMTPPassword()
+	if len(s.listener.Username) > 0 {
+		wantUser, wantPass = s.listener.Username, s.listener.Password
+	}
+	if s.listener.RequireTLS && !s.tls {
+		return tlsRequired
+	} else if username != wantUser || password != wantPass {
 		return smtp.ErrAuthFailed
 	}
 	s.auth = true
@@ -49,9 +162,13 @@ func (s *session) AuthPlain(username, password string) error {
 }
 
 func (s *session) Mail(from string, opts *smtp.MailOptions) error {
-	if config.IsSMTPAuthRequired() && !s.auth {
-		return smtp.ErrAuthRequired
+	if err := s.check(); err != nil {
+		return err
+	}
+	if max := s.maxMessageBytes(); max > 0 && opts.Size > max {
+		return smtp.ErrDataTooLarge
 	}
+	s.from, s.mailOpts = from, opts
 	mode := "US-ASCII"
 	if opts.UTF8 {
 		mode = "SMTPUTF8"
@@ -67,9 +184,16 @@ func (s *session) Mail(from string, opts *smtp.MailOptions) error {
 }
 
 func (s *session) Rcpt(to string, opts *smtp.RcptOptions) error {
-	if config.IsSMTPAuthRequired() && !s.auth {
-		return smtp.ErrAuthRequired
+	if err := s.check(); err != nil {
+		return err
+	} else if max := config.GetSMTPMaxRecipients(); max > 0 && int64(len(s.rcpts)) >= max {
+		return &smtp.SMTPError{
+			Code:         452,
+			EnhancedCode: smtp.EnhancedCode{4, 5, 3},
+			Message:      fmt.Sprintf("Maximum limit of %v recipients reached", max),
+		}
 	}
+	s.rcpts = append(s.rcpts, dsn.Recipient{Addr: to, Options: opts})
 	slog.Info("SMTP command", "uuid", s.uuid.String(),
 		"command", "RCPT TO", "to", to, "type", opts.OriginalRecipientType,
 		"recipient", opts.OriginalRecipient)
@@ -83,26 +207,88 @@ var invalidContent = &smtp.SMTPError{
 }
 
 func (s *session) Data(r io.Reader) error {
-	if config.IsSMTPAuthRequired() && !s.auth {
-		return smtp.ErrAuthRequired
+	return s.deliver(r, s.rcpts)
+}
+
+// deliver stores the mail and, if enabled, reports the delivery to the given
+// recipients back to the sender.
+func (s *session) deliver(r io.Reader, rcpts []dsn.Recipient) error {
+	if err := s.check(); err != nil {
+		return err
 	}
 	start := time.Now()
 	slog.Info("SMTP command", "uuid", s.uuid.String(),
 		"command", "DATA")
-	d := &readerDecorator{delegate: r}
-	if err := s.addMailSvc.StoreMail(d); err != nil {
+	l := &limitReader{r: r, n: s.maxMessageBytes()}
+	d := &readerDecorator{delegate: l}
+	var original *bytes.Buffer
+	if config.GetDSNMode() != "off" {
+		original = new(bytes.Buffer)
+		d.delegate = io.TeeReader(l, original)
+	}
+	if _, err := s.addMailSvc.DeliverMail(d, s.listener.Mailbox); err != nil {
 		slog.Error("SMTP: failed to store mail", "uuid", s.uuid,
 			"error", err.Error())
+		if l.exceeded {
+			return smtp.ErrDataTooLarge
+		}
 		return invalidContent
 	}
 	elapsed := time.Since(start)
 	slog.Info("SMTP command", "uuid", s.uuid.String(),
 		"command", "<CR><LF>.<CR><LF>", "length", d.length,
 		"elapsed", elapsed)
+	if original != nil {
+		s.report(rcpts, original.Bytes(), start)
+	}
 	return nil
 }
 
+var (
+	rejectedRcpt = &smtp.SMTPError{
+		Code:         550,
+		EnhancedCode: smtp.EnhancedCode{5, 1, 1},
+		Message:      "Mailbox unavailable",
+	}
+	deferredRcpt = &smtp.SMTPError{
+		Code:         451,
+		EnhancedCode: smtp.EnhancedCode{4, 2, 0},
+		Message:      "Mailbox temporarily unavailable",
+	}
+)
+
+// LMTPData fails the recipients matching MAILHEAP_SMTP_LMTP_REJECT_RECIPIENTS
+// or MAILHEAP_SMTP_LMTP_DEFER_RECIPIENTS and stores the mail once for the
+// remaining recipients, which all get the result of Data as status.
+func (s *session) LMTPData(r io.Reader, status smtp.StatusCollector) error {
+	if err := s.check(); err != nil {
+		return err
+	}
+	reject := config.GetSMTPLMTPRejectRecipients()
+	deferred := config.GetSMTPLMTPDeferRecipients()
+	accepted := make([]dsn.Recipient, 0, len(s.rcpts))
+	for _, rcpt := range s.rcpts {
+		var err error
+		if reject != nil && reject.MatchString(rcpt.Addr) {
+			err = rejectedRcpt
+		} else if deferred != nil && deferred.MatchString(rcpt.Addr) {
+			err = deferredRcpt
+		} else {
+			accepted = append(accepted, rcpt)
+			continue
+		}
+		slog.Info("LMTP recipient failed", "uuid", s.uuid.String(),
+			"to", rcpt.Addr, "error", err.Error())
+		status.SetStatus(rcpt.Addr, err)
+	}
+	if len(accepted) == 0 {
+		return nil
+	}
+	return s.deliver(r, accepted)
+}
+
 func (s *session) Reset() {
+	s.from, s.mailOpts, s.rcpts = "", nil, nil
 	slog.Info("SMTP command", "uuid", s.uuid.String(), "command", "RSET")
 }
 
@@ -111,19 +297,33 @@ func (s *session) Logout() error {
 	return nil
 }
 
-func Init(addMailSvc msg.StoreMailSvc) *smtp.Server {
-	s := smtp.NewServer(&recv{
-		username:   config.GetSMTPUsername(),
-		password:   config.GetSMTPPassword(),
-		addMailSvc: addMailSvc,
-	})
-	s.Network = config.GetSMTPNetworkType()
-	s.Addr = config.GetSMTPAddress()
+// Init creates a server for each listener of config.GetSMTPListeners. TLS is
+// offered via STARTTLS if tlsCfg is set and required by implicit TLS and
+// tls=required listeners.
+func Init(addMailSvc msg.StoreMailSvc, tlsCfg *tls.Config) ([]*Server, error) {
+	listeners := config.GetSMTPListeners()
+	servers := make([]*Server, 0, len(listeners))
+	for _, l := range listeners {
+		if tlsCfg == nil && (l.ImplicitTLS || l.RequireTLS) {
+			return nil, fmt.Errorf("listener %v requires a TLS certificate", l)
+		}
+		servers = append(servers, New(l, addMailSvc, tlsCfg))
+	}
+	return servers, nil
+}
+
+func New(l config.SMTPListener, addMailSvc msg.StoreMailSvc, tlsCfg *tls.Config) *Server {
+	s := &Server{Listener: l}
+	s.Server = smtp.NewServer(&recv{listener: &s.Listener, relays: &s.relays, addMailSvc: addMailSvc})
+	s.Network = l.Network
+	s.Addr = l.Addr
+	s.LMTP = l.LMTP
+	s.TLSConfig = tlsCfg
 	s.Domain = config.GetSMTPDomain()
 	s.ReadTimeout = config.GetSMTPReadTimeout()
 	s.WriteTimeout = config.GetSMTPWriteTimeout()
-	s.MaxMessageBytes = config.GetSMTPMaxMessageBytes()
-	s.MaxRecipients = int(config.GetSMTPMaxRecipients())
+	// MaxMessageBytes and MaxRecipients are enforced by the session instead,
+	// since the server fields must not change while serving.
 	s.MaxLineLength = int(config.GetSMTPMaxLineLength())
 	s.AllowInsecureAuth = config.IsSMTPAllowInsecureAuth()
 	s.EnableSMTPUTF8 = config.IsSMTPEnableSMTPUTF8()
@@ -132,3 +332,25 @@ func Init(addMailSvc msg.StoreMailSvc) *smtp.Server {
 	s.EnableREQUIRETLS = config.IsSMTPEnableREQUIRETLS()
 	return s
 }
+
+// limitReader fails with smtp.ErrDataTooLarge once more than n bytes have
+// been read. A limit of 0 or less disables the check.
+type limitReader struct {
+	r        io.Reader
+	n        int64
+	read     int64
+	exceeded bool
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.exceeded {
+		return 0, smtp.ErrDataTooLarge
+	}
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.n > 0 && l.read > l.n {
+		l.exceeded = true
+		return n, smtp.ErrDataTooLarge
+	}
+	return n, err
+}
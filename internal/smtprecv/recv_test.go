@@ -0,0 +1,266 @@
+package smtprecv
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+	"github.com/rntrp/mailheap/internal/config"
+	"github.com/rntrp/mailheap/internal/msg"
+)
+
+type fakeSvc struct {
+	mtx       sync.Mutex
+	mailboxes []string
+	mails     []string
+}
+
+func (f *fakeSvc) DeliverMail(r io.Reader, mailbox string) (int64, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.mailboxes = append(f.mailboxes, mailbox)
+	f.mails = append(f.mails, string(b))
+	return int64(len(f.mailboxes)), nil
+}
+
+func (f *fakeSvc) ImportMail(r io.Reader, t msg.TimeSource) (int64, error) {
+	return f.DeliverMail(r, "")
+}
+
+func (f *fakeSvc) StoreMail(r io.Reader) (int64, error) {
+	return f.DeliverMail(r, "")
+}
+
+func TestLMTPData(t *testing.T) {
+	t.Setenv("MAILHEAP_ENV", "test")
+	t.Setenv("MAILHEAP_SMTP_LMTP_REJECT_RECIPIENTS", "^bounce@")
+	t.Setenv("MAILHEAP_SMTP_LMTP_DEFER_RECIPIENTS", "^later@")
+	if err := config.LoadQuietly(); err != nil {
+		t.Fatal(err)
+	}
+	sock := filepath.Join(t.TempDir(), "lmtp.sock")
+	svc := new(fakeSvc)
+	srv := New(config.SMTPListener{Network: "unix", Addr: sock, LMTP: true, Mailbox: "lmtp"}, svc, nil)
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	defer srv.Close()
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := smtp.NewClientLMTP(conn)
+	defer c.Close()
+	if err := c.Hello("localhost"); err != nil {
+		t.Fatal(err)
+	} else if err := c.Mail("alice@example.com", nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, to := range []string{"bob@example.com", "bounce@example.com", "later@example.com"} {
+		if err := c.Rcpt(to, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "From: alice@example.com\r\n"+
+		"Date: Mon, 19 Oct 2026 11:39:27 -0000\r\n"+
+		"Subject: LMTP\r\n\r\nHello\r\n")
+	_, err = w.CloseWithLMTPResponse()
+	var lmtpErr smtp.LMTPDataError
+	if !errors.As(err, &lmtpErr) {
+		t.Fatalf("expected per-recipient errors, got %v", err)
+	} else if len(lmtpErr) != 2 {
+		t.Errorf("unexpected errors %v", lmtpErr)
+	} else if e := lmtpErr["bounce@example.com"]; e == nil || e.Code != 550 {
+		t.Errorf("bounce: %v", e)
+	} else if e := lmtpErr["later@example.com"]; e == nil || e.Code != 451 {
+		t.Errorf("later: %v", e)
+	}
+	if len(svc.mailboxes) != 1 || svc.mailboxes[0] != "lmtp" {
+		t.Errorf("stored %v", svc.mailboxes)
+	}
+}
+
+const eml = "From: alice@example.com\r\n" +
+	"Date: Mon, 19 Oct 2026 11:39:27 -0000\r\n" +
+	"Subject: Hello\r\n\r\nHello\r\n"
+
+// testTLSConfig creates a self-signed certificate for 127.0.0.1.
+func testTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}
+}
+
+// serveTCP starts a server of the listener on a random local port.
+func serveTCP(t *testing.T, l config.SMTPListener, tlsCfg *tls.Config) (string, *fakeSvc) {
+	t.Helper()
+	t.Setenv("MAILHEAP_ENV", "test")
+	if err := config.LoadQuietly(); err != nil {
+		t.Fatal(err)
+	}
+	l.Network = "tcp"
+	svc := new(fakeSvc)
+	srv := New(l, svc, tlsCfg)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.ImplicitTLS {
+		ln = tls.NewListener(ln, tlsCfg)
+	}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String(), svc
+}
+
+func smtpCode(err error) int {
+	var smtpErr *smtp.SMTPError
+	if errors.As(err, &smtpErr) {
+		return smtpErr.Code
+	}
+	return 0
+}
+
+func sendData(c *smtp.Client, body string) error {
+	if err := c.Mail("alice@example.com", nil); err != nil {
+		return err
+	} else if err := c.Rcpt("bob@example.com", nil); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	} else if _, err := io.WriteString(w, body); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func TestRequireTLS(t *testing.T) {
+	tlsCfg := testTLSConfig(t)
+	addr, svc := serveTCP(t, config.SMTPListener{RequireTLS: true, Mailbox: "tls"}, tlsCfg)
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Mail("alice@example.com", nil); smtpCode(err) != 530 {
+		t.Errorf("MAIL before STARTTLS not rejected: %v", err)
+	}
+	clientCfg := &tls.Config{InsecureSkipVerify: true}
+	c, err = smtp.DialStartTLS(addr, clientCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := sendData(c, eml); err != nil {
+		t.Fatal(err)
+	} else if len(svc.mailboxes) != 1 || svc.mailboxes[0] != "tls" {
+		t.Errorf("stored %v", svc.mailboxes)
+	}
+}
+
+func TestImplicitTLS(t *testing.T) {
+	addr, svc := serveTCP(t, config.SMTPListener{ImplicitTLS: true, RequireTLS: true}, testTLSConfig(t))
+	c, err := smtp.DialTLS(addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, ok := c.TLSConnectionState(); !ok {
+		t.Errorf("no TLS connection")
+	}
+	if err := sendData(c, eml); err != nil {
+		t.Fatal(err)
+	} else if len(svc.mailboxes) != 1 {
+		t.Errorf("stored %v", svc.mailboxes)
+	}
+}
+
+func TestListenerAuth(t *testing.T) {
+	required := true
+	addr, svc := serveTCP(t, config.SMTPListener{AuthRequired: &required,
+		Username: "listener", Password: "secret"}, testTLSConfig(t))
+	clientCfg := &tls.Config{InsecureSkipVerify: true}
+	c, err := smtp.DialStartTLS(addr, clientCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Mail("alice@example.com", nil); smtpCode(err) != smtp.ErrAuthRequired.Code {
+		t.Errorf("MAIL before AUTH not rejected: %v", err)
+	}
+	// the global credentials do not apply to the listener
+	global := sasl.NewPlainClient("", config.GetSMTPUsername(), config.GetSMTPPassword())
+	if err := c.Auth(global); smtpCode(err) != 535 {
+		t.Errorf("global credentials not rejected: %v", err)
+	}
+	c, err = smtp.DialStartTLS(addr, clientCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Auth(sasl.NewPlainClient("", "listener", "secret")); err != nil {
+		t.Fatal(err)
+	} else if err := sendData(c, eml); err != nil {
+		t.Fatal(err)
+	} else if len(svc.mailboxes) != 1 {
+		t.Errorf("stored %v", svc.mailboxes)
+	}
+}
+
+func TestListenerMaxMessageBytes(t *testing.T) {
+	addr, svc := serveTCP(t, config.SMTPListener{MaxMessageBytes: int64(len(eml)) + 16}, nil)
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := sendData(c, eml); err != nil {
+		t.Fatal(err)
+	}
+	body := eml + strings.Repeat("Hello\r\n", 16)
+	if err := sendData(c, body); smtpCode(err) != 552 {
+		t.Errorf("oversized mail not rejected: %v", err)
+	} else if len(svc.mailboxes) != 1 {
+		t.Errorf("stored %v", svc.mailboxes)
+	}
+}
@@ -0,0 +1,86 @@
+package smtprecv
+
+import (
+	"bytes"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/rntrp/mailheap/internal/config"
+	"github.com/rntrp/mailheap/internal/dsn"
+)
+
+// report generates a delivery status notification for the recipients as
+// requested by their NOTIFY parameters. Recipients matching
+// MAILHEAP_DSN_FAILURE_RECIPIENTS or MAILHEAP_DSN_DELAY_RECIPIENTS are
+// reported as failed or delayed, all others as delivered. Depending on
+// MAILHEAP_DSN_MODE, the report is stored like a received mail or relayed to
+// the sender via MAILHEAP_DSN_RELAY_ADDRESS.
+func (s *session) report(rcpts []dsn.Recipient, original []byte, arrival time.Time) {
+	failure, delay := config.GetDSNFailureRecipients(), config.GetDSNDelayRecipients()
+	rep := dsn.Report{
+		ReportingMTA: config.GetSMTPDomain(),
+		From:         s.from,
+		Arrival:      arrival,
+		Recipients:   make([]dsn.Recipient, len(rcpts)),
+	}
+	if s.mailOpts != nil {
+		rep.EnvelopeID, rep.Return = s.mailOpts.EnvelopeID, s.mailOpts.Return
+	}
+	for i, rcpt := range rcpts {
+		switch {
+		case failure != nil && failure.MatchString(rcpt.Addr):
+			rcpt.Action, rcpt.Err = dsn.Failed, rejectedRcpt
+		case delay != nil && delay.MatchString(rcpt.Addr):
+			rcpt.Action, rcpt.Err = dsn.Delayed, deferredRcpt
+		default:
+			rcpt.Action = dsn.Delivered
+		}
+		rep.Recipients[i] = rcpt
+	}
+	b, err := rep.Message(original)
+	if err != nil {
+		slog.Error("SMTP: failed to generate DSN", "uuid", s.uuid, "error", err.Error())
+		return
+	} else if b == nil {
+		return
+	}
+	switch mode := config.GetDSNMode(); mode {
+	case "store":
+		if _, err := s.addMailSvc.DeliverMail(bytes.NewReader(b), s.listener.Mailbox); err != nil {
+			slog.Error("SMTP: failed to store DSN", "uuid", s.uuid, "error", err.Error())
+			return
+		}
+	case "relay":
+		// the session is reset once DATA returns, so its fields are copied
+		uuid, addr, to := s.uuid.String(), config.GetDSNRelayAddress(), s.from
+		s.relays.Add(1)
+		go func() {
+			defer s.relays.Done()
+			relay(uuid, addr, to, b)
+		}()
+	}
+	slog.Info("SMTP DSN", "uuid", s.uuid.String(), "to", s.from,
+		"recipients", len(rep.Notified()))
+}
+
+// relayTimeout limits connecting to the relay and the whole conversation.
+const relayTimeout = 30 * time.Second
+
+// relay sends the report with a null reverse-path, so that it cannot cause
+// another report.
+func relay(uuid, addr, to string, b []byte) {
+	conn, err := net.DialTimeout("tcp", addr, relayTimeout)
+	if err == nil {
+		conn.SetDeadline(time.Now().Add(relayTimeout))
+		c := smtp.NewClient(conn)
+		defer c.Close()
+		if err = c.SendMail("", []string{to}, bytes.NewReader(b)); err == nil {
+			err = c.Quit()
+		}
+	}
+	if err != nil {
+		slog.Error("SMTP: failed to relay DSN", "uuid", uuid, "relay", addr, "error", err.Error())
+	}
+}
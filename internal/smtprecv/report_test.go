@@ -0,0 +1,106 @@
+package smtprecv
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/rntrp/mailheap/internal/config"
+)
+
+func setDSNEnv(t *testing.T, mode string) {
+	t.Helper()
+	t.Setenv("MAILHEAP_SMTP_ENABLE_DSN", "true")
+	t.Setenv("MAILHEAP_DSN_MODE", mode)
+	t.Setenv("MAILHEAP_DSN_FAILURE_RECIPIENTS", "^bounce@")
+	t.Setenv("MAILHEAP_DSN_DELAY_RECIPIENTS", "^later@")
+}
+
+// sendNotify sends a mail to the recipients requesting all notifications.
+func sendNotify(t *testing.T, addr string, rcpts ...string) {
+	t.Helper()
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	notify := &smtp.RcptOptions{Notify: []smtp.DSNNotify{smtp.DSNNotifySuccess,
+		smtp.DSNNotifyFailure, smtp.DSNNotifyDelayed}}
+	if err := c.Mail("alice@example.com", &smtp.MailOptions{EnvelopeID: "env-1"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, rcpt := range rcpts {
+		if err := c.Rcpt(rcpt, notify); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := io.WriteString(w, eml); err != nil {
+		t.Fatal(err)
+	} else if err := w.Close(); err != nil {
+		t.Fatal(err)
+	} else if err := c.Quit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReportStore(t *testing.T) {
+	setDSNEnv(t, "store")
+	addr, svc := serveTCP(t, config.SMTPListener{Mailbox: "dsn"}, nil)
+	sendNotify(t, addr, "bob@example.com", "bounce@example.com", "later@example.com")
+	if len(svc.mails) != 2 || svc.mailboxes[1] != "dsn" {
+		t.Fatalf("stored %v", svc.mailboxes)
+	}
+	rep := svc.mails[1]
+	for _, want := range []string{
+		"To: <alice@example.com>\r\n",
+		"Original-Envelope-Id: env-1\r\n",
+		"Final-Recipient: rfc822; bob@example.com\r\nAction: delivered\r\nStatus: 2.",
+		"Final-Recipient: rfc822; bounce@example.com\r\nAction: failed\r\nStatus: 5.1.1",
+		"Final-Recipient: rfc822; later@example.com\r\nAction: delayed\r\nStatus: 4.2.0",
+	} {
+		if !strings.Contains(rep, want) {
+			t.Errorf("%q missing in report:\n%v", want, rep)
+		}
+	}
+}
+
+func TestReportRelay(t *testing.T) {
+	// the relay receives the report like any other mail
+	relayAddr, relaySvc := serveTCP(t, config.SMTPListener{}, nil)
+	setDSNEnv(t, "relay")
+	t.Setenv("MAILHEAP_DSN_RELAY_ADDRESS", relayAddr)
+	if err := config.LoadQuietly(); err != nil {
+		t.Fatal(err)
+	}
+	svc := new(fakeSvc)
+	srv := New(config.SMTPListener{Network: "tcp"}, svc, nil)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	sendNotify(t, ln.Addr().String(), "bounce@example.com")
+	// the shutdown waits until the report is relayed
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	relaySvc.mtx.Lock()
+	defer relaySvc.mtx.Unlock()
+	if len(svc.mails) != 1 {
+		t.Errorf("stored %v", svc.mailboxes)
+	} else if len(relaySvc.mails) != 1 {
+		t.Fatalf("relayed %v", relaySvc.mailboxes)
+	} else if rep := relaySvc.mails[0]; !strings.Contains(rep, "Action: failed\r\n") ||
+		!strings.Contains(rep, "To: <alice@example.com>\r\n") {
+		t.Errorf("unexpected report:\n%v", rep)
+	}
+}
@@ -0,0 +1,218 @@
+package spam
+
+import (
+	"mime"
+	"net"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/rntrp/mailheap/internal/msg"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+type Hit struct {
+	Rule        string  `json:"rule"`
+	Score       float64 `json:"score"`
+	Description string  `json:"description"`
+	Source      string  `json:"source"`
+}
+
+const sourceBuiltin = "builtin"
+
+type rule struct {
+	name  string
+	score float64
+	desc  string
+	match func(a *analysis) bool
+}
+
+var rules = []rule{
+	{"MISSING_FROM", 1.5, "Message has no From header",
+		func(a *analysis) bool { return len(a.hdr.Get("From")) == 0 }},
+	{"MISSING_TO", 0.5, "Message has no To header",
+		func(a *analysis) bool { return len(a.hdr.Get("To")) == 0 }},
+	{"MISSING_SUBJECT", 1.0, "Message has no or an empty Subject",
+		func(a *analysis) bool { return len(strings.TrimSpace(a.subject)) == 0 }},
+	{"MISSING_MESSAGE_ID", 1.0, "Message has no Message-Id header",
+		func(a *analysis) bool { return len(a.hdr.Get("Message-Id")) == 0 }},
+	{"MISSING_MIME_VERSION", 0.5, "MIME message without MIME-Version header",
+		func(a *analysis) bool {
+			return len(a.hdr.Get("Content-Type")) > 0 && len(a.hdr.Get("Mime-Version")) == 0
+		}},
+	{"DATE_IN_FUTURE", 1.0, "Date header is more than 12 hours in the future",
+		func(a *analysis) bool {
+			d, err := a.hdr.Date()
+			return err == nil && d.After(time.Now().Add(12*time.Hour))
+		}},
+	{"FROM_DISPLAYNAME_SPOOF", 2.0, "From display name contains a different address",
+		func(a *analysis) bool {
+			from, err := mail.ParseAddress(a.hdr.Get("From"))
+			return err == nil && strings.Contains(from.Name, "@") &&
+				!strings.Contains(strings.ToLower(from.Name), strings.ToLower(from.Address))
+		}},
+	{"REPLYTO_DOMAIN_DIFFERS", 0.5, "Reply-To domain differs from From domain",
+		func(a *analysis) bool {
+			from, err := mail.ParseAddress(a.hdr.Get("From"))
+			if err != nil {
+				return false
+			}
+			replyTo, err := mail.ParseAddress(a.hdr.Get("Reply-To"))
+			return err == nil && !strings.EqualFold(domain(from.Address), domain(replyTo.Address))
+		}},
+	{"SUBJECT_ALL_CAPS", 1.5, "Subject is written in capital letters only",
+		func(a *analysis) bool {
+			letters, upper := 0, 0
+			for _, r := range a.subject {
+				if unicode.IsLetter(r) {
+					letters++
+					if unicode.IsUpper(r) {
+						upper++
+					}
+				}
+			}
+			return letters >= 8 && letters == upper
+		}},
+	{"HTML_ONLY", 1.0, "HTML part without plain text alternative",
+		func(a *analysis) bool {
+			return len(a.c.Html) > 0 && len(strings.TrimSpace(a.c.Text)) == 0
+		}},
+	{"HTML_TEXT_RATIO_LOW", 0.8, "HTML part contains little visible text compared to markup",
+		func(a *analysis) bool {
+			return len(a.c.Html) >= 512 && float64(len(a.htmlText))/float64(len(a.c.Html)) < 0.1
+		}},
+	{"HTML_IMAGE_ONLY", 2.0, "HTML part consists of images with hardly any text",
+		func(a *analysis) bool { return a.images > 0 && len(a.htmlText) < 100 }},
+	{"URL_IP_ADDRESS", 1.5, "Message links to a numeric IP address",
+		func(a *analysis) bool {
+			for _, u := range a.urls {
+				if net.ParseIP(u.Hostname()) != nil {
+					return true
+				}
+			}
+			return false
+		}},
+	{"URL_SHORTENER", 1.5, "Message contains links to an URL shortener",
+		func(a *analysis) bool {
+			for _, u := range a.urls {
+				if shorteners[strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")] {
+					return true
+				}
+			}
+			return false
+		}},
+	{"URL_TEXT_MISMATCH", 2.0, "Link text shows a different host than the link target",
+		func(a *analysis) bool { return a.mismatch }},
+}
+
+var shorteners = map[string]bool{
+	"bit.ly": true, "bitly.com": true, "buff.ly": true, "cutt.ly": true,
+	"goo.gl": true, "is.gd": true, "ow.ly": true, "rb.gy": true,
+	"rebrand.ly": true, "shorturl.at": true, "t.co": true, "t.ly": true,
+	"tiny.cc": true, "tinyurl.com": true, "v.gd": true,
+}
+
+type analysis struct {
+	c        *msg.Content
+	hdr      mail.Header
+	subject  string
+	htmlText string
+	images   int
+	urls     []*url.URL
+	mismatch bool
+}
+
+func Check(c *msg.Content) []Hit {
+	a := analyze(c)
+	hits := make([]Hit, 0)
+	for _, r := range rules {
+		if r.match(a) {
+			hits = append(hits, Hit{
+				Rule:        r.name,
+				Score:       r.score,
+				Description: r.desc,
+				Source:      sourceBuiltin,
+			})
+		}
+	}
+	return hits
+}
+
+func analyze(c *msg.Content) *analysis {
+	a := &analysis{c: c, hdr: c.Header}
+	a.subject = c.Header.Get("Subject")
+	if dec, err := new(mime.WordDecoder).DecodeHeader(a.subject); err == nil {
+		a.subject = dec
+	}
+	for _, l := range msg.ExtractLinks(c) {
+		if u, err := url.Parse(l.Url); err == nil {
+			a.urls = append(a.urls, u)
+		}
+	}
+	// every anchor counts, a link may be repeated with a misleading text
+	for _, l := range msg.ExtractAnchors(c.Html) {
+		if u, err := url.Parse(l.Url); err == nil && mismatches(u, l.Text) {
+			a.mismatch = true
+		}
+	}
+	if len(c.Html) > 0 {
+		a.scanHtml()
+	}
+	return a
+}
+
+func (a *analysis) scanHtml() {
+	text := new(strings.Builder)
+	z := html.NewTokenizer(strings.NewReader(a.c.Html))
+	skip := 0
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			a.htmlText = strings.Join(strings.Fields(text.String()), " ")
+			return
+		case html.TextToken:
+			if skip == 0 {
+				text.Write(z.Text())
+				text.WriteByte(' ')
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.Script, atom.Style, atom.Title:
+				skip++
+			case atom.Img:
+				a.images++
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.Script, atom.Style, atom.Title:
+				if skip > 0 {
+					skip--
+				}
+			}
+		}
+	}
+}
+
+func mismatches(href *url.URL, anchorText string) bool {
+	s := strings.TrimSpace(anchorText)
+	if !strings.Contains(s, "://") {
+		s = "http://" + s
+	}
+	shown, err := url.Parse(s)
+	if err != nil || !strings.Contains(shown.Hostname(), ".") || strings.ContainsAny(shown.Hostname(), " \t") {
+		return false
+	}
+	return !strings.EqualFold(shown.Hostname(), href.Hostname())
+}
+
+func domain(address string) string {
+	if i := strings.LastIndexByte(address, '@'); i >= 0 {
+		return address[i+1:]
+	}
+	return address
+}
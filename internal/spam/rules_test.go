@@ -0,0 +1,93 @@
+package spam
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/rntrp/mailheap/internal/msg"
+)
+
+const spammy = "From: \"support@paypal.com\" <noreply@evil.example.net>\r\n" +
+	"Reply-To: claims@other.example.org\r\n" +
+	"To: bob@example.com\r\n" +
+	"Subject: =?utf-8?q?FREE_MONEY_N=C3=96W?=\r\n" +
+	"Date: Mon, 19 Oct 2026 11:39:27 -0000\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n\r\n" +
+	"<p><a href=\"http://evil.example.net/login\">www.paypal.com</a>" +
+	"<a href=\"https://bit.ly/x\">Claim</a><a href=\"http://192.0.2.1/\">Now</a>" +
+	"<img src=\"https://evil.example.net/offer.png\"></p>\r\n"
+
+const ham = "From: Shop <shop@example.com>\r\n" +
+	"To: bob@example.com\r\n" +
+	"Subject: Your order\r\n" +
+	"Message-Id: <1@example.com>\r\n" +
+	"Date: Mon, 19 Oct 2026 11:39:27 -0000\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/alternative; boundary=b\r\n\r\n" +
+	"--b\r\nContent-Type: text/plain\r\n\r\nSee https://www.example.com/orders/1\r\n" +
+	"--b\r\nContent-Type: text/html\r\n\r\n" +
+	"<p>See <a href=\"https://www.example.com/orders/1\">www.example.com</a> " +
+	"for the details of your order.</p>\r\n" +
+	"--b--\r\n"
+
+func rulesOf(hits []Hit) []string {
+	names := make([]string, len(hits))
+	for i, h := range hits {
+		names[i] = h.Rule
+	}
+	return names
+}
+
+func TestCheck(t *testing.T) {
+	for _, tc := range []struct {
+		name, eml string
+		rules     []string
+	}{
+		{"spammy", spammy, []string{"MISSING_MESSAGE_ID", "FROM_DISPLAYNAME_SPOOF", "REPLYTO_DOMAIN_DIFFERS",
+			"SUBJECT_ALL_CAPS", "HTML_ONLY", "HTML_IMAGE_ONLY", "URL_IP_ADDRESS", "URL_SHORTENER",
+			"URL_TEXT_MISMATCH"}},
+		{"ham", ham, []string{}},
+		// the mismatching text of a repeated link counts as well
+		{"repeated link", "From: shop@example.com\r\nTo: bob@example.com\r\nSubject: Order\r\n" +
+			"Message-Id: <1@example.com>\r\nMIME-Version: 1.0\r\nContent-Type: text/html\r\n\r\n" +
+			"<p>Your order: <a href=\"https://evil.example.net/\">click here</a> or visit " +
+			"<a href=\"https://evil.example.net/\">www.example.com</a></p>\r\n",
+			[]string{"HTML_ONLY", "URL_TEXT_MISMATCH"}},
+		{"empty", "X-Empty: yes\r\n\r\n", []string{"MISSING_FROM", "MISSING_TO", "MISSING_SUBJECT",
+			"MISSING_MESSAGE_ID"}},
+	} {
+		c, err := msg.Decode([]byte(tc.eml))
+		if err != nil {
+			t.Fatal(err)
+		}
+		hits := Check(c)
+		if got := rulesOf(hits); !slices.Equal(got, tc.rules) {
+			t.Errorf("%v: got %v, want %v", tc.name, got, tc.rules)
+		}
+		for _, h := range hits {
+			if h.Score <= 0 || h.Source != sourceBuiltin || len(h.Description) == 0 {
+				t.Errorf("%v: unexpected hit %+v", tc.name, h)
+			}
+		}
+	}
+}
+
+func TestMismatches(t *testing.T) {
+	for _, tc := range []struct {
+		href, text string
+		want       bool
+	}{
+		{"https://www.example.com/a", "www.example.com", false},
+		{"https://www.example.com/a", "https://WWW.EXAMPLE.COM/b", false},
+		{"https://evil.example.net/", "www.example.com", true},
+		{"https://evil.example.net/", "Click here", false},
+		{"https://evil.example.net/", "Sign in at example.com now", false},
+	} {
+		href, _ := url.Parse(tc.href)
+		if got := mismatches(href, tc.text); got != tc.want {
+			t.Errorf("%v %q: got %v", tc.href, tc.text, got)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package spam
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const sourceSpamd = "spamd"
+
+type spamd struct {
+	addr    string
+	timeout time.Duration
+}
+
+type spamdResult struct {
+	score float64
+	hits  []Hit
+}
+
+var reportLine = regexp.MustCompile(`^\s*(-?\d+(?:\.\d+)?)\s+([A-Za-z0-9_]+)\s+(.*)$`)
+
+// report submits the raw message using the REPORT command of the spamc
+// protocol, which yields the total score along with a table of matched rules.
+func (s *spamd) report(raw []byte) (spamdResult, error) {
+	res := spamdResult{}
+	conn, err := net.DialTimeout("tcp", s.addr, s.timeout)
+	if err != nil {
+		return res, fmt.Errorf("spamd connection failed: %w", err)
+	}
+	defer conn.Close()
+	if s.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(s.timeout))
+	}
+	if _, err := fmt.Fprintf(conn, "REPORT SPAMC/1.5\r\nContent-length: %d\r\n\r\n", len(raw)); err != nil {
+		return res, fmt.Errorf("spamd request failed: %w", err)
+	} else if _, err := conn.Write(raw); err != nil {
+		return res, fmt.Errorf("spamd request failed: %w", err)
+	} else if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.CloseWrite()
+	}
+	return parseReport(bufio.NewReader(conn))
+}
+
+func parseReport(r *bufio.Reader) (spamdResult, error) {
+	res := spamdResult{}
+	status, err := r.ReadString('\n')
+	if err != nil {
+		return res, fmt.Errorf("spamd response failed: %w", err)
+	}
+	if f := strings.Fields(status); len(f) < 3 || !strings.HasPrefix(f[0], "SPAMD/") {
+		return res, fmt.Errorf("spamd response malformed: %q", status)
+	} else if f[1] != "0" {
+		return res, fmt.Errorf("spamd responded with error: %v", strings.Join(f[1:], " "))
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return res, fmt.Errorf("spamd response headers failed: %w", err)
+		}
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			break
+		}
+		name, value, _ := strings.Cut(line, ":")
+		if !strings.EqualFold(name, "Spam") {
+			continue
+		}
+		// Spam: True ; 15.3 / 5.0
+		_, value, _ = strings.Cut(value, ";")
+		value, _, _ = strings.Cut(value, "/")
+		if res.score, err = strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil {
+			return res, fmt.Errorf("spamd score malformed: %w", err)
+		}
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return res, fmt.Errorf("spamd report failed: %w", err)
+	}
+	table := false
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimRight(line, "\r")
+		switch {
+		case strings.HasPrefix(line, "---- "):
+			table = true
+		case !table:
+		case reportLine.MatchString(line):
+			m := reportLine.FindStringSubmatch(line)
+			score, _ := strconv.ParseFloat(m[1], 64)
+			res.hits = append(res.hits, Hit{
+				Rule:        m[2],
+				Score:       score,
+				Description: strings.TrimSpace(m[3]),
+				Source:      sourceSpamd,
+			})
+		case len(res.hits) > 0 && len(strings.TrimSpace(line)) > 0:
+			last := &res.hits[len(res.hits)-1]
+			last.Description += " " + strings.TrimSpace(line)
+		}
+	}
+	return res, nil
+}
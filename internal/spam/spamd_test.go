@@ -0,0 +1,121 @@
+package spam
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rntrp/mailheap/internal/model"
+	"github.com/rntrp/mailheap/internal/msg"
+)
+
+const spamdReport = "SPAMD/1.1 0 EX_OK\r\n" +
+	"Content-length: 402\r\n" +
+	"Spam: True ; 15.3 / 5.0\r\n\r\n" +
+	"Spam detection software has identified this incoming email as possible spam.\r\n\r\n" +
+	"Content analysis details:   (15.3 points, 5.0 required)\r\n\r\n" +
+	" pts rule name              description\r\n" +
+	"---- ---------------------- --------------------------------------------------\r\n" +
+	" 3.5 BAYES_99               BODY: Bayes spam probability is 99 to 100%\r\n" +
+	"                            [score: 1.0000]\r\n" +
+	"-0.1 DKIM_VALID             Message has at least one valid DKIM or DK signature\r\n"
+
+// fakeSpamd answers a single REPORT request with the response and returns
+// its address along with the channel of the received message.
+func fakeSpamd(t *testing.T, response string) (string, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := textproto.NewReader(bufio.NewReader(conn))
+		if line, err := r.ReadLine(); err != nil || line != "REPORT SPAMC/1.5" {
+			t.Errorf("unexpected request %q: %v", line, err)
+			return
+		}
+		hdr, err := r.ReadMIMEHeader()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		n, _ := strconv.Atoi(hdr.Get("Content-Length"))
+		body := make([]byte, n)
+		if _, err := io.ReadFull(r.R, body); err != nil {
+			t.Error(err)
+			return
+		}
+		received <- string(body)
+		io.WriteString(conn, response)
+	}()
+	return ln.Addr().String(), received
+}
+
+func TestParseReport(t *testing.T) {
+	res, err := parseReport(bufio.NewReader(strings.NewReader(spamdReport)))
+	if err != nil {
+		t.Fatal(err)
+	} else if res.score != 15.3 || len(res.hits) != 2 {
+		t.Fatalf("unexpected result %+v", res)
+	} else if h := res.hits[0]; h.Rule != "BAYES_99" || h.Score != 3.5 || h.Source != sourceSpamd ||
+		h.Description != "BODY: Bayes spam probability is 99 to 100% [score: 1.0000]" {
+		t.Errorf("unexpected hit %+v", h)
+	} else if h := res.hits[1]; h.Rule != "DKIM_VALID" || h.Score != -0.1 {
+		t.Errorf("unexpected hit %+v", h)
+	}
+	for _, response := range []string{
+		"",
+		"HTTP/1.1 200 OK\r\n\r\n",
+		"SPAMD/1.0 76 Bad header line: (Content-Length mismatch)\r\n\r\n",
+		"SPAMD/1.1 0 EX_OK\r\nSpam: True ; many / 5.0\r\n\r\n",
+		"SPAMD/1.1 0 EX_OK\r\nSpam: False ; 0.0 / 5.0\r\n",
+	} {
+		if _, err := parseReport(bufio.NewReader(strings.NewReader(response))); err == nil {
+			t.Errorf("%q: no error", response)
+		}
+	}
+}
+
+func TestStage(t *testing.T) {
+	c, err := msg.Decode([]byte(spammy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	builtin := 0.0
+	for _, h := range Check(c) {
+		builtin += h.Score
+	}
+	addr, received := fakeSpamd(t, spamdReport)
+	m := &model.Mail{Mime: spammy}
+	if err := NewStage(addr, time.Second).Process(m, c); err != nil {
+		t.Fatal(err)
+	} else if got := <-received; got != spammy {
+		t.Errorf("spamd received %q", got)
+	}
+	if m.SpamScore != builtin+15.3 {
+		t.Errorf("unexpected score %v, builtin %v", m.SpamScore, builtin)
+	} else if !strings.Contains(m.SpamRules, `"rule":"URL_SHORTENER"`) ||
+		!strings.Contains(m.SpamRules, `{"rule":"BAYES_99","score":3.5,`) {
+		t.Errorf("unexpected rules %v", m.SpamRules)
+	}
+	// the built-in score is kept if spamd fails
+	addr, _ = fakeSpamd(t, "SPAMD/1.0 76 Bad header line\r\n")
+	m = &model.Mail{Mime: spammy}
+	if err := NewStage(addr, time.Second).Process(m, c); err == nil {
+		t.Errorf("spamd error ignored")
+	} else if m.SpamScore != builtin || strings.Contains(m.SpamRules, sourceSpamd) {
+		t.Errorf("unexpected result %v %v", m.SpamScore, m.SpamRules)
+	}
+}
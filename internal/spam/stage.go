@@ -0,0 +1,46 @@
+package spam
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/rntrp/mailheap/internal/model"
+	"github.com/rntrp/mailheap/internal/msg"
+)
+
+type stage struct {
+	spamd *spamd
+}
+
+// NewStage returns a processing stage which scores incoming mail with the
+// built-in rules and, if spamdAddr is not empty, with a SpamAssassin spamd.
+func NewStage(spamdAddr string, spamdTimeout time.Duration) msg.Stage {
+	s := new(stage)
+	if len(spamdAddr) > 0 {
+		s.spamd = &spamd{addr: spamdAddr, timeout: spamdTimeout}
+	}
+	return s
+}
+
+func (s *stage) Process(m *model.Mail, c *msg.Content) error {
+	hits := Check(c)
+	score := 0.0
+	for _, h := range hits {
+		score += h.Score
+	}
+	var err error
+	if s.spamd != nil {
+		var res spamdResult
+		if res, err = s.spamd.report([]byte(m.Mime)); err == nil {
+			hits = append(hits, res.hits...)
+			score += res.score
+		}
+	}
+	b, jsonErr := json.Marshal(hits)
+	if jsonErr != nil {
+		return jsonErr
+	}
+	m.SpamScore = score
+	m.SpamRules = string(b)
+	return err
+}
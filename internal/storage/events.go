@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"log/slog"
+	"sync"
+)
+
+type EventType int
+
+const (
+	EventAdded EventType = iota
+	EventDeleted
+	EventUpdated
+	// EventOverflow is the last event of a subscriber which could not keep
+	// up; its channel is closed afterwards. Subscribers must assume that
+	// they missed events and subscribe again to resync.
+	EventOverflow
+)
+
+// Event notifies subscribers about changes to the stored mails. Ids is nil
+// if all mails have been deleted.
+type Event struct {
+	Type EventType
+	Ids  []int64
+}
+
+const eventBufferSize = 64
+
+type broker struct {
+	mtx    sync.Mutex
+	next   int
+	subs   map[int]chan Event
+	closed bool
+}
+
+func (b *broker) subscribe() (<-chan Event, func()) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.subs == nil {
+		b.subs = make(map[int]chan Event)
+	}
+	id := b.next
+	b.next++
+	ch := make(chan Event, eventBufferSize)
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	b.subs[id] = ch
+	return ch, func() {
+		b.mtx.Lock()
+		defer b.mtx.Unlock()
+		if ch, ok := b.subs[id]; ok {
+			delete(b.subs, id)
+			close(ch)
+		}
+	}
+}
+
+// publish never blocks. Instead of dropping events, subscribers lagging
+// behind get EventOverflow in the last free slot of their buffer and are
+// unsubscribed. Only publish sends while holding the lock, so the buffer
+// cannot fill up between the check and the send.
+func (b *broker) publish(e Event) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	for id, ch := range b.subs {
+		if len(ch) < cap(ch)-1 {
+			ch <- e
+			continue
+		}
+		slog.Warn("Unsubscribing slow subscriber from storage events")
+		ch <- Event{Type: EventOverflow}
+		delete(b.subs, id)
+		close(ch)
+	}
+}
+
+func (b *broker) close() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.closed = true
+	for id, ch := range b.subs {
+		delete(b.subs, id)
+		close(ch)
+	}
+}
@@ -1,43 +1,199 @@
 package storage
 
 import (
+	"encoding/json"
+	"errors"
 	"log/slog"
 	"os"
-	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/glebarez/sqlite"
+	"github.com/rntrp/mailheap/internal/config"
 	"github.com/rntrp/mailheap/internal/idsrc"
 	"github.com/rntrp/mailheap/internal/model"
 	"gorm.io/gorm"
 )
 
+var ErrNotFound = gorm.ErrRecordNotFound
+
 type MailStorage interface {
-	AddMail(mail model.Mail) error
-	CountMails() (int64, error)
+	AddDelivery(d model.Delivery) error
+	AddMail(mail model.Mail) (int64, error)
+	CountMails(f Filter) (int64, error)
 	DeleteAllMails() (int64, error)
 	DeleteMails(ids ...int64) (int64, error)
+	FindLatestMail(f Filter) (model.Mail, error)
+	GetLinks(id int64) ([]model.Link, error)
 	GetMime(id int64) (string, error)
-	SeekMails(int64, int) ([]model.Mail, error)
+	ImportMail(mail model.Mail) (int64, error)
+	ListDeliveries(webhook string, limit int) ([]model.Delivery, error)
+	ListMails() ([]model.Mail, error)
+	SeekMails(afterId int64, limit int, f Filter) ([]model.Mail, error)
 	Shutdown() error
+	Subscribe() (<-chan Event, func())
+	UpdateState(f Filter, u StateUpdate) (int64, error)
+	WalkMails(f Filter, fn func(model.Mail) error) error
+}
+
+type Filter struct {
+	Ids     []int64
+	To      string
+	From    string
+	Subject string
+	Since   time.Time
+	Until   time.Time
+	Seen    *bool
+	Starred *bool
+	Tag     string
+	Mailbox string
+}
+
+// IsZero reports whether the filter is empty and thus matches all mails.
+func (f Filter) IsZero() bool {
+	return len(f.Ids) == 0 && len(f.To) == 0 && len(f.From) == 0 && len(f.Subject) == 0 &&
+		f.Since.IsZero() && f.Until.IsZero() && f.Seen == nil && f.Starred == nil &&
+		len(f.Tag) == 0 && len(f.Mailbox) == 0
+}
+
+// StateUpdate describes changes to the state of mails. Nil fields are left
+// unchanged; Tags replaces all tags before AddTags and RemoveTags apply.
+type StateUpdate struct {
+	Seen       *bool
+	Starred    *bool
+	Tags       []string
+	AddTags    []string
+	RemoveTags []string
+}
+
+func (u StateUpdate) changesTags() bool {
+	return u.Tags != nil || len(u.AddTags) > 0 || len(u.RemoveTags) > 0
+}
+
+func (u StateUpdate) apply(tags []string) []string {
+	if u.Tags != nil {
+		tags = u.Tags
+	}
+	res := make([]string, 0, len(tags)+len(u.AddTags))
+	for _, t := range append(slices.Clone(tags), u.AddTags...) {
+		if t = strings.TrimSpace(t); len(t) > 0 &&
+			!slices.Contains(res, t) && !slices.Contains(u.RemoveTags, t) {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+func (f Filter) apply(db *gorm.DB) *gorm.DB {
+	if len(f.Ids) > 0 {
+		db = db.Where("id IN ?", f.Ids)
+	}
+	if to := strings.TrimSpace(f.To); len(to) > 0 {
+		// recipients are stored as JSON arrays of "addr" or "Name <addr>", so
+		// the address must be enclosed in quotes or angle brackets
+		addr := likeEscaper.Replace(jsonString(to))
+		bare, named := "%"+addr+"%", "%<"+addr[1:len(addr)-1]+`>"%`
+		db = db.Where("(`to` LIKE ? ESCAPE '\\' OR `to` LIKE ? ESCAPE '\\' OR "+
+			"cc LIKE ? ESCAPE '\\' OR cc LIKE ? ESCAPE '\\' OR "+
+			"bcc LIKE ? ESCAPE '\\' OR bcc LIKE ? ESCAPE '\\')",
+			bare, named, bare, named, bare, named)
+	}
+	if len(f.From) > 0 {
+		db = db.Where("`from` LIKE ? ESCAPE '\\'", like(f.From))
+	}
+	if len(f.Subject) > 0 {
+		db = db.Where("subject LIKE ? ESCAPE '\\'", like(f.Subject))
+	}
+	if !f.Since.IsZero() {
+		db = db.Where("created>=?", f.Since)
+	}
+	if !f.Until.IsZero() {
+		db = db.Where("created<?", f.Until)
+	}
+	if f.Seen != nil {
+		db = db.Where("seen=?", *f.Seen)
+	}
+	if f.Starred != nil {
+		db = db.Where("starred=?", *f.Starred)
+	}
+	if len(f.Tag) > 0 {
+		// tags are stored as a JSON array, so the quoted tag must match
+		if b, err := json.Marshal(f.Tag); err == nil {
+			db = db.Where("tags LIKE ? ESCAPE '\\'", like(string(b)))
+		}
+	}
+	if len(f.Mailbox) > 0 {
+		db = db.Where("mailbox=?", f.Mailbox)
+	}
+	return db
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func like(s string) string {
+	return "%" + likeEscaper.Replace(s) + "%"
+}
+
+// jsonString quotes s like msg does when storing addresses, i.e. without
+// escaping HTML characters.
+func jsonString(s string) string {
+	b := new(strings.Builder)
+	enc := json.NewEncoder(b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSpace(b.String())
 }
 
 type store struct {
-	db    *gorm.DB
-	idSrc idsrc.IdSrc
+	db     *gorm.DB
+	idSrc  idsrc.IdSrc
+	events broker
 }
 
 func New() (MailStorage, error) {
-	path := filepath.Join(os.TempDir(), "mailheap.db")
+	path := config.GetDBLocation()
 	f, err := os.OpenFile(path, os.O_CREATE, 0600)
 	if err != nil {
 		return nil, err
 	} else if err := f.Close(); err != nil {
 		slog.Warn("Closing db file failed:", "error", err.Error())
 	}
-	db, err := gorm.Open(sqlite.Open(path), new(gorm.Config))
+	// transactions take the write lock up front, so that concurrent read and
+	// write transactions like ImportMail wait instead of failing as busy
+	dsn := path + "?_txlock=immediate&_pragma=busy_timeout(5000)"
+	db, err := gorm.Open(sqlite.Open(dsn), newGormConfig())
 	if err != nil {
 		return nil, err
-	} else if err := db.AutoMigrate(new(model.Mail)); err != nil {
+	}
+	return open(db)
+}
+
+// NewInMemory creates a storage which lives in memory until Shutdown. Since
+// every connection to an in-memory database gets a database of its own, the
+// connection pool is limited to a single connection.
+func NewInMemory() (MailStorage, error) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), newGormConfig())
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(1)
+	return open(db)
+}
+
+// newGormConfig translates unique constraint violations into
+// gorm.ErrDuplicatedKey.
+func newGormConfig() *gorm.Config {
+	return &gorm.Config{TranslateError: true}
+}
+
+func open(db *gorm.DB) (MailStorage, error) {
+	err := db.AutoMigrate(new(model.Mail), new(model.Link), new(model.Delivery), new(model.IdMark))
+	if err != nil {
 		return nil, err
 	}
 	return &store{
@@ -46,30 +202,165 @@ func New() (MailStorage, error) {
 	}, nil
 }
 
-func (s *store) AddMail(mail model.Mail) error {
+func (s *store) AddMail(mail model.Mail) (int64, error) {
 	id, err := s.idSrc.Gen()
 	if err != nil {
-		return err
+		return 0, err
 	}
 	mail.Id = id
-	s.db.Create(&mail)
+	return id, s.create(&mail)
+}
+
+// ImportMail stores the mail with an ID derived from its Created time, so
+// that imported mails keep their original order. Mails created within the
+// same millisecond take the next free counter value; if the counter space
+// is exhausted, the following milliseconds are tried. Recent mails get a
+// regular ID in order not to collide with concurrently received mails.
+// IDs of deleted mails are never handed out again.
+func (s *store) ImportMail(mail model.Mail) (int64, error) {
+	if time.Since(mail.Created) < time.Second {
+		return s.AddMail(mail)
+	}
+	start, err := importId(mail.Created)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			id, err := freeId(tx, start)
+			if err != nil {
+				return err
+			}
+			mail.Id = id
+			return tx.Create(&mail).Error
+		})
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			continue // taken by a concurrent import in the meantime
+		} else if err != nil {
+			return 0, err
+		}
+		s.events.publish(Event{Type: EventAdded, Ids: []int64{mail.Id}})
+		return mail.Id, nil
+	}
+}
+
+// importId returns the preferred ID of a mail created at t. Mails predating
+// the ID epoch take the IDs from 1 onwards by their Unix second, which keeps
+// their order, but interleaves them with mails of the first two hours of the
+// epoch.
+func importId(t time.Time) (int64, error) {
+	if !t.Before(idsrc.Epoch()) {
+		return idsrc.Encode(t, 0)
+	}
+	return max(t.Unix(), 1), nil
+}
+
+// freeId returns the lowest ID from the preferred one onwards, which is
+// neither taken nor above the highest ID of a deleted mail in its block of
+// 256 IDs. If the block is exhausted, the following blocks are tried.
+func freeId(tx *gorm.DB, id int64) (int64, error) {
+	for {
+		block := id >> 8
+		marks := make([]model.IdMark, 0, 1)
+		err := tx.Where("block=?", block).Find(&marks).Error
+		if err != nil {
+			return 0, err
+		} else if len(marks) > 0 {
+			id = max(id, marks[0].Last+1)
+		}
+		var ids []int64
+		err = tx.Model(new(model.Mail)).
+			Where("id BETWEEN ? AND ?", id, block<<8|0xff).
+			Order("id").
+			Pluck(model.Id, &ids).
+			Error
+		if err != nil {
+			return 0, err
+		}
+		for _, taken := range ids {
+			if taken != id {
+				break
+			}
+			id++
+		}
+		if id <= block<<8|0xff {
+			return id, nil
+		}
+		id = (block + 1) << 8
+	}
+}
+
+// markDeleted records the highest IDs of the deleted mails per block. The
+// mails must match the where clause of the mails table.
+func markDeleted(tx *gorm.DB, where string, args ...any) error {
+	return tx.Exec("INSERT INTO id_marks (block, last) "+
+		"SELECT id >> 8, MAX(id) FROM mails WHERE "+where+" GROUP BY id >> 8 "+
+		"ON CONFLICT (block) DO UPDATE SET last=MAX(last, excluded.last)", args...).Error
+}
+
+func (s *store) create(mail *model.Mail) error {
+	if err := s.db.Create(mail).Error; err != nil {
+		return err
+	}
+	s.events.publish(Event{Type: EventAdded, Ids: []int64{mail.Id}})
 	return nil
 }
 
-func (s *store) CountMails() (int64, error) {
+func (s *store) CountMails(f Filter) (int64, error) {
 	cnt := int64(0)
-	err := s.db.Model(new(model.Mail)).Count(&cnt).Error
+	err := f.apply(s.db.Model(new(model.Mail))).Count(&cnt).Error
 	return cnt, err
 }
 
 func (s *store) DeleteAllMails() (int64, error) {
-	tx := s.db.Delete(new(model.Mail), "id>=?", 0)
-	return tx.RowsAffected, tx.Error
+	cnt := int64(0)
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(new(model.Link), "mail_id>=?", 0).Error; err != nil {
+			return err
+		} else if err := markDeleted(tx, "id>=?", 0); err != nil {
+			return err
+		}
+		res := tx.Delete(new(model.Mail), "id>=?", 0)
+		cnt = res.RowsAffected
+		return res.Error
+	})
+	if err == nil && cnt > 0 {
+		s.events.publish(Event{Type: EventDeleted})
+	}
+	return cnt, err
 }
 
 func (s *store) DeleteMails(ids ...int64) (int64, error) {
-	tx := s.db.Delete(new(model.Mail), ids)
-	return tx.RowsAffected, tx.Error
+	cnt := int64(0)
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(new(model.Link), "mail_id IN ?", ids).Error; err != nil {
+			return err
+		} else if err := markDeleted(tx, "id IN ?", ids); err != nil {
+			return err
+		}
+		res := tx.Delete(new(model.Mail), ids)
+		cnt = res.RowsAffected
+		return res.Error
+	})
+	if err == nil && cnt > 0 {
+		s.events.publish(Event{Type: EventDeleted, Ids: ids})
+	}
+	return cnt, err
+}
+
+func (s *store) FindLatestMail(f Filter) (model.Mail, error) {
+	m := model.Mail{}
+	err := f.apply(s.db).Order("id DESC").Take(&m).Error
+	return m, err
+}
+
+func (s *store) GetLinks(id int64) ([]model.Link, error) {
+	if err := s.db.Select(model.Id).First(new(model.Mail), id).Error; err != nil {
+		return nil, err
+	}
+	links := make([]model.Link, 0)
+	err := s.db.Order("id").Find(&links, "mail_id=?", id).Error
+	return links, err
 }
 
 func (s *store) GetMime(id int64) (string, error) {
@@ -78,16 +369,121 @@ func (s *store) GetMime(id int64) (string, error) {
 	return m.Mime, err
 }
 
-func (s *store) SeekMails(afterId int64, limit int) ([]model.Mail, error) {
+// ListMails returns the basic attributes of all mails in ascending id order.
+func (s *store) ListMails() ([]model.Mail, error) {
+	mails := make([]model.Mail, 0)
+	err := s.db.Select(model.BasicMail).Order("id").Find(&mails).Error
+	return mails, err
+}
+
+func (s *store) SeekMails(afterId int64, limit int, f Filter) ([]model.Mail, error) {
 	mails := make([]model.Mail, 0, limit)
-	err := s.db.Order("id DESC").
+	err := f.apply(s.db).Order("id DESC").
 		Limit(limit).
 		Find(&mails, "id<?", afterId).
 		Error
 	return mails, err
 }
 
+// WalkMails calls fn for every mail matching f in ascending id order. Mails
+// are fetched in batches, so that only one batch is held in memory.
+func (s *store) WalkMails(f Filter, fn func(model.Mail) error) error {
+	const batchSize = 50
+	lastId := int64(-1)
+	for {
+		mails := make([]model.Mail, 0, batchSize)
+		err := f.apply(s.db).Order("id").
+			Limit(batchSize).
+			Find(&mails, "id>?", lastId).
+			Error
+		if err != nil {
+			return err
+		}
+		for _, m := range mails {
+			if err := fn(m); err != nil {
+				return err
+			}
+		}
+		if len(mails) < batchSize {
+			return nil
+		}
+		lastId = mails[len(mails)-1].Id
+	}
+}
+
+func (s *store) UpdateState(f Filter, u StateUpdate) (int64, error) {
+	ids := make([]int64, 0)
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		mails := make([]model.Mail, 0)
+		if err := f.apply(tx).Select(model.Id, "tags").Find(&mails).Error; err != nil {
+			return err
+		} else if len(mails) == 0 {
+			return nil
+		}
+		for _, m := range mails {
+			ids = append(ids, m.Id)
+		}
+		cols := make(map[string]any)
+		if u.Seen != nil {
+			cols["seen"] = *u.Seen
+		}
+		if u.Starred != nil {
+			cols["starred"] = *u.Starred
+		}
+		if len(cols) > 0 {
+			err := tx.Model(new(model.Mail)).Where("id IN ?", ids).Updates(cols).Error
+			if err != nil {
+				return err
+			}
+		}
+		if !u.changesTags() {
+			return nil
+		}
+		for _, m := range mails {
+			tags := make([]string, 0)
+			json.Unmarshal([]byte(m.Tags), &tags)
+			b, err := json.Marshal(u.apply(tags))
+			if err != nil {
+				return err
+			} else if string(b) == m.Tags {
+				continue
+			}
+			err = tx.Model(new(model.Mail)).Where("id=?", m.Id).Update("tags", string(b)).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	} else if len(ids) > 0 {
+		s.events.publish(Event{Type: EventUpdated, Ids: ids})
+	}
+	return int64(len(ids)), nil
+}
+
+func (s *store) AddDelivery(d model.Delivery) error {
+	return s.db.Create(&d).Error
+}
+
+// ListDeliveries returns the latest deliveries, optionally only those of the
+// given webhook.
+func (s *store) ListDeliveries(webhook string, limit int) ([]model.Delivery, error) {
+	deliveries := make([]model.Delivery, 0, limit)
+	tx := s.db.Order("id DESC").Limit(limit)
+	if len(webhook) > 0 {
+		tx = tx.Where("webhook = ?", webhook)
+	}
+	return deliveries, tx.Find(&deliveries).Error
+}
+
+func (s *store) Subscribe() (<-chan Event, func()) {
+	return s.events.subscribe()
+}
+
 func (s *store) Shutdown() error {
+	s.events.close()
 	if db, err := s.db.DB(); err != nil {
 		return err
 	} else {
@@ -0,0 +1,388 @@
+package storage
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rntrp/mailheap/internal/config"
+	"github.com/rntrp/mailheap/internal/idsrc"
+	"github.com/rntrp/mailheap/internal/model"
+)
+
+func TestImportMailIds(t *testing.T) {
+	s, err := NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown()
+	created := time.UnixMilli(time.Now().Add(-time.Hour).UnixMilli())
+	base, _ := idsrc.Encode(created, 0)
+	next, _ := idsrc.Encode(created.Add(time.Millisecond), 0)
+	// the counter of the millisecond is exhausted after 256 mails
+	for i := range 258 {
+		id, err := s.ImportMail(model.Mail{Created: created, Subject: "same"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := base + int64(i)
+		if i >= 256 {
+			want = next + int64(i-256)
+		}
+		if id != want {
+			t.Fatalf("mail %v: got id %v, want %v", i, id, want)
+		}
+	}
+	// a mail of the next millisecond continues after the rolled over ones
+	if id, err := s.ImportMail(model.Mail{Created: created.Add(time.Millisecond)}); err != nil {
+		t.Fatal(err)
+	} else if id != next+2 {
+		t.Errorf("got id %v, want %v", id, next+2)
+	}
+	earlier := created.Add(-time.Minute)
+	if id, err := s.ImportMail(model.Mail{Created: earlier}); err != nil {
+		t.Fatal(err)
+	} else if decoded, _, _ := idsrc.Decode(id); !decoded.Equal(earlier) {
+		t.Errorf("unexpected id time %v", decoded)
+	}
+	before := time.Now().Add(-time.Millisecond)
+	if id, err := s.ImportMail(model.Mail{Created: time.Now()}); err != nil {
+		t.Fatal(err)
+	} else if decoded, _, _ := idsrc.Decode(id); decoded.Before(before) {
+		t.Errorf("recent mail got id of %v", decoded)
+	}
+}
+
+func TestImportMailNoReuse(t *testing.T) {
+	s, err := NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown()
+	created := time.UnixMilli(time.Now().Add(-time.Hour).UnixMilli())
+	base, _ := idsrc.Encode(created, 0)
+	importAt := func(created time.Time) int64 {
+		t.Helper()
+		id, err := s.ImportMail(model.Mail{Created: created})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	importAt(created)
+	if id := importAt(created); id != base+1 {
+		t.Fatalf("got id %v, want %v", id, base+1)
+	} else if _, err := s.DeleteMails(id); err != nil {
+		t.Fatal(err)
+	}
+	if id := importAt(created); id != base+2 {
+		t.Errorf("got id %v, want %v", id, base+2)
+	}
+	if _, err := s.DeleteAllMails(); err != nil {
+		t.Fatal(err)
+	}
+	if id := importAt(created); id != base+3 {
+		t.Errorf("got id %v, want %v", id, base+3)
+	}
+	// the preferred ID of a deleted mail is not taken again either
+	later := created.Add(time.Second)
+	id := importAt(later)
+	if _, err := s.DeleteMails(id); err != nil {
+		t.Fatal(err)
+	} else if again := importAt(later); again != id+1 {
+		t.Errorf("got id %v, want %v", again, id+1)
+	}
+}
+
+func TestImportMailBeforeEpoch(t *testing.T) {
+	s, err := NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown()
+	newer := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	older := newer.Add(-30 * time.Second)
+	ids := make([]int64, 0)
+	for _, created := range []time.Time{newer, older, older, time.Date(1950, 1, 1, 0, 0, 0, 0, time.UTC)} {
+		id, err := s.ImportMail(model.Mail{Created: created})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if !(ids[3] < ids[1] && ids[1] < ids[2] && ids[2] < ids[0]) {
+		t.Errorf("ids not in date order: %v", ids)
+	}
+	epoch, _ := idsrc.Encode(idsrc.Epoch().Add(3*time.Hour), 0)
+	if ids[0] >= epoch {
+		t.Errorf("id %v after the first hours of the epoch", ids[0])
+	}
+}
+
+func TestImportMailConcurrent(t *testing.T) {
+	t.Setenv("MAILHEAP_ENV", "test")
+	t.Setenv("MAILHEAP_DB_LOCATION", filepath.Join(t.TempDir(), "mailheap.db"))
+	if err := config.LoadQuietly(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown()
+	created := time.Now().Add(-time.Hour)
+	const routines, mails = 8, 20
+	ids := make(chan int64, routines*mails)
+	var wg sync.WaitGroup
+	for range routines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range mails {
+				id, err := s.ImportMail(model.Mail{Created: created})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				ids <- id
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+	seen := make(map[int64]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Errorf("duplicate id %v", id)
+		}
+		seen[id] = true
+	}
+	if n, err := s.CountMails(Filter{}); err != nil || n != routines*mails {
+		t.Errorf("unexpected count %v: %v", n, err)
+	}
+}
+
+func TestNewDBLocation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "custom.db")
+	t.Setenv("MAILHEAP_ENV", "test")
+	t.Setenv("MAILHEAP_DB_LOCATION", path)
+	if err := config.LoadQuietly(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown()
+	if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+		t.Errorf("database not created at %v: %v", path, err)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	s, err := NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown()
+	now := time.Now()
+	add := func(m model.Mail) int64 {
+		t.Helper()
+		id, err := s.AddMail(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	a := add(model.Mail{Created: now.Add(-time.Hour), Seen: true, Tags: `["ab","c_d"]`, Mailbox: "lmtp"})
+	b := add(model.Mail{Created: now, Starred: true, Tags: `["a"]`})
+	c := add(model.Mail{Created: now.Add(time.Hour), Tags: `[]`, Mailbox: "LMTP"})
+	yes, no := true, false
+	for _, tc := range []struct {
+		name string
+		f    Filter
+		ids  []int64
+	}{
+		{"all", Filter{}, []int64{c, b, a}},
+		{"seen", Filter{Seen: &yes}, []int64{a}},
+		{"unseen", Filter{Seen: &no}, []int64{c, b}},
+		{"starred", Filter{Starred: &yes}, []int64{b}},
+		{"tag", Filter{Tag: "a"}, []int64{b}},
+		{"tag with wildcard", Filter{Tag: "c%"}, []int64{}},
+		{"tag with underscore", Filter{Tag: "c_d"}, []int64{a}},
+		{"mailbox", Filter{Mailbox: "lmtp"}, []int64{a}},
+		{"since", Filter{Since: now}, []int64{c, b}},
+		{"until", Filter{Until: now}, []int64{a}},
+		{"combined", Filter{Seen: &no, Mailbox: "LMTP"}, []int64{c}},
+	} {
+		mails, err := s.SeekMails(math.MaxInt64, 10, tc.f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]int64, 0, len(mails))
+		for _, m := range mails {
+			ids = append(ids, m.Id)
+		}
+		if !slices.Equal(ids, tc.ids) {
+			t.Errorf("%v: got %v, want %v", tc.name, ids, tc.ids)
+		}
+		if tc.f.IsZero() != (tc.name == "all") {
+			t.Errorf("%v: unexpected IsZero %v", tc.name, tc.f.IsZero())
+		}
+	}
+}
+
+func TestWalkMails(t *testing.T) {
+	s, err := NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown()
+	// more than two batches of 50 mails
+	all, starred := make([]int64, 0), make([]int64, 0)
+	for i := range 120 {
+		id, err := s.AddMail(model.Mail{Created: time.Now(), Starred: i%3 == 0, Tags: `[]`})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if all = append(all, id); i%3 == 0 {
+			starred = append(starred, id)
+		}
+	}
+	yes := true
+	stop := errors.New("stop")
+	for _, tc := range []struct {
+		name string
+		f    Filter
+		ids  []int64
+	}{
+		{"all", Filter{}, all},
+		{"starred", Filter{Starred: &yes}, starred},
+		{"none", Filter{Tag: "missing"}, []int64{}},
+	} {
+		ids := make([]int64, 0)
+		err := s.WalkMails(tc.f, func(m model.Mail) error {
+			ids = append(ids, m.Id)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		} else if !slices.Equal(ids, tc.ids) {
+			t.Errorf("%v: got %v, want %v", tc.name, ids, tc.ids)
+		}
+	}
+	n := 0
+	err = s.WalkMails(Filter{}, func(m model.Mail) error {
+		if n++; n == 60 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) || n != 60 {
+		t.Errorf("walk not stopped: %v after %v mails", err, n)
+	}
+}
+
+func TestUpdateState(t *testing.T) {
+	s, err := NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown()
+	a, _ := s.AddMail(model.Mail{Created: time.Now(), Tags: `["keep","old"]`})
+	b, _ := s.AddMail(model.Mail{Created: time.Now(), Seen: true, Tags: `[]`})
+	events, unsubscribe := s.Subscribe()
+	defer unsubscribe()
+	yes, no := true, false
+	tags := func(id int64) string {
+		t.Helper()
+		m, err := s.FindLatestMail(Filter{Ids: []int64{id}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m.Tags
+	}
+
+	cnt, err := s.UpdateState(Filter{Seen: &no}, StateUpdate{Starred: &yes,
+		AddTags: []string{" new ", "keep", ""}, RemoveTags: []string{"old"}})
+	if err != nil || cnt != 1 {
+		t.Fatalf("unexpected update %v: %v", cnt, err)
+	} else if e := <-events; e.Type != EventUpdated || !slices.Equal(e.Ids, []int64{a}) {
+		t.Errorf("unexpected event %+v", e)
+	} else if got := tags(a); got != `["keep","new"]` {
+		t.Errorf("unexpected tags %v", got)
+	} else if m, _ := s.FindLatestMail(Filter{Starred: &yes}); m.Id != a {
+		t.Errorf("mail not starred")
+	}
+	// Tags replaces the tags before AddTags and RemoveTags apply
+	cnt, err = s.UpdateState(Filter{}, StateUpdate{Seen: &yes, Tags: []string{"x", "y", "x"},
+		AddTags: []string{"z"}, RemoveTags: []string{"y"}})
+	if err != nil || cnt != 2 {
+		t.Fatalf("unexpected update %v: %v", cnt, err)
+	} else if got := tags(a); got != `["x","z"]` {
+		t.Errorf("unexpected tags %v", got)
+	} else if got := tags(b); got != `["x","z"]` {
+		t.Errorf("unexpected tags %v", got)
+	} else if n, _ := s.CountMails(Filter{Seen: &no}); n != 0 {
+		t.Errorf("%v mails unseen", n)
+	}
+	<-events
+	if cnt, err := s.UpdateState(Filter{Tag: "missing"}, StateUpdate{Seen: &no}); err != nil || cnt != 0 {
+		t.Errorf("unexpected update %v: %v", cnt, err)
+	}
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event %+v", e)
+	default:
+	}
+}
+
+func TestSubscribeOverflow(t *testing.T) {
+	s, err := NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	slow, _ := s.Subscribe()
+	fast, unsubscribe := s.Subscribe()
+	defer unsubscribe()
+	for i := range 2 * eventBufferSize {
+		if _, err := s.AddMail(model.Mail{Created: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		if e := <-fast; e.Type != EventAdded {
+			t.Fatalf("unexpected event %v: %+v", i, e)
+		}
+	}
+	n := 0
+	for e := range slow {
+		if n++; n < eventBufferSize && e.Type != EventAdded {
+			t.Errorf("unexpected event %v: %+v", n, e)
+		} else if n == eventBufferSize && e.Type != EventOverflow {
+			t.Errorf("expected overflow, got %+v", e)
+		}
+	}
+	if n != eventBufferSize {
+		t.Errorf("unexpected number of events %v", n)
+	}
+	if err := s.Shutdown(); err != nil {
+		t.Fatal(err)
+	} else if _, ok := <-fast; ok {
+		t.Errorf("channel not closed on shutdown")
+	} else if late, _ := s.Subscribe(); !isClosed(late) {
+		t.Errorf("subscribed after shutdown")
+	}
+}
+
+func isClosed(ch <-chan Event) bool {
+	select {
+	case _, ok := <-ch:
+		return !ok
+	default:
+		return false
+	}
+}
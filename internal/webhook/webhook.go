@@ -0,0 +1,279 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rntrp/mailheap/internal/model"
+)
+
+const (
+	EventMailReceived = "mail.received"
+	maxBackoff        = 5 * time.Minute
+)
+
+// Hook is a receiver of mail notifications. If Secret is set, the payload is
+// signed with HMAC-SHA256 in the X-Mailheap-Signature header.
+type Hook struct {
+	Name        string `json:"name"`
+	Url         string `json:"url"`
+	Secret      string `json:"secret,omitempty"`
+	IncludeMime bool   `json:"includeMime,omitempty"`
+	Filter      Filter `json:"filter,omitempty"`
+}
+
+// Filter restricts the mails a hook is notified about. To, From and Subject
+// match case-insensitive substrings, Tag an exact tag of the mail and Mailbox
+// the exact mailbox.
+type Filter struct {
+	To      string `json:"to,omitempty"`
+	From    string `json:"from,omitempty"`
+	Subject string `json:"subject,omitempty"`
+	Tag     string `json:"tag,omitempty"`
+	Mailbox string `json:"mailbox,omitempty"`
+}
+
+func (f Filter) match(m model.Mail) bool {
+	contains := func(s, substr string) bool {
+		return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+	}
+	if !contains(m.To, f.To) && !contains(m.Cc, f.To) && !contains(m.Bcc, f.To) {
+		return false
+	} else if !contains(m.From, f.From) || !contains(m.Subject, f.Subject) {
+		return false
+	} else if len(f.Tag) > 0 && !slices.Contains(list(m.Tags), f.Tag) {
+		return false
+	} else if len(f.Mailbox) > 0 && m.Mailbox != f.Mailbox {
+		return false
+	}
+	return true
+}
+
+// Load reads the hooks from a JSON file. An empty file name yields no hooks.
+func Load(file string) ([]Hook, error) {
+	hooks := make([]Hook, 0)
+	if len(file) == 0 {
+		return hooks, nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	} else if err := json.Unmarshal(b, &hooks); err != nil {
+		return nil, fmt.Errorf("parsing webhooks file %v failed: %w", file, err)
+	}
+	for i, h := range hooks {
+		if u, err := url.Parse(h.Url); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("webhook %v: invalid URL %v", h.Name, h.Url)
+		} else if len(h.Name) == 0 {
+			hooks[i].Name = u.Host
+		}
+	}
+	return hooks, nil
+}
+
+type Payload struct {
+	Event      string      `json:"event"`
+	DeliveryId string      `json:"deliveryId"`
+	Mail       MailSummary `json:"mail"`
+}
+
+type MailSummary struct {
+	Id      int64     `json:"id"`
+	Created time.Time `json:"created"`
+	Date    time.Time `json:"date"`
+	Subject string    `json:"subject"`
+	From    []string  `json:"from"`
+	To      []string  `json:"to"`
+	Cc      []string  `json:"cc"`
+	Size    int32     `json:"size"`
+	Tags    []string  `json:"tags"`
+	Mime    string    `json:"mime,omitempty"`
+}
+
+// DeliveryLog records every delivery attempt.
+type DeliveryLog interface {
+	AddDelivery(d model.Delivery) error
+}
+
+// Dispatcher delivers notifications about stored mails to the hooks. Failed
+// deliveries are retried with exponential backoff until maxAttempts is
+// reached or the dispatcher is shut down.
+type Dispatcher struct {
+	hooks       []Hook
+	log         DeliveryLog
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+	ctx         context.Context
+	cancel      context.CancelFunc
+	mtx         sync.Mutex // guards starting deliveries against Shutdown
+	wg          sync.WaitGroup
+}
+
+func New(hooks []Hook, log DeliveryLog, timeout time.Duration, maxAttempts int,
+	backoff time.Duration) *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Dispatcher{
+		hooks:       hooks,
+		log:         log,
+		client:      &http.Client{Timeout: timeout},
+		maxAttempts: max(maxAttempts, 1),
+		backoff:     backoff,
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+}
+
+func (d *Dispatcher) Hooks() []Hook {
+	return slices.Clone(d.hooks)
+}
+
+// MailStored starts the deliveries to the matching hooks. Mails stored after
+// Shutdown are dropped.
+func (d *Dispatcher) MailStored(m model.Mail) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.ctx.Err() != nil {
+		return
+	}
+	for _, h := range d.hooks {
+		if h.Filter.match(m) {
+			d.wg.Add(1)
+			go func() {
+				defer d.wg.Done()
+				d.deliver(h, m)
+			}()
+		}
+	}
+}
+
+// Shutdown cancels running delivery attempts and pending retries and waits
+// for the deliveries to end.
+func (d *Dispatcher) Shutdown(ctx context.Context) error {
+	d.mtx.Lock()
+	d.cancel()
+	d.mtx.Unlock()
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *Dispatcher) deliver(h Hook, m model.Mail) {
+	p := Payload{
+		Event:      EventMailReceived,
+		DeliveryId: uuid.NewString(),
+		Mail: MailSummary{
+			Id:      m.Id,
+			Created: m.Created,
+			Date:    m.Date,
+			Subject: m.Subject,
+			From:    list(m.From),
+			To:      list(m.To),
+			Cc:      list(m.Cc),
+			Size:    m.Size,
+			Tags:    list(m.Tags),
+		},
+	}
+	if h.IncludeMime {
+		p.Mail.Mime = m.Mime
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		slog.Error("Marshalling webhook payload failed", "webhook", h.Name, "error", err.Error())
+		return
+	}
+	wait := d.backoff
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		status, err := d.post(h, p.DeliveryId, body)
+		entry := model.Delivery{
+			Created:  start,
+			Webhook:  h.Name,
+			MailId:   m.Id,
+			Url:      h.Url,
+			Attempt:  attempt,
+			Status:   status,
+			Duration: time.Since(start).Milliseconds(),
+		}
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		if err := d.log.AddDelivery(entry); err != nil {
+			slog.Warn("Logging webhook delivery failed", "webhook", h.Name, "error", err.Error())
+		}
+		if err == nil {
+			return
+		} else if attempt >= d.maxAttempts {
+			slog.Warn("Webhook delivery failed", "webhook", h.Name, "id", m.Id,
+				"attempts", attempt, "error", entry.Error)
+			return
+		}
+		select {
+		case <-time.After(wait):
+		case <-d.ctx.Done():
+			return
+		}
+		wait = min(wait*2, maxBackoff)
+	}
+}
+
+func (d *Dispatcher) post(h Hook, deliveryId string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, h.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "mailheap")
+	req.Header.Set("X-Mailheap-Event", EventMailReceived)
+	req.Header.Set("X-Mailheap-Delivery", deliveryId)
+	if len(h.Secret) > 0 {
+		req.Header.Set("X-Mailheap-Signature", "sha256="+Sign(h.Secret, body))
+	}
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, errors.New(res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of the body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func list(s string) []string {
+	res := make([]string, 0)
+	json.Unmarshal([]byte(s), &res)
+	return res
+}
@@ -0,0 +1,126 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rntrp/mailheap/internal/model"
+)
+
+type memLog struct {
+	mtx        sync.Mutex
+	deliveries []model.Delivery
+}
+
+func (l *memLog) AddDelivery(d model.Delivery) error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.deliveries = append(l.deliveries, d)
+	return nil
+}
+
+func TestDispatcher(t *testing.T) {
+	calls := 0
+	var payload Payload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if sig := r.Header.Get("X-Mailheap-Signature"); sig != "sha256="+Sign("secret", body) {
+			t.Errorf("invalid signature: %v", sig)
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+	log := new(memLog)
+	d := New([]Hook{
+		{Name: "qa", Url: srv.URL, Secret: "secret", IncludeMime: true,
+			Filter: Filter{To: "@QA.example.com", Tag: "reset"}},
+		{Name: "other", Url: srv.URL, Filter: Filter{Subject: "invoice"}},
+	}, log, time.Second, 5, time.Millisecond)
+	d.MailStored(model.Mail{
+		Id:      42,
+		Subject: "Reset your password",
+		From:    `["shop@example.com"]`,
+		To:      `["alice@qa.example.com"]`,
+		Cc:      `[]`,
+		Tags:    `["reset"]`,
+		Mime:    "Subject: Reset your password\r\n\r\n",
+	})
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		log.mtx.Lock()
+		n := len(log.deliveries)
+		log.mtx.Unlock()
+		if n >= 3 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := d.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 3 {
+		t.Errorf("expected 3 calls, got %v", calls)
+	}
+	if payload.Event != EventMailReceived || payload.Mail.Id != 42 ||
+		payload.Mail.To[0] != "alice@qa.example.com" || len(payload.Mail.Mime) == 0 {
+		t.Errorf("unexpected payload: %+v", payload)
+	}
+	if len(log.deliveries) != 3 {
+		t.Fatalf("expected 3 logged deliveries, got %v", len(log.deliveries))
+	}
+	for i, d := range log.deliveries {
+		if d.Webhook != "qa" || d.Attempt != i+1 || d.MailId != 42 {
+			t.Errorf("unexpected delivery: %+v", d)
+		}
+	}
+	if last := log.deliveries[2]; last.Status != http.StatusOK || len(last.Error) > 0 {
+		t.Errorf("expected successful last delivery: %+v", last)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	received, release := make(chan struct{}, 2), make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		<-release // hangs until the test ends
+	}))
+	defer srv.Close()
+	defer close(release)
+	log := new(memLog)
+	d := New([]Hook{{Name: "slow", Url: srv.URL}}, log, time.Minute, 5, time.Minute)
+	d.MailStored(model.Mail{Id: 1})
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no delivery attempt")
+	}
+	// the running attempt is canceled instead of waiting for the timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := d.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	d.MailStored(model.Mail{Id: 2})
+	d.Shutdown(ctx)
+	select {
+	case <-received:
+		t.Error("delivery started after shutdown")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if len(log.deliveries) != 1 || log.deliveries[0].MailId != 1 || len(log.deliveries[0].Error) == 0 {
+		t.Errorf("unexpected deliveries %+v", log.deliveries)
+	}
+}
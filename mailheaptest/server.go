@@ -0,0 +1,254 @@
+// Package mailheaptest runs mailheap in-process for tests of code sending
+// mail. A Server receives mail via SMTP, serves the REST API and web UI via
+// HTTP and keeps the mails in memory:
+//
+//	func TestSignup(t *testing.T) {
+//		srv := mailheaptest.NewServer(t)
+//		app := newApp(srv.SMTPAddr)
+//		app.Signup("alice@example.com")
+//		m := srv.WaitForMail(5*time.Second, mailheaptest.To("alice@example.com"))
+//		...
+//	}
+//
+// The config is resolved from the MAILHEAP_* environment variables and the
+// defaults, .env files are not read.
+package mailheaptest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/rntrp/mailheap/internal/config"
+	"github.com/rntrp/mailheap/internal/httpsrv"
+	"github.com/rntrp/mailheap/internal/model"
+	"github.com/rntrp/mailheap/internal/msg"
+	"github.com/rntrp/mailheap/internal/rest"
+	"github.com/rntrp/mailheap/internal/rules"
+	"github.com/rntrp/mailheap/internal/smtprecv"
+	"github.com/rntrp/mailheap/internal/storage"
+	"github.com/rntrp/mailheap/internal/webhook"
+)
+
+var (
+	initOnce sync.Once
+	initErr  error
+)
+
+// Message is a received mail. Addresses are formatted as in the headers,
+// e.g. "Alice <alice@example.com>".
+type Message struct {
+	Id       int64
+	Received time.Time
+	Date     time.Time
+	Subject  string
+	From     []string
+	To       []string
+	Cc       []string
+	Bcc      []string
+	Tags     []string
+	Seen     bool
+	Starred  bool
+	Raw      []byte
+}
+
+// Server is a mailheap instance listening on random local ports.
+type Server struct {
+	// SMTPAddr is the host:port of the SMTP server.
+	SMTPAddr string
+	// HTTPAddr is the host:port of the HTTP server.
+	HTTPAddr string
+	// URL is the base URL of the REST API and web UI, e.g. http://127.0.0.1:1234
+	URL string
+
+	t       testing.TB
+	storage storage.MailStorage
+	smtp    *smtprecv.Server
+	http    *http.Server
+}
+
+// NewServer starts a server which is shut down when the test finishes.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	initOnce.Do(func() {
+		initErr = config.LoadDefaults()
+		rest.InitIndex()
+	})
+	if initErr != nil {
+		t.Fatalf("mailheaptest: invalid config: %v", initErr)
+	}
+	st, err := storage.NewInMemory()
+	if err != nil {
+		t.Fatalf("mailheaptest: creating storage failed: %v", err)
+	}
+	smtpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		st.Shutdown()
+		t.Fatalf("mailheaptest: listening for SMTP failed: %v", err)
+	}
+	httpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		smtpLn.Close()
+		st.Shutdown()
+		t.Fatalf("mailheaptest: listening for HTTP failed: %v", err)
+	}
+	engine, _ := rules.Load("")
+	hooks := webhook.New(nil, st, config.GetWebhookTimeout(), 1, 0)
+	svc := msg.NewAddMailSvc(st, nil, engine)
+	s := &Server{
+		SMTPAddr: smtpLn.Addr().String(),
+		HTTPAddr: httpLn.Addr().String(),
+		URL:      "http://" + httpLn.Addr().String(),
+		t:        t,
+		storage:  st,
+		smtp:     smtprecv.New(config.SMTPListener{Network: "tcp"}, svc, nil),
+		http:     httpsrv.New(rest.New(st, svc, nil, engine, hooks), make(chan os.Signal, 1)),
+	}
+	s.smtp.Addr, s.http.Addr = s.SMTPAddr, s.HTTPAddr
+	go s.smtp.Serve(smtpLn)
+	go s.http.Serve(httpLn)
+	t.Cleanup(s.close)
+	return s
+}
+
+func (s *Server) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := errors.Join(s.http.Shutdown(ctx), s.smtp.Shutdown(ctx), s.storage.Shutdown())
+	if err != nil && !errors.Is(err, smtp.ErrServerClosed) {
+		s.t.Errorf("mailheaptest: shutdown failed: %v", err)
+	}
+}
+
+// Messages returns all received mails, oldest first.
+func (s *Server) Messages() []Message {
+	s.t.Helper()
+	msgs, err := s.find(storage.Filter{})
+	if err != nil {
+		s.t.Fatalf("mailheaptest: loading mails failed: %v", err)
+	}
+	return msgs
+}
+
+// Reset deletes all mails.
+func (s *Server) Reset() {
+	s.t.Helper()
+	if _, err := s.storage.DeleteAllMails(); err != nil {
+		s.t.Fatalf("mailheaptest: deleting mails failed: %v", err)
+	}
+}
+
+// WaitForMail returns the oldest mail accepted by match, including mails
+// received before the call. A nil match accepts any mail. The test fails if
+// no such mail arrives within the timeout. Like t.Fatal, WaitForMail must be
+// called from the goroutine running the test.
+func (s *Server) WaitForMail(timeout time.Duration, match func(Message) bool) Message {
+	s.t.Helper()
+	events, unsubscribe := s.storage.Subscribe()
+	defer func() { unsubscribe() }()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	f := storage.Filter{}
+	for {
+		msgs, err := s.find(f)
+		if err != nil {
+			s.t.Fatalf("mailheaptest: loading mails failed: %v", err)
+		}
+		for _, m := range msgs {
+			if match == nil || match(m) {
+				return m
+			}
+		}
+		select {
+		case e, ok := <-events:
+			if !ok {
+				s.t.Fatalf("mailheaptest: server shut down while waiting for mail")
+			} else if e.Type == storage.EventOverflow {
+				events, unsubscribe = s.storage.Subscribe()
+				f.Ids = nil
+				continue
+			} else if e.Type != storage.EventAdded {
+				f.Ids = []int64{}
+				continue
+			}
+			f.Ids = e.Ids
+		case <-timer.C:
+			s.t.Fatalf("mailheaptest: no matching mail received within %v", timeout)
+		}
+	}
+}
+
+func (s *Server) find(f storage.Filter) ([]Message, error) {
+	msgs := make([]Message, 0)
+	if f.Ids != nil && len(f.Ids) == 0 {
+		return msgs, nil
+	}
+	err := s.storage.WalkMails(f, func(m model.Mail) error {
+		msgs = append(msgs, newMessage(m))
+		return nil
+	})
+	return msgs, err
+}
+
+func newMessage(m model.Mail) Message {
+	return Message{
+		Id:       m.Id,
+		Received: m.Created,
+		Date:     m.Date,
+		Subject:  m.Subject,
+		From:     jsonList(m.From),
+		To:       jsonList(m.To),
+		Cc:       jsonList(m.Cc),
+		Bcc:      jsonList(m.Bcc),
+		Tags:     jsonList(m.Tags),
+		Seen:     m.Seen,
+		Starred:  m.Starred,
+		Raw:      []byte(m.Mime),
+	}
+}
+
+func jsonList(s string) []string {
+	list := make([]string, 0)
+	json.Unmarshal([]byte(s), &list)
+	return list
+}
+
+// To matches mails with a recipient in To, Cc or Bcc containing the address,
+// ignoring case.
+func To(addr string) func(Message) bool {
+	return func(m Message) bool {
+		return containsAddr(m.To, addr) || containsAddr(m.Cc, addr) || containsAddr(m.Bcc, addr)
+	}
+}
+
+// From matches mails with a sender containing the address, ignoring case.
+func From(addr string) func(Message) bool {
+	return func(m Message) bool {
+		return containsAddr(m.From, addr)
+	}
+}
+
+// Subject matches mails with a subject containing the text.
+func Subject(text string) func(Message) bool {
+	return func(m Message) bool {
+		return strings.Contains(m.Subject, text)
+	}
+}
+
+func containsAddr(list []string, addr string) bool {
+	addr = strings.ToLower(addr)
+	for _, a := range list {
+		if strings.Contains(strings.ToLower(a), addr) {
+			return true
+		}
+	}
+	return false
+}
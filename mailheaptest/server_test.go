@@ -0,0 +1,89 @@
+package mailheaptest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rntrp/mailheap/internal/storage"
+)
+
+func send(t *testing.T, addr, to, subject string) {
+	t.Helper()
+	body := "Date: " + time.Now().Format(time.RFC1123Z) + "\r\nFrom: alice@example.com\r\nTo: " +
+		to + "\r\nSubject: " + subject + "\r\n\r\nHello\r\n"
+	if err := smtp.SendMail(addr, nil, "alice@example.com", []string{to}, []byte(body)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestServer(t *testing.T) {
+	srv := NewServer(t)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		send(t, srv.SMTPAddr, "bob@example.com", "first")
+		send(t, srv.SMTPAddr, "carol@example.com", "second")
+	}()
+	m := srv.WaitForMail(5*time.Second, To("carol@example.com"))
+	if m.Subject != "second" || !strings.Contains(string(m.Raw), "Hello") {
+		t.Errorf("unexpected mail: %+v", m)
+	}
+	if msgs := srv.Messages(); len(msgs) != 2 || msgs[0].Subject != "first" {
+		t.Errorf("unexpected mails: %+v", msgs)
+	}
+	res, err := http.Get(srv.URL + "/mails/0?subject=first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	page := struct{ Size int }{}
+	if err := json.NewDecoder(res.Body).Decode(&page); err != nil || page.Size != 1 {
+		t.Errorf("unexpected REST result: %+v, %v", page, err)
+	}
+	srv.Reset()
+	if msgs := srv.Messages(); len(msgs) != 0 {
+		t.Errorf("mails left after reset: %v", len(msgs))
+	}
+}
+
+func TestServersAreIsolated(t *testing.T) {
+	a, b := NewServer(t), NewServer(t)
+	send(t, a.SMTPAddr, "bob@example.com", "only a")
+	a.WaitForMail(5*time.Second, Subject("only a"))
+	if msgs := b.Messages(); len(msgs) != 0 {
+		t.Errorf("unexpected mails in other server: %+v", msgs)
+	}
+}
+
+// overflowStorage drops the first subscription, like the storage does for
+// slow subscribers.
+type overflowStorage struct {
+	storage.MailStorage
+	dropped bool
+}
+
+func (s *overflowStorage) Subscribe() (<-chan storage.Event, func()) {
+	if s.dropped {
+		return s.MailStorage.Subscribe()
+	}
+	s.dropped = true
+	ch := make(chan storage.Event, 1)
+	ch <- storage.Event{Type: storage.EventOverflow}
+	close(ch)
+	return ch, func() {}
+}
+
+func TestWaitForMailAfterOverflow(t *testing.T) {
+	srv := NewServer(t)
+	srv.storage = &overflowStorage{MailStorage: srv.storage}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		send(t, srv.SMTPAddr, "bob@example.com", "resubscribed")
+	}()
+	if m := srv.WaitForMail(5*time.Second, Subject("resubscribed")); m.Subject != "resubscribed" {
+		t.Errorf("unexpected mail: %+v", m)
+	}
+}
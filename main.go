@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
+	"io"
 	"log"
 	"log/slog"
 	"net/http"
@@ -12,17 +14,28 @@ import (
 	"syscall"
 
 	"github.com/emersion/go-smtp"
+	"github.com/rntrp/mailheap/internal/archive"
+	"github.com/rntrp/mailheap/internal/cli"
 	"github.com/rntrp/mailheap/internal/config"
 	"github.com/rntrp/mailheap/internal/httpsrv"
+	"github.com/rntrp/mailheap/internal/imapsrv"
+	"github.com/rntrp/mailheap/internal/linkcheck"
 	"github.com/rntrp/mailheap/internal/logs"
 	"github.com/rntrp/mailheap/internal/msg"
+	"github.com/rntrp/mailheap/internal/pop3srv"
 	"github.com/rntrp/mailheap/internal/rest"
+	"github.com/rntrp/mailheap/internal/rules"
 	"github.com/rntrp/mailheap/internal/smtprecv"
+	"github.com/rntrp/mailheap/internal/spam"
 	"github.com/rntrp/mailheap/internal/storage"
+	"github.com/rntrp/mailheap/internal/webhook"
 )
 
 func main() {
-	config.Load()
+	os.Exit(cli.Run(os.Args[1:], serve))
+}
+
+func serve() {
 	slog.SetDefault(logs.Logger())
 	slog.Info("📮 Initializing services...")
 	rest.InitIndex()
@@ -32,29 +45,150 @@ func main() {
 		log.Fatal(err)
 	}
 	slog.Info("🥞 Database connection established")
-	addMailSvc := msg.NewAddMailSvc(storage)
-	recv := smtprecv.Init(addMailSvc)
+	engine, err := rules.Load(config.GetRulesFile())
+	if err != nil {
+		log.Fatal(err)
+	}
+	hooks, err := webhook.Load(config.GetWebhooksFile())
+	if err != nil {
+		log.Fatal(err)
+	}
+	webhooks := webhook.New(hooks, storage, config.GetWebhookTimeout(),
+		int(config.GetWebhookMaxAttempts()), config.GetWebhookBackoff())
+	if len(hooks) > 0 {
+		slog.Info("🪝 Webhooks enabled", "webhooks", len(hooks))
+	}
+	addMailSvc := msg.NewAddMailSvc(storage, []msg.Listener{webhooks}, stages(engine)...)
+	importDir(addMailSvc)
+	tlsCfg := tlsConfig()
+	recvs, err := smtprecv.Init(addMailSvc, tlsCfg)
+	if err != nil {
+		log.Fatal(err)
+	}
 	sig := make(chan os.Signal, 1)
-	srv := httpsrv.New(rest.New(storage, addMailSvc), sig)
+	srv := httpsrv.New(rest.New(storage, addMailSvc, linkChecker(), engine, webhooks), sig)
+	switches := []shutdownSwitch{srv, webhooks}
+	for _, recv := range recvs {
+		switches = append(switches, recv)
+	}
+	var imap *imapsrv.Server
+	if config.IsIMAPEnable() {
+		if imap, err = imapsrv.New(storage, addMailSvc, tlsCfg); err != nil {
+			log.Fatal(err)
+		}
+		switches = append(switches, imap)
+	}
+	var pop3 *pop3srv.Server
+	if config.IsPOP3Enable() {
+		pop3 = pop3srv.New(storage, tlsCfg)
+		switches = append(switches, pop3)
+	}
+	go reloadMonitor()
+	if interval := config.GetConfigWatchInterval(); interval > 0 && len(config.GetConfigFile()) > 0 {
+		go config.Watch(interval)
+		slog.Info("👀 Watching config file", "file", config.GetConfigFile(), "interval", interval)
+	}
 	shutdown := make(chan error)
-	go shutdownMonitor(sig, shutdown, storage, recv, srv)
+	go shutdownMonitor(sig, shutdown, storage, switches...)
 	slog.Info("🔌 Set up graceful shutdown monitor")
-	out := make(chan<- error)
-	go startRecv(out, recv)
+	out := make(chan error)
+	go listenMonitor(out, sig)
+	for _, recv := range recvs {
+		go startRecv(out, recv)
+	}
 	go startSrv(out, srv)
+	if imap != nil {
+		go startImap(out, imap)
+	}
+	if pop3 != nil {
+		go startPop3(out, pop3)
+	}
 	logShutdown(<-shutdown)
 	if err := storage.Shutdown(); err != nil {
 		slog.Error("DB shutdown failed", "error", err.Error())
 	}
 }
 
-func startRecv(out chan<- error, recv *smtp.Server) {
+func stages(engine *rules.Engine) []msg.Stage {
+	stages := make([]msg.Stage, 0)
+	if config.IsSpamEnable() {
+		stages = append(stages, spam.NewStage(config.GetSpamSpamdAddress(),
+			config.GetSpamSpamdTimeout()))
+		slog.Info("🥫 Spam scoring enabled",
+			"spamd", config.GetSpamSpamdAddress())
+	}
+	stages = append(stages, engine)
+	slog.Info("🏷️ Labelling rules loaded",
+		"file", config.GetRulesFile(), "rules", len(engine.Rules()))
+	return stages
+}
+
+func importDir(svc msg.StoreMailSvc) {
+	dir := config.GetImportDir()
+	if len(dir) == 0 {
+		return
+	}
+	src, err := msg.ParseTimeSource(config.GetImportTimeSource())
+	if err != nil {
+		slog.Warn("Invalid import time source, using current time", "error", err.Error())
+		src = msg.TimeNow
+	}
+	stored, failed := 0, 0
+	err = archive.WalkDir(dir, func(entry string, r io.Reader) error {
+		if _, err := svc.ImportMail(r, src); err != nil {
+			slog.Warn("Importing mail failed", "entry", entry, "error", err.Error())
+			failed++
+		} else {
+			stored++
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("Importing mails aborted", "dir", dir, "error", err.Error())
+	}
+	slog.Info("📥 Imported mails", "dir", dir, "stored", stored, "failed", failed)
+}
+
+func linkChecker() linkcheck.Checker {
+	if !config.IsLinkCheckEnable() {
+		return nil
+	}
+	hosts := config.GetLinkCheckAllowedHosts()
+	slog.Info("🔗 Link checker enabled", "hosts", hosts)
+	return linkcheck.New(hosts, config.GetLinkCheckTimeout(),
+		int(config.GetLinkCheckMaxRedirects()))
+}
+
+func startRecv(out chan<- error, recv *smtprecv.Server) {
 	slog.Info("📧 Receiving SMTP connections",
 		"domain", recv.Domain,
-		"addr", recv.Addr)
+		"listener", recv.Listener.String())
 	out <- recv.ListenAndServe()
 }
 
+func startImap(out chan<- error, imap *imapsrv.Server) {
+	slog.Info("📬 Listening to IMAP connections", "addr", imap.Addr())
+	out <- imap.ListenAndServe()
+}
+
+func startPop3(out chan<- error, pop3 *pop3srv.Server) {
+	slog.Info("📪 Listening to POP3 connections", "addr", pop3.Addr)
+	out <- pop3.ListenAndServe()
+}
+
+func tlsConfig() *tls.Config {
+	certFile, keyFile := config.GetTLSCertFile(), config.GetTLSKeyFile()
+	if len(certFile) == 0 || len(keyFile) == 0 {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.Info("🔒 TLS certificate loaded", "cert", certFile)
+	return &tls.Config{Certificates: []tls.Certificate{cert}}
+}
+
 func startSrv(out chan<- error, srv *http.Server) {
 	slog.Info("🌐 Listening to HTTP connections", "addr", srv.Addr)
 	switch {
@@ -95,6 +229,37 @@ func shutdownMonitor(sig chan os.Signal, out chan error,
 	out <- errors.Join(err...)
 }
 
+// listenMonitor shuts down if any server stops listening for other reasons
+// than the shutdown itself, e.g. because its address is already in use.
+func listenMonitor(errs <-chan error, sig chan os.Signal) {
+	for err := range errs {
+		switch {
+		case err == nil,
+			errors.Is(err, http.ErrServerClosed),
+			errors.Is(err, smtp.ErrServerClosed),
+			errors.Is(err, imapsrv.ErrServerClosed),
+			errors.Is(err, pop3srv.ErrServerClosed):
+			continue
+		}
+		slog.Error("Server stopped listening", "error", err.Error())
+		select {
+		case sig <- syscall.SIGTERM:
+		default:
+		}
+	}
+}
+
+func reloadMonitor() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for sig := range hup {
+		slog.Info("Reload signal received", "signal", sig.String())
+		if err := config.Reload(); err != nil {
+			slog.Error("Config reload failed", "error", err.Error())
+		}
+	}
+}
+
 func logShutdown(err error) {
 	switch err {
 	case nil: